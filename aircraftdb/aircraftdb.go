@@ -0,0 +1,185 @@
+// Package aircraftdb loads an aircraft registration database (the OpenSky Network
+// aircraftDatabase.csv, https://opensky-network.org/datasets/metadata/, or any CSV with the same
+// column names) and looks up registration, type and operator by ICAO24 address.
+package aircraftdb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Aircraft is one registration record.
+type Aircraft struct {
+	Icao24       string `json:"icao24"`
+	Registration string `json:"registration,omitempty"`
+	TypeCode     string `json:"typecode,omitempty"` // ICAO type designator, e.g. "A320"
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Operator     string `json:"operator,omitempty"`
+	OperatorICAO string `json:"operator_icao,omitempty"`
+	Owner        string `json:"owner,omitempty"`
+	Built        string `json:"built,omitempty"`
+}
+
+// DB is a loaded database indexed by ICAO24.
+type DB struct {
+	byICAO map[string]Aircraft
+}
+
+var (
+	dbMu sync.RWMutex
+	db   *DB
+)
+
+// Get returns the loaded database or nil.
+func Get() *DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return db
+}
+
+// Load reads the CSV at path and makes it the current database. Both the classic export and the
+// newer one with single-quoted fields are accepted; rows without a valid ICAO24 are skipped.
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(unquote(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	if _, ok := cols["icao24"]; !ok {
+		return nil, errors.New("unexpected CSV header in " + path + " (no icao24 column)")
+	}
+	d := &DB{byICAO: map[string]Aircraft{}}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		col := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(rec) {
+				return unquote(rec[i])
+			}
+			return ""
+		}
+		icao := strings.ToLower(col("icao24"))
+		if !validICAO(icao) {
+			continue
+		}
+		a := Aircraft{
+			Icao24:       icao,
+			Registration: strings.ToUpper(col("registration")),
+			TypeCode:     strings.ToUpper(col("typecode")),
+			Manufacturer: col("manufacturername"),
+			Model:        col("model"),
+			Operator:     col("operator"),
+			OperatorICAO: strings.ToUpper(col("operatoricao")),
+			Owner:        col("owner"),
+			Built:        col("built"),
+		}
+		if a.Registration == "" && a.TypeCode == "" && a.Operator == "" && a.Model == "" {
+			continue
+		}
+		d.byICAO[icao] = a
+	}
+	dbMu.Lock()
+	db = d
+	dbMu.Unlock()
+	log.Printf("aircraftdb: loaded aircraft=%d", len(d.byICAO))
+	return d, nil
+}
+
+// unquote trims spaces and the single quotes used by newer OpenSky exports.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+func validICAO(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the record for an ICAO24 address.
+func (d *DB) Lookup(icao string) (Aircraft, bool) {
+	if d == nil {
+		return Aircraft{}, false
+	}
+	a, ok := d.byICAO[strings.ToLower(strings.TrimSpace(icao))]
+	return a, ok
+}
+
+// TypeCode returns the type designator of an aircraft in the current database, or "".
+func TypeCode(icao string) string {
+	a, _ := Get().Lookup(icao)
+	return a.TypeCode
+}
+
+// Info returns registration, type designator and operator of an aircraft in the current
+// database (the operator name, or its ICAO designator when the name is unknown).
+func Info(icao string) (registration, typeCode, operator string) {
+	a, ok := Get().Lookup(icao)
+	if !ok {
+		return "", "", ""
+	}
+	op := a.Operator
+	if op == "" {
+		op = a.OperatorICAO
+	}
+	return a.Registration, a.TypeCode, op
+}
+
+// Handler serves /api/aircraft?icao24=.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	icao := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("icao24")))
+	if !validICAO(icao) {
+		problem.Write(w, r, http.StatusBadRequest, fmt.Sprintf("invalid icao24: want 6 hex digits, got %q", icao))
+		return
+	}
+	d := Get()
+	if d == nil {
+		problem.Write(w, r, http.StatusNotFound, "aircraft database is not configured")
+		return
+	}
+	a, ok := d.Lookup(icao)
+	if !ok {
+		problem.Write(w, r, http.StatusNotFound, "aircraft not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
@@ -0,0 +1,443 @@
+// Package airports loads an OurAirports-style airport and runway dataset
+// (https://ourairports.com/data/ airports.csv and runways.csv), indexes it spatially and
+// matches aircraft movements to runways (landings and takeoffs).
+package airports
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/geo"
+)
+
+// Airport is a single airport record.
+type Airport struct {
+	Ident        string  `json:"ident"` // ICAO/GPS ident, e.g. "EDDM"
+	IATA         string  `json:"iata,omitempty"`
+	Type         string  `json:"type"` // large_airport, medium_airport, ...
+	Name         string  `json:"name"`
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	ElevationM   float64 `json:"elevation_m"`
+	Country      string  `json:"country,omitempty"`
+	Municipality string  `json:"municipality,omitempty"`
+}
+
+// RunwayEnd is one threshold of a runway; Heading is the true course when landing on it.
+type RunwayEnd struct {
+	Ident      string  `json:"ident"` // e.g. "26R"
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	ElevationM float64 `json:"elevation_m"`
+	Heading    float64 `json:"heading"`
+}
+
+// Runway is a runway with both ends located.
+type Runway struct {
+	Airport string       `json:"airport"`
+	LengthM float64      `json:"length_m"`
+	Ends    [2]RunwayEnd `json:"ends"`
+}
+
+// DB is a loaded dataset.
+type DB struct {
+	Airports []Airport
+	Runways  []Runway
+	byIdent  map[string]int
+	byIATA   map[string]int
+	aptIndex *geo.PointIndex
+	rwyIndex *geo.PointIndex // runway midpoints
+	runways  map[string][]int
+}
+
+var (
+	dbMu sync.RWMutex
+	db   *DB
+)
+
+// Get returns the loaded dataset or nil.
+func Get() *DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return db
+}
+
+// Load reads airports.csv and optionally runways.csv and makes the dataset current.
+// Heliports, seaplane bases, balloonports and closed airports are skipped.
+func Load(airportsPath, runwaysPath string) (*DB, error) {
+	d := &DB{
+		byIdent:  map[string]int{},
+		byIATA:   map[string]int{},
+		aptIndex: geo.NewPointIndex(1),
+		rwyIndex: geo.NewPointIndex(0.5),
+		runways:  map[string][]int{},
+	}
+	err := eachCSV(airportsPath, func(col func(string) string) {
+		typ := col("type")
+		switch typ {
+		case "large_airport", "medium_airport", "small_airport":
+		default:
+			return
+		}
+		lat, err1 := strconv.ParseFloat(col("latitude_deg"), 64)
+		lon, err2 := strconv.ParseFloat(col("longitude_deg"), 64)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		elev, _ := strconv.ParseFloat(col("elevation_ft"), 64)
+		a := Airport{
+			Ident: strings.ToUpper(col("ident")), IATA: strings.ToUpper(col("iata_code")), Type: typ, Name: col("name"),
+			Lat: lat, Lon: lon, ElevationM: math.Round(elev * geo.Foot), Country: col("iso_country"), Municipality: col("municipality"),
+		}
+		if gps := strings.ToUpper(col("gps_code")); len(a.Ident) != 4 && len(gps) == 4 {
+			a.Ident = gps
+		}
+		d.byIdent[a.Ident] = len(d.Airports)
+		if a.IATA != "" {
+			d.byIATA[a.IATA] = len(d.Airports)
+		}
+		d.Airports = append(d.Airports, a)
+		d.aptIndex.Add(lat, lon)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if runwaysPath != "" {
+		err = eachCSV(runwaysPath, func(col func(string) string) {
+			if col("closed") == "1" {
+				return
+			}
+			ai, ok := d.byIdent[strings.ToUpper(col("airport_ident"))]
+			if !ok {
+				return
+			}
+			apt := d.Airports[ai]
+			var ends [2]RunwayEnd
+			for i, p := range []string{"le_", "he_"} {
+				lat, err1 := strconv.ParseFloat(col(p+"latitude_deg"), 64)
+				lon, err2 := strconv.ParseFloat(col(p+"longitude_deg"), 64)
+				if err1 != nil || err2 != nil {
+					return
+				}
+				elev, err := strconv.ParseFloat(col(p+"elevation_ft"), 64)
+				if err != nil {
+					elev = apt.ElevationM / geo.Foot
+				}
+				ends[i] = RunwayEnd{Ident: col(p + "ident"), Lat: lat, Lon: lon, ElevationM: math.Round(elev * geo.Foot)}
+			}
+			// course when landing on an end points towards the opposite threshold
+			ends[0].Heading = math.Round(geo.Bearing(ends[0].Lat, ends[0].Lon, ends[1].Lat, ends[1].Lon))
+			ends[1].Heading = math.Round(geo.Bearing(ends[1].Lat, ends[1].Lon, ends[0].Lat, ends[0].Lon))
+			length := geo.Haversine(ends[0].Lat, ends[0].Lon, ends[1].Lat, ends[1].Lon)
+			if length < 100 {
+				return
+			}
+			rw := Runway{Airport: apt.Ident, LengthM: math.Round(length), Ends: ends}
+			d.runways[apt.Ident] = append(d.runways[apt.Ident], len(d.Runways))
+			d.Runways = append(d.Runways, rw)
+			d.rwyIndex.Add((ends[0].Lat+ends[1].Lat)/2, (ends[0].Lon+ends[1].Lon)/2)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	dbMu.Lock()
+	db = d
+	dbMu.Unlock()
+	log.Printf("airports: loaded airports=%d runways=%d", len(d.Airports), len(d.Runways))
+	return d, nil
+}
+
+// eachCSV calls fn for each data row of a headered CSV file; col looks up a column by header name.
+func eachCSV(path string, fn func(col func(string) string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))] = i
+	}
+	if _, ok := cols["ident"]; !ok {
+		if _, ok := cols["airport_ident"]; !ok {
+			return errors.New("unexpected CSV header in " + path)
+		}
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(func(name string) string {
+			if i, ok := cols[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		})
+	}
+}
+
+// Airport returns an airport by ICAO/GPS ident or IATA code.
+func (d *DB) Airport(code string) (*Airport, bool) {
+	if d == nil {
+		return nil, false
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	i, ok := d.byIdent[code]
+	if !ok {
+		i, ok = d.byIATA[code]
+	}
+	if !ok {
+		return nil, false
+	}
+	return &d.Airports[i], true
+}
+
+// RunwaysAt returns the runways of an airport.
+func (d *DB) RunwaysAt(ident string) []Runway {
+	if d == nil {
+		return nil
+	}
+	out := []Runway{}
+	for _, i := range d.runways[strings.ToUpper(ident)] {
+		out = append(out, d.Runways[i])
+	}
+	return out
+}
+
+// typeRank orders airport types by size for InBBox.
+var typeRank = map[string]int{"large_airport": 0, "medium_airport": 1, "small_airport": 2}
+
+// ValidType reports whether t is an airport type kept by Load.
+func ValidType(t string) bool {
+	_, ok := typeRank[t]
+	return ok
+}
+
+// InBBox returns up to limit airports inside [minLon,minLat,maxLon,maxLat] of the given types
+// (all when empty), larger airports first, and the number of matches before the limit.
+func (d *DB) InBBox(minLon, minLat, maxLon, maxLat float64, types []string, limit int) ([]Airport, int) {
+	if d == nil {
+		return []Airport{}, 0
+	}
+	out := []Airport{}
+	for _, id := range d.aptIndex.InBBox(minLon, minLat, maxLon, maxLat) {
+		if a := d.Airports[id]; len(types) == 0 || slices.Contains(types, a.Type) {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ri, rj := typeRank[out[i].Type], typeRank[out[j].Type]; ri != rj {
+			return ri < rj
+		}
+		return out[i].Ident < out[j].Ident
+	})
+	total := len(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, total
+}
+
+// Nearest returns the airport closest to a position within radius meters and its distance.
+func (d *DB) Nearest(lat, lon, radius float64) (*Airport, float64, bool) {
+	if d == nil {
+		return nil, 0, false
+	}
+	id, dist := d.aptIndex.Nearest(lat, lon, radius, nil)
+	if id < 0 {
+		return nil, 0, false
+	}
+	return &d.Airports[id], dist, true
+}
+
+// NearRadius is how far (meters) from its reference point a position is attributed to an
+// airport by NearestIdent; runways of large airports reach about 4 km from it.
+const NearRadius = 8000
+
+// NearestIdent returns the ident of the airport of the current dataset within NearRadius of a
+// position, or "" (used to annotate samples on the ground).
+func NearestIdent(lat, lon float64) string {
+	if a, _, ok := Get().Nearest(lat, lon, NearRadius); ok {
+		return a.Ident
+	}
+	return ""
+}
+
+// Along returns the large or medium airport within maxDist meters of a position whose bearing
+// from it deviates least from bearing (by at most maxDev degrees), weighing the deviation
+// against the distance, and its distance; used to guess where a climbing aircraft came from
+// or a descending one is heading.
+func (d *DB) Along(lat, lon, bearing, maxDist, maxDev float64) (*Airport, float64, bool) {
+	if d == nil {
+		return nil, 0, false
+	}
+	dLat := maxDist / 111320
+	dLon := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	best, bestDist, bestScore := -1, 0.0, math.Inf(1)
+	for _, id := range d.aptIndex.InBBox(lon-dLon, lat-dLat, lon+dLon, lat+dLat) {
+		a := d.Airports[id]
+		if typeRank[a.Type] > 1 {
+			continue
+		}
+		dist := geo.Haversine(lat, lon, a.Lat, a.Lon)
+		if dist > maxDist {
+			continue
+		}
+		dev := 0.0
+		if dist > NearRadius {
+			// close to the airport the bearing to its reference point says little
+			dev = angleDiff(bearing, geo.Bearing(lat, lon, a.Lat, a.Lon))
+		}
+		if dev > maxDev {
+			continue
+		}
+		if score := dev/maxDev + dist/maxDist; score < bestScore {
+			best, bestDist, bestScore = id, dist, score
+		}
+	}
+	if best < 0 {
+		return nil, 0, false
+	}
+	return &d.Airports[best], bestDist, true
+}
+
+// NearRunway reports whether a position is within radius meters of a runway of the current
+// dataset, measured from the runway midpoint less half its length (of an airport reference
+// point when no runways are loaded).
+func NearRunway(lat, lon, radius float64) bool {
+	d := Get()
+	if d == nil {
+		return false
+	}
+	if len(d.Runways) == 0 {
+		id, _ := d.aptIndex.Nearest(lat, lon, radius, nil)
+		return id >= 0
+	}
+	// the longest runways are about 5.5 km, so midpoints beyond radius+3 km never qualify
+	id, _ := d.rwyIndex.Nearest(lat, lon, radius+3000, func(id int) bool {
+		e := d.Runways[id].Ends
+		mid := geo.Haversine(lat, lon, (e[0].Lat+e[1].Lat)/2, (e[0].Lon+e[1].Lon)/2)
+		return mid-d.Runways[id].LengthM/2 <= radius
+	})
+	return id >= 0
+}
+
+// Fix is an aircraft position sample used for runway matching (alt in meters MSL, 0 = on ground).
+type Fix struct {
+	Lat, Lon, Alt, Track, Speed float64
+	TS                          int64
+}
+
+// Movement is a landing or takeoff matched to a runway end.
+type Movement struct {
+	Airport string `json:"airport"`
+	Runway  string `json:"runway"` // runway end ident in the direction of movement, e.g. "08L"
+	Kind    string `json:"kind"`   // "landing" or "takeoff"
+}
+
+// Matching thresholds.
+const (
+	maxHeadingDiff  = 20.0   // degrees between aircraft track and runway course
+	maxCrossTrack   = 600.0  // meters from the extended centerline
+	maxHeightAbove  = 600.0  // meters above the threshold
+	approachLength  = 15000. // meters before the threshold considered final approach
+	departureLength = 8000.  // meters beyond the far threshold considered initial climb
+)
+
+// MatchMovement classifies the step prev -> cur as a landing or takeoff on a runway, if any.
+// Descending (or touching down) aircraft aligned with a runway are landings; climbing ones are takeoffs.
+func (d *DB) MatchMovement(prev, cur Fix) (Movement, bool) {
+	if d == nil || len(d.Runways) == 0 {
+		return Movement{}, false
+	}
+	dAlt := cur.Alt - prev.Alt
+	var kind string
+	switch {
+	case cur.Alt == 0 && prev.Alt > 0, cur.Alt > 0 && dAlt < -1:
+		kind = "landing"
+	case cur.Alt > 0 && dAlt > 1:
+		kind = "takeoff"
+	default:
+		return Movement{}, false
+	}
+	track := cur.Track
+	if track == 0 && (prev.Lat != cur.Lat || prev.Lon != cur.Lon) {
+		track = geo.Bearing(prev.Lat, prev.Lon, cur.Lat, cur.Lon)
+	}
+	best, bestXT := Movement{}, math.Inf(1)
+	ids := nearbyRunways(d, cur.Lat, cur.Lon)
+	for _, id := range ids {
+		rw := d.Runways[id]
+		for i, end := range rw.Ends {
+			if angleDiff(track, end.Heading) > maxHeadingDiff {
+				continue
+			}
+			if cur.Alt > 0 && cur.Alt-end.ElevationM > maxHeightAbove {
+				continue
+			}
+			dist := geo.Haversine(end.Lat, end.Lon, cur.Lat, cur.Lon)
+			brg := geo.Bearing(end.Lat, end.Lon, cur.Lat, cur.Lon)
+			rel := (brg - end.Heading) * math.Pi / 180
+			xt := math.Abs(math.Asin(math.Sin(dist/geo.EarthRadius)*math.Sin(rel)) * geo.EarthRadius)
+			at := math.Acos(math.Max(-1, math.Min(1, math.Cos(dist/geo.EarthRadius)/math.Cos(xt/geo.EarthRadius)))) * geo.EarthRadius
+			if math.Cos(rel) < 0 {
+				at = -at // before the threshold
+			}
+			if xt > maxCrossTrack {
+				continue
+			}
+			if kind == "landing" && (at < -approachLength || at > rw.LengthM) {
+				continue
+			}
+			if kind == "takeoff" && (at < 0 || at > rw.LengthM+departureLength) {
+				continue
+			}
+			if xt < bestXT {
+				best, bestXT = Movement{Airport: rw.Airport, Runway: rw.Ends[i].Ident, Kind: kind}, xt
+			}
+		}
+	}
+	return best, best.Runway != ""
+}
+
+func nearbyRunways(d *DB, lat, lon float64) []int {
+	ids := []int{}
+	seen := map[int]struct{}{}
+	accept := func(id int) bool {
+		_, ok := seen[id]
+		return !ok
+	}
+	// a handful of nearest runway midpoints within approach range is plenty
+	for len(ids) < 8 {
+		id, _ := d.rwyIndex.Nearest(lat, lon, approachLength+5000, accept)
+		if id < 0 {
+			break
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func angleDiff(a, b float64) float64 { return math.Abs(math.Mod(a-b+540, 360) - 180) }
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/backend"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/urfave/cli/v3"
+)
+
+// Export dumps the position history of the database at storage.path to an archive
+// (miniflightradar export [--from T] [--to T] [--bbox B] FILE); FILE "-" is stdout and a .gz
+// name is compressed.
+func Export(ctx context.Context, c *cli.Command) error {
+	f, st, err := openArchiveStore(c, "export")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	path := c.Args().First()
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		defer out.Close()
+	}
+	var w io.Writer = out
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(out)
+		w = zw
+	}
+	start := time.Now()
+	stats, err := st.Export(w, f)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && path != "-" {
+		err = out.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	log.Printf("export: %d sample(s), %d callsign mapping(s) written to %s (%d outside the filter) in %s",
+		stats.Points, stats.Callsigns, path, stats.Skipped, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Import loads an archive written by Export into the database at storage.path
+// (miniflightradar import [--from T] [--to T] [--bbox B] FILE); FILE "-" is stdin and a .gz
+// name is decompressed.
+func Import(ctx context.Context, c *cli.Command) error {
+	f, st, err := openArchiveStore(c, "import")
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	path := c.Args().First()
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		in, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		defer in.Close()
+		r = in
+	}
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	start := time.Now()
+	stats, err := st.Import(r, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("import: %d sample(s) (%d already stored), %d callsign mapping(s) from %s; skipped %d outside the filter and %d beyond retention in %s",
+		stats.Points, stats.Replaced, stats.Callsigns, path, stats.Skipped, stats.Expired, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// openArchiveStore parses the archive filter flags and opens the store with the storage flags
+// of the server. The server must not run on the same files meanwhile.
+func openArchiveStore(c *cli.Command, name string) (storage.ArchiveFilter, *storage.Store, error) {
+	var f storage.ArchiveFilter
+	if c.NArg() != 1 {
+		return f, nil, fmt.Errorf("%s: want one archive FILE (or - for standard input/output)", name)
+	}
+	from, err := parseReplayTime(c.String("from"))
+	if err != nil {
+		return f, nil, fmt.Errorf("%s: --from: %w", name, err)
+	}
+	to, err := parseReplayTime(c.String("to"))
+	if err != nil {
+		return f, nil, fmt.Errorf("%s: --to: %w", name, err)
+	}
+	if !from.IsZero() {
+		f.From = from.Unix()
+	}
+	if !to.IsZero() {
+		f.To = to.Unix()
+	}
+	if f.From > 0 && f.To > 0 && f.From > f.To {
+		return f, nil, fmt.Errorf("%s: --from is after --to", name)
+	}
+	if s := strings.TrimSpace(c.String("bbox")); s != "" {
+		b, err := backend.ParseBBox(s)
+		if err != nil {
+			return f, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		f.BBox = &b
+	}
+	storage.SetShards(int(c.Int("storage.shards")), nil)
+	st, err := storage.Open(c.String("storage.path"), c.Duration("opensky.retention"))
+	if err != nil {
+		return f, nil, fmt.Errorf("%s: open storage: %w", name, err)
+	}
+	return f, st, nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// compressTypes are the response types worth compressing. Images, fonts, archives and
+// protobuf are left alone: they are compressed already or do not shrink.
+var compressTypes = []string{
+	"text/html", "text/css", "text/plain", "text/csv", "text/javascript", "text/xml",
+	"text/event-stream",
+	"application/javascript", "application/json", "application/problem+json",
+	"application/manifest+json", "application/geo+json", "application/x-ndjson",
+	"application/xml", "application/gpx+xml", "application/vnd.google-earth.kml+xml",
+	"image/svg+xml",
+}
+
+// noCompressPrefixes are routes whose bodies are never compressed: the OTLP proxy (exporters
+// compress themselves), offline tiles (stored compressed) and the generated PNG icons.
+var noCompressPrefixes = []string{"/otel/", "/tiles/offline/", "/icons/"}
+
+// streamTypes are flushed after every write so compression does not hold back events.
+var streamTypes = map[string]bool{"text/event-stream": true, "application/x-ndjson": true}
+
+// compressMiddleware gzip/deflate-compresses responses of compressTypes, except on
+// noCompressPrefixes; streamed NDJSON and SSE responses are compressed and flushed on write.
+func compressMiddleware(level int) func(http.Handler) http.Handler {
+	compress := middleware.Compress(level, compressTypes...)
+	return func(next http.Handler) http.Handler {
+		compressed := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&flushOnWrite{ResponseWriter: w}, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range noCompressPrefixes {
+				if strings.HasPrefix(r.URL.Path, p) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			compressed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// flushOnWrite flushes the (compressing) writer after each write of a streaming content type.
+type flushOnWrite struct {
+	http.ResponseWriter
+	checked, stream bool // content type inspected on the first write
+}
+
+func (f *flushOnWrite) Write(b []byte) (int, error) {
+	if !f.checked {
+		ct, _, _ := mime.ParseMediaType(f.Header().Get("Content-Type"))
+		f.checked, f.stream = true, streamTypes[ct]
+	}
+	n, err := f.ResponseWriter.Write(b)
+	if err == nil && f.stream {
+		f.Flush()
+	}
+	return n, err
+}
+
+func (f *flushOnWrite) Flush() {
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (f *flushOnWrite) Unwrap() http.ResponseWriter { return f.ResponseWriter }
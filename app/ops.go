@@ -0,0 +1,57 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/maniack/miniflightradar/backend"
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// opsRouter serves the operational endpoints of the metrics.listen listener: /metrics (unless
+// disabled), /healthz and the pprof profiles under /debug/pprof/. It has no authentication;
+// the listener is meant to be bound to localhost or a cluster-internal network.
+func opsRouter(prometheus bool) http.Handler {
+	r := chi.NewRouter()
+	r.MethodNotAllowed(methodNotAllowed)
+	r.Use(middleware.Recoverer)
+	if prometheus {
+		r.Handle("/metrics", monitoring.PrometheusHandler())
+	}
+	r.Get("/healthz", backend.HealthHandler)
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.Handle("/debug/pprof/{name}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(chi.URLParam(r, "name")).ServeHTTP(w, r)
+	}))
+	return r
+}
+
+// startOps starts the operational listener on addr and returns its server, or nil when addr
+// is empty. Listener errors are reported on errCh.
+func startOps(addr string, prometheus bool, errCh chan<- error) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           opsRouter(prometheus),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		// No WriteTimeout: CPU profiles and traces stream for ?seconds=
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+	log.Printf("Operational endpoints listening on %s (/metrics, /healthz, /debug/pprof/)", addr)
+	return srv
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maniack/miniflightradar/backend"
+	"github.com/urfave/cli/v3"
+)
+
+// replayer replaces OpenSky polling in Run when the replay subcommand is used.
+var replayer *backend.Replayer
+
+// Replay serves a recording through the normal HTTP/WS API (miniflightradar replay FILE). The
+// replayed positions are stored in a temporary database removed on exit, so the recording is
+// never modified and may be the file of a running server.
+func Replay(ctx context.Context, c *cli.Command) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("replay: want one recording FILE (a database file or a clip export in JSON)")
+	}
+	from, err := parseReplayTime(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("replay: --from: %w", err)
+	}
+	to, err := parseReplayTime(c.String("to"))
+	if err != nil {
+		return fmt.Errorf("replay: --to: %w", err)
+	}
+	if c.Float("speed") <= 0 {
+		return fmt.Errorf("replay: --speed must be positive")
+	}
+	r, err := backend.OpenReplay(backend.ReplayConfig{Path: c.Args().First(), Speed: c.Float("speed"), Loop: c.Bool("loop"), From: from, To: to})
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "miniflightradar-replay-")
+	if err != nil {
+		r.Close()
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := c.Set("storage.path", filepath.Join(dir, "flight.buntdb")); err != nil {
+		r.Close()
+		return err
+	}
+	replayer = r
+	return Run(ctx, c)
+}
+
+// parseReplayTime accepts RFC 3339 or "2006-01-02T15:04" (UTC); empty is the zero time.
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04", s)
+}
@@ -2,18 +2,36 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/maniack/miniflightradar/scheduler"
 	"github.com/maniack/miniflightradar/security"
 	"github.com/urfave/cli/v3"
+	"google.golang.org/grpc"
 
+	"github.com/maniack/miniflightradar/aircraftdb"
+	"github.com/maniack/miniflightradar/airports"
 	"github.com/maniack/miniflightradar/backend"
+	"github.com/maniack/miniflightradar/chaos"
+	"github.com/maniack/miniflightradar/config"
+	"github.com/maniack/miniflightradar/features"
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/geocode"
+	"github.com/maniack/miniflightradar/httpclient"
 	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
 	"github.com/maniack/miniflightradar/storage"
+	"github.com/maniack/miniflightradar/terrain"
+	"github.com/maniack/miniflightradar/tiles"
 	"github.com/maniack/miniflightradar/ui"
 )
 
@@ -28,36 +46,396 @@ func Run(ctx context.Context, c *cli.Command) error {
 	poll := c.Duration("opensky.interval")
 	proxy := c.String("server.proxy")
 
+	// Keep recent log records in memory for the admin log stream
+	monitoring.CaptureLogs(c.Int("log.buffer"))
+	if err := monitoring.SetCrashDir(c.String("crash.dir"), c.Int("crash.keep")); err != nil {
+		return fmt.Errorf("crash dir: %w", err)
+	}
+
 	// Logging level (override env if flag provided)
 	if c.Bool("debug") {
 		monitoring.SetLogLevel("debug")
 	}
+	if err := monitoring.ConfigureSubsystemLogs(c.String("log.debug.subsystems"), c.Int("log.debug.sample"), c.Float("log.debug.rate")); err != nil {
+		return err
+	}
+	monitoring.ConfigureRedaction(c.String("log.redact.params"), c.String("log.redact.headers"), c.String("log.headers"))
+	if p := c.String("log.access.path"); p != "" {
+		if err := monitoring.OpenAccessLog(monitoring.AccessLogConfig{
+			Path:       p,
+			Format:     c.String("log.access.format"),
+			MaxSize:    int64(c.Int("log.access.max_size_mb")) << 20,
+			MaxAge:     c.Duration("log.access.max_age"),
+			MaxBackups: c.Int("log.access.max_backups"),
+		}); err != nil {
+			return fmt.Errorf("access log: %w", err)
+		}
+		defer monitoring.CloseAccessLog()
+	}
 
 	// Tracing
 	shutdownTracer := monitoring.InitTracer(tracingEndpoint, "mini-flightradar")
 	defer shutdownTracer()
 
 	// Configure and initialize auth (loads/persists JWT secret) early so WS path can validate immediately
-	security.ConfigureJWT(c.String("security.jwt.secret"), c.String("security.jwt.file"))
+	// The secret file lives next to the database by default, not relative to the working directory
+	jwtFile := c.String("security.jwt.file")
+	if strings.TrimSpace(jwtFile) == "" {
+		jwtFile = filepath.Join(filepath.Dir(c.String("storage.path")), "jwt.secret")
+	}
+	security.ConfigureJWT(c.String("security.jwt.secret"), jwtFile)
 	security.InitAuth()
+	security.ConfigureAdmin(c.String("security.admin.token"))
+	security.ConfigureHooks(c.String("security.hooks.secret"))
+	if err := security.ConfigureAPIKeys(c.String("security.apikeys.file")); err != nil {
+		return err
+	}
+	if err := security.ConfigurePublic(c.String("security.public")); err != nil {
+		return err
+	}
+
+	// Regional OpenSky polling (optional) instead of the whole world
+	regions, err := backend.ParseRegions(c.String("opensky.regions"))
+	if err != nil {
+		return fmt.Errorf("opensky.regions: %w", err)
+	}
 
 	// Open storage and start ingestor
-	if _, err := storage.Open(c.String("storage.path"), retention); err != nil {
+	nowTTL := c.Duration("storage.now_ttl")
+	if nowTTL <= 0 {
+		// aircraft must survive the longest poll interval
+		slowest := poll
+		for _, rg := range regions {
+			slowest = max(slowest, rg.Interval)
+		}
+		nowTTL = storage.NowTTLFor(slowest)
+	}
+	storage.SetNowTTL(nowTTL)
+	storage.SetShards(int(c.Int("storage.shards")), nil)
+	maxSize, err := parseSize(c.String("storage.max_size"))
+	if err != nil {
+		return fmt.Errorf("storage.max_size: %w", err)
+	}
+	storage.SetCompaction(storage.CompactConfig{
+		MaxSize:        maxSize,
+		DownsampleAge:  c.Duration("storage.downsample_after"),
+		DownsampleKeep: int(c.Int("storage.downsample_keep")),
+	})
+	if c.Bool("storage.migrate_dry_run") {
+		res, err := storage.DryRunMigrations(c.String("storage.path"))
+		if err != nil {
+			return err
+		}
+		log.Printf("storage schema v%d: %d pending migration(s); nothing was changed", storage.SchemaVersion(), len(res))
+		return nil
+	}
+	if st, err := storage.Open(c.String("storage.path"), retention); errors.Is(err, storage.ErrMigration) {
+		return err
+	} else if err != nil {
 		log.Printf("failed to open storage: %v", err)
+	} else {
+		st.SetEventLog(c.Duration("storage.event_log"))
+	}
+	// Ingest filters (regional deployments fetching global data)
+	filter := storage.IngestFilter{
+		AirborneOnly:  c.Bool("ingest.airborne_only"),
+		ExcludeGround: c.Bool("ingest.exclude_ground_vehicles"),
+	}
+	if area := strings.TrimSpace(c.String("ingest.area")); area != "" {
+		var err error
+		if strings.HasSuffix(area, ".json") || strings.HasSuffix(area, ".geojson") {
+			var b []byte
+			if b, err = os.ReadFile(area); err == nil {
+				filter.Areas, err = geo.ParseGeoJSONPolygons(b)
+			}
+		} else {
+			filter.Areas, err = geo.ParsePolygons(area)
+		}
+		if err != nil {
+			log.Printf("invalid ingest.area: %v", err)
+		}
+	}
+	storage.SetIngestFilter(filter)
+	// Landed heuristic: aircraft considered parked/taxiing are hidden from current views
+	storage.SetLandedPolicy(storage.LandedPolicy{
+		Window:       c.Duration("landed.window"),
+		MaxSpeed:     c.Float("landed.max_speed"),
+		MaxMove:      c.Float("landed.max_move"),
+		MaxAltChange: c.Float("landed.max_alt_change"),
+		GroundSpeed:  c.Float("landed.ground_speed"),
+		RunwayRadius: c.Float("landed.runway_radius"),
+	})
+	// Web UI from disk instead of the embedded build (optional)
+	if err := ui.SetDir(strings.TrimSpace(c.String("ui.dir"))); err != nil {
+		return err
+	}
+	// White-label branding templated into index.html and served at /api/config
+	if err := ui.SetBranding(ui.Branding{
+		Title:       c.String("ui.title"),
+		Logo:        c.String("ui.logo"),
+		Color:       c.String("ui.color"),
+		Attribution: c.String("ui.attribution"),
+	}); err != nil {
+		return err
+	}
+	// Offline map tiles (optional MBTiles archive)
+	if p := c.String("tiles.mbtiles"); p != "" {
+		if _, err := tiles.Open(p); err != nil {
+			log.Printf("failed to open mbtiles: %v", err)
+		}
+	}
+	// Receiver/home location (optional)
+	if err := geo.SetReceiver(c.String("receiver.location")); err != nil {
+		log.Printf("invalid receiver.location: %v", err)
+	}
+	if err := geo.SetReceiverRange(c.String("receiver.range")); err != nil {
+		log.Printf("invalid receiver.range: %v", err)
+	}
+	if lat, lon, ok := geo.Receiver(); ok {
+		storage.SetRollupArea(lat, lon, geo.ReceiverRange())
+	}
+	storage.SetRareHandler(int(c.Int("rarity.alert_threshold")), backend.AlertRareSighting)
+	if c.Bool("alerts.new_airframe") {
+		storage.SetNewAirframeHandler(backend.AlertNewAirframe)
+	}
+	// Optional subsystems switched off for this deployment (toggled at /api/admin/features)
+	if err := features.Configure(c.String("features")); err != nil {
+		return err
+	}
+	// Geofence/pattern alerts (rules are managed via /api/alerts)
+	backend.SetAlertWebhook(c.String("alerts.webhook"))
+	storage.AddObserver(backend.ObserveAlerts)
+	// Offline reverse geocoding dataset (optional)
+	if p := c.String("geocode.cities"); p != "" {
+		files := geocode.Files{
+			Cities:         p,
+			Admin1:         c.String("geocode.admin1"),
+			Countries:      c.String("geocode.countries"),
+			AlternateNames: c.String("geocode.alternate_names"),
+		}
+		if langs := strings.TrimSpace(c.String("geocode.languages")); langs != "" {
+			files.Languages = strings.Split(langs, ",")
+		}
+		if _, err := geocode.Load(files); err != nil {
+			log.Printf("failed to load geocoder dataset: %v", err)
+		}
+	}
+	// Noise-exposure events around a monitoring point (defaults to the receiver location)
+	noiseAt := c.String("noise.location")
+	if strings.TrimSpace(noiseAt) == "" {
+		noiseAt = c.String("receiver.location")
+	}
+	if strings.TrimSpace(noiseAt) != "" {
+		lat, lon, err1 := geo.ParseLatLon(noiseAt)
+		radius, err2 := geo.ParseDistances(c.String("noise.radius"), geo.Kilometer)
+		if err1 != nil || err2 != nil || len(radius) != 1 {
+			log.Printf("invalid noise configuration: location=%q radius=%q", noiseAt, c.String("noise.radius"))
+		} else {
+			backend.SetNoiseConfig(&backend.NoiseConfig{Lat: lat, Lon: lon, Radius: radius[0], MaxAlt: float64(c.Int("noise.max_alt_ft")) * geo.Foot})
+			storage.AddObserver(backend.ObserveNoise)
+		}
+	}
+	// Aircraft registration database (optional): registration, type and operator per ICAO24
+	if p := c.String("aircraftdb.path"); p != "" {
+		if _, err := aircraftdb.Load(p); err != nil {
+			log.Printf("failed to load aircraft database: %v", err)
+		} else {
+			storage.SetTypeResolver(aircraftdb.TypeCode)
+			storage.SetAircraftSource(aircraftdb.Info)
+		}
+	}
+	// Airport and runway dataset (optional): runway usage from detected landings/takeoffs
+	if p := c.String("airports.path"); p != "" {
+		if _, err := airports.Load(p, c.String("airports.runways")); err != nil {
+			log.Printf("failed to load airports: %v", err)
+		} else {
+			storage.AddObserver(backend.ObserveRunways)
+			storage.SetRunwayProximity(airports.NearRunway)
+			storage.SetAirportLookup(airports.NearestIdent)
+		}
+	}
+	// Terrain elevation for AGL (optional): local DEM tiles or external API
+	terrain.Configure(c.String("terrain.dem_dir"), c.String("terrain.api"))
+	if terrain.Enabled() {
+		storage.SetElevationSource(terrain.Cached)
 	}
 	// Configure poll interval
 	backend.SetPollInterval(poll)
-	// Configure proxy for backend HTTP client
-	backend.SetProxy(proxy)
-	backend.SetEnvProxies(c.String("net.http_proxy"), c.String("net.https_proxy"), c.String("net.all_proxy"))
-	backend.SetNoProxy(c.String("net.no_proxy"))
+	// Configure proxies, TLS, DNS and the egress allow-list of the outbound HTTP clients
+	httpclient.SetProxy(proxy)
+	httpclient.SetEnvProxies(c.String("net.http_proxy"), c.String("net.https_proxy"), c.String("net.all_proxy"))
+	httpclient.SetNoProxy(c.String("net.no_proxy"))
+	if err := httpclient.SetTLS(c.String("net.tls.ca_file"), c.Bool("net.tls.insecure_skip_verify")); err != nil {
+		return err
+	}
+	if err := httpclient.SetDNS(c.Duration("net.dns.cache_ttl"), c.String("net.dns.resolvers")); err != nil {
+		return err
+	}
+	if err := httpclient.SetEgressAllow(c.String("net.egress.allow")); err != nil {
+		return err
+	}
 	// Configure OpenSky credentials
 	backend.SetOpenSkyCredentials(c.String("opensky.user"), c.String("opensky.pass"))
+	backend.SetOpenSkyOAuth(c.String("opensky.client_id"), c.String("opensky.client_secret"))
+	// Alternative REST feed (optional) instead of OpenSky
+	err = backend.ConfigureSource(backend.SourceConfig{
+		Provider: c.String("source.provider"),
+		URL:      c.String("source.api_url"),
+		APIKey:   c.String("source.api_key"),
+		Rate:     c.Duration("source.api_rate"),
+	}, len(regions) > 0)
+	if err != nil {
+		return err
+	}
+
+	// Feature inventory for fleet operators (miniflightradar_feature_enabled)
+	_, _, hasReceiver := geo.Receiver()
+	monitoring.SetFeatures(map[string]bool{
+		"local_receiver": hasReceiver,
+		"terrain":        terrain.Enabled(),
+		"geocode":        geocode.Get() != nil,
+		"airports":       airports.Get() != nil,
+		"aircraftdb":     aircraftdb.Get() != nil,
+		"noise":          backend.GetNoiseConfig() != nil,
+		"offline_tiles":  tiles.Get() != nil,
+		"ingest_filter":  len(filter.Areas) > 0 || filter.AirborneOnly || filter.ExcludeGround,
+		"event_log":      c.Duration("storage.event_log") > 0,
+		"tracing":        tracingEndpoint != "",
+		"remote_write":   c.String("metrics.remote_write.url") != "",
+		"statsd":         c.String("metrics.statsd.addr") != "",
+		"mqtt":           c.String("output.mqtt.broker") != "",
+		"access_log":     c.String("log.access.path") != "",
+		"admin_api":      strings.TrimSpace(c.String("security.admin.token")) != "",
+		"api_keys":       strings.TrimSpace(c.String("security.apikeys.file")) != "",
+		"grpc":           strings.TrimSpace(c.String("grpc.listen")) != "",
+		"tls_insecure":   c.Bool("net.tls.insecure_skip_verify"),
+		"egress_allow":   strings.TrimSpace(c.String("net.egress.allow")) != "",
+		"cluster":        strings.TrimSpace(c.String("cluster.redis")) != "",
+		"chaos":          chaos.Enabled,
+	})
+
+	// Optional Prometheus remote-write push (for setups without a local scraper)
+	monitoring.StartRemoteWrite(ctx, monitoring.RemoteWriteConfig{
+		URL:      c.String("metrics.remote_write.url"),
+		Interval: c.Duration("metrics.remote_write.interval"),
+		Username: c.String("metrics.remote_write.username"),
+		Password: c.String("metrics.remote_write.password"),
+		Client:   httpclient.New("remote_write", 15*time.Second),
+		Prefix:   c.String("metrics.push.prefix"),
+	})
+	// Optional StatsD/DogStatsD sink
+	monitoring.StartStatsD(ctx, monitoring.StatsDConfig{
+		Addr:     c.String("metrics.statsd.addr"),
+		Flavor:   c.String("metrics.statsd.flavor"),
+		Prefix:   c.String("metrics.statsd.prefix"),
+		Interval: c.Duration("metrics.statsd.interval"),
+		Filter:   c.String("metrics.push.prefix"),
+	})
 
 	stop := make(chan struct{})
-	go backend.IngestLoop(stop)
+	backend.StartWarmup(c.Duration("server.warmup"))
+	backend.SetViewport(c.Float("server.viewport_margin"), c.Float("server.viewport_hysteresis"))
+	if l := c.Int("server.ws_deflate_level"); l < 1 || l > 9 {
+		return fmt.Errorf("server.ws_deflate_level: want 1..9, got %d", l)
+	}
+	backend.SetWSDeflate(c.Bool("server.ws_deflate"), int(c.Int("server.ws_deflate_level")), c.Bool("server.ws_deflate_context_takeover"))
+	// Periodic jobs; the first ingest runs immediately to reduce startup latency and rollups
+	// give it a head start
+	switch {
+	case replayer != nil:
+		// replay subcommand: the recording replaces OpenSky
+		defer replayer.Close()
+		scheduler.Register(scheduler.Job{Name: "replay", Interval: time.Second, Run: replayer.Step})
+		regions = nil
+	case len(regions) == 0:
+		scheduler.Register(scheduler.Job{Name: "ingest", Interval: backend.GetPollInterval(), Run: backend.IngestOnce})
+	}
+	for i, rg := range regions {
+		// one job per region with its own schedule and backoff; starts are staggered
+		scheduler.Register(scheduler.Job{Name: "ingest." + rg.Name, Interval: rg.PollInterval(), Delay: time.Duration(i) * 2 * time.Second, Run: rg.Ingest})
+	}
+	scheduler.Register(scheduler.Job{Name: "stats", Interval: time.Hour, Delay: 30 * time.Second, Jitter: 0.1, Run: backend.RollupStats})
+	if d := c.Duration("storage.compact_interval"); d > 0 {
+		scheduler.Register(scheduler.Job{Name: "compact", Interval: d, Delay: 5 * time.Minute, Jitter: 0.1, Run: backend.CompactStorage})
+	}
+	// Position output to an MQTT broker (optional); the observer must be in place before ingest
+	if err := backend.StartMQTT(backend.MQTTConfig{Broker: c.String("output.mqtt.broker"), TopicPrefix: c.String("output.mqtt.topic_prefix")}, stop); err != nil {
+		return err
+	}
+	// Cluster mode: only the elected leader polls OpenSky (optional)
+	if addr := strings.TrimSpace(c.String("cluster.redis")); addr != "" && replayer == nil {
+		err := backend.StartCluster(backend.ClusterConfig{
+			Redis:  addr,
+			Prefix: c.String("cluster.prefix"),
+			NodeID: strings.TrimSpace(c.String("cluster.node_id")),
+			Lease:  c.Duration("cluster.lease"),
+			// A new leader polls at once instead of waiting for the next scheduled run
+			OnLeader: func() {
+				for _, j := range scheduler.Jobs() {
+					if j.Name == "ingest" || strings.HasPrefix(j.Name, "ingest.") {
+						scheduler.Trigger(j.Name)
+					}
+				}
+			},
+		}, stop)
+		if err != nil {
+			return err
+		}
+	}
+	scheduler.Start(stop)
+	// Local ADS-B receiver feed alongside OpenSky (optional)
+	if replayer == nil {
+		backend.StartSBS(backend.SBSConfig{Addr: c.String("source.sbs.addr"), Flush: c.Duration("source.sbs.flush")}, stop)
+	}
+	backend.StartAlerts(stop)
+	backend.StartClockWatch(c.Duration("server.clock_jump"), stop)
+	backend.SetShedConfig(backend.ShedConfig{
+		Enabled:      c.Bool("load.shed"),
+		MaxLag:       c.Duration("load.max_lag"),
+		MaxIngest:    c.Duration("load.max_ingest"),
+		BacklogRatio: c.Float("load.ws_backlog_ratio"),
+		Sustain:      10 * time.Second,
+		Recover:      30 * time.Second,
+		DiffInterval: c.Duration("load.diff_interval"),
+		RetryAfter:   30 * time.Second,
+	})
+	backend.StartLoadShedding(stop)
+	wsQuota, err := parseSize(c.String("load.ws_quota"))
+	if err != nil {
+		return fmt.Errorf("load.ws_quota: %w", err)
+	}
+	backend.SetWSQuota(wsQuota, int(c.Int("load.ws_quota_strikes")))
+	backend.SetExportLimits(int(c.Int("export.max_rows")), int64(c.Int("export.max_bytes_mb"))<<20)
+	monitoring.WatchAccessLogReopen(stop)
+
+	routeTimeouts, err := parseRouteTimeouts(c.String("server.route_timeouts"))
+	if err != nil {
+		return err
+	}
+	timeouts := timeoutMiddleware(c.Duration("server.timeout"), append(routeTimeouts, defaultRouteTimeouts...))
+	rateLimit, err := security.ParseRateLimit(c.String("server.ratelimit"))
+	if err != nil {
+		return err
+	}
+	otlpProxy := backend.OTLPProxyConfig{Endpoint: tracingEndpoint}
+	if otlpProxy.Headers, err = backend.ParseOTLPHeaders(c.String("tracing.proxy.headers")); err != nil {
+		return fmt.Errorf("tracing.proxy.headers: %w", err)
+	}
+	if otlpProxy.RateLimit, err = security.ParseRateLimit(c.String("tracing.proxy.ratelimit")); err != nil {
+		return fmt.Errorf("tracing.proxy.ratelimit: %w", err)
+	}
+	for _, sig := range strings.Split(c.String("tracing.proxy.signals"), ",") {
+		switch sig = strings.TrimSpace(sig); sig {
+		case "":
+		case "traces", "metrics", "logs":
+			otlpProxy.Signals = append(otlpProxy.Signals, sig)
+		default:
+			return fmt.Errorf("tracing.proxy.signals: unknown signal %q (traces, metrics, logs)", sig)
+		}
+	}
 
 	r := chi.NewRouter()
+	r.MethodNotAllowed(methodNotAllowed)
 	// Global minimal middlewares (must be added before any routes on this mux)
 	// Keep only ones that don't wrap ResponseWriter in a way that breaks Hijacker.
 	r.Use(middleware.Recoverer)
@@ -68,19 +446,27 @@ func Run(ctx context.Context, c *cli.Command) error {
 
 	// WebSocket endpoint on the root router without extra wrapping middlewares
 	// to ensure http.Hijacker works during upgrade.
-	r.Get("/ws/flights", backend.FlightsWSHandler)
+	r.With(security.APIKeyMiddleware).Get("/ws/flights", backend.FlightsWSHandler)
+	r.With(features.Require("alerts"), security.APIKeyMiddleware).Get("/ws/alerts", backend.AlertsWSHandler)
+	// SSE fallback of /ws/flights; outside the subrouter (no ETag buffering), compressed with a
+	// flush after every event
+	compress := compressMiddleware(5)
+	r.With(security.APIKeyMiddleware, compress, timeouts).Get("/sse/flights", backend.FlightsSSEHandler)
+	// Admin live log stream (SSE) outside the subrouter so the timeout does not cut it
+	r.With(security.AdminMiddleware, compress, timeouts).Get("/api/admin/logs/stream", monitoring.LogStreamHandler)
 	// Health endpoint for heartbeat checks (no auth)
 	r.Get("/healthz", backend.HealthHandler)
 
 	// Frontend OTEL proxy endpoint (bypass security middleware). Sends to tracing.endpoint
-	r.HandleFunc("/otel/v1/traces", backend.OTLPTracesProxy(tracingEndpoint))
+	r.HandleFunc("/otel/v1/{signal}", backend.OTLPProxy(otlpProxy))
 
 	// Subrouter for regular HTTP routes with full middleware stack
 	api := chi.NewRouter()
-	// Enable gzip/deflate compression for API and static responses
-	api.Use(middleware.Compress(5))
-	// Request timeout
-	api.Use(middleware.Timeout(15 * time.Second))
+	api.MethodNotAllowed(methodNotAllowed)
+	// Enable gzip/deflate compression for API and static responses (text types only; see compress.go)
+	api.Use(compress)
+	// Request timeouts per route (exports are longer, streaming handlers are exempt)
+	api.Use(timeouts)
 	// Basic security headers
 	api.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -99,11 +485,110 @@ func Run(ctx context.Context, c *cli.Command) error {
 	// Metrics and structured logging
 	api.Use(monitoring.MetricsMiddleware)
 	api.Use(monitoring.LoggingMiddleware)
+	// Per-client rate limit for /api/* (after metrics/logging so rejections are recorded)
+	api.Use(security.RateLimitMiddleware(rateLimit))
+
+	// /metrics moves to the operational listener when metrics.listen is set
+	opsListen := strings.TrimSpace(c.String("metrics.listen"))
+	if c.Bool("metrics.prometheus") && opsListen == "" {
+		api.Handle("/metrics", monitoring.PrometheusHandler())
+	} else {
+		api.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			problem.Write(w, r, http.StatusNotFound, "not found")
+		})
+	}
 
-	api.Handle("/metrics", monitoring.PrometheusHandler())
+	// Inbound webhooks of orchestration and monitoring systems
+	api.With(security.HooksMiddleware).Post("/api/hooks/refetch", backend.RefetchHook)
+	api.With(security.HooksMiddleware).Post("/api/hooks/purge", backend.PurgeHook)
+	api.With(security.HooksMiddleware).Post("/api/hooks/alert_test", backend.AlertTestHook)
+
+	// Admin endpoints (bearer token instead of cookies/CSRF)
+	api.With(security.AdminMiddleware).Get("/api/admin/log", monitoring.LogConfigHandler)
+	api.With(security.AdminMiddleware).Put("/api/admin/log", monitoring.LogConfigHandler)
+	api.With(security.AdminMiddleware).Get("/api/admin/crashes", monitoring.CrashesHandler)
+	api.With(security.AdminMiddleware).Get("/api/admin/load", backend.LoadStatusHandler)
+	api.With(security.AdminMiddleware).Get("/api/admin/ws", backend.WSClientsHandler)
+	api.With(security.AdminMiddleware).Get("/api/admin/jobs", scheduler.JobsHandler)
+	api.With(security.AdminMiddleware).Post("/api/admin/jobs/{name}/run", scheduler.TriggerHandler)
+	var flags []cli.Flag
+	for _, cmd := range c.Lineage() {
+		flags = append(flags, cmd.Flags...)
+	}
+	api.With(security.AdminMiddleware).Get("/api/admin/config", config.Handler(flags, c.String("config")))
+	api.With(security.AdminMiddleware).Get("/api/admin/features", features.Handler)
+	api.With(security.AdminMiddleware).Put("/api/admin/features", features.Handler)
+	if chaos.Enabled {
+		// Fault injection, only in binaries built with -tags chaos
+		log.Printf("WARNING: built with -tags chaos: faults can be injected through /api/admin/chaos")
+		api.With(security.AdminMiddleware).Get("/api/admin/chaos", chaos.Handler)
+		api.With(security.AdminMiddleware).Put("/api/admin/chaos", chaos.Handler)
+		api.With(security.AdminMiddleware).Delete("/api/admin/chaos", chaos.Handler)
+	}
 
 	// HTTP fallback: all flights (frontend filters)
 	api.Get("/api/flights", backend.AllFlightsHandler)
+	api.Post("/api/flights/bulk", backend.BulkFlightsHandler)
+	// Single flight and current track by callsign
+	api.Get("/api/flight", backend.FlightHandler)
+	api.Get("/api/flight/info", backend.FlightInfoHandler)
+	api.Get("/api/track", backend.TrackHandler)
+	// Prefix/fuzzy search over current callsigns, ICAO24 addresses and registrations
+	api.Get("/api/search", backend.SearchHandler)
+	// OpenAPI 3 description of the REST API (no auth)
+	api.Get("/api/openapi.json", backend.OpenAPIHandler)
+	// Runtime UI configuration (branding, no auth)
+	api.Get("/api/config", ui.ConfigHandler)
+	// Web app manifest and icons generated from the branding
+	api.Get("/manifest.json", ui.ManifestHandler)
+	api.Get("/icons/{file}", ui.IconHandler)
+	// Noise-exposure events (low passes near the monitoring point)
+	api.Get("/api/noise/events", backend.NoiseEventsHandler)
+	api.Get("/api/noise/daily", backend.NoiseDailyHandler)
+	// Runway usage statistics per airport and airports in view (404 when no airport dataset is
+	// configured)
+	api.Get("/api/airport/{icao}/runways/stats", backend.RunwayStatsHandler)
+	api.Get("/api/airports", backend.AirportsHandler)
+	// Aligned track comparison with separation metrics
+	api.Get("/api/track/compare", backend.TrackCompareHandler)
+	// Track download for Google Earth, GPS tools and spreadsheets
+	api.Get("/api/track/export", backend.TrackExportHandler)
+	// Ingest event log: incremental changes and state reconstruction
+	api.Get("/api/changes", backend.ChangesHandler)
+	api.With(features.Require("replay")).Get("/api/changes/state", backend.ChangesStateHandler)
+	api.Get("/api/tombstones", backend.TombstonesHandler)
+	// All-time airframe ledger (first/last seen, sightings)
+	api.Get("/api/ledger", backend.LedgerHandler)
+	// Long-term daily statistics (rollups survive raw retention)
+	api.Get("/api/stats/daily", backend.DailyStatsHandler)
+	api.Get("/api/stats/rarity", backend.RarityHandler)
+	// Short-lived signed URLs for sharing exports/snapshots without cookies
+	api.Post("/api/share", security.SignURLHandler)
+	// Playback bookmarks (clips) and standalone clip export; geofence/pattern alert rules.
+	// Both are optional features (404 while switched off)
+	replay, alerts := features.Require("replay"), features.Require("alerts")
+	api.With(replay, security.RequireAdmin).Get("/api/clips", backend.ClipsHandler)
+	api.With(replay, security.RequireAdmin).Post("/api/clips", backend.ClipsHandler)
+	api.With(replay).Get("/api/clips/{id}", backend.ClipHandler)
+	api.With(replay, security.RequireAdmin).Delete("/api/clips/{id}", backend.ClipHandler)
+	api.With(replay).Get("/api/clips/{id}/export", backend.ClipExportHandler)
+	api.With(alerts).Get("/api/alerts", backend.AlertsHandler)
+	api.With(alerts, security.RequireAdmin).Post("/api/alerts", backend.AlertsHandler)
+	api.With(alerts).Get("/api/alerts/events", backend.AlertEventsHandler)
+	api.With(alerts).Get("/api/alerts/{id}", backend.AlertHandler)
+	api.With(alerts, security.RequireAdmin).Put("/api/alerts/{id}", backend.AlertHandler)
+	api.With(alerts, security.RequireAdmin).Delete("/api/alerts/{id}", backend.AlertHandler)
+	// GeoJSON range rings and bearing radials (around receiver or ?center=)
+	api.Get("/api/rings", backend.RingsHandler)
+	// Offline reverse geocoding (404 when no dataset is configured)
+	api.Get("/api/geocode", geocode.Handler)
+	api.Get("/api/aircraft", aircraftdb.Handler)
+	api.Get("/api/fleet/{airline}", backend.FleetHandler)
+	// Ground elevation lookup (404 when no terrain provider is configured)
+	api.Get("/api/elevation", terrain.ElevationHandler)
+	// Offline map tiles served from the MBTiles archive (404 when not configured)
+	api.Get("/tiles/offline/metadata.json", tiles.MetadataHandler)
+	api.Get("/tiles/offline/{z}/{x}/{y}", tiles.TileHandler)
 	// UI
 	api.Handle("/*", ui.Handler())
 
@@ -128,6 +613,21 @@ func Run(ctx context.Context, c *cli.Command) error {
 		}
 		errCh <- nil
 	}()
+	opsErr := make(chan error, 1)
+	ops := startOps(opsListen, c.Bool("metrics.prometheus"), opsErr)
+	grpcErr := make(chan error, 1)
+	var grpcSrv *grpc.Server
+	if addr := strings.TrimSpace(c.String("grpc.listen")); addr != "" {
+		if grpcSrv, err = backend.StartGRPC(addr, grpcErr); err != nil {
+			_ = srv.Close()
+			<-errCh
+			close(stop)
+			if s := storage.Get(); s != nil {
+				_ = s.Close()
+			}
+			return err
+		}
+	}
 
 	select {
 	case <-ctx.Done():
@@ -138,6 +638,12 @@ func Run(ctx context.Context, c *cli.Command) error {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		_ = srv.Shutdown(shutdownCtx)
+		if ops != nil {
+			_ = ops.Shutdown(shutdownCtx)
+		}
+		if grpcSrv != nil {
+			backend.StopGRPC(shutdownCtx, grpcSrv)
+		}
 		// Stop background ingestion
 		close(stop)
 		// Wait for the server goroutine to exit
@@ -147,6 +653,22 @@ func Run(ctx context.Context, c *cli.Command) error {
 			_ = s.Close()
 		}
 		return nil
+	case err := <-opsErr:
+		_ = srv.Close()
+		<-errCh
+		close(stop)
+		if s := storage.Get(); s != nil {
+			_ = s.Close()
+		}
+		return fmt.Errorf("metrics listener: %w", err)
+	case err := <-grpcErr:
+		_ = srv.Close()
+		<-errCh
+		close(stop)
+		if s := storage.Get(); s != nil {
+			_ = s.Close()
+		}
+		return fmt.Errorf("grpc listener: %w", err)
 	case err := <-errCh:
 		// Server exited (error or nil). Stop ingestor and close storage.
 		close(stop)
@@ -156,3 +678,9 @@ func Run(ctx context.Context, c *cli.Command) error {
 		return err
 	}
 }
+
+// methodNotAllowed answers unsupported methods with a problem document instead of chi's
+// plain-text default.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
+}
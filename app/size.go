@@ -0,0 +1,35 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseSize parses a byte size such as "512MB", "2GiB" or "1073741824" (decimal and binary
+// units; "" and "0" mean unset).
+func parseSize(v string) (int64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mul = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 512MB, 2GiB)", v)
+	}
+	return int64(n * float64(mul)), nil
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// routeTimeout overrides the handler timeout for paths matching pattern (path.Match syntax,
+// "*" matches one segment). A zero duration exempts the route (streaming, long-poll).
+type routeTimeout struct {
+	pattern string
+	d       time.Duration
+}
+
+// defaultRouteTimeouts cover long exports and streaming handlers.
+var defaultRouteTimeouts = []routeTimeout{
+	{"/api/clips/*/export", 2 * time.Minute},
+	{"/api/changes/state", time.Minute},
+	{"/api/admin/logs/stream", 0},
+	{"/sse/flights", 0},
+}
+
+// parseRouteTimeouts parses "pattern=duration,..." (e.g., "/api/clips/*/export=5m,/api/changes=0").
+func parseRouteTimeouts(spec string) ([]routeTimeout, error) {
+	var out []routeTimeout
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pat, dur, ok := strings.Cut(part, "=")
+		pat = strings.TrimSpace(pat)
+		if !ok || !strings.HasPrefix(pat, "/") {
+			return nil, fmt.Errorf("invalid route timeout %q (want /path=duration)", part)
+		}
+		if _, err := path.Match(pat, "/"); err != nil {
+			return nil, fmt.Errorf("invalid route pattern %q: %w", pat, err)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(dur))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid route timeout %q", part)
+		}
+		out = append(out, routeTimeout{pattern: pat, d: d})
+	}
+	return out, nil
+}
+
+// timeoutMiddleware applies the first matching rule's timeout (or def) to the handler context
+// and the connection write deadline; exempt routes have neither.
+func timeoutMiddleware(def time.Duration, rules []routeTimeout) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := map[time.Duration]http.Handler{}
+		handlerFor := func(d time.Duration) http.Handler {
+			if h, ok := limited[d]; ok {
+				return h
+			}
+			h := middleware.Timeout(d)(next)
+			limited[d] = h
+			return h
+		}
+		for _, rt := range rules {
+			if rt.d > 0 {
+				handlerFor(rt.d)
+			}
+		}
+		if def > 0 {
+			handlerFor(def)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := def
+			for _, rt := range rules {
+				if ok, _ := path.Match(rt.pattern, r.URL.Path); ok {
+					d = rt.d
+					break
+				}
+			}
+			rc := http.NewResponseController(w)
+			if d <= 0 {
+				_ = rc.SetWriteDeadline(time.Time{})
+				next.ServeHTTP(w, r)
+				return
+			}
+			// leave room to write the 504 after the handler deadline
+			_ = rc.SetWriteDeadline(time.Now().Add(d + 5*time.Second))
+			limited[d].ServeHTTP(w, r)
+		})
+	}
+}
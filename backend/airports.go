@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/maniack/miniflightradar/airports"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// airportRef names the airport at an end of a track.
+type airportRef struct {
+	Ident     string  `json:"ident"`
+	IATA      string  `json:"iata,omitempty"`
+	Name      string  `json:"name"`
+	DistanceM float64 `json:"distance_m"`      // from the airport reference point
+	Basis     string  `json:"basis,omitempty"` // how it was found, see estimateRoute
+}
+
+// endpointHeight is how far above the airport elevation (meters) a track end still counts as
+// departing from or arriving at it.
+const endpointHeight = 600
+
+// endpointAirport returns the airport a track end is at: the aircraft is on the ground (or
+// taking off) or low above an airport within airports.NearRadius. Track ends at altitude, where
+// the aircraft entered or left coverage, have none.
+func endpointAirport(p storage.Point) *airportRef {
+	a, dist, ok := airports.Get().Nearest(p.Lat, p.Lon, airports.NearRadius)
+	if !ok {
+		return nil
+	}
+	low := p.Ground || p.Phase == storage.PhaseLanded || p.Phase == storage.PhaseTakeoff || p.Alt-a.ElevationM <= endpointHeight
+	if !low {
+		return nil
+	}
+	return &airportRef{Ident: a.Ident, IATA: a.IATA, Name: a.Name, DistanceM: float64(int(dist))}
+}
+
+// AirportsHandler lists the airports of the dataset inside ?bbox= (/api/airports) for map
+// overlays, larger airports first; type= (comma-separated large_airport, medium_airport,
+// small_airport) and limit= (1..5000, default 500) narrow the list.
+func AirportsHandler(w http.ResponseWriter, r *http.Request) {
+	db := airports.Get()
+	if db == nil {
+		problem.Write(w, r, http.StatusNotFound, "airport dataset is not configured")
+		return
+	}
+	b, _, err := queryBBox(r, true)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", 500, 1, 5000)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	var types []string
+	if v := strings.TrimSpace(r.URL.Query().Get("type")); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if !airports.ValidType(t) {
+				problem.WriteError(w, r, invalidParam("type", "want large_airport, medium_airport or small_airport, got %q", t))
+				return
+			}
+			types = append(types, t)
+		}
+	}
+	list, total := db.InBBox(b.MinLon, b.MinLat, b.MaxLon, b.MaxLat, types, limit)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(airportsResponse{Airports: list, Total: total, Truncated: total > len(list)})
+}
@@ -0,0 +1,455 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/features"
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/httpclient"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/security"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// AlertEvent is fired when an aircraft enters or exits a geofence, matches a pattern rule, is
+// a rare sighting or a new airframe. It is the webhook payload and the /ws/alerts message body.
+type AlertEvent struct {
+	Type     string   `json:"type"` // enter, exit, match, rare, new
+	Rule     string   `json:"rule"` // rule ID ("rare_aircraft" for rare sightings, "new_airframe" for new airframes)
+	RuleName string   `json:"rule_name,omitempty"`
+	Icao24   string   `json:"icao24"`
+	Callsign string   `json:"callsign,omitempty"`
+	Lat      float64  `json:"lat"`
+	Lon      float64  `json:"lon"`
+	Alt      float64  `json:"alt,omitempty"`
+	AGL      *float64 `json:"agl,omitempty"` // height above ground (m), when known
+	TS       int64    `json:"ts"`
+}
+
+const (
+	alertWebhookQueue    = 1000
+	alertWebhookAttempts = 3
+)
+
+var (
+	// alertRules is the in-memory copy of the stored rules evaluated on every ingest
+	alertRules   atomic.Pointer[[]storage.AlertRule]
+	alertWebhook string // default webhook URL (alerts.webhook)
+	alertQueue   = make(chan webhookJob, alertWebhookQueue)
+	// webhookClient delivers alert webhooks to alerts.webhook; ruleWebhookClient to the URLs of
+	// rules, which API users choose and must not reach internal addresses
+	webhookClient     = httpclient.New("webhook", 15*time.Second)
+	ruleWebhookClient = httpclient.NewPublic("webhook", 15*time.Second)
+
+	alertSubsMu sync.Mutex
+	alertSubs   = map[chan []byte]struct{}{}
+)
+
+func init() {
+	features.Register("alerts", "Geofence, pattern and rare-aircraft alerts (/api/alerts, /ws/alerts, webhooks)", true)
+}
+
+type webhookJob struct {
+	url    string
+	ev     AlertEvent
+	public bool // the URL of a rule (ruleWebhookClient)
+}
+
+// SetAlertWebhook sets the default webhook URL for alert events (empty: rules without their own
+// webhook only reach /ws/alerts and the event history).
+func SetAlertWebhook(u string) { alertWebhook = strings.TrimSpace(u) }
+
+// LoadAlertRules refreshes the in-memory rule set from storage.
+func LoadAlertRules() error {
+	rules, err := storage.Get().AlertRules()
+	if err != nil {
+		return err
+	}
+	alertRules.Store(&rules)
+	return nil
+}
+
+// StartAlerts loads the stored rules and delivers webhooks until stop is closed.
+func StartAlerts(stop <-chan struct{}) {
+	if err := LoadAlertRules(); err != nil {
+		log.Printf("failed to load alert rules: %v", err)
+	}
+	go monitoring.Supervise("alerts.webhook", stop, func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case j := <-alertQueue:
+				deliverWebhook(j)
+			}
+		}
+	})
+}
+
+func alertMatch(pattern, v string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, v)
+	return ok
+}
+
+func ruleMatches(r storage.AlertRule, p storage.Point) bool {
+	return alertMatch(r.Icao24, strings.ToLower(p.Icao24)) && alertMatch(r.Callsign, strings.ToUpper(strings.TrimSpace(p.Callsign)))
+}
+
+// ruleLow checks the height limit of a rule: airborne less than BelowAGLFt above ground and,
+// with OffAirport, not over an airport. Samples without a terrain height never qualify.
+func ruleLow(r storage.AlertRule, p storage.Point) bool {
+	if r.BelowAGLFt == 0 {
+		return true
+	}
+	if p.Ground || p.AGL == nil || *p.AGL >= r.BelowAGLFt*geo.Foot {
+		return false
+	}
+	return !r.OffAirport || p.Airport == ""
+}
+
+func ruleContains(r storage.AlertRule, p storage.Point) bool {
+	if c := r.Circle; c != nil {
+		return geo.Haversine(c.Lat, c.Lon, p.Lat, p.Lon) <= c.Radius
+	}
+	return geo.Polygon(r.Polygon).Contains(p.Lat, p.Lon)
+}
+
+// ObserveAlerts is a storage observer that evaluates alert rules for each new position.
+func ObserveAlerts(prev *storage.Point, cur storage.Point) {
+	rules := alertRules.Load()
+	if rules == nil || !features.Enabled("alerts") {
+		return
+	}
+	for _, r := range *rules {
+		typ := ""
+		if r.HasFence() {
+			if !ruleMatches(r, cur) {
+				continue
+			}
+			in := ruleContains(r, cur) && ruleLow(r, cur)
+			was := prev != nil && ruleContains(r, *prev) && ruleLow(r, *prev)
+			switch {
+			case in && !was:
+				typ = "enter"
+			case !in && was:
+				typ = "exit"
+			}
+		} else if ruleMatches(r, cur) && ruleLow(r, cur) && (prev == nil || !ruleMatches(r, *prev) || !ruleLow(r, *prev)) {
+			typ = "match"
+		}
+		if typ != "" {
+			emitAlert(AlertEvent{Type: typ, Rule: r.ID, RuleName: r.Name, Icao24: cur.Icao24, Callsign: cur.Callsign,
+				Lat: cur.Lat, Lon: cur.Lon, Alt: cur.Alt, AGL: cur.AGL, TS: cur.TS}, r.Webhook)
+		}
+	}
+}
+
+// AlertRareSighting is the rare_aircraft rule action: it logs the sighting and fires a "rare"
+// alert event.
+func AlertRareSighting(sg storage.Sighting) {
+	LogRareSighting(sg)
+	p := sg.Point
+	emitAlert(AlertEvent{Type: "rare", Rule: "rare_aircraft", Icao24: p.Icao24, Callsign: p.Callsign,
+		Lat: p.Lat, Lon: p.Lon, Alt: p.Alt, TS: p.TS}, "")
+}
+
+// AlertNewAirframe is the new_airframe rule action: it fires a "new" alert for an airframe
+// entering the ledger, i.e. seen for the first time.
+func AlertNewAirframe(sg storage.Sighting) {
+	p := sg.Point
+	emitAlert(AlertEvent{Type: "new", Rule: "new_airframe", Icao24: p.Icao24, Callsign: p.Callsign,
+		Lat: p.Lat, Lon: p.Lon, Alt: p.Alt, AGL: p.AGL, TS: p.TS}, "")
+}
+
+// emitAlert records the event, pushes it to /ws/alerts subscribers and queues the webhook.
+func emitAlert(ev AlertEvent, webhook string) {
+	if !features.Enabled("alerts") {
+		return
+	}
+	monitoring.AlertEvents.WithLabelValues(ev.Type).Inc()
+	monitoring.SubDebugf("ingest", "alert type=%s rule=%s icao24=%s callsign=%s", ev.Type, ev.Rule, ev.Icao24, ev.Callsign)
+	if s := storage.Get(); s != nil {
+		_ = s.AddEvent(storage.Event{Kind: "alert", TS: ev.TS, Icao24: ev.Icao24, Callsign: ev.Callsign, Lat: ev.Lat, Lon: ev.Lon, Alt: ev.Alt,
+			Attrs: map[string]string{"type": ev.Type, "rule": ev.Rule, "rule_name": ev.RuleName}})
+	}
+	b, _ := json.Marshal(map[string]any{"type": "alert", "alert": ev})
+	alertSubsMu.Lock()
+	for ch := range alertSubs {
+		select {
+		case ch <- b:
+		default: // slow subscriber: drop rather than block ingest
+		}
+	}
+	alertSubsMu.Unlock()
+	public := webhook != ""
+	if webhook == "" {
+		// Every cluster instance sees the same states: only the leader calls the global
+		// webhook (rule webhooks fire on the instance storing the rule)
+		if clusterFollower() {
+			return
+		}
+		webhook = alertWebhook
+	}
+	if webhook == "" {
+		return
+	}
+	select {
+	case alertQueue <- webhookJob{url: webhook, ev: ev, public: public}:
+	default:
+		monitoring.AlertWebhooks.WithLabelValues("dropped").Inc()
+	}
+}
+
+// deliverWebhook POSTs the event as JSON, retrying with backoff on errors and 5xx responses.
+func deliverWebhook(j webhookJob) {
+	body, _ := json.Marshal(j.ev)
+	client := webhookClient
+	if j.public {
+		client = ruleWebhookClient
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, j.url, bytes.NewReader(body))
+		if err != nil {
+			monitoring.AlertWebhooks.WithLabelValues("error").Inc()
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "miniflightradar")
+		resp, err := client.Do(req)
+		status := 0
+		if httpclient.IsEgressDenied(err) {
+			attempt = alertWebhookAttempts // not retried either
+		}
+		if err == nil {
+			status = resp.StatusCode
+			resp.Body.Close()
+			if status < 300 {
+				monitoring.AlertWebhooks.WithLabelValues("ok").Inc()
+				return
+			}
+			err = fmt.Errorf("status %d", status)
+			if status < 500 {
+				attempt = alertWebhookAttempts // client errors are not retried
+			}
+		}
+		if attempt >= alertWebhookAttempts {
+			monitoring.AlertWebhooks.WithLabelValues("error").Inc()
+			log.Printf("alert webhook failed url=%s rule=%s: %v", j.url, j.ev.Rule, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// checkWebhookEgress rejects webhook URLs the egress allow-list (--net.egress.allow) or,
+// without one, the public address check of rule webhooks would refuse, so the rule does not
+// fail silently on its first alert. Delivery checks the dialed addresses again.
+func checkWebhookEgress(r *http.Request, raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil // normalize rejects it
+	}
+	if !httpclient.Allowed(u) {
+		return invalidParam("webhook", "host %s is not in the egress allow-list", u.Host)
+	}
+	if err := httpclient.CheckPublic(r.Context(), u); httpclient.IsEgressDenied(err) {
+		return invalidParam("webhook", "%v", err)
+	}
+	return nil
+}
+
+// AlertsHandler lists alert rules (GET) or creates one (POST, admin only: see
+// security.RequireAdmin) owned by the caller from a JSON body:
+// {"name":"...","polygon":[[lat,lon],...]|"circle":{"lat":..,"lon":..,"radius":m},
+// "callsign":"RYR*","icao24":"4ca*","below_agl_ft":500,"off_airport":true,"webhook":"https://..."}.
+func AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var rule storage.AlertRule
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&rule); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		rule.ID, rule.Owner = "", security.AdminPrincipal(r)
+		if err := checkWebhookEgress(r, rule.Webhook); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		created, _, err := storage.Get().SaveAlertRule(rule)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		_ = LoadAlertRules()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/alerts/"+created.ID)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(created)
+		return
+	}
+	rules, err := storage.Get().AlertRules()
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rules)
+}
+
+// AlertHandler returns (GET), replaces (PUT) or deletes (DELETE) one alert rule. Writes are
+// admin only and limited to the rule's owner (403 for others).
+func AlertHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	switch r.Method {
+	case http.MethodDelete:
+		found, err := storage.Get().DeleteAlertRule(id, security.AdminPrincipal(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if !found {
+			problem.Write(w, r, http.StatusNotFound, "alert rule not found")
+			return
+		}
+		_ = LoadAlertRules()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut:
+		var rule storage.AlertRule
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&rule); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		rule.ID, rule.Owner = id, security.AdminPrincipal(r)
+		if err := checkWebhookEgress(r, rule.Webhook); err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		saved, found, err := storage.Get().SaveAlertRule(rule)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if !found {
+			problem.Write(w, r, http.StatusNotFound, "alert rule not found")
+			return
+		}
+		_ = LoadAlertRules()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(saved)
+		return
+	}
+	rules, err := storage.Get().AlertRules()
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	for _, rule := range rules {
+		if rule.ID == id {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(rule)
+			return
+		}
+	}
+	problem.Write(w, r, http.StatusNotFound, "alert rule not found")
+}
+
+// AlertEventsHandler returns fired alerts with from <= ts <= to (unix seconds; default: last 24h),
+// optionally for one ?rule=.
+func AlertEventsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := queryTimeRange(r, 24*time.Hour)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	rule := r.URL.Query().Get("rule")
+	events, err := storage.Get().Events("alert", from, to, func(e storage.Event) bool {
+		return rule == "" || e.Attrs["rule"] == rule
+	})
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(events)
+}
+
+// AlertsWSHandler streams alert events as {"type":"alert","alert":{...}} messages. Auth follows
+// /ws/flights (JWT cookie and ?csrf=).
+func AlertsWSHandler(w http.ResponseWriter, r *http.Request) {
+	public := security.IsPublic(r) || security.HasAPIKey(r)
+	if !public && !security.ValidateJWTFromRequest(r) {
+		monitoring.WSClosures.WithLabelValues("alerts", "auth_failure").Inc()
+		problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if csrfQ := r.URL.Query().Get("csrf"); !public && (csrfQ == "" || csrfQ != security.GetCSRFFromRequest(r)) {
+		monitoring.WSClosures.WithLabelValues("alerts", "auth_failure").Inc()
+		problem.Write(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	ws, err := upgradeToWebSocket(w, r)
+	if err != nil {
+		monitoring.SubDebugf("ws", "upgrade error: %v", err)
+		return
+	}
+	ws.kind = "alerts"
+	cause := "panic"
+	defer monitoring.Recover("ws.alerts")
+	registerWS(ws)
+	ch := make(chan []byte, 64)
+	alertSubsMu.Lock()
+	alertSubs[ch] = struct{}{}
+	alertSubsMu.Unlock()
+	defer func() {
+		alertSubsMu.Lock()
+		delete(alertSubs, ch)
+		alertSubsMu.Unlock()
+		unregisterWS(ws)
+		ws.recordClose(cause)
+		_ = ws.Close()
+	}()
+	monitoring.SubDebugf("ws", "alerts connected remote=%s", r.RemoteAddr)
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			cause = "read_error"
+			if wsShuttingDown.Load() {
+				cause = "server_shutdown"
+			}
+			return
+		case b := <-ch:
+			if err := ws.WriteText(b); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case <-ping.C:
+			if err := ws.WritePing(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		}
+	}
+}
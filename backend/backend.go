@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -14,31 +13,45 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/maniack/miniflightradar/chaos"
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/httpclient"
 	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
 	"github.com/maniack/miniflightradar/storage"
 )
 
-// FlightData is a minimal subset of the OpenSky /api/states/all response used by the ingestor.
+// FlightData is a minimal subset of the OpenSky /api/states/all response used by the ingestor;
+// other sources convert their responses to it.
 type FlightData struct {
+	Time   int64           `json:"time"` // source clock (unix seconds) the states refer to
 	States [][]interface{} `json:"states"`
+	// Skew is the source clock minus the server clock at receipt (0 when time is missing)
+	Skew time.Duration `json:"-"`
 }
 
+// statesCache holds the last source response of a poll target (the world or a region) so
+// requests within the poll interval are not repeated.
+type statesCache struct {
+	mu   sync.Mutex
+	data *FlightData
+	at   time.Time
+	gen  int64 // statesGen when data was fetched
+}
+
+// statesGen invalidates all states caches when incremented (see PurgeStatesCache).
+var statesGen atomic.Int64
+
+// PurgeStatesCache makes the next poll of every target fetch from the source again.
+func PurgeStatesCache() { statesGen.Add(1) }
+
 var (
-	cacheMu   sync.Mutex
-	cacheData *FlightData
-	cacheAt   time.Time
+	worldCache statesCache
 
 	pollInterval = 10 * time.Second
 
-	// HTTP client/proxy configuration
-	proxyOverride string
-	noProxyList   string
-	// CLI-sourced Linux-style proxies (HTTP_PROXY/HTTPS_PROXY/ALL_PROXY)
-	envHTTPProxy  string
-	envHTTPSProxy string
-	envALLProxy   string
-	clientMu      sync.Mutex
-	httpClient    *http.Client
+	// openskyClient polls OpenSky and requests its OAuth tokens
+	openskyClient = httpclient.New("opensky", 15*time.Second)
 
 	// OpenSky credentials (optional)
 	openskyUser string
@@ -98,183 +111,12 @@ func publishUpdate() {
 	updatesMu.Unlock()
 }
 
-// SetProxy sets a CLI-provided proxy URL (overrides environment). Empty disables override.
-func SetProxy(p string) {
-	clientMu.Lock()
-	defer clientMu.Unlock()
-	proxyOverride = strings.TrimSpace(p)
-	// reset client to rebuild with new proxy settings on next use
-	httpClient = nil
-}
-
-// SetNoProxy sets a comma-separated NO_PROXY list (CLI-provided). Empty disables bypass rules.
-func SetNoProxy(list string) {
-	clientMu.Lock()
-	defer clientMu.Unlock()
-	noProxyList = strings.TrimSpace(list)
-	// reset client to rebuild with new proxy settings on next use
-	httpClient = nil
-}
-
-// SetEnvProxies configures per-scheme proxies provided via CLI/env flags (HTTP_PROXY/HTTPS_PROXY/ALL_PROXY)
-func SetEnvProxies(httpP, httpsP, allP string) {
-	clientMu.Lock()
-	defer clientMu.Unlock()
-	envHTTPProxy = strings.TrimSpace(httpP)
-	envHTTPSProxy = strings.TrimSpace(httpsP)
-	envALLProxy = strings.TrimSpace(allP)
-	// reset client to rebuild with new proxy settings on next use
-	httpClient = nil
-}
-
 // SetOpenSkyCredentials configures Basic Auth for OpenSky API.
 func SetOpenSkyCredentials(user, pass string) {
 	openskyUser = strings.TrimSpace(user)
 	openskyPass = pass
 }
 
-// noProxyMatch reports whether host should bypass proxy according to configured NO_PROXY list.
-func noProxyMatch(host string) bool {
-	if host == "" || strings.TrimSpace(noProxyList) == "" {
-		return false
-	}
-	list := noProxyList
-	host = strings.ToLower(host)
-	for _, token := range strings.Split(list, ",") {
-		t := strings.ToLower(strings.TrimSpace(token))
-		if t == "" {
-			continue
-		}
-		if t == "*" {
-			return true
-		}
-		// strip port in token if any
-		if h, _, err := net.SplitHostPort(t); err == nil {
-			t = h
-		}
-		// strip port from host too
-		if h, _, err := net.SplitHostPort(host); err == nil {
-			host = h
-		}
-		// leading dot means suffix match
-		if strings.HasPrefix(t, ".") {
-			if strings.HasSuffix(host, t) || host == strings.TrimPrefix(t, ".") {
-				return true
-			}
-			continue
-		}
-		// exact or subdomain match
-		if host == t || strings.HasSuffix(host, "."+t) {
-			return true
-		}
-	}
-	return false
-}
-
-// buildHTTPClient builds (once) an HTTP client honoring CLI proxy override and per-scheme proxies (HTTP/HTTPS/ALL) provided via urfave/cli.
-func buildHTTPClient(target string) *http.Client {
-	clientMu.Lock()
-	defer clientMu.Unlock()
-	if httpClient != nil {
-		return httpClient
-	}
-
-	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
-	tr := &http.Transport{
-		Proxy:               nil,
-		DialContext:         dialer.DialContext,
-		ForceAttemptHTTP2:   true,
-		TLSHandshakeTimeout: 10 * time.Second,
-	}
-
-	source := "none"
-	mode := "direct"
-	bypass := false
-
-	// Determine target host
-	thost := ""
-	if u, err := url.Parse(target); err == nil {
-		thost = u.Hostname()
-	}
-
-	if proxyOverride != "" {
-		source = "cli-override"
-		purl, err := url.Parse(proxyOverride)
-		if err == nil && purl.Host != "" {
-			bypass = noProxyMatch(thost)
-			if !bypass {
-				mode = strings.ToLower(purl.Scheme)
-				fixed := purl
-				tr.Proxy = func(req *http.Request) (*url.URL, error) {
-					if noProxyMatch(req.URL.Hostname()) {
-						return nil, nil
-					}
-					return fixed, nil
-				}
-			}
-		}
-	} else {
-		// Use CLI-provided per-scheme proxies (HTTP_PROXY/HTTPS_PROXY/ALL_PROXY) and NO_PROXY
-		source = "cli-env"
-		tr.Proxy = func(req *http.Request) (*url.URL, error) {
-			if req == nil || req.URL == nil {
-				return nil, nil
-			}
-			if noProxyMatch(req.URL.Hostname()) {
-				return nil, nil
-			}
-			var candidate string
-			scheme := strings.ToLower(req.URL.Scheme)
-			if scheme == "https" && envHTTPSProxy != "" {
-				candidate = envHTTPSProxy
-			} else if scheme == "http" && envHTTPProxy != "" {
-				candidate = envHTTPProxy
-			} else if envALLProxy != "" {
-				candidate = envALLProxy
-			}
-			if candidate == "" {
-				return nil, nil
-			}
-			purl, err := url.Parse(candidate)
-			if err != nil || purl.Host == "" {
-				return nil, nil
-			}
-			return purl, nil
-		}
-
-		// For logging purpose only, try to infer mode based on target URL
-		if u, err := url.Parse(target); err == nil {
-			if u.Scheme == "https" && envHTTPSProxy != "" {
-				mode = strings.ToLower(func() string {
-					if pu, e := url.Parse(envHTTPSProxy); e == nil {
-						return pu.Scheme
-					}
-					return "https"
-				}())
-			} else if u.Scheme == "http" && envHTTPProxy != "" {
-				mode = strings.ToLower(func() string {
-					if pu, e := url.Parse(envHTTPProxy); e == nil {
-						return pu.Scheme
-					}
-					return "http"
-				}())
-			} else if envALLProxy != "" {
-				mode = strings.ToLower(func() string {
-					if pu, e := url.Parse(envALLProxy); e == nil {
-						return pu.Scheme
-					}
-					return "http"
-				}())
-			}
-			bypass = noProxyMatch(u.Hostname())
-		}
-	}
-
-	httpClient = &http.Client{Transport: tr, Timeout: 15 * time.Second}
-	monitoring.Debugf("http_client configured source=%s mode=%s bypass=%t", source, mode, bypass)
-	return httpClient
-}
-
 // RateLimitError indicates API rate limiting with suggested retry delay.
 type RateLimitError struct {
 	Status     int
@@ -304,52 +146,115 @@ func parseRetryAfter(v string) time.Duration {
 	return 0
 }
 
-// FetchOpenSkyData calls OpenSky /api/states/all and returns parsed states.
-// If credentials were configured via CLI, it uses Basic Auth.
-func FetchOpenSkyData() (*FlightData, error) {
-	url := "https://opensky-network.org/api/states/all"
-	client := buildHTTPClient(url)
+// FetchStates fetches the worldwide states from the configured source (see SetSource).
+func FetchStates() (*FlightData, error) {
+	return fetchStates(nil, GetPollInterval(), &worldCache)
+}
 
-	// Auth for faster quota if available; TTL driven by configured poll interval
-	u, p := openskyUser, openskyPass
-	auth := u != "" && p != ""
-	ttl := GetPollInterval()
+// fetchStates requests the states of rg (nil: the whole world) from the configured source,
+// serving responses younger than ttl from cache.
+func fetchStates(rg *Region, ttl time.Duration, cache *statesCache) (*FlightData, error) {
 	if ttl <= 0 {
 		ttl = 10 * time.Second
 	}
-
+	src := currentSource()
+	gen := statesGen.Load()
 	// Serve from cache if fresh
-	cacheMu.Lock()
-	if cacheData != nil && time.Since(cacheAt) < ttl {
-		age := time.Since(cacheAt)
-		cacheMu.Unlock()
-		monitoring.Debugf("opensky cache hit age=%s ttl=%s states=%d", age, ttl, len(cacheData.States))
-		return cacheData, nil
+	cache.mu.Lock()
+	if cache.data != nil && cache.gen == gen && time.Since(cache.at) < ttl {
+		age := time.Since(cache.at)
+		data := cache.data
+		cache.mu.Unlock()
+		monitoring.SubDebugf("ingest", "%s cache hit age=%s ttl=%s states=%d", src.Name(), age, ttl, len(data.States))
+		return data, nil
 	}
-	cacheMu.Unlock()
+	cache.mu.Unlock()
 
-	start := time.Now()
-	req, err := http.NewRequest("GET", url, nil)
+	data, err := src.Fetch(rg)
 	if err != nil {
 		return nil, err
 	}
-	if auth {
-		req.SetBasicAuth(u, p)
+	monitoring.SubDebugf("ingest", "%s states count=%d", src.Name(), len(data.States))
+	if data.Time > 0 {
+		data.Skew = time.Duration(data.Time-time.Now().Unix()) * time.Second
+		observeSkew(data.Skew)
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	// Update cache
+	cache.mu.Lock()
+	cache.data = data
+	cache.at = time.Now()
+	cache.gen = gen
+	cache.mu.Unlock()
+	return data, nil
+}
+
+// openskySource polls the OpenSky /api/states/all endpoint.
+type openskySource struct{}
+
+func (openskySource) Name() string { return "opensky" }
+
+// Fetch requests the states within rg (lamin/lomin/lamax/lomax) or worldwide. It authenticates
+// with an OAuth2 bearer token when client credentials are configured, falls back to Basic Auth
+// when a username/password is set and otherwise polls anonymously.
+func (openskySource) Fetch(rg *Region) (*FlightData, error) {
+	q := url.Values{}
+	if rg != nil {
+		q.Set("lamin", strconv.FormatFloat(rg.LaMin, 'f', -1, 64))
+		q.Set("lomin", strconv.FormatFloat(rg.LoMin, 'f', -1, 64))
+		q.Set("lamax", strconv.FormatFloat(rg.LaMax, 'f', -1, 64))
+		q.Set("lomax", strconv.FormatFloat(rg.LoMax, 'f', -1, 64))
+	}
+	if storage.GetIngestFilter().ExcludeGround {
+		// aircraft category is only included in extended responses
+		q.Set("extended", "1")
+	}
+	target := "https://opensky-network.org/api/states/all"
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	client := openskyClient
+
+	// Auth for faster quota if available
+	u, p := openskyUser, openskyPass
+	auth := u != "" && p != ""
+
+	oauth := openskyOAuthEnabled()
+	start := time.Now()
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if oauth {
+			tok, err := openskyToken(client)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+tok)
+		} else if auth {
+			req.SetBasicAuth(u, p)
+		}
+		if resp = chaos.OpenSkyResponse(); resp == nil {
+			resp, err = client.Do(req)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if oauth && resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			// Token revoked or expired early: refresh once
+			resp.Body.Close()
+			invalidateOpenSkyToken()
+			continue
+		}
+		break
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(io.LimitReader(resp.Body, 5<<20)) // limit 5MB
 	dur := time.Since(start)
-	monitoring.Debugf("opensky request url=%s status=%d duration=%s body_len=%d", url, resp.StatusCode, dur, len(body))
-	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
-		ra := parseRetryAfter(resp.Header.Get("Retry-After"))
-		if ra <= 0 {
-			ra = 30 * time.Second
-		}
-		return nil, &RateLimitError{Status: resp.StatusCode, RetryAfter: ra}
+	monitoring.SubDebugf("ingest", "opensky request url=%s status=%d duration=%s body_len=%d", target, resp.StatusCode, dur, len(body))
+	if err := rateLimited(resp); err != nil {
+		return nil, err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("opensky status %d", resp.StatusCode)
@@ -358,81 +263,73 @@ func FetchOpenSkyData() (*FlightData, error) {
 	if err := json.Unmarshal(body, &data); err != nil {
 		return nil, err
 	}
-	monitoring.Debugf("opensky states count=%d", len(data.States))
-	// Update cache
-	cacheMu.Lock()
-	cacheData = &data
-	cacheAt = time.Now()
-	cacheMu.Unlock()
 	return &data, nil
 }
 
-// IngestLoop periodically fetches from OpenSky and stores into BuntDB.
-func IngestLoop(stop <-chan struct{}) {
-	fetchOnce := func() (nextSleep time.Duration) {
-		data, err := FetchOpenSkyData()
-		if err != nil {
-			if rl, ok := err.(*RateLimitError); ok {
-				// Respect server-provided Retry-After but never less than our polling interval
-				delay := rl.RetryAfter
-				min := GetPollInterval()
-				if min <= 0 {
-					min = 10 * time.Second
-				}
-				if delay < min {
-					delay = min
-				}
-				monitoring.Debugf("ingestor rate-limited status=%d retry_after=%s applied_backoff=%s", rl.Status, rl.RetryAfter, delay)
-				// Extend TTL for current positions so markers don't disappear while backing off
-				if s := storage.Get(); s != nil {
-					buf := 5 * time.Second
-					_ = s.TouchNow(delay + buf)
-				}
-				return delay
-			}
-			monitoring.Debugf("ingestor fetch error: %v", err)
-			// On transient error, keep current positions visible until next poll attempt
-			if s := storage.Get(); s != nil {
-				d := GetPollInterval()
-				if d <= 0 {
-					d = 10 * time.Second
-				}
-				_ = s.TouchNow(d + 5*time.Second)
-			}
-			// On error, try again after normal interval
-			d := GetPollInterval()
-			if d <= 0 {
-				d = 10 * time.Second
-			}
-			return d
-		}
-		if data != nil {
+// rateLimited returns a RateLimitError for 429/503 responses, honoring Retry-After (30s when
+// missing).
+func rateLimited(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	ra := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if ra <= 0 {
+		ra = 30 * time.Second
+	}
+	return &RateLimitError{Status: resp.StatusCode, RetryAfter: ra}
+}
+
+// IngestOnce fetches from the configured source once and stores the states into BuntDB. It returns the wait
+// before the next poll (the poll interval, or a longer backoff when rate-limited) and the fetch
+// error, if any; it runs as the "ingest" scheduler job.
+func IngestOnce() (time.Duration, error) {
+	return ingest("world", GetPollInterval(), FetchStates)
+}
+
+// ingest stores the states returned by fetch; source names the poll target ("world" or the
+// region) and d is its poll interval. Cluster followers skip the poll: the leader shares its
+// states with them.
+func ingest(source string, d time.Duration, fetch func() (*FlightData, error)) (time.Duration, error) {
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	if clusterFollower() {
+		return d, nil
+	}
+	defer endWarmup("first ingest")
+	data, err := fetch()
+	if err != nil {
+		if rl, ok := err.(*RateLimitError); ok {
+			// Respect server-provided Retry-After but never less than our polling interval
+			delay := max(rl.RetryAfter, d)
+			monitoring.SubDebugf("ingest", "ingestor rate-limited status=%d retry_after=%s applied_backoff=%s", rl.Status, rl.RetryAfter, delay)
+			// Keep current positions so markers don't disappear while backing off
 			if s := storage.Get(); s != nil {
-				_ = s.UpsertStates(data.States)
-				monitoring.Debugf("ingestor upserted states=%d", len(data.States))
-				// notify subscribers there is fresh data
-				publishUpdate()
-			} else {
-				monitoring.Debugf("ingestor: storage not initialized; skipping upsert")
+				_ = s.TouchNow(delay)
 			}
+			return delay, err
 		}
-		d := GetPollInterval()
-		if d <= 0 {
-			d = 10 * time.Second
+		monitoring.SubDebugf("ingest", "ingestor fetch error: %v", err)
+		// On error, try again after normal interval and keep current positions visible until then
+		if s := storage.Get(); s != nil {
+			_ = s.TouchNow(d)
 		}
-		return d
-	}
-
-	// First fetch immediately to reduce startup latency
-	sleep := fetchOnce()
-	for {
-		select {
-		case <-stop:
-			return
-		case <-time.After(sleep):
-			sleep = fetchOnce()
+		return d, err
+	}
+	if data != nil {
+		if s := storage.Get(); s != nil {
+			t0 := time.Now()
+			_ = s.UpsertStatesWithSkew(data.States, data.Skew)
+			recordIngestDuration(time.Since(t0))
+			monitoring.SubDebugf("ingest", "ingestor upserted states=%d", len(data.States))
+			// notify subscribers there is fresh data
+			publishUpdate()
+			clusterPublish(source, data)
+		} else {
+			monitoring.SubDebugf("ingest", "ingestor: storage not initialized; skipping upsert")
 		}
 	}
+	return d, nil
 }
 
 func normalizeCallsign(s string) string {
@@ -441,14 +338,13 @@ func normalizeCallsign(s string) string {
 
 // FlightHandler returns latest sample for callsign from storage (OpenSky-compatible shape)
 func FlightHandler(w http.ResponseWriter, r *http.Request) {
-	callsignRaw := r.URL.Query().Get("callsign")
-	if strings.TrimSpace(callsignRaw) == "" {
-		http.Error(w, "callsign is required", http.StatusBadRequest)
+	callsign, err := queryCallsign(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		monitoring.FlightErrors.WithLabelValues("unknown").Inc()
 		monitoring.LastStatus.WithLabelValues("unknown").Set(400.0)
 		return
 	}
-	callsign := normalizeCallsign(callsignRaw)
 
 	p, err := storage.Get().LatestByCallsign(callsign)
 	if err != nil || p == nil {
@@ -471,6 +367,9 @@ func FlightHandler(w http.ResponseWriter, r *http.Request) {
 	if p.Track != 0 {
 		row[10] = p.Track
 	}
+	if p.VRate != 0 {
+		row[11] = p.VRate // vertical_rate in m/s
+	}
 	if p.Alt != 0 {
 		row[13] = p.Alt
 	}
@@ -483,47 +382,14 @@ func FlightHandler(w http.ResponseWriter, r *http.Request) {
 // FlightsInBBoxHandler returns current positions within bbox (minLon,minLat,maxLon,maxLat).
 // It validates inputs to avoid pathological requests and responds with 400 on invalid parameters.
 func FlightsInBBoxHandler(w http.ResponseWriter, r *http.Request) {
-	bbox := r.URL.Query().Get("bbox")
-	parts := strings.Split(bbox, ",")
-	if len(parts) != 4 {
-		http.Error(w, "bbox is required as minLon,minLat,maxLon,maxLat", http.StatusBadRequest)
-		return
-	}
-	parse := func(s string) (float64, bool) {
-		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
-		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
-			return 0, false
-		}
-		return v, true
-	}
-	minLon, ok1 := parse(parts[0])
-	minLat, ok2 := parse(parts[1])
-	maxLon, ok3 := parse(parts[2])
-	maxLat, ok4 := parse(parts[3])
-	if !(ok1 && ok2 && ok3 && ok4) {
-		http.Error(w, "invalid bbox coordinates", http.StatusBadRequest)
-		return
-	}
-	// Clamp to valid ranges
-	if minLon < -180 {
-		minLon = -180
-	}
-	if maxLon > 180 {
-		maxLon = 180
-	}
-	if minLat < -90 {
-		minLat = -90
-	}
-	if maxLat > 90 {
-		maxLat = 90
-	}
-	if maxLon <= minLon || maxLat <= minLat {
-		http.Error(w, "invalid bbox order", http.StatusBadRequest)
+	b, _, err := queryBBox(r, true)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
-	pts, err := storage.Get().CurrentInBBox(minLon, minLat, maxLon, maxLat)
+	pts, err := storage.Get().CurrentInBBox(b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.WriteError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -533,19 +399,61 @@ func FlightsInBBoxHandler(w http.ResponseWriter, r *http.Request) {
 // TrackHandler returns the current flight segment track for the given callsign.
 // It avoids merging separate flights under the same callsign by trimming history
 // to the most recent continuous segment for the (icao24 + callsign) pair.
+// Optional simplify=<meters> thins the track with Douglas-Peucker at that tolerance, and
+// trail_color=alt|speed adds the color code of every point.
 func TrackHandler(w http.ResponseWriter, r *http.Request) {
-	callsignRaw := r.URL.Query().Get("callsign")
-	if strings.TrimSpace(callsignRaw) == "" {
-		http.Error(w, "callsign is required", http.StatusBadRequest)
+	callsign, err := queryCallsign(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	color, err := parseTrailColor(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
-	callsign := normalizeCallsign(callsignRaw)
+	var tolerance float64
+	if v := r.URL.Query().Get("simplify"); v != "" {
+		tolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil || tolerance < 0 || tolerance > maxSimplify {
+			problem.WriteError(w, r, invalidParam("simplify", "want meters in 0..%d, got %q", maxSimplify, v))
+			return
+		}
+	}
 
-	pts, icao, err := storage.Get().TrackByCallsign(callsign, 0)
+	segment, icao, err := currentSegment(callsign)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.WriteError(w, r, err)
 		return
 	}
+	resp := trackResponse{
+		Callsign: callsign,
+		Icao24:   icao,
+		Points:   segment,
+	}
+	resp.Origin, resp.Destination = estimateRoute(segment)
+	if tolerance > 0 {
+		keep := geo.Simplify(len(segment), func(i int) (float64, float64) { return segment[i].Lat, segment[i].Lon }, tolerance)
+		pts := make([]storage.Point, len(keep))
+		for i, k := range keep {
+			pts[i] = segment[k]
+		}
+		resp.Points, resp.Total = pts, len(segment)
+	}
+	resp.Colors = trailColorCodes(resp.Points, color)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// maxSimplify bounds the simplify tolerance of /api/track (meters).
+const maxSimplify = 100000
+
+// currentSegment returns the most recent continuous flight segment for a normalized callsign.
+func currentSegment(callsign string) ([]storage.Point, string, error) {
+	pts, icao, err := storage.Get().TrackByCallsign(callsign, 0)
+	if err != nil {
+		return nil, "", err
+	}
 	// Filter by exact callsign to avoid mixing with other identifiers
 	filtered := make([]storage.Point, 0, len(pts))
 	for _, p := range pts {
@@ -581,32 +489,99 @@ func TrackHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp := struct {
-		Callsign string          `json:"callsign"`
-		Icao24   string          `json:"icao24"`
-		Points   []storage.Point `json:"points"`
-	}{
-		Callsign: callsign,
-		Icao24:   icao,
-		Points:   filtered[start:],
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+	return filtered[start:], icao, nil
 }
 
 // AllFlightsHandler returns all current flights positions (worldwide). Frontend handles any filtering.
+// Optional precision=N rounds coordinates to N decimals; callsign=DLH*,EWG* (globs) and/or
+// callsign_re= (regular expression) keep only matching callsigns, type=B77W,A32* only matching
+// aircraft types. sort=distance|alt|speed (with ref=lat,lon, order=asc|desc and limit=) orders
+// the flights server-side, see parseFlightSort.
 func AllFlightsHandler(w http.ResponseWriter, r *http.Request) {
+	precision, err := parsePrecision(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	filter, err := queryFlightFilter(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	order, err := parseFlightSort(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
 	pts, err := storage.Get().CurrentAll()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.WriteError(w, r, err)
 		return
 	}
+	out := pts[:0]
+	for _, p := range pts {
+		if filter.Match(p) {
+			out = append(out, p)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(pts)
+	_ = json.NewEncoder(w).Encode(order.apply(out, precision))
 }
 
 // HealthHandler returns 200 OK with minimal JSON body for liveness checks.
 func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "ts": time.Now().Unix()})
+	body := map[string]any{"status": "ok", "ts": time.Now().Unix()}
+	if cluster != nil {
+		body["cluster_role"] = ClusterRole()
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// LedgerHandler returns the all-time airframe ledger with sorting and pagination.
+// Query: sort=last_seen|first_seen|sightings|samples|icao24 (default last_seen), order=asc|desc (default desc),
+// limit (1..500, default 50), offset. With icao24=XXXXXX it returns the single entry instead.
+func LedgerHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if v := strings.TrimSpace(q.Get("icao24")); v != "" {
+		icao, err := parseICAO24(v)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		e, err := storage.Get().LedgerGet(icao)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if e == nil {
+			problem.Write(w, r, http.StatusNotFound, "aircraft not found in ledger")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e)
+		return
+	}
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = "last_seen"
+	}
+	desc := !strings.EqualFold(q.Get("order"), "asc")
+	limit, err := queryInt(r, "limit", 50, 1, 500)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, math.MaxInt)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	items, total, err := storage.Get().Ledger(sortBy, desc, offset, limit)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"total": total, "offset": offset, "limit": limit, "items": items})
 }
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// bulkMaxIDs bounds the ICAO24 addresses and callsigns of one /api/flights/bulk request.
+const bulkMaxIDs = 200
+
+// bulkRequest is the body of POST /api/flights/bulk.
+type bulkRequest struct {
+	Icao24    []string `json:"icao24,omitempty"`
+	Callsign  []string `json:"callsign,omitempty"`
+	Trail     *int     `json:"trail,omitempty"`     // trail points per flight, 0..24 (default 10)
+	Precision int      `json:"precision,omitempty"` // round lon/lat to N decimals (1..7)
+}
+
+// bulkFlight is a current flight of a bulk response with its recent trail.
+type bulkFlight struct {
+	storage.Point
+	Query string       `json:"query"` // the requested ICAO24 or callsign it matched
+	Trail []trailPoint `json:"trail,omitempty"`
+}
+
+// bulkResponse is the body of /api/flights/bulk.
+type bulkResponse struct {
+	Flights []bulkFlight `json:"flights"`
+	Missing []string     `json:"missing"` // requested identifiers without a current flight
+}
+
+// BulkFlightsHandler looks up the current positions and short trails of a fixed set of
+// aircraft in one round trip (POST /api/flights/bulk), for dashboard widgets tracking a fleet:
+// {"icao24":["3c6444",...],"callsign":["DLH4AB",...],"trail":10,"precision":4}. Flights are
+// returned in request order, ICAO24 addresses first; an aircraft matched twice is listed once.
+func BulkFlightsHandler(w http.ResponseWriter, r *http.Request) {
+	var req bulkRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if n := len(req.Icao24) + len(req.Callsign); n == 0 || n > bulkMaxIDs {
+		problem.Write(w, r, http.StatusBadRequest, fmt.Sprintf("want 1..%d icao24 addresses and callsigns, got %d", bulkMaxIDs, n))
+		return
+	}
+	trail := 10
+	if req.Trail != nil {
+		trail = *req.Trail
+	}
+	if trail < 0 || trail > streamTrailLimit {
+		problem.WriteError(w, r, invalidParam("trail", "want 0..%d points, got %d", streamTrailLimit, trail))
+		return
+	}
+	if req.Precision < 0 || req.Precision > maxPrecision {
+		problem.WriteError(w, r, invalidParam("precision", "want an integer in 1..%d, got %d", maxPrecision, req.Precision))
+		return
+	}
+	icaos := make([]string, 0, len(req.Icao24))
+	for _, v := range req.Icao24 {
+		icao, err := parseICAO24(v)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		icaos = append(icaos, icao)
+	}
+	callsigns := make([]string, 0, len(req.Callsign))
+	for _, v := range req.Callsign {
+		cs, err := parseCallsign(v)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		callsigns = append(callsigns, cs)
+	}
+
+	st := storage.Get()
+	byICAO, err := st.CurrentByICAO(icaos)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	byCS, err := st.CurrentByCallsign(callsigns)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	found := map[string]storage.Point{}
+	for _, p := range byICAO {
+		found[p.Icao24] = p
+	}
+	for _, p := range byCS {
+		found[normalizeCallsign(p.Callsign)] = p
+	}
+	resp := bulkResponse{Flights: []bulkFlight{}, Missing: []string{}}
+	sent, missing := map[string]bool{}, map[string]bool{}
+	for _, q := range append(icaos, callsigns...) {
+		p, ok := found[q]
+		switch {
+		case !ok && !missing[q]:
+			missing[q] = true
+			resp.Missing = append(resp.Missing, q)
+			continue
+		case !ok || sent[p.Icao24]:
+			continue
+		}
+		sent[p.Icao24] = true
+		f := bulkFlight{Point: p, Query: q}
+		if trail > 0 && strings.TrimSpace(p.Icao24) != "" {
+			pts, _ := st.RecentTrackByICAO(p.Icao24, trail, streamTrailWindow)
+			for _, tp := range pts {
+				f.Trail = append(f.Trail, trailPoint{Lon: roundTo(tp.Lon, req.Precision), Lat: roundTo(tp.Lat, req.Precision), TS: tp.TS, Alt: tp.Alt})
+			}
+		}
+		f.Lon, f.Lat = roundTo(p.Lon, req.Precision), roundTo(p.Lat, req.Precision)
+		resp.Flights = append(resp.Flights, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
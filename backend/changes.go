@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// ChangesHandler returns ingest batches after a sequence number so clients can resume.
+// Query: since (default 0), limit (1..10000, default 50). When reset is true the requested range
+// was compacted away and the client should reload the full state first. Batches are read in
+// pages and streamed; truncated is true when the export budget cut the response short, in which
+// case the client continues with since=next.
+func ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := queryInt(r, "since", 0, 0, math.MaxInt)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	since := int64(n)
+	limit, err := queryInt(r, "limit", 50, 1, 10000)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	const page = 100
+	st := storage.Get()
+	batches, oldest, err := st.Changes(since, min(limit, page))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	ew := newExportWriter(w, r)
+	_ = ew.Raw(fmt.Sprintf(`{"since":%d,"latest":%d,"oldest":%d,"reset":%t,"batches":[`,
+		since, st.LastSeq(), oldest, oldest > 0 && since+1 < oldest))
+	next, written, truncated := since, 0, false
+	for len(batches) > 0 && !truncated {
+		for _, b := range batches {
+			row, err := json.Marshal(b)
+			if err != nil {
+				continue
+			}
+			prefix := ""
+			if written > 0 {
+				prefix = ","
+			}
+			if err := ew.Row(prefix, row); errors.Is(err, errExportBudget) {
+				truncated = true
+				break
+			} else if err != nil {
+				monitoring.SubDebugf("storage", "changes export aborted: %v", err)
+				return
+			}
+			next = b.Seq
+			written++
+		}
+		if truncated || written >= limit || len(batches) < page {
+			break
+		}
+		if batches, _, err = st.Changes(next, min(limit-written, page)); err != nil {
+			monitoring.SubDebugf("storage", "changes export aborted: %v", err)
+			return
+		}
+	}
+	_ = ew.Raw(fmt.Sprintf(`],"next":%d,"truncated":%t}`+"\n", next, truncated))
+	_ = ew.Close()
+}
+
+// ChangesStateHandler reconstructs the current-position state after ?seq= (default latest)
+// from the event log; useful to debug diff generation.
+func ChangesStateHandler(w http.ResponseWriter, r *http.Request) {
+	st := storage.Get()
+	n, err := queryInt(r, "seq", int(st.LastSeq()), 0, math.MaxInt)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	seq := int64(n)
+	state, err := st.StateAt(seq)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	pts := make([]storage.Point, 0, len(state))
+	for _, p := range state {
+		pts = append(pts, p)
+	}
+	sort.Slice(pts, func(i, j int) bool { return pts[i].Icao24 < pts[j].Icao24 })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"seq": seq, "aircraft": pts})
+}
+
+// TombstonesHandler lists aircraft removed from the current state since ?since= (unix seconds,
+// default: last 10 minutes).
+func TombstonesHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := queryUnix(r, "since", time.Now().Add(-10*time.Minute).Unix())
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	items, err := storage.Get().Tombstones(since)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].TS < items[j].TS })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"since": since, "items": items})
+}
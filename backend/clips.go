@@ -0,0 +1,386 @@
+package backend
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/features"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/security"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+func init() {
+	features.Register("replay", "Playback clips and state replay from the event log (/api/clips, /api/changes/state)", true)
+}
+
+// clipTrack groups clip positions of one aircraft in time order.
+type clipTrack struct {
+	Icao24   string          `json:"icao24"`
+	Callsign string          `json:"callsign,omitempty"`
+	Points   []storage.Point `json:"points"`
+}
+
+func groupTracks(pts []storage.Point) []clipTrack {
+	byIcao := map[string]*clipTrack{}
+	order := []string{}
+	for _, p := range pts {
+		t := byIcao[p.Icao24]
+		if t == nil {
+			t = &clipTrack{Icao24: p.Icao24}
+			byIcao[p.Icao24] = t
+			order = append(order, p.Icao24)
+		}
+		if p.Callsign != "" {
+			t.Callsign = p.Callsign
+		}
+		t.Points = append(t.Points, p)
+	}
+	sort.Strings(order)
+	out := make([]clipTrack, 0, len(order))
+	for _, icao := range order {
+		t := byIcao[icao]
+		sort.Slice(t.Points, func(i, j int) bool { return t.Points[i].TS < t.Points[j].TS })
+		out = append(out, *t)
+	}
+	return out
+}
+
+// ClipsHandler lists the caller's clips (GET) or creates one (POST) from a JSON body:
+// {"title":"...","from":unix,"to":unix,"bbox":[minLon,minLat,maxLon,maxLat],"icao24":["..."]}.
+// Both are admin only (security.RequireAdmin); clips are owned by their creator.
+func ClipsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var c storage.Clip
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&c); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		c.Owner = security.AdminPrincipal(r)
+		created, err := storage.Get().CreateClip(c)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/clips/"+created.ID)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(created)
+		return
+	}
+	clips, err := storage.Get().Clips(security.AdminPrincipal(r))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(clips)
+}
+
+// ClipHandler returns clip metadata (GET) or deletes the clip (DELETE, admin only and limited
+// to the clip's owner).
+func ClipHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if r.Method == http.MethodDelete {
+		found, err := storage.Get().DeleteClip(id, security.AdminPrincipal(r))
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if !found {
+			problem.Write(w, r, http.StatusNotFound, "clip not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c, _, err := storage.Get().ClipGet(id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if c == nil {
+		problem.Write(w, r, http.StatusNotFound, "clip not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+// ClipExportHandler exports a clip as a standalone bundle: ?format=json (default), czml, gpx,
+// kml or csv.
+// The export is streamed; when it exceeds the row/byte budget the response is a 206 page
+// (Content-Range: rows first-last/total) and X-Next-Cursor / Link rel="next" point to the rest,
+// also reachable with a "Range: rows=first-[last]" request header.
+func ClipExportHandler(w http.ResponseWriter, r *http.Request) {
+	c, pts, err := storage.Get().ClipGet(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if c == nil {
+		problem.Write(w, r, http.StatusNotFound, "clip not found")
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	var doc clipDoc
+	var ctype string
+	switch format {
+	case "json":
+		ctype, doc = "application/json", clipJSON{}
+	case "czml":
+		ctype, doc = "application/json", clipCZML{}
+	case "gpx":
+		ctype, doc = "application/gpx+xml", clipGPX{}
+	case "kml":
+		ctype, doc = "application/vnd.google-earth.kml+xml", clipKML{}
+	case "csv":
+		ctype, doc = "text/csv; charset=utf-8", clipCSV{}
+	default:
+		problem.Write(w, r, http.StatusBadRequest, "unsupported format (json, czml, gpx, kml, csv)")
+		return
+	}
+	start, end, err := rowRange(r, c.ID)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	total := len(pts)
+	if start > 0 && start >= total {
+		w.Header().Set("Content-Range", fmt.Sprintf("rows */%d", total))
+		problem.Write(w, r, http.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+		return
+	}
+	if end < 0 || end > total {
+		end = total
+	}
+	tracks := groupTracks(pts)
+	// Size the page before writing so the headers can announce it
+	rows := flattenRows(tracks)
+	var size int64
+	for i := start; i < end; i++ {
+		b, err := doc.row(c, rows[i])
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		if i-start >= exportMaxRows || (i > start && size+int64(len(b)) > exportMaxBytes) {
+			end = i
+			break
+		}
+		size += int64(len(b))
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="clip-%s.%s"`, c.ID, format))
+	h.Set("Accept-Ranges", "rows")
+	status := http.StatusOK
+	if start > 0 || end < total {
+		status = http.StatusPartialContent
+		h.Set("Content-Range", fmt.Sprintf("rows %d-%d/%d", start, end-1, total))
+		if end < total {
+			next := encodeCursor(c.ID, end)
+			h.Set("X-Next-Cursor", next)
+			h.Set("Link", fmt.Sprintf(`</api/clips/%s/export?format=%s&cursor=%s>; rel="next"`, c.ID, format, next))
+		}
+	}
+	w.WriteHeader(status)
+
+	ew := newExportWriter(w, r)
+	ew.maxRows, ew.maxBytes = end-start, 0 // budget already applied above
+	if err := writeClip(ew, doc, c, rows[start:end]); err != nil {
+		monitoring.SubDebugf("storage", "clip export id=%s aborted: %v", c.ID, err)
+		return
+	}
+	_ = ew.Close()
+}
+
+// clipRow is one exported position together with the track it belongs to.
+type clipRow struct {
+	track *clipTrack
+	p     storage.Point
+}
+
+func flattenRows(tracks []clipTrack) []clipRow {
+	n := 0
+	for _, t := range tracks {
+		n += len(t.Points)
+	}
+	out := make([]clipRow, 0, n)
+	for i := range tracks {
+		for _, p := range tracks[i].Points {
+			out = append(out, clipRow{track: &tracks[i], p: p})
+		}
+	}
+	return out
+}
+
+// clipDoc renders an export format as a header, one opening/closing section per track and one
+// row per position, so documents can be streamed without being built in memory.
+type clipDoc interface {
+	head(c *storage.Clip) string
+	openTrack(c *storage.Clip, t *clipTrack, n int, first, last storage.Point) string
+	row(c *storage.Clip, r clipRow) ([]byte, error)
+	sep() string
+	closeTrack() string
+	tail() string
+}
+
+// writeClip streams rows (grouped by consecutive track) framed by doc.
+func writeClip(ew *exportWriter, doc clipDoc, c *storage.Clip, rows []clipRow) error {
+	if err := ew.Raw(doc.head(c)); err != nil {
+		return err
+	}
+	for i, n := 0, 0; i < len(rows); n++ {
+		t := rows[i].track
+		j := i
+		for j < len(rows) && rows[j].track == t {
+			j++
+		}
+		if err := ew.Raw(doc.openTrack(c, t, n, rows[i].p, rows[j-1].p)); err != nil {
+			return err
+		}
+		for k := i; k < j; k++ {
+			b, err := doc.row(c, rows[k])
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if k > i {
+				prefix = doc.sep()
+			}
+			if err := ew.Row(prefix, b); err != nil {
+				return err
+			}
+		}
+		if err := ew.Raw(doc.closeTrack()); err != nil {
+			return err
+		}
+		i = j
+	}
+	return ew.Raw(doc.tail())
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// clipJSON renders {"clip":{...},"tracks":[{"icao24":...,"callsign":...,"points":[...]}]}.
+type clipJSON struct{}
+
+func (clipJSON) head(c *storage.Clip) string {
+	b, _ := json.Marshal(c)
+	return `{"clip":` + string(b) + `,"tracks":[`
+}
+
+func (clipJSON) openTrack(_ *storage.Clip, t *clipTrack, n int, _, _ storage.Point) string {
+	s := `{"icao24":` + jsonString(t.Icao24)
+	if n > 0 {
+		s = "," + s
+	}
+	if t.Callsign != "" {
+		s += `,"callsign":` + jsonString(t.Callsign)
+	}
+	return s + `,"points":[`
+}
+
+func (clipJSON) row(_ *storage.Clip, r clipRow) ([]byte, error) { return json.Marshal(r.p) }
+func (clipJSON) sep() string                                    { return "," }
+func (clipJSON) closeTrack() string                             { return "]}" }
+func (clipJSON) tail() string                                   { return "]}\n" }
+
+func isoTime(ts int64) string { return time.Unix(ts, 0).UTC().Format(time.RFC3339) }
+
+// clipCZML renders a Cesium CZML document: one packet per aircraft with time-tagged positions.
+type clipCZML struct{}
+
+func (clipCZML) head(c *storage.Clip) string {
+	name := c.Title
+	if name == "" {
+		name = "clip " + c.ID
+	}
+	b, _ := json.Marshal(map[string]any{
+		"id":      "document",
+		"name":    name,
+		"version": "1.0",
+		"clock":   map[string]any{"interval": isoTime(c.From) + "/" + isoTime(c.To), "currentTime": isoTime(c.From), "multiplier": 10},
+	})
+	return "[" + string(b)
+}
+
+func (clipCZML) openTrack(c *storage.Clip, t *clipTrack, _ int, first, last storage.Point) string {
+	label := t.Callsign
+	if label == "" {
+		label = t.Icao24
+	}
+	return `,{"id":` + jsonString(t.Icao24) + `,"name":` + jsonString(label) +
+		`,"availability":"` + isoTime(first.TS) + "/" + isoTime(last.TS) + `"` +
+		`,"point":{"pixelSize":8},"path":{"width":2,"leadTime":0,"trailTime":600}` +
+		`,"position":{"epoch":"` + isoTime(c.From) + `","cartographicDegrees":[`
+}
+
+// row emits "t,lon,lat,alt" (seconds since the clip start) without the surrounding brackets.
+func (clipCZML) row(c *storage.Clip, r clipRow) ([]byte, error) {
+	b, err := json.Marshal([]float64{float64(r.p.TS - c.From), r.p.Lon, r.p.Lat, r.p.Alt})
+	if err != nil {
+		return nil, err
+	}
+	return b[1 : len(b)-1], nil
+}
+
+func (clipCZML) sep() string        { return "," }
+func (clipCZML) closeTrack() string { return "]}}" }
+func (clipCZML) tail() string       { return "]\n" }
+
+type gpxPoint struct {
+	XMLName xml.Name `xml:"trkpt"`
+	Lat     float64  `xml:"lat,attr"`
+	Lon     float64  `xml:"lon,attr"`
+	Ele     float64  `xml:"ele"`
+	Time    string   `xml:"time"`
+}
+
+// clipGPX renders one GPX track per aircraft.
+type clipGPX struct{}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (clipGPX) head(c *storage.Clip) string {
+	s := xml.Header + `<gpx version="1.1" creator="miniflightradar" xmlns="http://www.topografix.com/GPX/1/1">` + "\n"
+	if c.Title != "" {
+		s += "  <metadata>\n    <name>" + xmlText(c.Title) + "</name>\n  </metadata>\n"
+	}
+	return s
+}
+
+func (clipGPX) openTrack(_ *storage.Clip, t *clipTrack, _ int, _, _ storage.Point) string {
+	name := t.Icao24
+	if t.Callsign != "" {
+		name = t.Callsign + " (" + t.Icao24 + ")"
+	}
+	return "  <trk>\n    <name>" + xmlText(name) + "</name>\n    <trkseg>\n      "
+}
+
+func (clipGPX) row(_ *storage.Clip, r clipRow) ([]byte, error) {
+	return xml.Marshal(gpxPoint{Lat: r.p.Lat, Lon: r.p.Lon, Ele: r.p.Alt, Time: isoTime(r.p.TS)})
+}
+
+func (clipGPX) sep() string        { return "\n      " }
+func (clipGPX) closeTrack() string { return "\n    </trkseg>\n  </trk>\n" }
+func (clipGPX) tail() string       { return "</gpx>\n" }
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/scheduler"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// clockCheckInterval is how often the wall clock is compared against the expected progress.
+const clockCheckInterval = 5 * time.Second
+
+// wsResync is bumped after a clock jump; flights WS connections that see a new value tell the
+// client to drop its state and send a fresh snapshot.
+var wsResync atomic.Int64
+
+// StartClockWatch detects wall-clock jumps larger than threshold between two checks (laptop
+// sleep, container pause, clock steps) and catches up: stale current positions are tombstoned
+// instead of being served as current, the ingest job runs right away and WS clients resync.
+// A threshold <= 0 disables the watch.
+func StartClockWatch(threshold time.Duration, stop <-chan struct{}) {
+	if threshold <= 0 {
+		return
+	}
+	go monitoring.Supervise("clock.watch", stop, func() {
+		t := time.NewTicker(clockCheckInterval)
+		defer t.Stop()
+		// Round(0) strips the monotonic reading: the monotonic clock stops during suspend,
+		// the wall clock does not
+		last := time.Now().Round(0)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				now := time.Now().Round(0)
+				if gap := now.Sub(last) - clockCheckInterval; gap > threshold || gap < -threshold {
+					catchUp(gap)
+				}
+				last = now
+			}
+		}
+	})
+}
+
+// catchUp invalidates state that went stale during a clock jump of gap.
+func catchUp(gap time.Duration) {
+	monitoring.ClockJumps.Inc()
+	removed, err := storage.Get().ExpireStale()
+	if err != nil {
+		monitoring.SubDebugf("ingest", "clock jump: expire stale positions: %v", err)
+	}
+	_, started := scheduler.Trigger("ingest")
+	log.Printf("clock jump of %s detected: expired %d stale positions, ingest triggered=%t", gap.Round(time.Second), len(removed), started)
+	wsResync.Add(1)
+	publishUpdate()
+}
+
+// skewed records whether the last source response was beyond storage.SkewTolerance, so
+// changes are logged once rather than on every poll.
+var skewed atomic.Bool
+
+// observeSkew exposes the source clock offset and logs when it starts or stops being corrected.
+func observeSkew(skew time.Duration) {
+	monitoring.ClockSkew.Set(skew.Seconds())
+	is := skew > storage.SkewTolerance || skew < -storage.SkewTolerance
+	if was := skewed.Swap(is); is && !was {
+		log.Printf("clock skew: source clock differs from server clock by %s; shifting ingest timestamps", skew)
+	} else if was && !is {
+		log.Printf("clock skew: source clock within %s of server clock again (%s)", storage.SkewTolerance, skew)
+	}
+}
+
+// resyncMessage tells a flights stream client to discard its state; the next diff is a full snapshot.
+func resyncMessage(reason string) []byte {
+	b, _ := json.Marshal(map[string]any{"type": "resync", "reason": reason, "ts": time.Now().Unix()})
+	return b
+}
@@ -0,0 +1,307 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// Cluster mode (--cluster.redis) scales WS/SSE clients over several instances behind a load
+// balancer. The instances elect a leader with a Redis lease; only the leader polls OpenSky and
+// publishes every ingest batch on a pub/sub channel, which the followers upsert into their own
+// storage as if they had polled, so each instance computes its client diffs locally. The last
+// batch of each poll target is also kept in a hash for instances joining later. While Redis is
+// unreachable every instance polls on its own rather than serving stale positions.
+
+// ClusterConfig configures cluster mode.
+type ClusterConfig struct {
+	Redis    string        // redis:// or rediss:// URL
+	Prefix   string        // prefix of keys and channels (default "mfr:")
+	NodeID   string        // instance name in the lease and logs (default hostname-pid)
+	Lease    time.Duration // leader lease, renewed every third of it (default 15s)
+	OnLeader func()        // called when this instance becomes the leader (e.g. to poll at once)
+}
+
+// Roles of an instance; standalone is also the role while Redis is unreachable.
+const (
+	roleStandalone int32 = iota
+	roleLeader
+	roleFollower
+)
+
+var roleNames = [...]string{"standalone", "leader", "follower"}
+
+// Lease scripts: renew and release only while the lease holds our node ID.
+const (
+	clusterRenewScript   = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	clusterReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// clusterBatch is the payload of the ingest channel and the state hash.
+type clusterBatch struct {
+	Node   string          `json:"node"`
+	Source string          `json:"source"` // "world" or the region name
+	Time   int64           `json:"time"`
+	SkewMS int64           `json:"skew_ms"`
+	States [][]interface{} `json:"states"`
+}
+
+type clusterState struct {
+	cfg   ClusterConfig
+	redis redisConfig
+	role  atomic.Int32
+	mu    sync.Mutex // guards conn (commands)
+	conn  *redisConn
+}
+
+// cluster is nil unless cluster mode is enabled.
+var cluster *clusterState
+
+// StartCluster joins the cluster and runs the election and subscriber loops until stop is
+// closed; it returns an error for an invalid configuration only (Redis may be down). Call it
+// before the ingest jobs start.
+func StartCluster(cfg ClusterConfig, stop <-chan struct{}) error {
+	rc, err := parseRedisURL(cfg.Redis)
+	if err != nil {
+		return fmt.Errorf("cluster.redis: %w", err)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "mfr:"
+	}
+	if cfg.NodeID == "" {
+		host, _ := os.Hostname()
+		cfg.NodeID = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 15 * time.Second
+	}
+	c := &clusterState{cfg: cfg, redis: rc}
+	cluster = c
+	log.Printf("cluster mode: node=%s redis=%s prefix=%s lease=%s", cfg.NodeID, rc.addr, cfg.Prefix, cfg.Lease)
+	// Settle the role before the first scheduled ingest so followers never poll at startup
+	c.campaign()
+	go monitoring.Supervise("cluster.election", stop, func() { c.elect(stop) })
+	go monitoring.Supervise("cluster.subscriber", stop, func() { c.subscribe(stop) })
+	return nil
+}
+
+// ClusterRole returns "leader", "follower" or "standalone" (also without cluster mode).
+func ClusterRole() string {
+	if cluster == nil {
+		return roleNames[roleStandalone]
+	}
+	return roleNames[cluster.role.Load()]
+}
+
+// clusterFollower reports whether this instance receives its data from the leader; followers
+// neither poll OpenSky nor deliver webhooks and MQTT messages, which the leader does once.
+func clusterFollower() bool {
+	return cluster != nil && cluster.role.Load() == roleFollower
+}
+
+// command runs one command on the shared connection, reconnecting once when it is broken.
+func (c *clusterState) command(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if c.conn == nil {
+			conn, err := dialRedis(c.redis)
+			if err != nil {
+				return nil, err
+			}
+			c.conn = conn
+		}
+		v, err := c.conn.do(args...)
+		if _, ok := err.(redisError); err == nil || ok || attempt > 0 {
+			if err != nil && !ok {
+				c.conn.Close()
+				c.conn = nil
+			}
+			return v, err
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *clusterState) setRole(r int32, why string) {
+	if old := c.role.Swap(r); old != r {
+		log.Printf("cluster: %s -> %s (%s)", roleNames[old], roleNames[r], why)
+		leader := 0.0
+		if r == roleLeader {
+			leader = 1
+		}
+		monitoring.ClusterLeader.Set(leader)
+		if r == roleLeader && c.cfg.OnLeader != nil {
+			c.cfg.OnLeader()
+		}
+	}
+}
+
+// elect acquires or renews the leader lease every third of its duration.
+func (c *clusterState) elect(stop <-chan struct{}) {
+	t := time.NewTicker(c.cfg.Lease / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			// Hand over at once instead of waiting for the lease to expire
+			if c.role.Load() == roleLeader {
+				_, _ = c.command("EVAL", clusterReleaseScript, "1", c.cfg.Prefix+"leader", c.cfg.NodeID)
+			}
+			return
+		case <-t.C:
+			c.campaign()
+		}
+	}
+}
+
+// campaign renews the lease when this instance holds it (also after a Redis outage shorter
+// than the lease), or tries to take it.
+func (c *clusterState) campaign() {
+	key := c.cfg.Prefix + "leader"
+	ms := strconv.FormatInt(c.cfg.Lease.Milliseconds(), 10)
+	v, err := c.command("EVAL", clusterRenewScript, "1", key, c.cfg.NodeID, ms)
+	if err == nil && v == int64(1) {
+		c.setRole(roleLeader, "lease renewed")
+		return
+	}
+	if err == nil {
+		v, err = c.command("SET", key, c.cfg.NodeID, "NX", "PX", ms)
+	}
+	switch {
+	case err != nil:
+		c.setRole(roleStandalone, "redis unreachable: "+err.Error())
+	case v == "OK":
+		c.setRole(roleLeader, "lease acquired")
+	default:
+		c.setRole(roleFollower, "another instance holds the lease")
+	}
+}
+
+// subscribe receives the leader's batches, catching up from the state hash after each
+// (re)connect.
+func (c *clusterState) subscribe(stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		err := c.receive(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		monitoring.SubDebugf("cluster", "cluster subscriber: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (c *clusterState) receive(stop <-chan struct{}) error {
+	conn, err := dialRedis(c.redis)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+	if _, err := conn.do("SUBSCRIBE", c.cfg.Prefix+"ingest"); err != nil {
+		return err
+	}
+	if v, err := c.command("HGETALL", c.cfg.Prefix+"state"); err == nil {
+		if kv, ok := v.([]any); ok {
+			for i := 1; i < len(kv); i += 2 {
+				if b, ok := kv[i].([]byte); ok {
+					c.apply(b)
+				}
+			}
+		}
+	}
+	for {
+		v, err := conn.read()
+		if err != nil {
+			return err
+		}
+		// Pushed messages are ["message", channel, payload] as bulk strings
+		msg, ok := v.([]any)
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if b, ok := msg[2].([]byte); ok {
+			c.apply(b)
+		}
+	}
+}
+
+// apply upserts a batch of another instance while this one is a follower.
+func (c *clusterState) apply(b []byte) {
+	if c.role.Load() != roleFollower {
+		return
+	}
+	var batch clusterBatch
+	if err := json.Unmarshal(b, &batch); err != nil {
+		monitoring.ClusterBatches.WithLabelValues("error").Inc()
+		monitoring.SubDebugf("cluster", "cluster: invalid batch: %v", err)
+		return
+	}
+	s := storage.Get()
+	if batch.Node == c.cfg.NodeID || s == nil {
+		return
+	}
+	t0 := time.Now()
+	_ = s.UpsertStatesWithSkew(batch.States, time.Duration(batch.SkewMS)*time.Millisecond)
+	recordIngestDuration(time.Since(t0))
+	monitoring.ClusterBatches.WithLabelValues("applied").Inc()
+	monitoring.SubDebugf("cluster", "cluster: applied batch source=%s node=%s states=%d", batch.Source, batch.Node, len(batch.States))
+	publishUpdate()
+	endWarmup("first cluster batch")
+}
+
+// clusterPublish shares a batch ingested by the leader with the followers.
+func clusterPublish(source string, data *FlightData) {
+	c := cluster
+	if c == nil || c.role.Load() != roleLeader {
+		return
+	}
+	b, err := json.Marshal(clusterBatch{Node: c.cfg.NodeID, Source: source, Time: data.Time,
+		SkewMS: data.Skew.Milliseconds(), States: data.States})
+	if err != nil {
+		monitoring.ClusterBatches.WithLabelValues("error").Inc()
+		return
+	}
+	// The state hash outlives a few missed polls, then expires with a dead cluster
+	ttl := strconv.FormatInt(max(10*GetPollInterval(), 5*time.Minute).Milliseconds(), 10)
+	for _, args := range [][]string{
+		{"HSET", c.cfg.Prefix + "state", source, string(b)},
+		{"PEXPIRE", c.cfg.Prefix + "state", ttl},
+		{"PUBLISH", c.cfg.Prefix + "ingest", string(b)},
+	} {
+		if _, err := c.command(args...); err != nil {
+			monitoring.ClusterBatches.WithLabelValues("error").Inc()
+			monitoring.SubDebugf("cluster", "cluster: publish failed: %v", err)
+			return
+		}
+	}
+	monitoring.ClusterBatches.WithLabelValues("published").Inc()
+}
@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/tidwall/buntdb"
+)
+
+// comparePair holds separation series and summary metrics for two aligned tracks.
+type comparePair struct {
+	A             string    `json:"a"`
+	B             string    `json:"b"`
+	Lateral       []float64 `json:"lateral_m"`  // horizontal separation per aligned step
+	Vertical      []float64 `json:"vertical_m"` // |altitude difference| per aligned step
+	MinLateral    float64   `json:"min_lateral_m"`
+	MinLateralAt  int64     `json:"min_lateral_at"` // closest point of approach (unix seconds)
+	MaxLateral    float64   `json:"max_lateral_m"`
+	MeanLateral   float64   `json:"mean_lateral_m"`
+	MeanVertical  float64   `json:"mean_vertical_m"`
+	Divergence    float64   `json:"divergence_m_per_min"` // lateral separation trend (least squares slope)
+	AlignedPoints int       `json:"aligned_points"`
+}
+
+// interpolateAt linearly interpolates a time-ordered track at ts; ok is false outside the track.
+func interpolateAt(pts []storage.Point, ts int64) (storage.Point, bool) {
+	n := len(pts)
+	if n == 0 || ts < pts[0].TS || ts > pts[n-1].TS {
+		return storage.Point{}, false
+	}
+	i := 1
+	for i < n && pts[i].TS < ts {
+		i++
+	}
+	if i >= n || pts[i].TS == ts {
+		return pts[min(i, n-1)], true
+	}
+	a, b := pts[i-1], pts[i]
+	f := float64(ts-a.TS) / float64(b.TS-a.TS)
+	dLon := b.Lon - a.Lon
+	if dLon > 180 {
+		dLon -= 360
+	} else if dLon < -180 {
+		dLon += 360
+	}
+	return storage.Point{
+		Icao24: a.Icao24, Callsign: a.Callsign, TS: ts,
+		Lat: a.Lat + (b.Lat-a.Lat)*f,
+		Lon: geo.NormLon(a.Lon + dLon*f),
+		Alt: a.Alt + (b.Alt-a.Alt)*f,
+	}, true
+}
+
+// TrackCompareHandler aligns the current tracks of 2..4 flights on a common time grid and returns
+// pairwise separation metrics. Query: flights=CS1,CS2[,...], step=seconds (1..300, default 10).
+func TrackCompareHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var flights []string
+	for _, f := range strings.Split(q.Get("flights"), ",") {
+		if strings.TrimSpace(f) == "" {
+			continue
+		}
+		cs, err := parseCallsign(f)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		flights = append(flights, cs)
+	}
+	if len(flights) < 2 || len(flights) > 4 {
+		problem.Write(w, r, http.StatusBadRequest, "flights must list 2 to 4 callsigns")
+		return
+	}
+	n, err := queryInt(r, "step", 10, 1, 300)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	step := int64(n)
+	type track struct {
+		Callsign string          `json:"callsign"`
+		Icao24   string          `json:"icao24"`
+		Points   []storage.Point `json:"points"`
+	}
+	tracks := make([]track, 0, len(flights))
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	for _, cs := range flights {
+		pts, icao, err := currentSegment(cs)
+		if errors.Is(err, buntdb.ErrNotFound) || (err == nil && len(pts) == 0) {
+			problem.Write(w, r, http.StatusNotFound, "flight not found: "+cs)
+			return
+		}
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		tracks = append(tracks, track{Callsign: cs, Icao24: icao, Points: pts})
+		from = max(from, pts[0].TS)
+		to = min(to, pts[len(pts)-1].TS)
+	}
+	resp := map[string]any{"flights": tracks, "step": step}
+	if to < from {
+		// tracks do not overlap in time
+		resp["aligned"] = nil
+		resp["pairs"] = []comparePair{}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+	// Align on a common grid (bounded to keep responses small)
+	if (to-from)/step > 5000 {
+		step = (to-from)/5000 + 1
+		resp["step"] = step
+	}
+	times := []int64{}
+	for t := from; t <= to; t += step {
+		times = append(times, t)
+	}
+	aligned := make([][]storage.Point, len(tracks))
+	positions := map[string][][3]float64{}
+	for i, tr := range tracks {
+		aligned[i] = make([]storage.Point, len(times))
+		pos := make([][3]float64, len(times))
+		for j, t := range times {
+			p, _ := interpolateAt(tr.Points, t)
+			aligned[i][j] = p
+			pos[j] = [3]float64{p.Lon, p.Lat, p.Alt}
+		}
+		positions[tr.Callsign] = pos
+	}
+	pairs := []comparePair{}
+	for i := 0; i < len(tracks); i++ {
+		for k := i + 1; k < len(tracks); k++ {
+			cp := comparePair{A: tracks[i].Callsign, B: tracks[k].Callsign, MinLateral: math.Inf(1), AlignedPoints: len(times)}
+			var sumL, sumV, sx, sy, sxx, sxy float64
+			for j, t := range times {
+				a, b := aligned[i][j], aligned[k][j]
+				lat := math.Round(geo.Haversine(a.Lat, a.Lon, b.Lat, b.Lon))
+				vert := math.Round(math.Abs(a.Alt - b.Alt))
+				cp.Lateral = append(cp.Lateral, lat)
+				cp.Vertical = append(cp.Vertical, vert)
+				if lat < cp.MinLateral {
+					cp.MinLateral, cp.MinLateralAt = lat, t
+				}
+				cp.MaxLateral = math.Max(cp.MaxLateral, lat)
+				sumL += lat
+				sumV += vert
+				x := float64(t-from) / 60
+				sx += x
+				sy += lat
+				sxx += x * x
+				sxy += x * lat
+			}
+			n := float64(len(times))
+			cp.MeanLateral = math.Round(sumL / n)
+			cp.MeanVertical = math.Round(sumV / n)
+			if d := n*sxx - sx*sx; d > 0 {
+				cp.Divergence = math.Round((n*sxy-sx*sy)/d*10) / 10
+			}
+			pairs = append(pairs, cp)
+		}
+	}
+	resp["aligned"] = map[string]any{"from": from, "to": to, "times": times, "positions": positions}
+	resp["pairs"] = pairs
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,26 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+func init() { problem.Classify(storageStatus) }
+
+// storageStatus maps storage error kinds to HTTP statuses for problem.WriteError.
+func storageStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrDisabled):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrNotInitialized):
+		return http.StatusServiceUnavailable
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export budgets bound a single history/export response; larger results are served in pages
+// that clients continue with the returned cursor.
+var (
+	exportMaxRows        = 50000
+	exportMaxBytes int64 = 32 << 20
+)
+
+// Streamed exports are flushed to the client every exportFlushRows rows or exportFlushInterval,
+// whichever comes first, so neither the server nor intermediate buffers hold a whole export.
+const (
+	exportFlushRows     = 500
+	exportFlushInterval = time.Second
+	exportBufferSize    = 32 << 10
+)
+
+// SetExportLimits sets the per-response row and byte budgets (<= 0 keeps the current value).
+func SetExportLimits(rows int, bytes int64) {
+	if rows > 0 {
+		exportMaxRows = rows
+	}
+	if bytes > 0 {
+		exportMaxBytes = bytes
+	}
+}
+
+// errExportBudget reports that the next row would exceed the row or byte budget.
+var errExportBudget = errors.New("export budget exhausted")
+
+// exportWriter streams rows in buffered chunks with periodic flushes and stops once the client
+// disconnects or the budget is spent.
+type exportWriter struct {
+	ctx       context.Context
+	maxRows   int
+	maxBytes  int64 // 0: unlimited
+	buf       *bufio.Writer
+	rc        *http.ResponseController
+	rows      int
+	bytes     int64
+	unflushed int
+	lastFlush time.Time
+}
+
+func newExportWriter(w http.ResponseWriter, r *http.Request) *exportWriter {
+	return &exportWriter{
+		ctx:       r.Context(),
+		maxRows:   exportMaxRows,
+		maxBytes:  exportMaxBytes,
+		buf:       bufio.NewWriterSize(w, exportBufferSize),
+		rc:        http.NewResponseController(w),
+		lastFlush: time.Now(),
+	}
+}
+
+// Raw writes document framing that does not count as a row.
+func (e *exportWriter) Raw(s string) error {
+	n, err := e.buf.WriteString(s)
+	e.bytes += int64(n)
+	return err
+}
+
+// Row writes prefix (e.g. a separator) followed by one encoded row. Nothing is written when the
+// row would exceed the budget (errExportBudget) or the client has gone away (the context error).
+func (e *exportWriter) Row(prefix string, b []byte) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	size := int64(len(prefix) + len(b))
+	if e.rows >= e.maxRows || (e.maxBytes > 0 && e.rows > 0 && e.bytes+size > e.maxBytes) {
+		return errExportBudget
+	}
+	if _, err := e.buf.WriteString(prefix); err != nil {
+		return err
+	}
+	if _, err := e.buf.Write(b); err != nil {
+		return err
+	}
+	e.rows++
+	e.bytes += size
+	e.unflushed++
+	if e.unflushed >= exportFlushRows || time.Since(e.lastFlush) >= exportFlushInterval {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush pushes buffered output to the client.
+func (e *exportWriter) Flush() error {
+	e.unflushed = 0
+	e.lastFlush = time.Now()
+	if err := e.buf.Flush(); err != nil {
+		return err
+	}
+	if err := e.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// Close writes out whatever is still buffered.
+func (e *exportWriter) Close() error { return e.buf.Flush() }
+
+// encodeCursor returns an opaque continuation token for row offset off within scope.
+func encodeCursor(scope string, off int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(scope + ":" + strconv.Itoa(off)))
+}
+
+// decodeCursor parses a token from encodeCursor; tokens issued for another scope are rejected.
+func decodeCursor(tok, scope string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	i := strings.LastIndexByte(string(b), ':')
+	if i < 0 || string(b[:i]) != scope {
+		return 0, errors.New("invalid cursor")
+	}
+	off, err := strconv.Atoi(string(b[i+1:]))
+	if err != nil || off < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return off, nil
+}
+
+// rowRange resolves the requested rows from ?cursor= or a "Range: rows=first-[last]" header.
+// end is exclusive and -1 when open.
+func rowRange(r *http.Request, scope string) (start, end int, err error) {
+	if tok := r.URL.Query().Get("cursor"); tok != "" {
+		start, err = decodeCursor(tok, scope)
+		return start, -1, err
+	}
+	v := strings.TrimSpace(r.Header.Get("Range"))
+	if v == "" {
+		return 0, -1, nil
+	}
+	spec, ok := strings.CutPrefix(v, "rows=")
+	if !ok {
+		return 0, 0, errors.New("invalid range (rows=first-[last])")
+	}
+	a, b, ok := strings.Cut(spec, "-")
+	start, err1 := strconv.Atoi(strings.TrimSpace(a))
+	if !ok || err1 != nil || start < 0 {
+		return 0, 0, errors.New("invalid range (rows=first-[last])")
+	}
+	end = -1
+	if b = strings.TrimSpace(b); b != "" {
+		last, err := strconv.Atoi(b)
+		if err != nil || last < start {
+			return 0, 0, errors.New("invalid range (rows=first-[last])")
+		}
+		end = last + 1
+	}
+	return start, end, nil
+}
@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/aircraftdb"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// fleetFlight is a current flight of an operator with its phase.
+type fleetFlight struct {
+	storage.Point
+	Phase string `json:"phase"` // storage.Phase* or unknown
+}
+
+// FleetHandler returns the current flights of an operator for /api/fleet/{airline}, where airline
+// is a 3-letter ICAO designator (e.g. DLH). Flights match by callsign prefix or, with an aircraft
+// database, by registered operator; ?type= restricts them to type designator globs. The response
+// includes counts per phase and aircraft type.
+func FleetHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(strings.TrimSpace(chi.URLParam(r, "airline")))
+	if len(code) != 3 || strings.IndexFunc(code, func(c rune) bool { return c < 'A' || c > 'Z' }) >= 0 {
+		problem.WriteError(w, r, invalidParam("airline", "want a 3-letter ICAO designator, got %q", code))
+		return
+	}
+	types, err := parseGlobs("type", r.URL.Query().Get("type"))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	st := storage.Get()
+	pts, err := st.CurrentAll()
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	adb := aircraftdb.Get()
+	flights := []fleetFlight{}
+	phases := map[string]int{}
+	byType := map[string]int{}
+	for _, p := range pts {
+		if len(types) > 0 && !matchGlobs(types, strings.ToUpper(p.TypeCode)) {
+			continue
+		}
+		if storage.AirlinePrefix(p.Callsign) != code {
+			if a, ok := adb.Lookup(p.Icao24); !ok || a.OperatorICAO != code {
+				continue
+			}
+		}
+		f := fleetFlight{Point: p, Phase: p.Phase}
+		if f.Phase == "" {
+			f.Phase = "unknown"
+		}
+		flights = append(flights, f)
+		phases[f.Phase]++
+		if p.TypeCode != "" {
+			byType[p.TypeCode]++
+		}
+	}
+	sort.Slice(flights, func(i, j int) bool { return flights[i].Callsign < flights[j].Callsign })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"airline": code,
+		"count":   len(flights),
+		"phases":  phases,
+		"types":   byType,
+		"flights": flights,
+	})
+}
@@ -0,0 +1,289 @@
+package backend
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// Diff building shared by the flights streams (/ws/flights and /sse/flights): the current state
+// is reduced to the flights a client wants, compared with what it was sent last, and the
+// upserts/deletes are encoded in the negotiated format.
+
+// Trails attached to upserted flights.
+const (
+	streamTrailLimit  = 24
+	streamTrailWindow = 45 * time.Minute
+)
+
+// Viewport filtering: flights enter a client's view within the viewport grown by viewportMargin
+// of its width/height on each side, so aircraft just off-screen are already known when the user
+// pans; flights already sent stay until they leave it grown by a further viewportHysteresis, so
+// aircraft straddling the edge do not flap between upserts and deletes.
+var (
+	viewportMargin     = 0.25
+	viewportHysteresis = 0.1
+)
+
+// SetViewport configures the viewport margin and hysteresis (fractions of the viewport size).
+func SetViewport(margin, hysteresis float64) {
+	viewportMargin, viewportHysteresis = max(margin, 0), max(hysteresis, 0)
+}
+
+// grownViewport returns b grown by frac of its width/height on each side.
+func grownViewport(b bbox, frac float64) bbox {
+	mx := (b.MaxLon - b.MinLon) * frac
+	my := (b.MaxLat - b.MinLat) * frac
+	return bbox{MinLon: math.Max(b.MinLon-mx, -180), MinLat: math.Max(b.MinLat-my, -90),
+		MaxLon: math.Min(b.MaxLon+mx, 180), MaxLat: math.Min(b.MaxLat+my, 90)}
+}
+
+// inViewport reports whether a point lies within the grown viewport of b; known flights (sent
+// to the client before) get the hysteresis band as well.
+func inViewport(b bbox, lon, lat float64, known bool) bool {
+	frac := viewportMargin
+	if known {
+		frac += viewportHysteresis
+	}
+	g := grownViewport(b, frac)
+	return lon >= g.MinLon && lon <= g.MaxLon && lat >= g.MinLat && lat <= g.MaxLat
+}
+
+// toItem converts a stored point, rounding coordinates to precision.
+func toItem(p storage.Point, precision int) wsItem {
+	return wsItem{Icao24: p.Icao24, Callsign: p.Callsign, Lon: roundTo(p.Lon, precision), Lat: roundTo(p.Lat, precision), Alt: p.Alt, Track: p.Track, Speed: p.Speed, VRate: p.VRate, AGL: p.AGL, Rarity: p.Rarity, TS: p.TS,
+		Reg: p.Registration, TypeCode: p.TypeCode, Operator: p.Operator, Phase: p.Phase}
+}
+
+// itemKey is the client-side identity of a flight: its ICAO24 address, or the callsign when
+// the address is missing ("" skips the point).
+func itemKey(p storage.Point) string {
+	if p.Icao24 != "" {
+		return p.Icao24
+	}
+	return strings.TrimSpace(strings.ToUpper(p.Callsign))
+}
+
+// itemChanged reports whether b differs from a in any field sent to clients (trails aside).
+func itemChanged(a, b wsItem) bool {
+	return a.Lon != b.Lon || a.Lat != b.Lat || a.Alt != b.Alt || a.Track != b.Track || a.Speed != b.Speed || a.VRate != b.VRate || !sameOptional(a.AGL, b.AGL) || a.Rarity != b.Rarity || a.TS != b.TS || a.Callsign != b.Callsign || a.Reg != b.Reg || a.TypeCode != b.TypeCode || a.Operator != b.Operator || a.Phase != b.Phase
+}
+
+// sameOptional reports whether two optional values are both unknown or both known and equal.
+func sameOptional(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// attachTrail adds the recent trail of it (plain or delta-encoded) and returns its length.
+// With full, trail points also carry their timestamp and altitude: inline in plain trails,
+// as delta-encoded TrailTA pairs next to delta-encoded positions. With a trail color mode, TrailC
+// carries the color code of every point.
+func attachTrail(it *wsItem, precision int, full bool, color trailColor) int {
+	icao := strings.TrimSpace(it.Icao24)
+	if icao == "" {
+		return 0
+	}
+	pts, err := storage.Get().RecentTrackByICAO(icao, streamTrailLimit, streamTrailWindow)
+	if err != nil || len(pts) == 0 {
+		return 0
+	}
+	tr := make([]trailPoint, 0, len(pts))
+	for _, tp := range pts {
+		t := trailPoint{Lon: tp.Lon, Lat: tp.Lat}
+		if full {
+			t.TS, t.Alt = tp.TS, tp.Alt
+		}
+		tr = append(tr, t)
+	}
+	if precision > 0 {
+		it.TrailD = deltaTrail(tr, precision)
+		if full {
+			it.TrailTA = deltaTrailTA(tr)
+		}
+	} else {
+		it.Trail = tr
+	}
+	it.TrailC = trailColorCodes(pts, color)
+	return len(tr)
+}
+
+// trailTA returns the delta-encoded ts,alt pairs of a full trail for the array encodings
+// (compact, pb), whose plain trails are flat lon,lat pairs; nil for position-only trails.
+func trailTA(it wsItem) []int64 {
+	if len(it.TrailTA) > 0 {
+		return it.TrailTA
+	}
+	if len(it.Trail) > 0 && it.Trail[0].TS != 0 {
+		return deltaTrailTA(it.Trail)
+	}
+	return nil
+}
+
+// candidates returns the current flights a stream may send, ordered by ICAO24 address: all of
+// them without a viewport, otherwise those in the viewport grown by margin and hysteresis (from
+// the spatial index) plus the watched aircraft.
+func candidates(view *bbox, icaos, callsigns map[string]bool) ([]storage.Point, error) {
+	st := storage.Get()
+	if view == nil {
+		return st.CurrentAll()
+	}
+	g := grownViewport(*view, viewportMargin+viewportHysteresis)
+	pts, err := st.CurrentInBBox(g.MinLon, g.MinLat, g.MaxLon, g.MaxLat)
+	if err != nil || (len(icaos) == 0 && len(callsigns) == 0) {
+		return pts, err
+	}
+	var extra []storage.Point
+	if len(icaos) > 0 {
+		byICAO, err := st.CurrentByICAO(slices.Collect(maps.Keys(icaos)))
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, byICAO...)
+	}
+	if len(callsigns) > 0 {
+		byCS, err := st.CurrentByCallsign(slices.Collect(maps.Keys(callsigns)))
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, byCS...)
+	}
+	seen := make(map[string]bool, len(pts))
+	for _, p := range pts {
+		seen[p.Icao24] = true
+	}
+	for _, p := range extra {
+		if !seen[p.Icao24] {
+			seen[p.Icao24] = true
+			pts = append(pts, p)
+		}
+	}
+	slices.SortStableFunc(pts, func(a, b storage.Point) int { return strings.Compare(a.Icao24, b.Icao24) })
+	return pts, nil
+}
+
+// currentItems returns the flights of pts kept by keep, by key and in order; known tells keep
+// whether the flight is in last (already on the client).
+func currentItems(pts []storage.Point, last map[string]wsItem, keep func(p storage.Point, known bool) bool, precision int) (map[string]wsItem, []wsItem) {
+	cur := make(map[string]wsItem, len(pts))
+	arr := make([]wsItem, 0, len(pts))
+	for _, p := range pts {
+		key := itemKey(p)
+		if key == "" {
+			continue
+		}
+		if _, known := last[key]; !keep(p, known) {
+			continue
+		}
+		it := toItem(p, precision)
+		cur[key] = it
+		arr = append(arr, it)
+	}
+	return cur, arr
+}
+
+// diffItems returns the flights of cur that are new or changed since last and the keys of those
+// no longer sent: dl for flights that disappeared, out for flights still tracked but now outside
+// the viewport or flight filter. An empty last means a full snapshot (arr, in order).
+func diffItems(last, cur map[string]wsItem, arr []wsItem) (up []wsItem, dl, out []string) {
+	if len(last) == 0 {
+		return arr, []string{}, nil
+	}
+	up = make([]wsItem, 0, len(arr))
+	for k, v := range cur {
+		if ov, ok := last[k]; !ok || itemChanged(ov, v) {
+			up = append(up, v)
+		}
+	}
+	var gone []string
+	var icaos []string
+	for k, v := range last {
+		if _, ok := cur[k]; !ok {
+			gone = append(gone, k)
+			if v.Icao24 != "" {
+				icaos = append(icaos, v.Icao24)
+			}
+		}
+	}
+	live := map[string]bool{}
+	if len(icaos) > 0 {
+		pts, _ := storage.Get().CurrentByICAO(icaos)
+		for _, p := range pts {
+			live[p.Icao24] = true
+		}
+	}
+	dl = make([]string, 0)
+	for _, k := range gone {
+		if ic := last[k].Icao24; ic != "" && live[ic] {
+			out = append(out, k)
+		} else {
+			dl = append(dl, k)
+		}
+	}
+	return up, dl, out
+}
+
+// encodeDiff renders a diff/priority message in encoding (pb: a binary Frame, see
+// flights.proto). Compact diffs carry upserts as u, deletes as d and out-of-view keys as o;
+// items are arrays [icao24, callsign, lon, lat, alt, track, speed, ts, agl, rarity, trail, registration,
+// typecode, operator, phase] with trailing empty
+// elements trimmed; trail is flat [lon,lat,...] (or trail_d integers when precision is set).
+func encodeDiff(m wsDiff, encoding string) []byte {
+	if encoding == encodingPB {
+		return pbDiff(m)
+	}
+	if encoding != encodingCompact {
+		b, _ := json.Marshal(m)
+		return b
+	}
+	u := make([][]any, 0, len(m.Upsert))
+	for _, it := range m.Upsert {
+		row := []any{it.Icao24, it.Callsign, it.Lon, it.Lat, it.Alt, it.Track, it.Speed, it.TS, nil, it.Rarity, nil, it.Reg, it.TypeCode, it.Operator, it.Phase, nil, it.VRate, nil}
+		if it.AGL != nil {
+			row[8] = *it.AGL
+		}
+		switch {
+		case len(it.TrailD) > 0:
+			row[10] = it.TrailD
+		case len(it.Trail) > 0:
+			flat := make([]float64, 0, 2*len(it.Trail))
+			for _, tp := range it.Trail {
+				flat = append(flat, tp.Lon, tp.Lat)
+			}
+			row[10] = flat
+		}
+		if ta := trailTA(it); len(ta) > 0 {
+			row[15] = ta
+		}
+		if len(it.TrailC) > 0 {
+			row[17] = it.TrailC
+		}
+		n := len(row)
+		for n > 8 && (row[n-1] == nil || row[n-1] == 0.0 || row[n-1] == 0 || row[n-1] == "") {
+			n--
+		}
+		u = append(u, row[:n])
+	}
+	out := map[string]any{"type": m.Type, "u": u}
+	if m.Seq > 0 {
+		out["seq"] = m.Seq
+	}
+	if m.Precision > 0 {
+		out["precision"] = m.Precision
+	}
+	if len(m.Delete) > 0 {
+		out["d"] = m.Delete
+	}
+	if len(m.OutOfView) > 0 {
+		out["o"] = m.OutOfView
+	}
+	b, _ := json.Marshal(out)
+	return b
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// flightSort orders /api/flights server-side (?sort=distance|alt|speed, order=, ref=, limit=),
+// so sidebars listing the nearest, highest or fastest aircraft only fetch the first rows.
+type flightSort struct {
+	by       string // "" keeps the storage order (ICAO24)
+	desc     bool
+	lat, lon float64 // reference for distance
+	limit    int     // 0: all
+}
+
+// maxFlightsLimit bounds the limit of /api/flights.
+const maxFlightsLimit = 10000
+
+// parseFlightSort reads the ordering parameters of /api/flights. Distance sorts nearest first
+// from ref=lat,lon (default: the receiver location); alt and speed highest/fastest first.
+func parseFlightSort(r *http.Request) (flightSort, error) {
+	q := r.URL.Query()
+	s := flightSort{by: strings.ToLower(strings.TrimSpace(q.Get("sort")))}
+	switch s.by {
+	case "":
+	case "distance":
+		ref := strings.TrimSpace(q.Get("ref"))
+		if ref == "" {
+			lat, lon, ok := geo.Receiver()
+			if !ok {
+				return s, invalidParam("ref", "sort=distance needs ref=lat,lon (no receiver location is configured)")
+			}
+			s.lat, s.lon = lat, lon
+			break
+		}
+		lat, lon, err := geo.ParseLatLon(ref)
+		if err != nil {
+			return s, invalidParam("ref", "want lat,lon: %v", err)
+		}
+		s.lat, s.lon = lat, lon
+	case "alt", "speed":
+		s.desc = true
+	default:
+		return s, invalidParam("sort", "want distance, alt or speed, got %q", s.by)
+	}
+	switch o := strings.ToLower(q.Get("order")); o {
+	case "":
+	case "asc", "desc":
+		s.desc = o == "desc"
+	default:
+		return s, invalidParam("order", "want asc or desc, got %q", o)
+	}
+	limit, err := queryInt(r, "limit", 0, 1, maxFlightsLimit)
+	if err != nil {
+		return s, err
+	}
+	s.limit = limit
+	return s, nil
+}
+
+// sortedFlight is a flight of a distance-sorted /api/flights response.
+type sortedFlight struct {
+	storage.Point
+	DistanceM float64 `json:"distance_m"` // great-circle distance from ref
+}
+
+// apply orders pts (ties by ICAO24, the storage order), cuts them to the limit and rounds the
+// coordinates of the rest to precision (after measuring distances). Distance sorts return
+// sortedFlight items, others the points themselves.
+func (s flightSort) apply(pts []storage.Point, precision int) any {
+	var key func(p storage.Point) float64
+	switch s.by {
+	case "distance":
+		key = func(p storage.Point) float64 { return geo.Haversine(s.lat, s.lon, p.Lat, p.Lon) }
+	case "alt":
+		key = func(p storage.Point) float64 { return p.Alt }
+	case "speed":
+		key = func(p storage.Point) float64 { return p.Speed }
+	default:
+		key = func(storage.Point) float64 { return 0 }
+	}
+	keys := make([]float64, len(pts))
+	idx := make([]int, len(pts))
+	for i, p := range pts {
+		keys[i], idx[i] = key(p), i
+	}
+	if s.by != "" {
+		sort.SliceStable(idx, func(a, b int) bool {
+			if s.desc {
+				return keys[idx[a]] > keys[idx[b]]
+			}
+			return keys[idx[a]] < keys[idx[b]]
+		})
+	}
+	idx = firstN(idx, s.limit)
+	out := make([]storage.Point, len(idx))
+	for i, k := range idx {
+		p := pts[k]
+		p.Lon, p.Lat = roundTo(p.Lon, precision), roundTo(p.Lat, precision)
+		out[i] = p
+	}
+	if s.by != "distance" {
+		return out
+	}
+	dist := make([]sortedFlight, len(out))
+	for i, p := range out {
+		dist[i] = sortedFlight{Point: p, DistanceM: math.Round(keys[idx[i]])}
+	}
+	return dist
+}
+
+// firstN returns the first n elements of v (all when n is 0).
+func firstN[T any](v []T, n int) []T {
+	if n > 0 && len(v) > n {
+		return v[:n]
+	}
+	return v
+}
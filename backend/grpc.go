@@ -0,0 +1,542 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/security"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/tidwall/buntdb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// gRPC API (--grpc.listen) for services that would rather not implement the WS protocol; the
+// service is defined in api.proto. Messages are encoded by hand with protowire like the WS
+// protobuf frames (wspb.go), through a codec registered under the standard "proto" name, so
+// clients generated from api.proto interoperate unchanged.
+
+const grpcServiceName = "miniflightradar.api.FlightRadar"
+
+// grpcStopping is closed when the server stops, ending WatchFlights streams.
+var grpcStopping = make(chan struct{})
+
+// StartGRPC serves the gRPC API on addr. Serve errors are reported on errCh. Calls must carry
+// an API key when security.apikeys.file is set; without keys the API is open, so addr must
+// then be a loopback address.
+func StartGRPC(addr string, errCh chan<- error) (*grpc.Server, error) {
+	if !security.APIKeysEnabled() && !loopbackAddr(addr) {
+		return nil, fmt.Errorf("grpc.listen %q: without security.apikeys.file the gRPC API has no authentication and must listen on a loopback address (e.g. 127.0.0.1:9091)", addr)
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("grpc listener: %w", err)
+	}
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(grpcCodec{}),
+		grpc.ChainUnaryInterceptor(grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(grpcStreamInterceptor),
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: 30 * time.Second, Timeout: 10 * time.Second}),
+	)
+	s.RegisterService(&grpcServiceDesc, nil)
+	go func() {
+		if err := s.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			errCh <- err
+		}
+	}()
+	log.Printf("gRPC API listening on %s (%s)", addr, grpcServiceName)
+	return s, nil
+}
+
+// StopGRPC ends the watch streams and stops s gracefully, forcibly once ctx is done.
+func StopGRPC(ctx context.Context, s *grpc.Server) {
+	close(grpcStopping)
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnary("GetFlight", grpcGetFlight),
+		grpcUnary("ListFlightsInBBox", grpcListFlightsInBBox),
+		grpcUnary("GetTrack", grpcGetTrack),
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchFlights", Handler: grpcWatchFlights, ServerStreams: true},
+	},
+	Metadata: "api.proto",
+}
+
+// grpcUnary adapts fn to a unary method handler.
+func grpcUnary[T any, PT interface {
+	*T
+	pbUnmarshaler
+}](name string, fn func(context.Context, PT) (pbMarshaler, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{MethodName: name, Handler: func(_ any, ctx context.Context, dec func(any) error, ic grpc.UnaryServerInterceptor) (any, error) {
+		req := PT(new(T))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		call := func(ctx context.Context, r any) (any, error) { return fn(ctx, r.(PT)) }
+		if ic == nil {
+			return call(ctx, req)
+		}
+		return ic(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/" + grpcServiceName + "/" + name}, call)
+	}}
+}
+
+// loopbackAddr reports whether the listen address addr only accepts local connections.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// grpcAuthorize checks the API key of a call, sent as "x-api-key: <key>" or "authorization:
+// Bearer <key>" metadata like the HTTP headers. Every key may call the read-only gRPC API.
+func grpcAuthorize(ctx context.Context, method string) error {
+	if !security.APIKeysEnabled() {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var secret string
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		secret = v[0]
+	} else if v := md.Get("authorization"); len(v) > 0 {
+		secret, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	if strings.TrimSpace(secret) == "" {
+		return status.Error(codes.Unauthenticated, "API key required")
+	}
+	name, ok := security.APIKeyName(secret)
+	if !ok {
+		monitoring.APIKeyRequests.WithLabelValues("unknown", "unauthorized").Inc()
+		log.Printf("apikey_denied grpc=%s", method)
+		return status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	monitoring.APIKeyRequests.WithLabelValues(name, "ok").Inc()
+	return nil
+}
+
+// grpcUnaryInterceptor authorizes and counts calls and turns panics into INTERNAL errors.
+func grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			monitoring.RecordPanic("grpc"+info.FullMethod, v, debug.Stack(), false)
+			err = status.Error(codes.Internal, "internal error")
+		}
+		monitoring.GRPCRequests.WithLabelValues(grpcMethod(info.FullMethod), status.Code(err).String()).Inc()
+	}()
+	if err := grpcAuthorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	monitoring.GRPCStreams.Inc()
+	defer monitoring.GRPCStreams.Dec()
+	defer func() {
+		if v := recover(); v != nil {
+			monitoring.RecordPanic("grpc"+info.FullMethod, v, debug.Stack(), false)
+			err = status.Error(codes.Internal, "internal error")
+		}
+		monitoring.GRPCRequests.WithLabelValues(grpcMethod(info.FullMethod), status.Code(err).String()).Inc()
+	}()
+	if err := grpcAuthorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcMethod returns the method name of a full method ("/service/Method").
+func grpcMethod(full string) string { return full[strings.LastIndexByte(full, '/')+1:] }
+
+// grpcError converts a handler error to a gRPC status using its HTTP status (problem.Status).
+func grpcError(err error) error {
+	st, detail, ok := problem.Status(err)
+	if !ok {
+		log.Printf("grpc internal error: %v", err)
+	}
+	code := codes.Internal
+	switch st {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, detail)
+}
+
+func grpcGetFlight(_ context.Context, req *grpcFlightRequest) (pbMarshaler, error) {
+	var p *storage.Point
+	switch {
+	case req.Icao24 != "":
+		icao, err := parseICAO24(req.Icao24)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		pts, err := storage.Get().CurrentByICAO([]string{icao})
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if len(pts) > 0 {
+			p = &pts[0]
+		}
+	default:
+		cs, err := parseCallsign(req.Callsign)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if p, err = storage.Get().LatestByCallsign(cs); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+			return nil, grpcError(err)
+		}
+	}
+	if p == nil {
+		return nil, status.Error(codes.NotFound, "flight not found")
+	}
+	return grpcPoint(*p), nil
+}
+
+func grpcListFlightsInBBox(_ context.Context, req *grpcBBoxRequest) (pbMarshaler, error) {
+	if req.BBox == nil {
+		return nil, status.Error(codes.InvalidArgument, "bbox is required")
+	}
+	b, err := req.BBox.validate()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	pts, err := storage.Get().CurrentInBBox(b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcFlightList(pts), nil
+}
+
+func grpcGetTrack(_ context.Context, req *grpcTrackRequest) (pbMarshaler, error) {
+	cs, err := parseCallsign(req.Callsign)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	pts, icao, err := currentSegment(cs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &grpcTrack{Callsign: cs, Icao24: icao, Points: pts}, nil
+}
+
+// grpcWatchFlights streams the /ws/flights diffs of a viewport and flight filter. There are
+// no ACKs: a slow client blocks Send through HTTP/2 flow control, and changes accumulate into
+// the next diff meanwhile.
+func grpcWatchFlights(_ any, stream grpc.ServerStream) error {
+	req := &grpcWatchRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	precision := int(req.Precision)
+	if precision > maxPrecision {
+		return status.Errorf(codes.InvalidArgument, "invalid precision: want 1..%d", maxPrecision)
+	}
+	var view *bbox
+	if req.BBox != nil {
+		b, err := req.BBox.validate()
+		if err != nil {
+			return grpcError(err)
+		}
+		view = &b
+	}
+	filter, err := parseFlightFilter(req.Callsign, req.CallsignRe, req.TypeCode)
+	if err != nil {
+		return grpcError(err)
+	}
+	color, ok := parseTrailColorName(req.TrailColor)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "invalid trail_color: want none, alt or speed")
+	}
+	if Shedding() {
+		monitoring.ShedEvents.WithLabelValues("rejected").Inc()
+		return status.Error(codes.Unavailable, "server overloaded, retry later")
+	}
+	keep := func(p storage.Point, known bool) bool {
+		return (view == nil || inViewport(*view, p.Lon, p.Lat, known)) && filter.Match(p)
+	}
+	ctx := stream.Context()
+	monitoring.SubDebugf("ws", "flights grpc watch started precision=%d bbox=%v", precision, view)
+
+	last := map[string]wsItem{}
+	var seq int64
+	send := func() error {
+		pts, err := candidates(view, nil, nil)
+		if err != nil {
+			return grpcError(err)
+		}
+		cur, arr := currentItems(pts, last, keep, precision)
+		up, dl, out := diffItems(last, cur, arr)
+		if len(up) == 0 && len(dl) == 0 && len(out) == 0 && seq > 0 {
+			last = cur
+			return nil
+		}
+		for i := 0; i < len(up) && !Shedding(); i++ {
+			attachTrail(&up[i], precision, req.TrailFull, color)
+		}
+		seq++
+		if err := stream.SendMsg(grpcDiff{Type: "diff", Seq: seq, Precision: precision, Upsert: up, Delete: dl, OutOfView: out}); err != nil {
+			return err
+		}
+		last = cur
+		monitoring.SubDebugf("ws", "flights grpc => diff seq=%d up=%d del=%d out=%d", seq, len(up), len(dl), len(out))
+		return nil
+	}
+
+	updates, unsubscribe := UpdatesSubscribe()
+	defer unsubscribe()
+	resyncSeen := wsResync.Load()
+	// During start-up warm-up the snapshot waits for the first poll
+	warmC := warmingUp()
+	if warmC == nil {
+		if err := send(); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-grpcStopping:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-warmC:
+			warmC = nil
+			if err := send(); err != nil {
+				return err
+			}
+		case <-updates:
+			if warmC != nil {
+				break
+			}
+			if v := wsResync.Load(); v != resyncSeen {
+				return status.Error(codes.Aborted, "server clock jump: watch again for a fresh snapshot")
+			}
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pbMarshaler and pbUnmarshaler are the messages grpcCodec handles.
+type pbMarshaler interface{ marshalPB() []byte }
+type pbUnmarshaler interface{ unmarshalPB([]byte) error }
+
+// grpcCodec encodes the hand-written messages of this file under the standard codec name.
+type grpcCodec struct{}
+
+func (grpcCodec) Name() string { return "proto" }
+
+func (grpcCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(pbMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("grpc codec: cannot marshal %T", v)
+	}
+	return m.marshalPB(), nil
+}
+
+func (grpcCodec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(pbUnmarshaler)
+	if !ok {
+		return fmt.Errorf("grpc codec: cannot unmarshal %T", v)
+	}
+	return m.unmarshalPB(b)
+}
+
+// grpcBBox is a BBox message; validate applies the checks of ?bbox=.
+type grpcBBox bbox
+
+func (g *grpcBBox) validate() (bbox, error) {
+	for _, v := range [...]float64{g.MinLon, g.MinLat, g.MaxLon, g.MaxLat} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return bbox{}, invalidParam("bbox", "coordinate is not a number")
+		}
+	}
+	return parseBBox(fmt.Sprintf("%g,%g,%g,%g", g.MinLon, g.MinLat, g.MaxLon, g.MaxLat))
+}
+
+func (g *grpcBBox) unmarshalPB(b []byte) error {
+	return pbFields(b, func(n protowire.Number, typ protowire.Type, _ []byte, x uint64) error {
+		if typ != protowire.Fixed64Type {
+			return nil
+		}
+		switch n {
+		case 1:
+			g.MinLon = math.Float64frombits(x)
+		case 2:
+			g.MinLat = math.Float64frombits(x)
+		case 3:
+			g.MaxLon = math.Float64frombits(x)
+		case 4:
+			g.MaxLat = math.Float64frombits(x)
+		}
+		return nil
+	})
+}
+
+// grpcBBoxField decodes an optional BBox field.
+func grpcBBoxField(v []byte) (*grpcBBox, error) {
+	g := &grpcBBox{}
+	return g, g.unmarshalPB(v)
+}
+
+type grpcFlightRequest struct{ Callsign, Icao24 string }
+
+func (r *grpcFlightRequest) unmarshalPB(b []byte) error {
+	return pbFields(b, func(n protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch n {
+		case 1:
+			r.Callsign = string(v)
+		case 2:
+			r.Icao24 = string(v)
+		}
+		return nil
+	})
+}
+
+type grpcBBoxRequest struct{ BBox *grpcBBox }
+
+func (r *grpcBBoxRequest) unmarshalPB(b []byte) error {
+	return pbFields(b, func(n protowire.Number, typ protowire.Type, v []byte, _ uint64) (err error) {
+		if n == 1 && typ == protowire.BytesType {
+			r.BBox, err = grpcBBoxField(v)
+		}
+		return err
+	})
+}
+
+type grpcTrackRequest struct{ Callsign string }
+
+func (r *grpcTrackRequest) unmarshalPB(b []byte) error {
+	return pbFields(b, func(n protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if n == 1 && typ == protowire.BytesType {
+			r.Callsign = string(v)
+		}
+		return nil
+	})
+}
+
+type grpcWatchRequest struct {
+	BBox                           *grpcBBox
+	Precision                      uint64
+	Callsign, CallsignRe, TypeCode string
+	TrailFull                      bool
+	TrailColor                     string
+}
+
+func (r *grpcWatchRequest) unmarshalPB(b []byte) error {
+	return pbFields(b, func(n protowire.Number, typ protowire.Type, v []byte, x uint64) (err error) {
+		switch {
+		case n == 1 && typ == protowire.BytesType:
+			r.BBox, err = grpcBBoxField(v)
+		case n == 2 && typ == protowire.VarintType:
+			r.Precision = x
+		case n == 3 && typ == protowire.BytesType:
+			r.Callsign = string(v)
+		case n == 4 && typ == protowire.BytesType:
+			r.CallsignRe = string(v)
+		case n == 5 && typ == protowire.BytesType:
+			r.TypeCode = string(v)
+		case n == 6 && typ == protowire.VarintType:
+			r.TrailFull = x != 0
+		case n == 7 && typ == protowire.BytesType:
+			r.TrailColor = string(v)
+		}
+		return err
+	})
+}
+
+// grpcPoint is a Point message.
+type grpcPoint storage.Point
+
+func (p grpcPoint) marshalPB() []byte {
+	var b []byte
+	b = pbString(b, 1, p.Icao24)
+	b = pbString(b, 2, p.Callsign)
+	b = pbDouble(b, 3, p.Lon)
+	b = pbDouble(b, 4, p.Lat)
+	b = pbDouble(b, 5, p.Alt)
+	b = pbDouble(b, 6, p.Track)
+	b = pbDouble(b, 7, p.Speed)
+	b = pbVarint(b, 8, uint64(p.TS))
+	b = pbOptDouble(b, 9, p.AGL)
+	b = pbDouble(b, 10, p.VRate)
+	if p.Ground {
+		b = pbVarint(b, 11, 1)
+	}
+	b = pbString(b, 12, p.Phase)
+	b = pbVarint(b, 13, uint64(p.Rarity))
+	b = pbString(b, 14, p.Registration)
+	b = pbString(b, 15, p.TypeCode)
+	b = pbString(b, 16, p.Operator)
+	return b
+}
+
+type grpcFlightList []storage.Point
+
+func (l grpcFlightList) marshalPB() []byte {
+	var b []byte
+	for _, p := range l {
+		b = pbMessage(b, 1, grpcPoint(p).marshalPB())
+	}
+	return b
+}
+
+type grpcTrack struct {
+	Callsign, Icao24 string
+	Points           []storage.Point
+}
+
+func (t *grpcTrack) marshalPB() []byte {
+	b := pbString(nil, 1, t.Callsign)
+	b = pbString(b, 2, t.Icao24)
+	for _, p := range t.Points {
+		b = pbMessage(b, 3, grpcPoint(p).marshalPB())
+	}
+	return b
+}
+
+// grpcDiff is a miniflightradar.ws.Diff message.
+type grpcDiff wsDiff
+
+func (d grpcDiff) marshalPB() []byte { return pbDiffMsg(wsDiff(d)) }
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/features"
+	"github.com/maniack/miniflightradar/httpclient"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/scheduler"
+	"github.com/maniack/miniflightradar/terrain"
+)
+
+// Inbound webhooks (/api/hooks/*) let orchestration and monitoring systems trigger actions
+// without the admin token; security.HooksMiddleware authenticates them with hooks.secret.
+
+// hookRefetchMin spaces re-fetches triggered by webhooks, so a looping caller cannot spend the
+// source's request quota.
+const hookRefetchMin = 10 * time.Second
+
+var (
+	hookRefetchMu   sync.Mutex
+	hookRefetchLast time.Time
+)
+
+// RefetchHook polls the source now (POST /api/hooks/refetch): the states caches are purged and
+// the ingest jobs (world or regions) triggered. Responds 202 with the jobs started and those
+// already running, 429 within hookRefetchMin of the previous re-fetch and 409 on cluster
+// followers, which do not poll.
+func RefetchHook(w http.ResponseWriter, r *http.Request) {
+	if clusterFollower() {
+		monitoring.HookRequests.WithLabelValues("refetch", "rejected").Inc()
+		problem.Write(w, r, http.StatusConflict, "this instance is a cluster follower; only the leader polls")
+		return
+	}
+	var jobs []string
+	for _, j := range scheduler.Jobs() {
+		if j.Name == "ingest" || strings.HasPrefix(j.Name, "ingest.") {
+			jobs = append(jobs, j.Name)
+		}
+	}
+	if len(jobs) == 0 {
+		monitoring.HookRequests.WithLabelValues("refetch", "rejected").Inc()
+		problem.Write(w, r, http.StatusConflict, "no ingest jobs (replay mode)")
+		return
+	}
+	hookRefetchMu.Lock()
+	if wait := hookRefetchMin - time.Since(hookRefetchLast); wait > 0 {
+		hookRefetchMu.Unlock()
+		monitoring.HookRequests.WithLabelValues("refetch", "throttled").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		problem.Write(w, r, http.StatusTooManyRequests, "re-fetched less than "+hookRefetchMin.String()+" ago")
+		return
+	}
+	hookRefetchLast = time.Now()
+	hookRefetchMu.Unlock()
+
+	PurgeStatesCache()
+	started, running := []string{}, []string{}
+	for _, name := range jobs {
+		if _, ok := scheduler.Trigger(name); ok {
+			started = append(started, name)
+		} else {
+			running = append(running, name)
+		}
+	}
+	monitoring.HookRequests.WithLabelValues("refetch", "ok").Inc()
+	monitoring.SubDebugf("ingest", "hook refetch started=%v running=%v", started, running)
+	writeHookResult(w, http.StatusAccepted, map[string]any{"hook": "refetch", "started": started, "running": running})
+}
+
+// PurgeHook drops the in-memory caches of upstream data (POST /api/hooks/purge): the source
+// responses, the DNS cache (net.dns.cache_ttl) and elevations from the terrain API. It lists
+// the caches flushed.
+func PurgeHook(w http.ResponseWriter, r *http.Request) {
+	PurgeStatesCache()
+	flushed := []string{"states"}
+	if httpclient.FlushDNS() {
+		flushed = append(flushed, "dns")
+	}
+	if terrain.Flush() {
+		flushed = append(flushed, "terrain")
+	}
+	monitoring.HookRequests.WithLabelValues("purge", "ok").Inc()
+	writeHookResult(w, http.StatusOK, map[string]any{"hook": "purge", "flushed": flushed})
+}
+
+// AlertTestHook fires an alert of type "test" through the alert pipeline (POST
+// /api/hooks/alert_test): the event log, /ws/alerts and the webhook, which is the one of the
+// rule given as {"rule":"<id>"} or else alerts.webhook. Receivers can tell tests apart by the
+// type and the empty icao24.
+func AlertTestHook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rule string `json:"rule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		monitoring.HookRequests.WithLabelValues("alert_test", "rejected").Inc()
+		problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if !features.Enabled("alerts") {
+		monitoring.HookRequests.WithLabelValues("alert_test", "rejected").Inc()
+		problem.Write(w, r, http.StatusConflict, "alerts are disabled")
+		return
+	}
+	ev := AlertEvent{Type: "test", Rule: "test", RuleName: "webhook test", TS: time.Now().Unix()}
+	webhook := ""
+	if id := strings.TrimSpace(req.Rule); id != "" {
+		var found bool
+		if rules := alertRules.Load(); rules != nil {
+			for _, rule := range *rules {
+				if rule.ID == id {
+					ev.Rule, ev.RuleName, webhook, found = rule.ID, rule.Name, rule.Webhook, true
+					break
+				}
+			}
+		}
+		if !found {
+			monitoring.HookRequests.WithLabelValues("alert_test", "rejected").Inc()
+			problem.Write(w, r, http.StatusNotFound, "alert rule not found")
+			return
+		}
+	}
+	emitAlert(ev, webhook)
+	monitoring.HookRequests.WithLabelValues("alert_test", "ok").Inc()
+	writeHookResult(w, http.StatusAccepted, map[string]any{"hook": "alert_test", "alert": ev, "webhook": webhook != "" || alertWebhook != ""})
+}
+
+func writeHookResult(w http.ResponseWriter, status int, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
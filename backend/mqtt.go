@@ -0,0 +1,272 @@
+package backend
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// MQTTConfig configures publishing ingested positions to an MQTT broker (Home Assistant,
+// Node-RED, ...).
+type MQTTConfig struct {
+	Broker      string // tcp://, mqtt://, ssl:// or mqtts:// URL; credentials as user:pass@
+	TopicPrefix string // positions are published to {prefix}/{icao24}
+}
+
+const (
+	mqttQueue     = 4096
+	mqttKeepAlive = 60 * time.Second
+)
+
+// mqttOut queues new positions for the publisher; nil while MQTT output is disabled.
+var mqttOut chan storage.Point
+
+// StartMQTT publishes every new position as JSON to {prefix}/{icao24} (QoS 0, retained, so a
+// subscriber gets the current position of each aircraft right away) until stop is closed,
+// reconnecting with backoff when the connection drops. It is a no-op without c.Broker.
+func StartMQTT(c MQTTConfig, stop <-chan struct{}) error {
+	if strings.TrimSpace(c.Broker) == "" {
+		return nil
+	}
+	u, err := parseMQTTBroker(c.Broker)
+	if err != nil {
+		return err
+	}
+	prefix := strings.Trim(strings.TrimSpace(c.TopicPrefix), "/")
+	if prefix == "" {
+		prefix = "flights"
+	}
+	if strings.ContainsAny(prefix, "+#") {
+		return fmt.Errorf("invalid MQTT topic prefix %q: wildcards are not allowed", prefix)
+	}
+	mqttOut = make(chan storage.Point, mqttQueue)
+	storage.AddObserver(ObserveMQTT)
+	go monitoring.Supervise("mqtt.publisher", stop, func() { mqttConnectLoop(u, prefix, stop) })
+	log.Printf("mqtt output enabled broker=%s topic=%s/{icao24}", u.Redacted(), prefix)
+	return nil
+}
+
+// ObserveMQTT is a storage observer that queues each new position for the MQTT publisher.
+func ObserveMQTT(_ *storage.Point, cur storage.Point) {
+	// Cluster followers receive the same states as the leader, which publishes them once
+	if mqttOut == nil || cur.Icao24 == "" || clusterFollower() {
+		return
+	}
+	select {
+	case mqttOut <- cur:
+	default: // broker down or slow: drop rather than block ingest
+		monitoring.MQTTPublished.WithLabelValues("dropped").Inc()
+	}
+}
+
+// parseMQTTBroker accepts host:port or a URL and fills in the default port of the scheme.
+func parseMQTTBroker(s string) (*url.URL, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "tcp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid MQTT broker %q", s)
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		port = "8883"
+	default:
+		return nil, fmt.Errorf("invalid MQTT broker %q: unsupported scheme %q", s, u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+func mqttConnectLoop(u *url.URL, prefix string, stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		conn, err := mqttDial(u)
+		if err != nil {
+			monitoring.SubDebugf("ingest", "mqtt connect broker=%s error: %v (retry in %s)", u.Host, err, backoff)
+		} else {
+			monitoring.SubDebugf("ingest", "mqtt connected broker=%s", u.Host)
+			monitoring.MQTTConnected.Set(1)
+			start := time.Now()
+			err = mqttPublish(conn, prefix, stop)
+			monitoring.MQTTConnected.Set(0)
+			if err != nil {
+				log.Printf("mqtt connection to %s lost: %v", u.Host, err)
+			}
+			if time.Since(start) > time.Minute {
+				backoff = time.Second
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// mqttDial opens the connection and completes the MQTT 3.1.1 handshake (clean session).
+func mqttDial(u *url.URL) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "tcp" || u.Scheme == "mqtt" {
+		conn, err = d.Dial("tcp", u.Host)
+	} else {
+		conn, err = tls.DialWithDialer(d, "tcp", u.Host, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	var flags byte = 0x02 // clean session
+	payload := mqttString(nil, "miniflightradar-"+host)
+	if user := u.User.Username(); user != "" {
+		flags |= 0x80
+		payload = mqttString(payload, user)
+		if pass, ok := u.User.Password(); ok {
+			flags |= 0x40
+			payload = mqttString(payload, pass)
+		}
+	}
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	typ, ack, err := mqttRead(bufio.NewReader(conn))
+	switch {
+	case err != nil:
+	case typ != 0x20 || len(ack) != 2:
+		err = fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", typ)
+	case ack[1] != 0:
+		err = fmt.Errorf("connection refused (code %d)", ack[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// mqttPublish sends queued positions as retained QoS 0 messages, pinging the broker when idle,
+// until the connection fails or stop is closed.
+func mqttPublish(conn net.Conn, prefix string, stop <-chan struct{}) error {
+	defer conn.Close()
+	// The reader only watches for PINGRESP and connection loss
+	dead := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+			if _, _, err := mqttRead(r); err != nil {
+				dead <- err
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	write := func(b []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(b)
+		return err
+	}
+	for {
+		select {
+		case <-stop:
+			_ = write([]byte{0xe0, 0}) // DISCONNECT
+			return nil
+		case err := <-dead:
+			return err
+		case <-ping.C:
+			if err := write([]byte{0xc0, 0}); err != nil { // PINGREQ
+				return err
+			}
+		case p := <-mqttOut:
+			payload, _ := json.Marshal(p)
+			body := mqttString(nil, prefix+"/"+strings.ToLower(p.Icao24))
+			if err := write(mqttPacket(0x31, append(body, payload...))); err != nil { // PUBLISH, retain
+				monitoring.MQTTPublished.WithLabelValues("error").Inc()
+				return err
+			}
+			monitoring.MQTTPublished.WithLabelValues("ok").Inc()
+		}
+	}
+}
+
+// mqttPacket frames body with the fixed header (type/flags byte and remaining length).
+func mqttPacket(header byte, body []byte) []byte {
+	b := []byte{header}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+// mqttString appends a length-prefixed UTF-8 string.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttRead reads one packet and returns its type (high nibble of the fixed header) and body.
+func mqttRead(r *bufio.Reader) (byte, []byte, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 3 && d&0x80 != 0 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		n += int(d&0x7f) * mult
+		mult *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return h & 0xf0, body, nil
+}
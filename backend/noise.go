@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// noiseEventKind is the storage event kind for low passes near the noise monitoring point.
+const noiseEventKind = "noise"
+
+// noisePassGap ends a pass when an aircraft has not been seen inside the zone for this long.
+const noisePassGap = int64(5 * 60)
+
+// NoiseConfig describes the monitored point: passes below MaxAlt (meters, AGL when known,
+// otherwise MSL) within Radius meters are recorded.
+type NoiseConfig struct {
+	Lat, Lon float64
+	Radius   float64
+	MaxAlt   float64
+}
+
+type noisePass struct {
+	best    storage.Point
+	minDist float64
+	last    int64
+}
+
+var (
+	noiseMu     sync.Mutex
+	noiseCfg    *NoiseConfig
+	noisePasses = map[string]*noisePass{}
+)
+
+// SetNoiseConfig enables noise event estimation; nil disables it.
+func SetNoiseConfig(c *NoiseConfig) {
+	noiseMu.Lock()
+	noiseCfg = c
+	noiseMu.Unlock()
+}
+
+// GetNoiseConfig returns the active noise configuration (nil when disabled).
+func GetNoiseConfig() *NoiseConfig {
+	noiseMu.Lock()
+	defer noiseMu.Unlock()
+	return noiseCfg
+}
+
+// ObserveNoise is a storage observer that tracks low passes near the monitored point and records
+// one event per pass at its closest approach.
+func ObserveNoise(_ *storage.Point, cur storage.Point) {
+	noiseMu.Lock()
+	cfg := noiseCfg
+	if cfg == nil {
+		noiseMu.Unlock()
+		return
+	}
+	var done []*noisePass
+	// flush passes of aircraft that left the zone (or disappeared)
+	for icao, p := range noisePasses {
+		if cur.TS-p.last > noisePassGap {
+			done = append(done, p)
+			delete(noisePasses, icao)
+		}
+	}
+	height := cur.Alt
+	if cur.AGL != nil {
+		height = *cur.AGL
+	}
+	dist := geo.Haversine(cfg.Lat, cfg.Lon, cur.Lat, cur.Lon)
+	inside := cur.Alt > 0 && height <= cfg.MaxAlt && dist <= cfg.Radius
+	p := noisePasses[cur.Icao24]
+	switch {
+	case inside && p == nil:
+		noisePasses[cur.Icao24] = &noisePass{best: cur, minDist: dist, last: cur.TS}
+	case inside:
+		p.last = cur.TS
+		if dist < p.minDist {
+			p.best, p.minDist = cur, dist
+		}
+	case p != nil:
+		done = append(done, p)
+		delete(noisePasses, cur.Icao24)
+	}
+	noiseMu.Unlock()
+	for _, p := range done {
+		b := p.best
+		attrs := map[string]string{"distance_m": strconv.Itoa(int(math.Round(p.minDist)))}
+		if b.AGL != nil {
+			attrs["agl_m"] = strconv.Itoa(int(math.Round(*b.AGL)))
+		}
+		if t := storage.AircraftType(b.Icao24); t != "" {
+			attrs["type"] = t
+		}
+		_ = storage.Get().AddEvent(storage.Event{
+			Kind: noiseEventKind, TS: b.TS, Icao24: b.Icao24, Callsign: b.Callsign,
+			Lat: b.Lat, Lon: b.Lon, Alt: b.Alt, Attrs: attrs,
+		})
+	}
+}
+
+// NoiseEventsHandler lists recorded low passes: ?from=&to= (YYYY-MM-DD, default today), limit (default 500).
+func NoiseEventsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDayRange(r, 1)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", 500, 1, 5000)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	events, err := storage.Get().Events(noiseEventKind, from.Unix(), to.Unix(), nil)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	total := len(events)
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"total": total, "items": events})
+}
+
+// NoiseDailyHandler returns daily pass counts: ?from=&to= (YYYY-MM-DD, default last 30 days).
+// Night passes are those between 22:00 and 06:00 UTC.
+func NoiseDailyHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDayRange(r, 30)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	events, err := storage.Get().Events(noiseEventKind, from.Unix(), to.Unix(), nil)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	type day struct {
+		Day        string  `json:"day"`
+		Passes     int     `json:"passes"`
+		Night      int     `json:"night_passes"`
+		PerHour    [24]int `json:"passes_per_hour"`
+		LowestAltM float64 `json:"lowest_alt_m"`
+	}
+	out := []*day{}
+	var cur *day
+	for _, e := range events {
+		t := time.Unix(e.TS, 0).UTC()
+		key := t.Format("2006-01-02")
+		if cur == nil || cur.Day != key {
+			cur = &day{Day: key, LowestAltM: math.Inf(1)}
+			out = append(out, cur)
+		}
+		cur.Passes++
+		cur.PerHour[t.Hour()]++
+		if t.Hour() >= 22 || t.Hour() < 6 {
+			cur.Night++
+		}
+		cur.LowestAltM = math.Min(cur.LowestAltM, e.Alt)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"from": from.Format("2006-01-02"), "to": to.Format("2006-01-02"), "days": out})
+}
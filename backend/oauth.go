@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// openskyTokenURL is the OpenSky OAuth2 token endpoint (client-credentials grant).
+const openskyTokenURL = "https://auth.opensky-network.org/auth/realms/opensky-network/protocol/openid-connect/token"
+
+// Tokens are refreshed this long before they expire so a poll never races the expiry.
+const openskyTokenSkew = time.Minute
+
+var (
+	// OpenSky OAuth2 client credentials (optional; preferred over Basic Auth)
+	openskyClientID     string
+	openskyClientSecret string
+
+	tokenMu  sync.Mutex
+	token    string
+	tokenExp time.Time
+)
+
+// SetOpenSkyOAuth configures OAuth2 client credentials for the OpenSky API. When set they take
+// precedence over Basic Auth credentials.
+func SetOpenSkyOAuth(clientID, clientSecret string) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	openskyClientID = strings.TrimSpace(clientID)
+	openskyClientSecret = clientSecret
+	token, tokenExp = "", time.Time{}
+}
+
+func openskyOAuthEnabled() bool {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	return openskyClientID != "" && openskyClientSecret != ""
+}
+
+// openskyToken returns a cached access token, requesting a new one when it is missing or
+// about to expire.
+func openskyToken(client *http.Client) (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	if token != "" && time.Until(tokenExp) > openskyTokenSkew {
+		return token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {openskyClientID},
+		"client_secret": {openskyClientSecret},
+	}
+	resp, err := client.PostForm(openskyTokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("opensky token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("opensky token status %d", resp.StatusCode)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil || tr.AccessToken == "" {
+		return "", fmt.Errorf("opensky token: invalid response")
+	}
+	if tr.ExpiresIn <= 0 {
+		tr.ExpiresIn = 300
+	}
+	token = tr.AccessToken
+	tokenExp = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	monitoring.SubDebugf("ingest", "opensky token acquired expires_in=%ds", tr.ExpiresIn)
+	return token, nil
+}
+
+// invalidateOpenSkyToken drops the cached token (e.g., after a 401) so the next call refreshes it.
+func invalidateOpenSkyToken() {
+	tokenMu.Lock()
+	token, tokenExp = "", time.Time{}
+	tokenMu.Unlock()
+}
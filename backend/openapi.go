@@ -0,0 +1,320 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/airports"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/maniack/miniflightradar/ui"
+)
+
+// apiParam is a query or path parameter of an API operation.
+type apiParam struct {
+	Name, In, Type, Desc string // In: query or path; Type: string, integer, number or boolean
+	Required             bool
+}
+
+// apiOp documents one REST operation. Result (and Body) are zero values whose Go type describes
+// the JSON response (request body); nil means a free-form object.
+type apiOp struct {
+	Method, Path, Summary string
+	Params                []apiParam
+	Body                  any
+	Result                any
+	Status                int  // success status; 0 means 200
+	NoAuth                bool // served without cookies and CSRF
+}
+
+// Parameters shared by several operations.
+var (
+	pCallsign  = apiParam{Name: "callsign", In: "query", Type: "string", Desc: "1-8 letters or digits", Required: true}
+	pPrecision = apiParam{Name: "precision", In: "query", Type: "integer", Desc: "round lon/lat to N decimals (1..7)"}
+	pFrom      = apiParam{Name: "from", In: "query", Type: "string", Desc: "unix seconds or YYYY-MM-DD, depending on the endpoint"}
+	pTo        = apiParam{Name: "to", In: "query", Type: "string", Desc: "unix seconds or YYYY-MM-DD, depending on the endpoint"}
+	pID        = apiParam{Name: "id", In: "path", Type: "string", Required: true}
+	pFilter    = []apiParam{
+		{Name: "callsign", In: "query", Type: "string", Desc: "comma-separated callsign globs (*, ?, [...])"},
+		{Name: "callsign_re", In: "query", Type: "string", Desc: "callsign regular expression"},
+		{Name: "type", In: "query", Type: "string", Desc: "comma-separated ICAO type designator globs"},
+	}
+)
+
+// trackResponse is the body of /api/track.
+type trackResponse struct {
+	Callsign string          `json:"callsign"`
+	Icao24   string          `json:"icao24"`
+	Points   []storage.Point `json:"points"`
+	Total    int             `json:"total,omitempty"`  // points before simplification
+	Colors   []int           `json:"colors,omitempty"` // color code per point (trail_color)
+	// Estimated airports at the ends of the segment, with an airport dataset (see estimateRoute)
+	Origin      *airportRef `json:"origin,omitempty"`
+	Destination *airportRef `json:"destination,omitempty"`
+}
+
+// airportsResponse is the body of /api/airports.
+type airportsResponse struct {
+	Airports  []airports.Airport `json:"airports"`
+	Total     int                `json:"total"`     // airports matching before the limit
+	Truncated bool               `json:"truncated"` // more than limit matched
+}
+
+// apiOps lists the documented REST operations; add new endpoints here when wiring them in
+// app/run.go. Admin, WebSocket and SSE endpoints are described in the README only.
+var apiOps = []apiOp{
+	{Method: "GET", Path: "/api/flights", Summary: "All current flight positions", Params: append(append([]apiParam{pPrecision}, pFilter...),
+		apiParam{Name: "sort", In: "query", Type: "string", Desc: "distance (nearest first), alt or speed (highest first)"},
+		apiParam{Name: "ref", In: "query", Type: "string", Desc: "lat,lon for sort=distance (default: receiver location)"},
+		apiParam{Name: "order", In: "query", Type: "string", Desc: "asc or desc"},
+		apiParam{Name: "limit", In: "query", Type: "integer", Desc: "1..10000"},
+	), Result: []storage.Point{}},
+	{Method: "POST", Path: "/api/flights/bulk", Summary: "Current positions and short trails of up to 200 aircraft by ICAO24 or callsign", Body: bulkRequest{}, Result: bulkResponse{}},
+	{Method: "GET", Path: "/api/flight", Summary: "Latest sample of a flight as an OpenSky-style states array (empty when unknown)", Params: []apiParam{pCallsign}, Result: [][]any{}},
+	{Method: "GET", Path: "/api/search", Summary: "Current flights by callsign, ICAO24 or registration, ranked", Params: []apiParam{
+		{Name: "q", In: "query", Type: "string", Required: true},
+		{Name: "limit", In: "query", Type: "integer"},
+	}, Result: struct {
+		Query   string      `json:"query"`
+		Total   int         `json:"total"`
+		Results []searchHit `json:"results"`
+	}{}},
+	{Method: "GET", Path: "/api/flight/info", Summary: "Latest sample of a flight with its estimated origin and destination", Params: []apiParam{pCallsign}, Result: flightInfoResponse{}},
+	{Method: "GET", Path: "/api/track", Summary: "Current flight segment of a callsign", Params: []apiParam{pCallsign,
+		{Name: "simplify", In: "query", Type: "number", Desc: "Douglas-Peucker tolerance in meters (0..100000)"},
+		{Name: "trail_color", In: "query", Type: "string", Desc: "add color codes per point: alt or speed"},
+	}, Result: trackResponse{}},
+	{Method: "GET", Path: "/api/track/export", Summary: "Current flight segment of a callsign as a CSV, KML or GPX download", Params: []apiParam{pCallsign,
+		{Name: "format", In: "query", Type: "string", Desc: "csv (default), kml or gpx"},
+	}},
+	{Method: "GET", Path: "/api/track/compare", Summary: "Aligned tracks of 2-4 flights with separation metrics", Params: []apiParam{
+		{Name: "flights", In: "query", Type: "string", Desc: "comma-separated callsigns", Required: true},
+		{Name: "step", In: "query", Type: "integer", Desc: "grid step in seconds"},
+	}},
+	{Method: "GET", Path: "/api/changes", Summary: "Ingest event log batches after a sequence number", Params: []apiParam{
+		{Name: "since", In: "query", Type: "integer", Required: true},
+		{Name: "limit", In: "query", Type: "integer", Desc: "1..10000"},
+	}},
+	{Method: "GET", Path: "/api/changes/state", Summary: "Current state replayed from the event log", Params: []apiParam{{Name: "seq", In: "query", Type: "integer"}}},
+	{Method: "GET", Path: "/api/tombstones", Summary: "Aircraft removed from the current state", Params: []apiParam{{Name: "since", In: "query", Type: "integer", Desc: "unix seconds"}}, Result: struct {
+		Since int64               `json:"since"`
+		Items []storage.Tombstone `json:"items"`
+	}{}},
+	{Method: "GET", Path: "/api/ledger", Summary: "All-time airframe ledger", Params: []apiParam{
+		{Name: "sort", In: "query", Type: "string", Desc: "last_seen, first_seen, sightings, samples or icao24"},
+		{Name: "order", In: "query", Type: "string", Desc: "asc or desc"},
+		{Name: "limit", In: "query", Type: "integer"},
+		{Name: "offset", In: "query", Type: "integer"},
+		{Name: "icao24", In: "query", Type: "string", Desc: "return this entry only"},
+	}},
+	{Method: "GET", Path: "/api/stats/daily", Summary: "Persistent daily rollups", Params: []apiParam{pFrom, pTo}},
+	{Method: "GET", Path: "/api/stats/rarity", Summary: "Operators or types by rarity", Params: []apiParam{
+		{Name: "kind", In: "query", Type: "string", Desc: "operator or type"},
+		{Name: "limit", In: "query", Type: "integer"},
+	}},
+	{Method: "GET", Path: "/api/noise/events", Summary: "Low passes near the noise monitoring point", Params: []apiParam{pFrom, pTo, {Name: "limit", In: "query", Type: "integer"}}},
+	{Method: "GET", Path: "/api/noise/daily", Summary: "Daily noise pass counts", Params: []apiParam{pFrom, pTo}},
+	{Method: "GET", Path: "/api/airports", Summary: "Airports inside a bounding box, larger airports first", Params: []apiParam{
+		{Name: "bbox", In: "query", Type: "string", Desc: "minLon,minLat,maxLon,maxLat", Required: true},
+		{Name: "type", In: "query", Type: "string", Desc: "comma-separated large_airport, medium_airport, small_airport"},
+		{Name: "limit", In: "query", Type: "integer", Desc: "1..5000 (default 500)"},
+	}, Result: airportsResponse{}},
+	{Method: "GET", Path: "/api/airport/{icao}/runways/stats", Summary: "Runway usage of an airport", Params: []apiParam{
+		{Name: "icao", In: "path", Type: "string", Required: true},
+		pFrom, pTo,
+		{Name: "bucket", In: "query", Type: "string", Desc: "day or hour"},
+	}},
+	{Method: "POST", Path: "/api/share", Summary: "Signed URL for sharing a read-only API resource", Body: struct {
+		Path string `json:"path"`
+		TTL  string `json:"ttl,omitempty"`
+	}{}, Result: struct {
+		URL     string `json:"url"`
+		Expires int64  `json:"expires"`
+	}{}},
+	{Method: "GET", Path: "/api/clips", Summary: "Playback clips, newest first", Result: []storage.Clip{}},
+	{Method: "POST", Path: "/api/clips", Summary: "Bookmark a time range as a clip", Body: struct {
+		Title  string      `json:"title"`
+		From   int64       `json:"from"`
+		To     int64       `json:"to"`
+		BBox   *[4]float64 `json:"bbox,omitempty"`
+		Icao24 []string    `json:"icao24,omitempty"`
+	}{}, Result: storage.Clip{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/clips/{id}", Summary: "Clip metadata", Params: []apiParam{pID}, Result: storage.Clip{}},
+	{Method: "DELETE", Path: "/api/clips/{id}", Summary: "Remove a clip", Params: []apiParam{pID}, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/clips/{id}/export", Summary: "Standalone clip export (paged with Content-Range)", Params: []apiParam{pID,
+		{Name: "format", In: "query", Type: "string", Desc: "json, czml, gpx, kml or csv"},
+		{Name: "cursor", In: "query", Type: "string"},
+	}},
+	{Method: "GET", Path: "/api/alerts", Summary: "Alert rules", Result: []storage.AlertRule{}},
+	{Method: "POST", Path: "/api/alerts", Summary: "Create an alert rule", Body: storage.AlertRule{}, Result: storage.AlertRule{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/api/alerts/events", Summary: "Fired alerts", Params: []apiParam{pFrom, pTo, {Name: "rule", In: "query", Type: "string"}}, Result: []storage.Event{}},
+	{Method: "GET", Path: "/api/alerts/{id}", Summary: "One alert rule", Params: []apiParam{pID}, Result: storage.AlertRule{}},
+	{Method: "PUT", Path: "/api/alerts/{id}", Summary: "Replace an alert rule", Params: []apiParam{pID}, Body: storage.AlertRule{}, Result: storage.AlertRule{}},
+	{Method: "DELETE", Path: "/api/alerts/{id}", Summary: "Remove an alert rule", Params: []apiParam{pID}, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/api/rings", Summary: "GeoJSON range rings and radials", Params: []apiParam{
+		{Name: "center", In: "query", Type: "string", Desc: "lat,lon"},
+		{Name: "rings", In: "query", Type: "string", Desc: "e.g. 50,100,150nm"},
+		{Name: "radials", In: "query", Type: "integer"},
+	}},
+	{Method: "GET", Path: "/api/geocode", Summary: "Offline reverse geocoding", Params: []apiParam{
+		{Name: "lat", In: "query", Type: "number", Required: true},
+		{Name: "lon", In: "query", Type: "number", Required: true},
+		{Name: "lang", In: "query", Type: "string"},
+	}},
+	{Method: "GET", Path: "/api/aircraft", Summary: "Registration record of an aircraft", Params: []apiParam{{Name: "icao24", In: "query", Type: "string", Required: true}}},
+	{Method: "GET", Path: "/api/fleet/{airline}", Summary: "Current flights of an operator", Params: []apiParam{
+		{Name: "airline", In: "path", Type: "string", Desc: "3-letter ICAO designator", Required: true},
+		{Name: "type", In: "query", Type: "string"},
+	}},
+	{Method: "GET", Path: "/api/elevation", Summary: "Ground elevation in meters", Params: []apiParam{
+		{Name: "lat", In: "query", Type: "number", Required: true},
+		{Name: "lon", In: "query", Type: "number", Required: true},
+	}},
+	{Method: "GET", Path: "/api/openapi.json", Summary: "This document", NoAuth: true},
+	{Method: "GET", Path: "/api/config", Summary: "Runtime UI configuration (branding)", NoAuth: true, Result: struct {
+		Branding ui.Branding `json:"branding"`
+	}{}},
+	{Method: "GET", Path: "/healthz", Summary: "Liveness check", NoAuth: true, Result: struct {
+		Status      string `json:"status"`
+		TS          int64  `json:"ts"`
+		ClusterRole string `json:"cluster_role,omitempty"` // leader, follower or standalone (cluster mode)
+	}{}},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPISpec []byte
+)
+
+// OpenAPIHandler serves the OpenAPI 3 description of the REST API at /api/openapi.json.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() { openAPISpec, _ = json.Marshal(buildOpenAPI(apiOps)) })
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPISpec)
+}
+
+// buildOpenAPI renders ops as an OpenAPI 3.0 document. Response and body schemas are derived
+// from the Go types (json tags); named structs become shared component schemas.
+func buildOpenAPI(ops []apiOp) map[string]any {
+	version, _ := monitoring.BuildVersion()
+	schemas := map[string]any{}
+	paths := map[string]any{}
+	errResp := map[string]any{
+		"description": "Error (RFC 7807 problem document)",
+		"content":     map[string]any{problem.ContentType: map[string]any{"schema": schemaOf(reflect.TypeOf(problem.Problem{}), schemas)}},
+	}
+	for _, op := range ops {
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		params := make([]any, 0, len(op.Params))
+		for _, p := range op.Params {
+			params = append(params, map[string]any{
+				"name": p.Name, "in": p.In, "required": p.Required || p.In == "path",
+				"description": p.Desc, "schema": map[string]any{"type": p.Type},
+			})
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		if status != http.StatusNoContent {
+			schema := map[string]any{"type": "object"}
+			if op.Result != nil {
+				schema = schemaOf(reflect.TypeOf(op.Result), schemas)
+			}
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+		}
+		o := map[string]any{
+			"summary":    op.Summary,
+			"parameters": params,
+			"responses":  map[string]any{strconv.Itoa(status): ok, "default": errResp},
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]any{"required": true, "content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(op.Body), schemas)},
+			}}
+		}
+		if op.NoAuth {
+			o["security"] = []any{}
+		}
+		item[strings.ToLower(op.Method)] = o
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "miniflightradar API",
+			"version":     version,
+			"description": "Requests need the mfr_jwt cookie and an X-CSRF-Token header equal to the mfr_csrf cookie (both issued on the first visit), except for endpoints of the public tier.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"jwtCookie": map[string]any{"type": "apiKey", "in": "cookie", "name": "mfr_jwt"},
+				"csrf":      map[string]any{"type": "apiKey", "in": "header", "name": "X-CSRF-Token"},
+			},
+		},
+		"security": []any{map[string]any{"jwtCookie": []any{}, "csrf": []any{}}},
+	}
+}
+
+// schemaOf returns the JSON schema of t; named structs are added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]any{} // placeholder for recursive types
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{} // any value
+}
+
+// structSchema lists the exported fields of t under their JSON names.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type, schemas)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
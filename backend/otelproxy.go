@@ -3,27 +3,81 @@ package backend
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/httpclient"
 	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/security"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 )
 
-// OTLPTracesProxy returns an http.HandlerFunc that proxies OTLP/HTTP trace export requests
-// from the frontend to the configured OpenTelemetry collector endpoint.
+// otlpSignals are the OTLP/HTTP signals the proxy can forward (/otel/v1/{signal}).
+var otlpSignals = []string{"traces", "metrics", "logs"}
+
+// otlpContentTypes are the accepted OTLP/HTTP payload encodings.
+var otlpContentTypes = map[string]bool{"application/x-protobuf": true, "application/json": true}
+
+// Collector requests use the shared outbound transport (httpclient: proxy flags, NO_PROXY,
+// connection pool). Transient failures are retried within otlpProxyTimeout, as the exporters
+// in the browser do not retry.
+const (
+	otlpProxyTimeout  = 10 * time.Second
+	otlpProxyAttempts = 3
+)
+
+var otlpClient = httpclient.New("otlp", otlpProxyTimeout)
+
+// OTLPProxyConfig configures the frontend OTLP proxy.
+type OTLPProxyConfig struct {
+	Endpoint  string             // collector host:port or URL (--tracing.endpoint)
+	Headers   http.Header        // added to collector requests, e.g. Authorization
+	Signals   []string           // forwarded signals; empty means all of otlpSignals
+	RateLimit security.RateLimit // per client IP and signal; zero disables
+}
+
+// ParseOTLPHeaders parses collector headers in the OTEL_EXPORTER_OTLP_HEADERS format:
+// "name=value,name2=value2" with URL-encoded values (e.g. "Authorization=Bearer%20abc").
+func ParseOTLPHeaders(s string) (http.Header, error) {
+	h := http.Header{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " :") {
+			return nil, fmt.Errorf("invalid header %q: want name=value", part)
+		}
+		uv, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %s: %v", k, err)
+		}
+		h.Set(k, uv)
+	}
+	return h, nil
+}
+
+// OTLPProxy returns an http.HandlerFunc for /otel/v1/{signal} that proxies OTLP/HTTP export
+// requests (traces, metrics, logs) from the frontend to the configured OpenTelemetry collector.
 //
-// It expects the collector endpoint in form host:port (same as --tracing.endpoint flag),
-// and will forward requests to http://host:port/v1/traces using the incoming request body
-// and content headers. If the endpoint is empty, the handler returns 503.
-func OTLPTracesProxy(collectorEndpoint string) http.HandlerFunc {
+// The collector endpoint is in form host:port (same as --tracing.endpoint flag) or a URL;
+// requests go to {endpoint}/v1/{signal} with the incoming body, content type and encoding
+// (gzip payloads are passed through unchanged) plus the configured collector headers, which
+// never reach the browser. Only protobuf and JSON payloads of allowed signals are accepted.
+// If the endpoint is empty, the handler returns 503.
+func OTLPProxy(cfg OTLPProxyConfig) http.HandlerFunc {
 	// Normalize endpoint into a base URL string acceptable by http.NewRequest.
 	var targetBase string
-	if collectorEndpoint != "" {
+	if collectorEndpoint := cfg.Endpoint; collectorEndpoint != "" {
 		// If endpoint already has a scheme, use as-is, otherwise default to http.
 		if strings.HasPrefix(collectorEndpoint, "http://") || strings.HasPrefix(collectorEndpoint, "https://") {
 			targetBase = strings.TrimRight(collectorEndpoint, "/")
@@ -31,76 +85,146 @@ func OTLPTracesProxy(collectorEndpoint string) http.HandlerFunc {
 			targetBase = "http://" + strings.TrimRight(collectorEndpoint, "/")
 		}
 	}
+	signals := cfg.Signals
+	if len(signals) == 0 {
+		signals = otlpSignals
+	}
+
+	// One forwarding handler per allowed signal, each with its own rate limit buckets
+	handlers := map[string]http.Handler{}
+	for _, sig := range signals {
+		forward := func(w http.ResponseWriter, r *http.Request) {
+			// Construct target URL: base + /v1/{signal}
+			targetURL := targetBase + "/v1/" + sig
+			if _, err := url.Parse(targetURL); err != nil {
+				problem.Write(w, r, http.StatusInternalServerError, "invalid collector endpoint")
+				return
+			}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+			// Limit request body size to prevent abuse. Typical OTLP payloads are small.
+			const maxBody = 5 << 20 // 5MB
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			defer r.Body.Close()
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				monitoring.OTLPProxyRequests.WithLabelValues(sig, "rejected").Inc()
+				problem.Write(w, r, http.StatusBadRequest, "failed to read body")
+				return
+			}
+
+			// Covers all attempts; the client adds a span (and trace context) per attempt
+			ctx, span := monitoring.StartClientSpan(r.Context(), "proxy otlp "+sig, targetURL, http.MethodPost)
+			defer span.End()
+
+			// Preserve content type and encoding for the collector, then add the collector auth
+			hdr := http.Header{}
+			hdr.Set("Content-Type", r.Header.Get("Content-Type"))
+			if ce := r.Header.Get("Content-Encoding"); ce != "" {
+				hdr.Set("Content-Encoding", ce)
+			}
+			for k, vv := range cfg.Headers {
+				hdr[k] = vv
+			}
+			ctx, cancel := context.WithTimeout(ctx, otlpProxyTimeout)
+			defer cancel()
+			resp, err := otlpForward(ctx, sig, targetURL, hdr, body)
+			if err != nil {
+				monitoring.OTLPProxyRequests.WithLabelValues(sig, "error").Inc()
+				problem.Write(w, r, http.StatusBadGateway, "failed to reach collector")
+				return
+			}
+			defer resp.Body.Close()
+			result := "ok"
+			if resp.StatusCode >= 400 {
+				result = "error"
+			}
+			monitoring.OTLPProxyRequests.WithLabelValues(sig, result).Inc()
+
+			// Copy status code and body back to client
+			for k, vv := range resp.Header {
+				for _, v := range vv {
+					w.Header().Add(k, v)
+				}
+			}
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}
+		handlers[sig] = security.Limit(cfg.RateLimit, monitoring.OTLPProxyRequests.WithLabelValues(sig, "ratelimited"))(http.HandlerFunc(forward))
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Only allow POST as per OTLP/HTTP
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			problem.Write(w, r, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
 
-		if targetBase == "" {
-			http.Error(w, "otel collector endpoint is not configured", http.StatusServiceUnavailable)
+		sig := chi.URLParam(r, "signal")
+		h, ok := handlers[sig]
+		if !ok {
+			problem.Write(w, r, http.StatusNotFound, "unsupported otlp signal")
 			return
 		}
 
-		// Construct target URL: base + /v1/traces
-		targetURL := targetBase + "/v1/traces"
-		if _, err := url.Parse(targetURL); err != nil {
-			http.Error(w, "invalid collector endpoint", http.StatusInternalServerError)
+		if targetBase == "" {
+			problem.Write(w, r, http.StatusServiceUnavailable, "otel collector endpoint is not configured")
 			return
 		}
 
-		// Limit request body size to prevent abuse. Typical OTLP payloads are small.
-		const maxBody = 5 << 20 // 5MB
-		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
-		defer r.Body.Close()
-
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "failed to read body", http.StatusBadRequest)
+		// Payload allow-list: OTLP protobuf or JSON, plain or gzip-compressed
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		ce := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if !otlpContentTypes[ct] || (ce != "" && ce != "gzip" && ce != "identity") {
+			monitoring.OTLPProxyRequests.WithLabelValues(sig, "rejected").Inc()
+			problem.Write(w, r, http.StatusUnsupportedMediaType, "want application/x-protobuf or application/json, optionally gzip-encoded")
 			return
 		}
 
-		ctx, span := monitoring.StartClientSpan(r.Context(), "proxy otlp traces", targetURL, http.MethodPost)
-		defer span.End()
+		h.ServeHTTP(w, r)
+	}
+}
 
-		// Build outbound request
-		outReq, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
+// otlpForward POSTs body to the collector, retrying network errors and 429/502/503/504
+// responses with backoff (or the collector's Retry-After, up to 2s) while ctx allows.
+func otlpForward(ctx context.Context, sig, target string, hdr http.Header, body []byte) (*http.Response, error) {
+	client := otlpClient
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
 		if err != nil {
-			http.Error(w, "failed to create request", http.StatusInternalServerError)
-			return
+			return nil, err
 		}
-
-		// Copy relevant headers
-		// Preserve content type and encoding for the collector
-		if ct := r.Header.Get("Content-Type"); ct != "" {
-			outReq.Header.Set("Content-Type", ct)
+		req.Header = hdr.Clone()
+		start := time.Now()
+		resp, err := client.Do(req)
+		monitoring.OTLPProxyDuration.WithLabelValues(sig).Observe(time.Since(start).Seconds())
+		wait := backoff
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				if ra := parseRetryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+					wait = min(ra, 2*time.Second)
+				}
+			default:
+				return resp, nil
+			}
 		}
-		if ce := r.Header.Get("Content-Encoding"); ce != "" {
-			outReq.Header.Set("Content-Encoding", ce)
+		if attempt >= otlpProxyAttempts || httpclient.IsEgressDenied(err) {
+			return resp, err
 		}
-		// Propagate trace context using the global OTEL propagator configured in monitoring
-		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(outReq.Header))
-
-		resp, err := client.Do(outReq)
-		if err != nil {
-			http.Error(w, "failed to reach collector", http.StatusBadGateway)
-			return
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
 		}
-		defer resp.Body.Close()
-
-		// Copy status code and body back to client
-		for k, vv := range resp.Header {
-			for _, v := range vv {
-				w.Header().Add(k, v)
-			}
+		monitoring.OTLPProxyRequests.WithLabelValues(sig, "retry").Inc()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
 		}
-		w.WriteHeader(resp.StatusCode)
-		_, _ = io.Copy(w, resp.Body)
+		backoff *= 2
 	}
 }
 
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxPrecision bounds the coordinate precision option; 7 decimals (~1 cm) is below any
+// position source accuracy.
+const maxPrecision = 7
+
+// parsePrecision reads the optional "precision" query parameter (decimal places for lon/lat,
+// 1..7). It returns 0 when absent, meaning full precision.
+func parsePrecision(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("precision")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPrecision {
+		return 0, invalidParam("precision", "want an integer in 1..%d, got %q", maxPrecision, v)
+	}
+	return n, nil
+}
+
+// parseTrailMode reads the optional "trail" query parameter: "full" adds timestamps and
+// altitudes to trail points, "short" (the default) sends positions only.
+func parseTrailMode(r *http.Request) (bool, error) {
+	switch v := r.URL.Query().Get("trail"); v {
+	case "", "short":
+		return false, nil
+	case "full":
+		return true, nil
+	default:
+		return false, invalidParam("trail", "want short or full, got %q", v)
+	}
+}
+
+// roundTo rounds v to n decimal places; n <= 0 leaves v unchanged.
+func roundTo(v float64, n int) float64 {
+	if n <= 0 {
+		return v
+	}
+	p := math.Pow10(n)
+	return math.Round(v*p) / p
+}
+
+// deltaTrail encodes trail points as integers scaled by 10^n: the first lon,lat pair is
+// absolute and every following pair is the difference to the previous point.
+// Decoding: running sum of pairs divided by 10^n.
+func deltaTrail(pts []trailPoint, n int) []int64 {
+	p := math.Pow10(n)
+	out := make([]int64, 0, 2*len(pts))
+	var pl, pa int64
+	for i, tp := range pts {
+		lon, lat := int64(math.Round(tp.Lon*p)), int64(math.Round(tp.Lat*p))
+		if i == 0 {
+			out = append(out, lon, lat)
+		} else {
+			out = append(out, lon-pl, lat-pa)
+		}
+		pl, pa = lon, lat
+	}
+	return out
+}
+
+// deltaTrailTA encodes the timestamps (unix seconds) and altitudes (whole meters) of trail
+// points like deltaTrail: the first ts,alt pair is absolute, the following ones are differences.
+// Consecutive samples mostly differ by a few seconds and tens of meters, so the pairs stay short.
+func deltaTrailTA(pts []trailPoint) []int64 {
+	out := make([]int64, 0, 2*len(pts))
+	var pt, pa int64
+	for i, tp := range pts {
+		ts, alt := tp.TS, int64(math.Round(tp.Alt))
+		if i == 0 {
+			out = append(out, ts, alt)
+		} else {
+			out = append(out, ts-pt, alt-pa)
+		}
+		pt, pa = ts, alt
+	}
+	return out
+}
@@ -0,0 +1,330 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/httpclient"
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// readsbSource polls a REST API serving the readsb JSON schema: ADS-B Exchange v2, adsb.fi and
+// airplanes.live, or the aircraft.json of a readsb/tar1090 installation. The public APIs only
+// answer point/radius queries, so a region is covered by circles of at most maxNM nautical
+// miles, fetched one after another and spaced by the provider's rate limit. An endpoint URL
+// without {lat}/{lon}/{dist} placeholders is taken to return all aircraft, which are then cut
+// to the region.
+type readsbSource struct {
+	name   string
+	url    string // with {lat}, {lon} and {dist} (nautical miles) for point queries
+	maxNM  float64
+	apiKey string
+	client *http.Client
+
+	// requests are spaced by gap across all regions (the providers limit per client)
+	mu   sync.Mutex
+	gap  time.Duration
+	next time.Time
+}
+
+// readsbProvider describes the defaults of a readsb-schema API.
+type readsbProvider struct {
+	url      string
+	maxNM    float64 // largest query radius accepted
+	gap      time.Duration
+	needsKey bool
+}
+
+var readsbProviders = map[string]readsbProvider{
+	"adsbx":         {url: "https://adsbexchange-com1.p.rapidapi.com/v2/lat/{lat}/lon/{lon}/dist/{dist}/", maxNM: 250, gap: time.Second, needsKey: true},
+	"adsbfi":        {url: "https://opendata.adsb.fi/api/v2/lat/{lat}/lon/{lon}/dist/{dist}", maxNM: 250, gap: time.Second},
+	"airplaneslive": {url: "https://api.airplanes.live/v2/point/{lat}/{lon}/{dist}", maxNM: 250, gap: time.Second},
+}
+
+// readsbMaxTiles bounds the queries of one region poll.
+const readsbMaxTiles = 16
+
+// readsbPositionMaxAge skips aircraft whose last position is older than this.
+const readsbPositionMaxAge = time.Minute
+
+func newReadsbSource(name string, c SourceConfig) (*readsbSource, error) {
+	p, ok := readsbProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source.provider %q (want %s)", name, strings.Join(SourceProviders, ", "))
+	}
+	s := &readsbSource{name: name, url: p.url, maxNM: p.maxNM, apiKey: strings.TrimSpace(c.APIKey), gap: p.gap}
+	if u := strings.TrimSpace(c.URL); u != "" {
+		pu, err := url.Parse(u)
+		if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			return nil, fmt.Errorf("source.api_url: want an http(s) URL, got %q", u)
+		}
+		s.url = u
+	}
+	if p.needsKey && s.apiKey == "" && strings.TrimSpace(c.URL) == "" {
+		return nil, fmt.Errorf("source.provider %s needs source.api_key", name)
+	}
+	if c.Rate > 0 {
+		s.gap = c.Rate
+	}
+	s.client = httpclient.New(name, 15*time.Second)
+	return s, nil
+}
+
+func (s *readsbSource) Name() string { return s.name }
+
+// pointQuery reports whether the endpoint takes a point and radius.
+func (s *readsbSource) pointQuery() bool { return strings.Contains(s.url, "{lat}") }
+
+// Fetch requests the aircraft within rg, tile by tile for point queries.
+func (s *readsbSource) Fetch(rg *Region) (*FlightData, error) {
+	if !s.pointQuery() {
+		return s.fetch(s.url, rg)
+	}
+	if rg == nil {
+		return nil, fmt.Errorf("%s: worldwide polling needs source.api_url returning all aircraft", s.name)
+	}
+	tiles, err := coverRegion(rg, s.maxNM)
+	if err != nil {
+		return nil, err
+	}
+	out := &FlightData{}
+	seen := map[string]bool{}
+	for _, t := range tiles {
+		u := strings.NewReplacer(
+			"{lat}", strconv.FormatFloat(t.lat, 'f', 4, 64),
+			"{lon}", strconv.FormatFloat(t.lon, 'f', 4, 64),
+			"{dist}", strconv.Itoa(t.distNM),
+		).Replace(s.url)
+		data, err := s.fetch(u, rg)
+		if err != nil {
+			return nil, err
+		}
+		out.Time = max(out.Time, data.Time)
+		// tiles overlap: keep the first report of an aircraft
+		for _, st := range data.States {
+			if icao := st[0].(string); !seen[icao] {
+				seen[icao] = true
+				out.States = append(out.States, st)
+			}
+		}
+	}
+	return out, nil
+}
+
+// wait blocks until the next request is allowed by the rate limit.
+func (s *readsbSource) wait() {
+	s.mu.Lock()
+	now := time.Now()
+	at := now
+	if s.next.After(now) {
+		at = s.next
+	}
+	s.next = at.Add(s.gap)
+	s.mu.Unlock()
+	time.Sleep(at.Sub(now))
+}
+
+// fetch requests one URL and converts the aircraft within rg (all when nil).
+func (s *readsbSource) fetch(target string, rg *Region) (*FlightData, error) {
+	s.wait()
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.apiKey != "" {
+		if host := req.URL.Hostname(); strings.HasSuffix(host, ".rapidapi.com") {
+			req.Header.Set("X-RapidAPI-Key", s.apiKey)
+			req.Header.Set("X-RapidAPI-Host", host)
+		} else {
+			req.Header.Set("api-auth", s.apiKey) // ADS-B Exchange direct API
+		}
+	}
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 20<<20)) // limit 20MB
+	monitoring.SubDebugf("ingest", "%s request url=%s status=%d duration=%s body_len=%d", s.name, target, resp.StatusCode, time.Since(start), len(body))
+	if err := rateLimited(resp); err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%s status %d (check source.api_key)", s.name, resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s status %d", s.name, resp.StatusCode)
+	}
+	var r readsbResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	return r.flightData(rg), nil
+}
+
+// readsbResponse is the body of the v2 APIs ("ac", "now" in milliseconds) and of readsb's
+// aircraft.json ("aircraft", "now" in seconds).
+type readsbResponse struct {
+	Now      float64          `json:"now"`
+	AC       []readsbAircraft `json:"ac"`
+	Aircraft []readsbAircraft `json:"aircraft"`
+}
+
+// readsbAircraft holds the fields of a readsb aircraft used for state vectors. Altitudes are
+// in feet, speeds in knots and rates in feet per minute.
+type readsbAircraft struct {
+	Hex      string          `json:"hex"` // "~" prefix: non-ICAO address (TIS-B)
+	Flight   string          `json:"flight"`
+	AltBaro  json.RawMessage `json:"alt_baro"` // feet, or "ground"
+	AltGeom  *float64        `json:"alt_geom"`
+	GS       *float64        `json:"gs"`
+	Track    *float64        `json:"track"`
+	BaroRate *float64        `json:"baro_rate"`
+	GeomRate *float64        `json:"geom_rate"`
+	Squawk   string          `json:"squawk"`
+	Category string          `json:"category"` // A1..C7
+	Type     string          `json:"type"`     // adsb_icao, mlat, tisb_icao, ...
+	Lat      *float64        `json:"lat"`
+	Lon      *float64        `json:"lon"`
+	SeenPos  *float64        `json:"seen_pos"` // seconds since the position
+}
+
+// flightData converts the aircraft with a recent position within rg to state vectors.
+func (r readsbResponse) flightData(rg *Region) *FlightData {
+	now := r.Now
+	if now > 1e11 {
+		now /= 1000 // milliseconds
+	}
+	if now <= 0 {
+		now = float64(time.Now().UnixNano()) / 1e9
+	}
+	out := &FlightData{Time: int64(now), States: [][]interface{}{}}
+	for _, a := range append(r.AC, r.Aircraft...) {
+		icao := strings.ToLower(strings.TrimSpace(a.Hex))
+		if len(icao) != 6 || a.Lat == nil || a.Lon == nil || a.SeenPos == nil || *a.SeenPos > readsbPositionMaxAge.Seconds() {
+			continue
+		}
+		if rg != nil && (*a.Lat < rg.LaMin || *a.Lat > rg.LaMax || *a.Lon < rg.LoMin || *a.Lon > rg.LoMax) {
+			continue
+		}
+		out.States = append(out.States, a.state(now))
+	}
+	return out
+}
+
+// state renders the aircraft in OpenSky /states/all vector order, with the extended category.
+func (a readsbAircraft) state(now float64) []interface{} {
+	scaled := func(p *float64, f float64) interface{} {
+		if p == nil {
+			return nil
+		}
+		return *p * f
+	}
+	var alt interface{}
+	ground := string(a.AltBaro) == `"ground"`
+	if v, err := strconv.ParseFloat(string(a.AltBaro), 64); err == nil && !ground {
+		alt = v * geo.Foot
+	}
+	vrate := a.BaroRate
+	if vrate == nil {
+		vrate = a.GeomRate
+	}
+	var squawk interface{}
+	if a.Squawk != "" {
+		squawk = a.Squawk
+	}
+	posSource := 0 // ADS-B
+	if a.Type == "mlat" {
+		posSource = 2
+	}
+	// time_position and last_contact both carry the position time, as for SBS
+	ts := math.Floor(now - *a.SeenPos)
+	return []interface{}{
+		strings.ToLower(strings.TrimSpace(a.Hex)), strings.TrimSpace(a.Flight), nil,
+		ts, ts,
+		*a.Lon, *a.Lat, alt, ground,
+		scaled(a.GS, knotsToMps), scaled(a.Track, 1), scaled(vrate, fpmToMps),
+		nil, scaled(a.AltGeom, geo.Foot), squawk, false, posSource,
+		readsbCategory(a.Category),
+	}
+}
+
+// readsbCategory maps an emitter category (A1..C7) to the OpenSky category number.
+func readsbCategory(c string) int {
+	if len(c) != 2 || c[1] < '0' || c[1] > '7' {
+		return 0
+	}
+	n := int(c[1] - '0')
+	switch c[0] {
+	case 'A':
+		if n == 0 {
+			return 1
+		}
+		return 1 + n // 2 light .. 8 rotorcraft
+	case 'B':
+		if n >= 1 {
+			return 8 + n // 9 glider .. 15 space
+		}
+	case 'C':
+		if n >= 1 && n <= 5 {
+			return 15 + n // 16 surface emergency .. 20 line obstacle
+		}
+	}
+	return 0
+}
+
+// readsbTile is a point query covering part of a region.
+type readsbTile struct {
+	lat, lon float64
+	distNM   int
+}
+
+// coverRegion splits rg into the fewest grid cells whose circumscribed circles are within
+// maxNM and returns those circles.
+func coverRegion(rg *Region, maxNM float64) ([]readsbTile, error) {
+	maxM := maxNM * geo.NauticalMile
+	for n := 1; n <= readsbMaxTiles; n++ {
+		for rows := 1; rows <= n; rows++ {
+			if n%rows != 0 {
+				continue
+			}
+			cols := n / rows
+			tiles, ok := gridTiles(rg, rows, cols, maxM)
+			if ok {
+				return tiles, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("region %s needs more than %d queries of %g nm per poll: split it", rg.Name, readsbMaxTiles, maxNM)
+}
+
+// gridTiles returns the circles of a rows x cols grid over rg, ok when all fit in maxM meters.
+func gridTiles(rg *Region, rows, cols int, maxM float64) ([]readsbTile, bool) {
+	dLat, dLon := (rg.LaMax-rg.LaMin)/float64(rows), (rg.LoMax-rg.LoMin)/float64(cols)
+	tiles := make([]readsbTile, 0, rows*cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			la0, lo0 := rg.LaMin+float64(i)*dLat, rg.LoMin+float64(j)*dLon
+			lat, lon := la0+dLat/2, lo0+dLon/2
+			r := 0.0
+			for _, c := range [][2]float64{{la0, lo0}, {la0, lo0 + dLon}, {la0 + dLat, lo0}, {la0 + dLat, lo0 + dLon}} {
+				r = max(r, geo.Haversine(lat, lon, c[0], c[1]))
+			}
+			if r > maxM {
+				return nil, false
+			}
+			tiles = append(tiles, readsbTile{lat: lat, lon: lon, distNM: int(math.Ceil(r / geo.NauticalMile))})
+		}
+	}
+	return tiles, true
+}
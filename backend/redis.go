@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minimal Redis (RESP2) client for cluster mode: commands on one connection and a separate
+// connection for SUBSCRIBE. Callers serialize use of a connection.
+
+// redisTimeout bounds dialing and each command round trip.
+const redisTimeout = 5 * time.Second
+
+// redisMaxBulk bounds a bulk reply (ingest batches of the whole world are a few MB).
+const redisMaxBulk = 64 << 20
+
+// redisError is an error reply of the server ("-ERR ...").
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConfig is a parsed redis:// or rediss:// URL.
+type redisConfig struct {
+	addr     string
+	tls      bool
+	user     string
+	password string
+	db       int
+}
+
+// parseRedisURL accepts redis://[[user]:password@]host[:port][/db], rediss:// for TLS, or a bare
+// host:port.
+func parseRedisURL(s string) (redisConfig, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "redis://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || (u.Scheme != "redis" && u.Scheme != "rediss") {
+		return redisConfig{}, fmt.Errorf("invalid redis URL %q (redis://[:password@]host:port[/db])", s)
+	}
+	c := redisConfig{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return redisConfig{}, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects, authenticates and selects the database.
+func dialRedis(c redisConfig) (*redisConn, error) {
+	d := &net.Dialer{Timeout: redisTimeout, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		conn, err = tls.DialWithDialer(d, "tcp", c.addr, &tls.Config{MinVersion: tls.VersionTLS12, ServerName: host})
+	} else {
+		conn, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReaderSize(conn, 64<<10)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.user != "" {
+			args = []string{"AUTH", c.user, c.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db > 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *redisConn) Close() error { return c.conn.Close() }
+
+// do sends a command and returns its reply: string, int64, []byte (nil for a null bulk) or
+// []any. Error replies are returned as redisError.
+func (c *redisConn) do(args ...string) (any, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// send writes a command as an array of bulk strings.
+func (c *redisConn) send(args ...string) error {
+	b := make([]byte, 0, 64)
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, '\r', '\n')
+	for _, a := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(a)), 10)
+		b = append(b, '\r', '\n')
+		b = append(b, a...)
+		b = append(b, '\r', '\n')
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// read parses one reply.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > redisMaxBulk {
+			return nil, fmt.Errorf("redis: bad bulk length %q", body)
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", body)
+		}
+		if n < 0 {
+			return []any(nil), nil
+		}
+		out := make([]any, n)
+		for i := range out {
+			if out[i], err = c.read(); err != nil {
+				var re redisError
+				if !errors.As(err, &re) {
+					return nil, err
+				}
+				out[i] = re
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
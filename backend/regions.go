@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Region is a bounding box polled from the source on its own schedule instead of the whole
+// world (opensky.regions), so the request quota goes to the areas of interest.
+type Region struct {
+	Name                       string
+	LaMin, LoMin, LaMax, LoMax float64
+	Interval                   time.Duration // 0 uses opensky.interval
+
+	cache statesCache
+}
+
+// ParseRegions parses "[name=]lat,lon,lat,lon[@interval];..." where the two corners are in any
+// order (e.g. "alps=48,5,45.5,16@30s;55,5,50,15"). Unnamed regions are called r1, r2, ...
+// Boxes crossing the antimeridian must be split in two.
+func ParseRegions(spec string) ([]*Region, error) {
+	var out []*Region
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rg := &Region{Name: fmt.Sprintf("r%d", len(out)+1)}
+		if name, rest, ok := strings.Cut(part, "="); ok {
+			rg.Name = strings.TrimSpace(name)
+			part = rest
+			if rg.Name == "" || strings.ContainsAny(rg.Name, " ,;@") {
+				return nil, fmt.Errorf("invalid region name %q", name)
+			}
+		}
+		if box, iv, ok := strings.Cut(part, "@"); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(iv))
+			if err != nil || d < time.Second {
+				return nil, fmt.Errorf("region %s: invalid interval %q", rg.Name, iv)
+			}
+			rg.Interval = d
+			part = box
+		}
+		f := strings.Split(part, ",")
+		if len(f) != 4 {
+			return nil, fmt.Errorf("region %s: want lat,lon,lat,lon, got %q", rg.Name, part)
+		}
+		var v [4]float64
+		for i, s := range f {
+			n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, fmt.Errorf("region %s: invalid number %q", rg.Name, s)
+			}
+			v[i] = n
+		}
+		rg.LaMin, rg.LaMax = min(v[0], v[2]), max(v[0], v[2])
+		rg.LoMin, rg.LoMax = min(v[1], v[3]), max(v[1], v[3])
+		if rg.LaMin < -90 || rg.LaMax > 90 || rg.LoMin < -180 || rg.LoMax > 180 || rg.LaMin == rg.LaMax || rg.LoMin == rg.LoMax {
+			return nil, fmt.Errorf("region %s: box out of range or empty", rg.Name)
+		}
+		if seen[rg.Name] {
+			return nil, fmt.Errorf("duplicate region name %q", rg.Name)
+		}
+		seen[rg.Name] = true
+		out = append(out, rg)
+	}
+	return out, nil
+}
+
+// PollInterval returns the region's interval, or opensky.interval when it has none.
+func (rg *Region) PollInterval() time.Duration {
+	if rg.Interval > 0 {
+		return rg.Interval
+	}
+	return GetPollInterval()
+}
+
+// Ingest polls the region once and stores its states; like IngestOnce it returns the wait
+// before the next poll, backing off on rate limits independently of other regions.
+func (rg *Region) Ingest() (time.Duration, error) {
+	d := rg.PollInterval()
+	return ingest(rg.Name, d, func() (*FlightData, error) { return fetchStates(rg, d, &rg.cache) })
+}
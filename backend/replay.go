@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// Replay mode (the replay subcommand) feeds a recording into the store instead of polling
+// OpenSky, so the UI and API clients can be demonstrated and tested offline. Recorded time is
+// mapped onto the wall clock from the start of the replay, compressed by the speed factor, so
+// the replayed positions look current to everything downstream (diffs, trails, expiry).
+
+// ReplayConfig configures replay mode.
+type ReplayConfig struct {
+	Path  string    // database file (or shard file) or clip export in JSON
+	Speed float64   // recorded seconds played per second (default 1)
+	Loop  bool      // start over at the end
+	From  time.Time // skip the recording before this time (zero: from the start)
+	To    time.Time // stop the recording after this time (zero: to the end)
+}
+
+// replaySource returns the recorded samples of one hour in time order.
+type replaySource interface {
+	Span() (from, to int64) // first and last sample (unix seconds)
+	Hour(h int64) ([]storage.Point, error)
+	Close() error
+}
+
+// Replayer plays a recording; its Step runs as the "replay" scheduler job.
+type Replayer struct {
+	cfg      ReplayConfig
+	src      replaySource
+	from, to int64 // recorded time range played (unix seconds)
+
+	start   time.Time // wall clock when the recording was at from
+	hour    int64     // next hour to load
+	pending []storage.Point
+	done    bool
+}
+
+// OpenReplay opens the recording: a clip export in JSON (GET /api/clips/{id}/export) or a
+// database file written by this application.
+func OpenReplay(cfg ReplayConfig) (*Replayer, error) {
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+	src, err := openReplaySource(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	r := &Replayer{cfg: cfg, src: src}
+	r.from, r.to = src.Span()
+	if !cfg.From.IsZero() {
+		r.from = max(r.from, cfg.From.Unix())
+	}
+	if !cfg.To.IsZero() {
+		r.to = min(r.to, cfg.To.Unix())
+	}
+	if r.from > r.to {
+		src.Close()
+		return nil, fmt.Errorf("replay: %s has no samples between %s and %s", cfg.Path, isoTime(r.from), isoTime(r.to))
+	}
+	log.Printf("replay: %s from %s to %s at %gx (%s)", cfg.Path, isoTime(r.from), isoTime(r.to), cfg.Speed,
+		time.Duration(float64(r.to-r.from)/cfg.Speed*float64(time.Second)).Round(time.Second))
+	r.rewind()
+	return r, nil
+}
+
+func openReplaySource(path string) (replaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	first, _ := bufio.NewReader(f).Peek(1)
+	f.Close()
+	if len(first) == 1 && first[0] == '{' {
+		return openClipSource(path)
+	}
+	return storage.OpenRecording(path)
+}
+
+func (r *Replayer) rewind() {
+	r.start = time.Now()
+	r.hour = r.from - r.from%3600
+	r.pending = nil
+}
+
+// Close releases the recording.
+func (r *Replayer) Close() error { return r.src.Close() }
+
+// Step stores the samples recorded up to the current replay time.
+func (r *Replayer) Step() (time.Duration, error) {
+	defer endWarmup("first replay batch")
+	if r.done {
+		return time.Second, nil
+	}
+	now := time.Now()
+	upto := min(r.from+int64(now.Sub(r.start).Seconds()*r.cfg.Speed), r.to)
+	// Load whole hours until the pending samples cover the replay time
+	for r.hour <= upto {
+		pts, err := r.src.Hour(r.hour)
+		if err != nil {
+			return time.Second, fmt.Errorf("replay: %w", err)
+		}
+		r.hour += 3600
+		r.pending = append(r.pending, pts...)
+	}
+	n := sort.Search(len(r.pending), func(i int) bool { return r.pending[i].TS > upto })
+	due := r.pending[:n]
+	r.pending = r.pending[n:]
+	if s := storage.Get(); s != nil && len(due) > 0 {
+		t0 := time.Now()
+		// One sample per aircraft and batch, as in an OpenSky response
+		var batch [][]interface{}
+		seen := map[string]bool{}
+		for _, p := range due {
+			if p.TS < r.from {
+				continue
+			}
+			if seen[p.Icao24] {
+				_ = s.UpsertStates(batch)
+				batch, seen = nil, map[string]bool{}
+			}
+			seen[p.Icao24] = true
+			batch = append(batch, r.state(p))
+		}
+		if len(batch) > 0 {
+			_ = s.UpsertStates(batch)
+		}
+		recordIngestDuration(time.Since(t0))
+		publishUpdate()
+	}
+	if upto >= r.to {
+		if !r.cfg.Loop {
+			r.done = true
+			log.Printf("replay: finished at %s", isoTime(r.to))
+			return time.Second, nil
+		}
+		log.Printf("replay: reached %s, starting over", isoTime(r.to))
+		r.rewind()
+	}
+	return time.Second, nil
+}
+
+// state renders a recorded sample as an OpenSky state vector at its replay time.
+func (r *Replayer) state(p storage.Point) []interface{} {
+	ts := float64(r.start.Unix() + int64(float64(p.TS-r.from)/r.cfg.Speed))
+	return []interface{}{p.Icao24, p.Callsign, "", ts, ts, p.Lon, p.Lat, p.Alt, p.Ground,
+		p.Speed, p.Track, p.VRate, nil, p.Alt, nil, false, 0.0}
+}
+
+// clipSource replays a clip export, held in memory (clips are bounded in size).
+type clipSource struct {
+	hours map[int64][]storage.Point
+	from  int64
+	to    int64
+}
+
+func openClipSource(path string) (*clipSource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Tracks []clipTrack `json:"tracks"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: not a clip export in JSON: %w", path, err)
+	}
+	c := &clipSource{hours: map[int64][]storage.Point{}}
+	for _, t := range doc.Tracks {
+		for _, p := range t.Points {
+			if p.Icao24 == "" {
+				p.Icao24 = t.Icao24
+			}
+			h := p.TS - p.TS%3600
+			c.hours[h] = append(c.hours[h], p)
+			if c.from == 0 || p.TS < c.from {
+				c.from = p.TS
+			}
+			c.to = max(c.to, p.TS)
+		}
+	}
+	if len(c.hours) == 0 {
+		return nil, fmt.Errorf("%s: clip has no positions", path)
+	}
+	for _, pts := range c.hours {
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].TS < pts[j].TS })
+	}
+	return c, nil
+}
+
+func (c *clipSource) Span() (int64, int64)                  { return c.from, c.to }
+func (c *clipSource) Hour(h int64) ([]storage.Point, error) { return c.hours[h], nil }
+func (c *clipSource) Close() error                          { return nil }
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+const (
+	maxRings      = 20
+	maxRingRadius = 2000 * geo.Kilometer
+)
+
+// RingsHandler returns GeoJSON range rings and compass radials around a center point.
+// Query:
+//   - center=lat,lon (defaults to the configured receiver location)
+//   - rings=50,100,150nm (units: nm, km, mi, m; default nm)
+//   - radials=N number of evenly spaced bearing lines (default 12, 0 disables)
+//   - segments=N points per ring (default 128)
+func RingsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var lat, lon float64
+	if c := strings.TrimSpace(q.Get("center")); c != "" {
+		var err error
+		lat, lon, err = geo.ParseLatLon(c)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid center: "+err.Error())
+			return
+		}
+	} else {
+		var ok bool
+		lat, lon, ok = geo.Receiver()
+		if !ok {
+			problem.Write(w, r, http.StatusBadRequest, "center is required (no receiver location configured)")
+			return
+		}
+	}
+	ringsParam := q.Get("rings")
+	if strings.TrimSpace(ringsParam) == "" {
+		ringsParam = "50,100,150nm"
+	}
+	radii, err := geo.ParseDistances(ringsParam, geo.NauticalMile)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(radii) > maxRings {
+		problem.Write(w, r, http.StatusBadRequest, "too many rings")
+		return
+	}
+	radials, err := queryInt(r, "radials", 12, 0, 360)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	segments, err := queryInt(r, "segments", 128, 8, 1024)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	fc := geo.NewFeatureCollection()
+	maxR := 0.0
+	for _, rad := range radii {
+		if rad > maxRingRadius {
+			problem.Write(w, r, http.StatusBadRequest, "ring radius too large")
+			return
+		}
+		maxR = math.Max(maxR, rad)
+		fc.Features = append(fc.Features, geo.Feature{
+			Type:     "Feature",
+			Geometry: geo.Geometry{Type: "LineString", Coordinates: geo.Circle(lat, lon, rad, segments)},
+			Properties: map[string]any{
+				"kind":      "ring",
+				"radius_m":  math.Round(rad),
+				"radius_nm": math.Round(rad/geo.NauticalMile*10) / 10,
+				"radius_km": math.Round(rad/geo.Kilometer*10) / 10,
+			},
+		})
+	}
+	for i := 0; i < radials; i++ {
+		brg := 360 * float64(i) / float64(radials)
+		la, lo := geo.Destination(lat, lon, brg, maxR)
+		fc.Features = append(fc.Features, geo.Feature{
+			Type:       "Feature",
+			Geometry:   geo.Geometry{Type: "LineString", Coordinates: [][2]float64{{lon, lat}, {lo, la}}},
+			Properties: map[string]any{"kind": "radial", "bearing": brg},
+		})
+	}
+	fc.Features = append(fc.Features, geo.Feature{
+		Type:       "Feature",
+		Geometry:   geo.Geometry{Type: "Point", Coordinates: [2]float64{lon, lat}},
+		Properties: map[string]any{"kind": "center"},
+	})
+	w.Header().Set("Content-Type", "application/geo+json")
+	_ = json.NewEncoder(w).Encode(fc)
+}
@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/maniack/miniflightradar/airports"
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/tidwall/buntdb"
+)
+
+// Route estimation: the origin and destination of a flight segment, from the airport dataset
+// and the track geometry. In order of confidence, an end of the segment is attributed to
+//   - "endpoint": the airport the aircraft is on or low above at that end (endpointAirport);
+//   - "phase": the airport of a takeoff (landed) sample near the start (end) of the segment;
+//   - "heading": the large or medium airport behind a climbing first sample or ahead of a
+//     descending last sample, within the distance covered at a shallow climb or descent angle
+//     from its altitude. This is a guess; cruising aircraft get none.
+const (
+	routeBasisEndpoint = "endpoint"
+	routeBasisPhase    = "phase"
+	routeBasisHeading  = "heading"
+)
+
+// Heading estimates: ends above routeMaxAlt (meters) are too far from any airport to guess;
+// the search radius is the altitude divided by routeGradient (about 2°, shallower than typical
+// climbs and approaches), within routeMinDist..routeMaxDist, and airports must lie within
+// routeMaxDev degrees of the track.
+const (
+	routeMaxAlt   = 4500
+	routeGradient = 0.035
+	routeMinDist  = 20000
+	routeMaxDist  = 130000
+	routeMaxDev   = 25
+	// routePhaseWindow bounds how far from the segment ends (seconds) phase samples count.
+	routePhaseWindow = 600
+)
+
+// estimateRoute returns the estimated origin and destination of a flight segment (oldest
+// sample first); nil ends are unknown.
+func estimateRoute(seg []storage.Point) (origin, dest *airportRef) {
+	n := len(seg)
+	if n == 0 || airports.Get() == nil {
+		return nil, nil
+	}
+	first, last := seg[0], seg[n-1]
+	if origin = withBasis(endpointAirport(first), routeBasisEndpoint); origin == nil {
+		for i := 0; i < n && seg[i].TS-first.TS <= routePhaseWindow && origin == nil; i++ {
+			if seg[i].Phase == storage.PhaseTakeoff {
+				origin = phaseAirport(seg[i])
+			}
+		}
+	}
+	if origin == nil && climbing(first) {
+		origin = headingAirport(first, math.Mod(routeTrack(seg, 0)+180, 360))
+	}
+	if n > 1 {
+		dest = withBasis(endpointAirport(last), routeBasisEndpoint)
+	}
+	for i := n - 1; i > 0 && last.TS-seg[i].TS <= routePhaseWindow && dest == nil; i-- {
+		if seg[i].Phase == storage.PhaseLanded {
+			dest = phaseAirport(seg[i])
+		}
+	}
+	if dest == nil && descending(last) {
+		dest = headingAirport(last, routeTrack(seg, n-1))
+	}
+	return origin, dest
+}
+
+func withBasis(a *airportRef, basis string) *airportRef {
+	if a != nil {
+		a.Basis = basis
+	}
+	return a
+}
+
+// phaseAirport resolves the airport a sample was annotated with (see storage.SetAirportLookup).
+func phaseAirport(p storage.Point) *airportRef {
+	if p.Airport == "" {
+		return nil
+	}
+	a, ok := airports.Get().Airport(p.Airport)
+	if !ok {
+		return nil
+	}
+	dist := geo.Haversine(p.Lat, p.Lon, a.Lat, a.Lon)
+	return &airportRef{Ident: a.Ident, IATA: a.IATA, Name: a.Name, DistanceM: float64(int(dist)), Basis: routeBasisPhase}
+}
+
+// headingAirport guesses the airport in direction bearing of a low sample.
+func headingAirport(p storage.Point, bearing float64) *airportRef {
+	if p.Alt > routeMaxAlt {
+		return nil
+	}
+	radius := min(max(p.Alt/routeGradient, routeMinDist), routeMaxDist)
+	a, dist, ok := airports.Get().Along(p.Lat, p.Lon, bearing, radius, routeMaxDev)
+	if !ok {
+		return nil
+	}
+	return &airportRef{Ident: a.Ident, IATA: a.IATA, Name: a.Name, DistanceM: float64(int(dist)), Basis: routeBasisHeading}
+}
+
+func climbing(p storage.Point) bool {
+	return !p.Ground && (p.Phase == storage.PhaseTakeoff || p.Phase == storage.PhaseClimb || p.VRate > 1)
+}
+
+func descending(p storage.Point) bool {
+	return !p.Ground && (p.Phase == storage.PhaseDescent || p.VRate < -1)
+}
+
+// routeTrack returns the track of sample i, or the bearing of its step from (to) the
+// neighbouring sample when the source did not report one.
+func routeTrack(seg []storage.Point, i int) float64 {
+	if seg[i].Track != 0 || len(seg) < 2 {
+		return seg[i].Track
+	}
+	a, b := seg[max(i-1, 0)], seg[max(i, 1)]
+	return geo.Bearing(a.Lat, a.Lon, b.Lat, b.Lon)
+}
+
+// flightInfoResponse is the body of /api/flight/info: the latest sample of a flight with its
+// estimated route.
+type flightInfoResponse struct {
+	storage.Point
+	Origin      *airportRef `json:"origin,omitempty"`
+	Destination *airportRef `json:"destination,omitempty"`
+	Since       int64       `json:"since"`                 // first sample of the current segment
+	FlownM      float64     `json:"flown_m"`               // along the segment's samples
+	RemainingM  float64     `json:"remaining_m,omitempty"` // great-circle distance to the destination
+}
+
+// FlightInfoHandler returns the latest sample of a callsign's current flight segment with its
+// estimated origin and destination (/api/flight/info), for flight detail panels.
+func FlightInfoHandler(w http.ResponseWriter, r *http.Request) {
+	callsign, err := queryCallsign(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	segment, _, err := currentSegment(callsign)
+	if err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+		problem.WriteError(w, r, err)
+		return
+	}
+	n := len(segment)
+	if n == 0 {
+		problem.Write(w, r, http.StatusNotFound, "flight not found")
+		return
+	}
+	last := segment[n-1]
+	resp := flightInfoResponse{Point: last, Since: segment[0].TS}
+	for i := 1; i < n; i++ {
+		resp.FlownM += geo.Haversine(segment[i-1].Lat, segment[i-1].Lon, segment[i].Lat, segment[i].Lon)
+	}
+	resp.FlownM = math.Round(resp.FlownM)
+	resp.Origin, resp.Destination = estimateRoute(segment)
+	if d := resp.Destination; d != nil {
+		if a, ok := airports.Get().Airport(d.Ident); ok {
+			resp.RemainingM = math.Round(geo.Haversine(last.Lat, last.Lon, a.Lat, a.Lon))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/airports"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// runwayEventKind is the storage event kind for landings/takeoffs.
+const runwayEventKind = "runway"
+
+// runwayDedup suppresses repeated movements of the same aircraft at the same airport.
+const runwayDedup = int64(10 * 60)
+
+var (
+	runwayMu   sync.Mutex
+	runwayLast = map[string]int64{} // icao|airport|kind -> ts
+)
+
+// ObserveRunways is a storage observer that records landings and takeoffs matched to runways.
+func ObserveRunways(prev *storage.Point, cur storage.Point) {
+	db := airports.Get()
+	if db == nil || prev == nil || cur.TS-prev.TS > 120 {
+		return
+	}
+	m, ok := db.MatchMovement(
+		airports.Fix{Lat: prev.Lat, Lon: prev.Lon, Alt: prev.Alt, Track: prev.Track, Speed: prev.Speed, TS: prev.TS},
+		airports.Fix{Lat: cur.Lat, Lon: cur.Lon, Alt: cur.Alt, Track: cur.Track, Speed: cur.Speed, TS: cur.TS},
+	)
+	if !ok {
+		return
+	}
+	key := cur.Icao24 + "|" + m.Airport + "|" + m.Kind
+	runwayMu.Lock()
+	if last, seen := runwayLast[key]; seen && cur.TS-last < runwayDedup {
+		runwayMu.Unlock()
+		return
+	}
+	runwayLast[key] = cur.TS
+	// drop stale entries occasionally
+	if len(runwayLast) > 10000 {
+		for k, ts := range runwayLast {
+			if cur.TS-ts > runwayDedup {
+				delete(runwayLast, k)
+			}
+		}
+	}
+	runwayMu.Unlock()
+	_ = storage.Get().AddEvent(storage.Event{
+		Kind: runwayEventKind, TS: cur.TS, Icao24: cur.Icao24, Callsign: cur.Callsign,
+		Lat: cur.Lat, Lon: cur.Lon, Alt: cur.Alt,
+		Attrs: map[string]string{"airport": m.Airport, "runway": m.Runway, "movement": m.Kind},
+	})
+}
+
+type runwayCount struct {
+	Runway   string `json:"runway"`
+	Landings int    `json:"landings"`
+	Takeoffs int    `json:"takeoffs"`
+}
+
+// RunwayStatsHandler returns runway usage for /api/airport/{icao}/runways/stats.
+// Query: from, to (YYYY-MM-DD, default last 7 days), bucket=hour|day (default day).
+// The response lists totals per runway end, a time series and the runway(s) currently in use
+// (movements in the last 30 minutes).
+func RunwayStatsHandler(w http.ResponseWriter, r *http.Request) {
+	db := airports.Get()
+	if db == nil {
+		problem.Write(w, r, http.StatusNotFound, "airport dataset is not configured")
+		return
+	}
+	apt, ok := db.Airport(chi.URLParam(r, "icao"))
+	if !ok {
+		problem.Write(w, r, http.StatusNotFound, "airport not found")
+		return
+	}
+	from, to, err := parseDayRange(r, 7)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	bucket := int64(24 * 3600)
+	switch strings.ToLower(q.Get("bucket")) {
+	case "", "day":
+	case "hour":
+		bucket = 3600
+	default:
+		problem.Write(w, r, http.StatusBadRequest, "invalid bucket (hour, day)")
+		return
+	}
+	events, err := storage.Get().Events(runwayEventKind, from.Unix(), to.Unix(), func(e storage.Event) bool {
+		return e.Attrs["airport"] == apt.Ident
+	})
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	add := func(m map[string]*runwayCount, e storage.Event) {
+		rw := e.Attrs["runway"]
+		c := m[rw]
+		if c == nil {
+			c = &runwayCount{Runway: rw}
+			m[rw] = c
+		}
+		if e.Attrs["movement"] == "landing" {
+			c.Landings++
+		} else {
+			c.Takeoffs++
+		}
+	}
+	list := func(m map[string]*runwayCount) []runwayCount {
+		out := make([]runwayCount, 0, len(m))
+		for _, c := range m {
+			out = append(out, *c)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Runway < out[j].Runway })
+		return out
+	}
+	totals := map[string]*runwayCount{}
+	buckets := map[int64]map[string]*runwayCount{}
+	for _, e := range events {
+		add(totals, e)
+		start := e.TS - e.TS%bucket
+		if buckets[start] == nil {
+			buckets[start] = map[string]*runwayCount{}
+		}
+		add(buckets[start], e)
+	}
+	type seriesItem struct {
+		Start   int64         `json:"start"`
+		Runways []runwayCount `json:"runways"`
+	}
+	series := make([]seriesItem, 0, len(buckets))
+	for start, m := range buckets {
+		series = append(series, seriesItem{Start: start, Runways: list(m)})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Start < series[j].Start })
+	// Runways in use: most recent 30 minutes, regardless of the requested range
+	recent, _ := storage.Get().Events(runwayEventKind, now.Add(-30*time.Minute).Unix(), now.Unix(), func(e storage.Event) bool {
+		return e.Attrs["airport"] == apt.Ident
+	})
+	inUse := map[string]*runwayCount{}
+	for _, e := range recent {
+		add(inUse, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"airport": apt,
+		"runways": db.RunwaysAt(apt.Ident),
+		"from":    from.Unix(),
+		"to":      to.Unix(),
+		"bucket":  bucket,
+		"totals":  list(totals),
+		"series":  series,
+		"in_use":  list(inUse),
+	})
+}
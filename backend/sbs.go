@@ -0,0 +1,246 @@
+package backend
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// SBSConfig configures the BaseStation (SBS-1) feed of a local dump1090/readsb receiver.
+type SBSConfig struct {
+	Addr  string        // host:port of the SBS output (usually port 30003)
+	Flush time.Duration // how often aggregated positions are upserted
+}
+
+// Unit conversions: SBS reports feet, knots and feet per minute; storage uses OpenSky units.
+const (
+	knotsToMps = 0.514444
+	fpmToMps   = geo.Foot / 60
+)
+
+// sbsStale drops aircraft from the aggregation after this long without messages; positions
+// older than sbsPositionMaxAge are not re-upserted with newer non-position fields.
+const (
+	sbsStale          = 5 * time.Minute
+	sbsPositionMaxAge = time.Minute
+)
+
+// sbsAircraft accumulates the partial fields carried by different SBS message types.
+type sbsAircraft struct {
+	icao     string
+	callsign string
+	alt      *float64 // meters
+	speed    *float64 // m/s
+	track    *float64
+	vrate    *float64 // m/s
+	squawk   string
+	onGround bool
+	lat, lon float64
+	posAt    time.Time
+	seenAt   time.Time
+	dirty    bool
+}
+
+// sbsState is the aggregation shared by the reader and the flusher.
+type sbsState struct {
+	mu       sync.Mutex
+	aircraft map[string]*sbsAircraft
+}
+
+// StartSBS connects to an SBS feed and upserts aggregated positions every c.Flush until stop is
+// closed, reconnecting with backoff when the connection drops. It is a no-op without c.Addr.
+func StartSBS(c SBSConfig, stop <-chan struct{}) {
+	if strings.TrimSpace(c.Addr) == "" {
+		return
+	}
+	if c.Flush <= 0 {
+		c.Flush = 500 * time.Millisecond
+	}
+	st := &sbsState{aircraft: map[string]*sbsAircraft{}}
+	go monitoring.Supervise("sbs.reader", stop, func() { st.connectLoop(c.Addr, stop) })
+	go monitoring.Supervise("sbs.flush", stop, func() {
+		t := time.NewTicker(c.Flush)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				st.flush()
+			}
+		}
+	})
+}
+
+func (st *sbsState) connectLoop(addr string, stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			monitoring.SubDebugf("ingest", "sbs connect addr=%s error: %v (retry in %s)", addr, err, backoff)
+		} else {
+			monitoring.SubDebugf("ingest", "sbs connected addr=%s", addr)
+			monitoring.SBSConnected.Set(1)
+			start := time.Now()
+			st.read(conn, stop)
+			monitoring.SBSConnected.Set(0)
+			if time.Since(start) > time.Minute {
+				backoff = time.Second
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// read consumes lines until the connection fails or stop is closed.
+func (st *sbsState) read(conn net.Conn, stop <-chan struct{}) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	for {
+		// A silent feed is treated as dead so the reader reconnects
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				monitoring.SubDebugf("ingest", "sbs read error: %v", err)
+			}
+			return
+		}
+		if st.apply(sc.Text(), time.Now()) {
+			monitoring.SBSMessages.WithLabelValues("ok").Inc()
+		} else {
+			monitoring.SBSMessages.WithLabelValues("ignored").Inc()
+		}
+	}
+}
+
+// apply merges one SBS line into the aggregation; it reports whether the line was a usable
+// MSG record. Format: MSG,type,session,aircraft,hex,flight,date,time,date,time,callsign,
+// altitude,groundspeed,track,lat,lon,vertical_rate,squawk,alert,emergency,spi,is_on_ground.
+func (st *sbsState) apply(line string, now time.Time) bool {
+	f := strings.Split(strings.TrimSpace(line), ",")
+	if len(f) < 22 || f[0] != "MSG" {
+		return false
+	}
+	icao := strings.ToLower(strings.TrimSpace(f[4]))
+	if len(icao) != 6 {
+		return false
+	}
+	num := func(i int) (float64, bool) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f[i]), 64)
+		return v, err == nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	a := st.aircraft[icao]
+	if a == nil {
+		a = &sbsAircraft{icao: icao}
+		st.aircraft[icao] = a
+	}
+	a.seenAt = now
+	if cs := strings.TrimSpace(f[10]); cs != "" {
+		a.callsign = cs
+	}
+	if v, ok := num(11); ok {
+		m := v * geo.Foot
+		a.alt = &m
+	}
+	if v, ok := num(12); ok {
+		mps := v * knotsToMps
+		a.speed = &mps
+	}
+	if v, ok := num(13); ok {
+		a.track = &v
+	}
+	lat, latOK := num(14)
+	lon, lonOK := num(15)
+	if latOK && lonOK && lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180 {
+		a.lat, a.lon, a.posAt = lat, lon, now
+	}
+	if v, ok := num(16); ok {
+		mps := v * fpmToMps
+		a.vrate = &mps
+	}
+	if sq := strings.TrimSpace(f[17]); sq != "" {
+		a.squawk = sq
+	}
+	if g := strings.TrimSpace(f[21]); g != "" {
+		a.onGround = g == "-1" || g == "1"
+	}
+	a.dirty = true
+	return true
+}
+
+// flush upserts aircraft that changed since the last flush as OpenSky-style state vectors.
+func (st *sbsState) flush() {
+	now := time.Now()
+	var states [][]interface{}
+	st.mu.Lock()
+	for icao, a := range st.aircraft {
+		if now.Sub(a.seenAt) > sbsStale {
+			delete(st.aircraft, icao)
+			continue
+		}
+		if !a.dirty || a.posAt.IsZero() || now.Sub(a.posAt) > sbsPositionMaxAge {
+			continue
+		}
+		a.dirty = false
+		states = append(states, a.state())
+	}
+	st.mu.Unlock()
+	if len(states) == 0 {
+		return
+	}
+	s := storage.Get()
+	if s == nil {
+		return
+	}
+	if err := s.UpsertStates(states); err != nil {
+		monitoring.SubDebugf("ingest", "sbs upsert error: %v", err)
+		return
+	}
+	monitoring.SubDebugf("ingest", "sbs upserted states=%d", len(states))
+	publishUpdate()
+}
+
+// state renders the aircraft in OpenSky /states/all vector order.
+func (a *sbsAircraft) state() []interface{} {
+	opt := func(p *float64) interface{} {
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	var squawk interface{}
+	if a.squawk != "" {
+		squawk = a.squawk
+	}
+	return []interface{}{
+		a.icao, a.callsign, nil,
+		// time_position and last_contact: storage keys points by the latter, so both carry the
+		// position time rather than the last (possibly non-position) message
+		float64(a.posAt.Unix()), float64(a.posAt.Unix()),
+		a.lon, a.lat, opt(a.alt), a.onGround,
+		opt(a.speed), opt(a.track), opt(a.vrate),
+		nil, nil, squawk, false, 0.0, // sensors, geo_altitude, squawk, spi, position_source (ADS-B)
+	}
+}
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// searchHit is a current flight matched by /api/search with the field and kind of its best match.
+type searchHit struct {
+	storage.Point
+	Field string `json:"field"` // callsign, icao24 or registration
+	Match string `json:"match"` // exact, prefix, contains or fuzzy
+	Score int    `json:"score"` // higher ranks first
+}
+
+// Match kinds by rank; within a kind, shorter values (closer to the query) rank higher.
+var searchScores = map[string]int{"exact": 400, "prefix": 300, "contains": 200, "fuzzy": 100}
+
+const maxSearchQuery = 32
+
+// SearchHandler finds current flights by callsign, ICAO24 address or (with an aircraft
+// database) registration for /api/search?q=. Exact matches rank before prefix, substring and
+// fuzzy ones (up to one typo, two for queries longer than 5 characters); registrations match
+// with or without the dash (DAIMA finds D-AIMA). Query: q (required), limit (1..100, default 20).
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := normalizeSearch(r.URL.Query().Get("q"))
+	if q == "" || len(q) > maxSearchQuery {
+		problem.WriteError(w, r, invalidParam("q", "want 1..%d letters or digits", maxSearchQuery))
+		return
+	}
+	limit, err := queryInt(r, "limit", 20, 1, 100)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	pts, err := storage.Get().CurrentAll()
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	hits := []searchHit{}
+	for _, p := range pts {
+		best := searchHit{Point: p}
+		for _, f := range [...]struct{ name, v string }{
+			{"callsign", p.Callsign}, {"icao24", p.Icao24}, {"registration", p.Registration},
+		} {
+			if match, score := searchMatch(q, normalizeSearch(f.v)); score > best.Score {
+				best.Field, best.Match, best.Score = f.name, match, score
+			}
+		}
+		if best.Score > 0 {
+			hits = append(hits, best)
+		}
+	}
+	slices.SortStableFunc(hits, func(a, b searchHit) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		return strings.Compare(strings.TrimSpace(a.Callsign), strings.TrimSpace(b.Callsign))
+	})
+	total := len(hits)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"query": q, "total": total, "results": hits})
+}
+
+// normalizeSearch upper-cases s and keeps only letters and digits.
+func normalizeSearch(s string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			return c
+		}
+		return -1
+	}, s)
+}
+
+// searchMatch ranks how v matches q (both normalized); score 0 means no match.
+func searchMatch(q, v string) (string, int) {
+	if v == "" {
+		return "", 0
+	}
+	tail := min(len(v)-len(q), 99) // longer values rank lower within a kind
+	switch {
+	case v == q:
+		return "exact", searchScores["exact"]
+	case strings.HasPrefix(v, q):
+		return "prefix", searchScores["prefix"] - tail
+	case strings.Contains(v, q):
+		return "contains", searchScores["contains"] - tail
+	}
+	if len(q) < 3 {
+		return "", 0
+	}
+	maxDist := 1
+	if len(q) > 5 {
+		maxDist = 2
+	}
+	// A typo anywhere in the query, compared with the start of v (one character more or less)
+	best := maxDist + 1
+	for n := len(q) - maxDist; n <= len(q)+maxDist; n++ {
+		if n > 0 && n <= len(v) {
+			best = min(best, editDistance(q, v[:n]))
+		}
+	}
+	if best > maxDist {
+		return "", 0
+	}
+	return "fuzzy", searchScores["fuzzy"] - 10*best - min(max(tail, 0), 9)
+}
+
+// editDistance is the Levenshtein distance of two ASCII strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// ShedConfig controls automatic load shedding. Overload is declared when any signal stays above
+// its threshold for Sustain; shedding ends after signals stay below the thresholds for Recover.
+type ShedConfig struct {
+	Enabled      bool
+	MaxLag       time.Duration // Go scheduler lag (timer overshoot)
+	MaxIngest    time.Duration // storage upsert duration per ingest cycle (0 = half the poll interval)
+	BacklogRatio float64       // share of WS clients that are backlogged (buffer > 1MB or ACK overdue)
+	Sustain      time.Duration
+	Recover      time.Duration
+	DiffInterval time.Duration // minimum time between diffs per client while shedding
+	RetryAfter   time.Duration // Retry-After for rejected WS connections
+}
+
+// ShedEvent records a transition into or out of shedding mode.
+type ShedEvent struct {
+	Time   time.Time `json:"time"`
+	Active bool      `json:"active"`
+	Reason string    `json:"reason"`
+}
+
+var (
+	shedCfg      = ShedConfig{Enabled: true, MaxLag: 250 * time.Millisecond, BacklogRatio: 0.5, Sustain: 10 * time.Second, Recover: 30 * time.Second, DiffInterval: 10 * time.Second, RetryAfter: 30 * time.Second}
+	shedding     atomic.Bool
+	schedLag     atomic.Int64 // ns, smoothed
+	ingestDur    atomic.Int64 // ns, last storage upsert
+	shedMu       sync.Mutex
+	shedHistory  []ShedEvent
+	shedPressure map[string]float64
+)
+
+// SetShedConfig replaces the load-shedding configuration; call before StartLoadShedding.
+func SetShedConfig(c ShedConfig) { shedCfg = c }
+
+// Shedding reports whether load shedding is currently active.
+func Shedding() bool { return shedding.Load() }
+
+// recordIngestDuration feeds the ingest-lag signal.
+func recordIngestDuration(d time.Duration) { ingestDur.Store(int64(d)) }
+
+// StartLoadShedding samples overload signals once per second until stop is closed.
+func StartLoadShedding(stop <-chan struct{}) {
+	if !shedCfg.Enabled {
+		return
+	}
+	go monitoring.Supervise("load.lag", stop, func() { measureSchedulerLag(stop) })
+	go monitoring.Supervise("load.shed", stop, func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		var overSince, underSince time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-t.C:
+				reason := shedReason()
+				if reason != "" {
+					underSince = time.Time{}
+					if overSince.IsZero() {
+						overSince = now
+					}
+					if !shedding.Load() && now.Sub(overSince) >= shedCfg.Sustain {
+						setShedding(true, reason)
+					}
+				} else {
+					overSince = time.Time{}
+					if underSince.IsZero() {
+						underSince = now
+					}
+					if shedding.Load() && now.Sub(underSince) >= shedCfg.Recover {
+						setShedding(false, "pressure subsided")
+					}
+				}
+			}
+		}
+	})
+}
+
+// measureSchedulerLag measures how late a 100ms timer fires (smoothed), a proxy for CPU saturation.
+func measureSchedulerLag(stop <-chan struct{}) {
+	const tick = 100 * time.Millisecond
+	for {
+		start := time.Now()
+		select {
+		case <-stop:
+			return
+		case <-time.After(tick):
+		}
+		lag := time.Since(start) - tick
+		if lag < 0 {
+			lag = 0
+		}
+		prev := schedLag.Load()
+		schedLag.Store(prev + (int64(lag)-prev)/8)
+	}
+}
+
+// shedReason evaluates the overload signals and returns the first one above its threshold.
+func shedReason() string {
+	lag := time.Duration(schedLag.Load())
+	ing := time.Duration(ingestDur.Load())
+	maxIngest := shedCfg.MaxIngest
+	if maxIngest <= 0 {
+		maxIngest = GetPollInterval() / 2
+	}
+	total, backlogged := wsBacklog(30 * time.Second)
+	ratio := 0.0
+	if total > 0 {
+		ratio = float64(backlogged) / float64(total)
+	}
+	pressure := map[string]float64{
+		"scheduler_lag_seconds": lag.Seconds(),
+		"ingest_seconds":        ing.Seconds(),
+		"ws_backlog_ratio":      ratio,
+	}
+	for k, v := range pressure {
+		monitoring.LoadPressure.WithLabelValues(k).Set(v)
+	}
+	shedMu.Lock()
+	shedPressure = pressure
+	shedMu.Unlock()
+	switch {
+	case shedCfg.MaxLag > 0 && lag > shedCfg.MaxLag:
+		return "scheduler lag " + lag.Round(100*time.Microsecond).String()
+	case maxIngest > 0 && ing > maxIngest:
+		return "ingest took " + ing.Round(time.Millisecond).String()
+	case shedCfg.BacklogRatio > 0 && total >= 4 && ratio >= shedCfg.BacklogRatio:
+		return strconv.Itoa(backlogged) + "/" + strconv.Itoa(total) + " ws clients backlogged"
+	}
+	return ""
+}
+
+func setShedding(on bool, reason string) {
+	shedding.Store(on)
+	action := "exit"
+	if on {
+		action = "enter"
+		monitoring.LoadShedding.Set(1)
+	} else {
+		monitoring.LoadShedding.Set(0)
+	}
+	monitoring.ShedEvents.WithLabelValues(action).Inc()
+	log.Printf("load_shedding active=%t reason=%q", on, reason)
+	shedMu.Lock()
+	shedHistory = append(shedHistory, ShedEvent{Time: time.Now().UTC(), Active: on, Reason: reason})
+	if len(shedHistory) > 100 {
+		shedHistory = shedHistory[len(shedHistory)-100:]
+	}
+	shedMu.Unlock()
+}
+
+// rejectIfShedding answers new WS connections with 503 + Retry-After while shedding.
+func rejectIfShedding(w http.ResponseWriter, r *http.Request) bool {
+	if !shedding.Load() {
+		return false
+	}
+	monitoring.ShedEvents.WithLabelValues("ws_rejected").Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(shedCfg.RetryAfter.Seconds())))
+	problem.Write(w, r, http.StatusServiceUnavailable, "server overloaded, retry later")
+	return true
+}
+
+// LoadStatusHandler reports the load-shedding state, current signals and recent transitions (admin).
+func LoadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	shedMu.Lock()
+	resp := map[string]any{
+		"shedding": shedding.Load(),
+		"enabled":  shedCfg.Enabled,
+		"pressure": shedPressure,
+		"events":   append([]ShedEvent{}, shedHistory...),
+	}
+	shedMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
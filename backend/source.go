@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is an upstream REST feed of aircraft states polled by the ingest jobs
+// (source.provider). Responses are converted to OpenSky state vectors.
+type Source interface {
+	// Name labels the source in logs and outbound request metrics.
+	Name() string
+	// Fetch returns the current states within rg, or worldwide when rg is nil.
+	Fetch(rg *Region) (*FlightData, error)
+}
+
+var (
+	sourceMu sync.RWMutex
+	source   Source = openskySource{}
+)
+
+func currentSource() Source {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+	return source
+}
+
+// SetSource replaces the source polled by IngestOnce and the regions (default: OpenSky).
+func SetSource(s Source) {
+	sourceMu.Lock()
+	source = s
+	sourceMu.Unlock()
+}
+
+// SourceConfig selects and configures the ingest source.
+type SourceConfig struct {
+	Provider string        // opensky (default), adsbx, adsbfi, airplaneslive
+	URL      string        // overrides the provider's endpoint (see readsbSource)
+	APIKey   string        // ADS-B Exchange API key
+	Rate     time.Duration // minimum spacing of requests; 0 uses the provider's limit
+}
+
+// SourceProviders lists the accepted source.provider values.
+var SourceProviders = []string{"opensky", "adsbx", "adsbfi", "airplaneslive"}
+
+// ConfigureSource installs the source selected by c. Sources answering point/radius queries
+// only cannot poll the whole world, so regional (opensky.regions configured) must be set for
+// them unless c.URL returns all aircraft.
+func ConfigureSource(c SourceConfig, regional bool) error {
+	name := strings.ToLower(strings.TrimSpace(c.Provider))
+	if name == "" || name == "opensky" {
+		if strings.TrimSpace(c.URL) != "" {
+			return fmt.Errorf("source.api_url is not supported with the opensky provider")
+		}
+		SetSource(openskySource{})
+		return nil
+	}
+	s, err := newReadsbSource(name, c)
+	if err != nil {
+		return err
+	}
+	if !regional && s.pointQuery() {
+		return fmt.Errorf("source.provider %s queries by point and radius: poll areas with opensky.regions, or set source.api_url to an endpoint returning all aircraft", name)
+	}
+	SetSource(s)
+	return nil
+}
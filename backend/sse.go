@@ -0,0 +1,244 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/security"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// sseRetry is the reconnect delay suggested to EventSource clients.
+const sseRetry = 2500 * time.Millisecond
+
+// FlightsSSEHandler serves the /ws/flights diff stream as Server-Sent Events for clients behind
+// proxies that block WebSockets. Messages are the JSON (or compact) diffs of the WebSocket
+// stream; there are no ACKs, so a diff is sent after every ingest. The viewport and flight
+// filter come from the query (bbox, callsign, callsign_re, type) and are changed by
+// reconnecting. Event ids are event log sequence numbers: a client reconnecting with
+// Last-Event-ID (or ?last_event_id=) receives only the changes since then, or a resync followed
+// by a full snapshot when the log no longer covers that point.
+func FlightsSSEHandler(w http.ResponseWriter, r *http.Request) {
+	public := security.IsPublic(r) || security.HasAPIKey(r)
+	if !public && !security.ValidateJWTFromRequest(r) {
+		problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if csrfQ := r.URL.Query().Get("csrf"); !public && (csrfQ == "" || csrfQ != security.GetCSRFFromRequest(r)) {
+		problem.Write(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		problem.Write(w, r, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	precision, err := parsePrecision(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	trailFull, err := parseTrailMode(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	color, err := parseTrailColor(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = encodingJSON
+	}
+	if encoding != encodingJSON && encoding != encodingCompact {
+		problem.Write(w, r, http.StatusBadRequest, "invalid encoding (json|compact)")
+		return
+	}
+	view, hasView, err := queryBBox(r, false)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	filter, err := queryFlightFilter(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	var resumeID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" || r.URL.Query().Get("last_event_id") != "" {
+		if v == "" {
+			v = r.URL.Query().Get("last_event_id")
+		}
+		if resumeID, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil || resumeID < 0 {
+			problem.WriteError(w, r, invalidParam("last_event_id", "want a sequence number"))
+			return
+		}
+	}
+	if rejectIfShedding(w, r) {
+		return
+	}
+	keep := func(p storage.Point, known bool) bool {
+		return (!hasView || inViewport(view, p.Lon, p.Lat, known)) && filter.Match(p)
+	}
+	var viewp *bbox
+	if hasView {
+		viewp = &view
+	}
+
+	defer monitoring.Recover("sse.flights")
+	monitoring.SSEClients.Inc()
+	defer monitoring.SSEClients.Dec()
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	monitoring.SubDebugf("ws", "flights sse connected remote=%s resume=%d", r.RemoteAddr, resumeID)
+
+	// event writes one message; id 0 omits the event id (event log disabled)
+	event := func(id int64, data []byte) error {
+		var err error
+		if id > 0 {
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+		} else {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if err == nil {
+			fl.Flush()
+		}
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+		return
+	}
+
+	last := map[string]wsItem{}
+	resumed := false
+	if resumeID > 0 {
+		state, ok := resumeState(resumeID)
+		if ok {
+			resumed = true
+			for _, p := range state {
+				if key := itemKey(p); key != "" && keep(p, false) {
+					last[key] = toItem(p, precision)
+				}
+			}
+			monitoring.SubDebugf("ws", "flights sse resume after=%d known=%d", resumeID, len(last))
+		} else if err := event(0, resyncMessage("resume_failed")); err != nil {
+			return
+		}
+	}
+	var seq int64
+	lastSend := time.Now()
+	resyncSeen := wsResync.Load()
+
+	// trySend sends the changes since the last diff; the event id is the log sequence read
+	// before the state, so a resuming client never claims changes it has not seen.
+	trySend := func() error {
+		id := storage.Get().LastSeq()
+		pts, err := candidates(viewp, nil, nil)
+		if err != nil {
+			return err
+		}
+		cur, arr := currentItems(pts, last, keep, precision)
+		up, dl, out := diffItems(last, cur, arr)
+		if len(up) == 0 && len(dl) == 0 && len(out) == 0 && (seq > 0 || resumed) {
+			last = cur
+			return nil
+		}
+		trails := 0
+		for i := 0; i < len(up) && !Shedding(); i++ {
+			trails += attachTrail(&up[i], precision, trailFull, color)
+		}
+		seq++
+		b := encodeDiff(wsDiff{Type: "diff", Seq: seq, Precision: precision, Upsert: up, Delete: dl, OutOfView: out}, encoding)
+		if err := event(id, b); err != nil {
+			return err
+		}
+		last = cur
+		lastSend = time.Now()
+		monitoring.SubDebugf("ws", "flights sse => diff seq=%d id=%d up=%d del=%d out=%d bytes=%d trails=%d", seq, id, len(up), len(dl), len(out), len(b), trails)
+		return nil
+	}
+
+	updates, unsubscribe := UpdatesSubscribe()
+	defer unsubscribe()
+	hb := time.NewTicker(25 * time.Second)
+	defer hb.Stop()
+
+	// A resuming client keeps its state through warm-up; a new one waits for the first poll
+	var warmC <-chan struct{}
+	if !resumed {
+		if warmC = warmingUp(); warmC != nil {
+			b, _ := json.Marshal(map[string]any{"type": "status", "status": "warming_up", "ts": time.Now().Unix()})
+			if err := event(0, b); err != nil {
+				return
+			}
+		}
+	}
+	if warmC == nil {
+		if err := trySend(); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-warmC:
+			warmC = nil
+			if err := trySend(); err != nil {
+				return
+			}
+		case <-updates:
+			if warmC != nil {
+				break
+			}
+			if v := wsResync.Load(); v != resyncSeen {
+				resyncSeen = v
+				if err := event(0, resyncMessage("clock_jump")); err != nil {
+					return
+				}
+				last, seq, resumed = map[string]wsItem{}, 0, false
+			}
+			if err := trySend(); err != nil {
+				return
+			}
+		case <-hb.C:
+			if time.Since(lastSend) > 20*time.Second {
+				if _, err := io.WriteString(w, ": hb\n\n"); err != nil {
+					return
+				}
+				fl.Flush()
+				lastSend = time.Now()
+			}
+		}
+	}
+}
+
+// resumeState returns the current state as of event log sequence id, when the retained log
+// still covers it.
+func resumeState(id int64) (map[string]storage.Point, bool) {
+	st := storage.Get()
+	if id > st.LastSeq() {
+		return nil, false // from another database, or the sequence was reset
+	}
+	_, oldest, err := st.Changes(id, 1)
+	if err != nil || oldest == 0 || id+1 < oldest {
+		return nil, false
+	}
+	state, err := st.StateAt(id)
+	if err != nil {
+		return nil, false
+	}
+	return state, true
+}
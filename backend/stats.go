@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// RollupStats rolls up completed days into persistent daily statistics, well before raw
+// positions expire; it runs as the hourly "stats" scheduler job.
+func RollupStats() (time.Duration, error) {
+	s := storage.Get()
+	if s == nil {
+		return 0, nil
+	}
+	days, err := s.RollupPending()
+	if err != nil {
+		monitoring.SubDebugf("storage", "stats rollup error: %v", err)
+	}
+	if len(days) > 0 {
+		monitoring.SubDebugf("storage", "stats rolled up days=%v", days)
+	}
+	return 0, err
+}
+
+// CompactStorage thins old history, shrinks the database files and enforces storage.max_size;
+// it runs as the "compact" scheduler job.
+func CompactStorage() (time.Duration, error) {
+	s := storage.Get()
+	if s == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	res, err := s.Compact()
+	if err != nil {
+		monitoring.SubDebugf("storage", "compaction error: %v", err)
+	}
+	if res.Downsampled > 0 || res.Dropped > 0 {
+		log.Printf("storage compaction: downsampled=%d dropped=%d reclaimed=%d size=%d duration=%s", res.Downsampled, res.Dropped, res.Reclaimed, res.Size, time.Since(start).Round(time.Millisecond))
+	}
+	return 0, err
+}
+
+// DailyStatsHandler returns daily rollups for ?from=YYYY-MM-DD&to=YYYY-MM-DD (default: last 30 days)
+// together with totals over the range.
+func DailyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	const layout = "2006-01-02"
+	from, to, err := parseDayRange(r, 30)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	days, err := storage.Get().Rollups(from.Format(layout), to.Format(layout))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	// Totals: aircraft-days and per-airline/type sums across the range
+	var samples int64
+	var distance float64
+	aircraftDays := 0
+	byAirline := map[string]int{}
+	byType := map[string]int{}
+	for _, d := range days {
+		samples += d.Samples
+		distance += d.AreaDistanceKm
+		aircraftDays += d.UniqueAircraft
+		for k, v := range d.ByAirline {
+			byAirline[k] += v
+		}
+		for k, v := range d.ByType {
+			byType[k] += v
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"from": from.Format(layout),
+		"to":   to.Format(layout),
+		"days": days,
+		"totals": map[string]any{
+			"samples":          samples,
+			"aircraft_days":    aircraftDays,
+			"area_distance_km": distance,
+			"by_airline":       byAirline,
+			"by_type":          byType,
+		},
+	})
+}
+
+// RarityHandler lists operators or aircraft types from rarest to most common.
+// Query: kind=operator|type (default operator), limit (1..500, default 50).
+func RarityHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind == "" {
+		kind = "operator"
+	}
+	limit, err := queryInt(r, "limit", 50, 1, 500)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	items, err := storage.Get().Rarity(kind, limit)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"kind": kind, "items": items})
+}
+
+// LogRareSighting is the default rare_aircraft rule action: it logs the sighting.
+func LogRareSighting(sg storage.Sighting) {
+	p := sg.Point
+	log.Printf("rare aircraft: icao24=%s callsign=%s operator=%s type=%s rarity=%d", p.Icao24, p.Callsign, sg.Operator, sg.Type, p.Rarity)
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+	"github.com/tidwall/buntdb"
+)
+
+// TrackExportHandler serves /api/track/export?callsign=&format=csv|kml|gpx: the current flight
+// segment of /api/track as a download for spreadsheets, Google Earth or GPS tools. It uses the
+// clip export documents, with the segment as a single-track clip.
+func TrackExportHandler(w http.ResponseWriter, r *http.Request) {
+	callsign, err := queryCallsign(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	var doc clipDoc
+	var ctype string
+	switch format {
+	case "csv":
+		ctype, doc = "text/csv; charset=utf-8", clipCSV{}
+	case "kml":
+		ctype, doc = "application/vnd.google-earth.kml+xml", clipKML{}
+	case "gpx":
+		ctype, doc = "application/gpx+xml", clipGPX{}
+	default:
+		problem.WriteError(w, r, invalidParam("format", "want csv, kml or gpx, got %q", format))
+		return
+	}
+	segment, icao, err := currentSegment(callsign)
+	if err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if len(segment) == 0 {
+		problem.Write(w, r, http.StatusNotFound, "track not found")
+		return
+	}
+	first, last := segment[0], segment[len(segment)-1]
+	c := &storage.Clip{ID: callsign, Title: callsign, From: first.TS, To: last.TS, Aircraft: 1, Points: len(segment)}
+	rows := flattenRows([]clipTrack{{Icao24: icao, Callsign: callsign, Points: segment}})
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%s-%s.%s"`,
+		callsign, time.Unix(first.TS, 0).UTC().Format("20060102T1504Z"), format))
+	h.Set("Cache-Control", "no-store")
+	ew := newExportWriter(w, r)
+	ew.maxRows, ew.maxBytes = len(rows), 0 // a segment is bounded by the retention
+	if err := writeClip(ew, doc, c, rows); err != nil {
+		monitoring.SubDebugf("storage", "track export callsign=%s aborted: %v", callsign, err)
+		return
+	}
+	_ = ew.Close()
+}
+
+// clipCSV renders one row per position with a header line; tracks are told apart by the
+// icao24 and callsign columns.
+type clipCSV struct{}
+
+func (clipCSV) head(*storage.Clip) string {
+	return "time,icao24,callsign,lat,lon,alt_m,speed_ms,track_deg,vrate_ms,ground,phase\r\n"
+}
+
+func (clipCSV) openTrack(*storage.Clip, *clipTrack, int, storage.Point, storage.Point) string {
+	return ""
+}
+
+func (clipCSV) row(_ *storage.Clip, r clipRow) ([]byte, error) {
+	p := r.p
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	cw.UseCRLF = true
+	_ = cw.Write([]string{
+		isoTime(p.TS), r.track.Icao24, r.track.Callsign, f(p.Lat), f(p.Lon), f(p.Alt),
+		f(p.Speed), f(p.Track), f(p.VRate), strconv.FormatBool(p.Ground), p.Phase,
+	})
+	cw.Flush()
+	return bytes.TrimSuffix(b.Bytes(), []byte("\r\n")), cw.Error()
+}
+
+func (clipCSV) sep() string        { return "\r\n" }
+func (clipCSV) closeTrack() string { return "\r\n" }
+func (clipCSV) tail() string       { return "" }
+
+// clipKML renders a KML document with one placemark per aircraft: a line at absolute altitude
+// spanning the time of its first and last position.
+type clipKML struct{}
+
+func (clipKML) head(c *storage.Clip) string {
+	name := c.Title
+	if name == "" {
+		name = "clip " + c.ID
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<kml xmlns="http://www.opengis.net/kml/2.2">` + "\n<Document>\n  <name>" + xmlText(name) + "</name>\n" +
+		"  <Style id=\"track\"><LineStyle><color>ffeb6325</color><width>3</width></LineStyle></Style>\n"
+}
+
+func (clipKML) openTrack(_ *storage.Clip, t *clipTrack, _ int, first, last storage.Point) string {
+	name := t.Icao24
+	if t.Callsign != "" {
+		name = t.Callsign + " (" + t.Icao24 + ")"
+	}
+	return "  <Placemark>\n    <name>" + xmlText(name) + "</name>\n" +
+		"    <TimeSpan><begin>" + isoTime(first.TS) + "</begin><end>" + isoTime(last.TS) + "</end></TimeSpan>\n" +
+		"    <styleUrl>#track</styleUrl>\n" +
+		"    <LineString><altitudeMode>absolute</altitudeMode><coordinates>\n      "
+}
+
+// row emits "lon,lat,alt" (meters above sea level).
+func (clipKML) row(_ *storage.Clip, r clipRow) ([]byte, error) {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []byte(f(r.p.Lon) + "," + f(r.p.Lat) + "," + f(r.p.Alt)), nil
+}
+
+func (clipKML) sep() string        { return "\n      " }
+func (clipKML) closeTrack() string { return "\n    </coordinates></LineString>\n  </Placemark>\n" }
+func (clipKML) tail() string       { return "</Document>\n</kml>\n" }
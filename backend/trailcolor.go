@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"net/http"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// Trail colors: on request (?trail_color=alt|speed) every trail point is classified server-side
+// into a small code, sent next to the trail (trail_c) and the points of /api/track (colors), so
+// clients can color trails by altitude band or speed bucket without receiving every sample's
+// altitude and speed. Code 0 is unknown, 1 on the ground; the bands follow from 2 upwards.
+
+type trailColor uint32
+
+const (
+	trailColorNone trailColor = iota
+	trailColorAlt
+	trailColorSpeed
+)
+
+// Band upper bounds, in the units of FR24-style legends (feet, knots); a point above the last
+// bound gets the highest code.
+var (
+	trailAltBandsFt   = []float64{500, 1000, 2000, 4000, 6000, 8000, 10000, 20000, 30000, 40000}
+	trailSpeedBandsKt = []float64{100, 200, 300, 400, 500}
+)
+
+const (
+	metersToFeet   = 1 / geo.Foot
+	mpsToKnots     = 1.943844
+	trailColorBase = 2 // code of the lowest band
+)
+
+// parseTrailColorName maps a trail color mode name ("" and "none" disable colors).
+func parseTrailColorName(v string) (trailColor, bool) {
+	switch v {
+	case "", "none":
+		return trailColorNone, true
+	case "alt":
+		return trailColorAlt, true
+	case "speed":
+		return trailColorSpeed, true
+	}
+	return trailColorNone, false
+}
+
+// parseTrailColor reads the optional "trail_color" query parameter.
+func parseTrailColor(r *http.Request) (trailColor, error) {
+	v := r.URL.Query().Get("trail_color")
+	c, ok := parseTrailColorName(v)
+	if !ok {
+		return trailColorNone, invalidParam("trail_color", "want none, alt or speed, got %q", v)
+	}
+	return c, nil
+}
+
+// trailColorCode classifies one sample.
+func trailColorCode(p storage.Point, c trailColor) int {
+	if c == trailColorNone {
+		return 0
+	}
+	if p.Ground || p.Phase == "landed" {
+		return 1
+	}
+	v, bands := p.Alt*metersToFeet, trailAltBandsFt
+	if c == trailColorSpeed {
+		if p.Speed <= 0 {
+			return 0
+		}
+		v, bands = p.Speed*mpsToKnots, trailSpeedBandsKt
+	}
+	for i, ub := range bands {
+		if v < ub {
+			return trailColorBase + i
+		}
+	}
+	return trailColorBase + len(bands)
+}
+
+// trailColorCodes classifies samples; nil when colors are off.
+func trailColorCodes(pts []storage.Point, c trailColor) []int {
+	if c == trailColorNone || len(pts) == 0 {
+		return nil
+	}
+	out := make([]int, len(pts))
+	for i, p := range pts {
+		out[i] = trailColorCode(p, c)
+	}
+	return out
+}
@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/maniack/miniflightradar/storage"
+)
+
+// Shared query-parameter validation. Helpers return *problem.Error values (400) whose detail
+// names the parameter and the expected form, so handlers answer with problem.WriteError.
+
+// maxCallsignLen is the longest callsign accepted (ICAO flight IDs are at most 7 characters;
+// OpenSky pads to 8).
+const maxCallsignLen = 8
+
+// bbox is a validated rectangle; longitudes and latitudes are clamped to the valid ranges.
+type bbox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+func invalidParam(name, format string, args ...any) *problem.Error {
+	return problem.New(http.StatusBadRequest, "invalid "+name+": "+fmt.Sprintf(format, args...))
+}
+
+// parseBBox parses "minLon,minLat,maxLon,maxLat". Values beyond ±180/±90 are clamped (map views
+// may extend past the antimeridian); an empty or inverted box is rejected.
+func parseBBox(s string) (bbox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox{}, invalidParam("bbox", "want minLon,minLat,maxLon,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return bbox{}, invalidParam("bbox", "coordinate %q is not a number", strings.TrimSpace(p))
+		}
+		v[i] = f
+	}
+	b := bbox{
+		MinLon: max(v[0], -180), MinLat: max(v[1], -90),
+		MaxLon: min(v[2], 180), MaxLat: min(v[3], 90),
+	}
+	if b.MaxLon <= b.MinLon || b.MaxLat <= b.MinLat {
+		return bbox{}, invalidParam("bbox", "min must be below max (minLon,minLat,maxLon,maxLat)")
+	}
+	return b, nil
+}
+
+// ParseBBox parses "minLon,minLat,maxLon,maxLat" for command-line flags, with the rules of
+// the bbox query parameter.
+func ParseBBox(s string) ([4]float64, error) {
+	b, err := parseBBox(s)
+	return [4]float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat}, err
+}
+
+// queryBBox reads ?bbox=; ok is false when it is absent and not required.
+func queryBBox(r *http.Request, required bool) (b bbox, ok bool, err error) {
+	v := strings.TrimSpace(r.URL.Query().Get("bbox"))
+	if v == "" {
+		if required {
+			return bbox{}, false, problem.New(http.StatusBadRequest, "bbox is required as minLon,minLat,maxLon,maxLat")
+		}
+		return bbox{}, false, nil
+	}
+	b, err = parseBBox(v)
+	return b, err == nil, err
+}
+
+// parseCallsign normalizes a callsign (trimmed, upper case) and checks it is 1..8 letters/digits.
+func parseCallsign(s string) (string, error) {
+	cs := normalizeCallsign(s)
+	if cs == "" {
+		return "", problem.New(http.StatusBadRequest, "callsign is required")
+	}
+	if len(cs) > maxCallsignLen || strings.IndexFunc(cs, func(c rune) bool {
+		return (c < 'A' || c > 'Z') && (c < '0' || c > '9')
+	}) >= 0 {
+		return "", invalidParam("callsign", "want 1..%d letters or digits, got %q", maxCallsignLen, cs)
+	}
+	return cs, nil
+}
+
+// queryCallsign reads the required ?callsign= parameter.
+func queryCallsign(r *http.Request) (string, error) {
+	return parseCallsign(r.URL.Query().Get("callsign"))
+}
+
+// maxFilterPattern bounds glob lists and regular expressions in flight filters.
+const maxFilterPattern = 256
+
+// flightFilter selects flights by callsign glob patterns (DLH*, EWG??, any of a comma-separated
+// list), a callsign regular expression and ICAO type designator globs (B77W, A32*). All parts
+// that are set must match.
+type flightFilter struct {
+	callsigns []string
+	re        *regexp.Regexp
+	types     []string
+}
+
+// parseGlobs splits a comma-separated list of case-insensitive glob patterns for param.
+func parseGlobs(param, list string) ([]string, error) {
+	if len(list) > maxFilterPattern {
+		return nil, invalidParam(param, "pattern list longer than %d characters", maxFilterPattern)
+	}
+	var out []string
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.ToUpper(strings.TrimSpace(g)); g == "" {
+			continue
+		}
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, invalidParam(param, "bad glob %q", g)
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+func matchGlobs(globs []string, v string) bool {
+	for _, g := range globs {
+		if m, _ := path.Match(g, v); m {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlightFilter builds a filter from callsign globs, a callsign regular expression and type
+// designator globs (all case-insensitive). It returns nil when all are empty.
+func parseFlightFilter(callsigns, re, types string) (*flightFilter, error) {
+	re = strings.TrimSpace(re)
+	f := &flightFilter{}
+	var err error
+	if f.callsigns, err = parseGlobs("callsign", callsigns); err != nil {
+		return nil, err
+	}
+	if f.types, err = parseGlobs("type", types); err != nil {
+		return nil, err
+	}
+	if len(re) > maxFilterPattern {
+		return nil, invalidParam("callsign_re", "expression longer than %d characters", maxFilterPattern)
+	}
+	if re != "" {
+		if _, err := regexp.Compile(re); err != nil {
+			return nil, invalidParam("callsign_re", "%v", err)
+		}
+		f.re = regexp.MustCompile("(?i)" + re)
+	}
+	if len(f.callsigns) == 0 && f.re == nil && len(f.types) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// queryFlightFilter reads ?callsign= (globs), ?callsign_re= and ?type= (type designator globs).
+func queryFlightFilter(r *http.Request) (*flightFilter, error) {
+	q := r.URL.Query()
+	return parseFlightFilter(q.Get("callsign"), q.Get("callsign_re"), q.Get("type"))
+}
+
+// Match reports whether a flight passes the filter; a nil filter matches everything. Type
+// designators come from the aircraft database, so without one a type filter matches nothing.
+func (f *flightFilter) Match(p storage.Point) bool {
+	if f == nil {
+		return true
+	}
+	cs := normalizeCallsign(p.Callsign)
+	if len(f.callsigns) > 0 && !matchGlobs(f.callsigns, cs) {
+		return false
+	}
+	if f.re != nil && !f.re.MatchString(cs) {
+		return false
+	}
+	return len(f.types) == 0 || matchGlobs(f.types, strings.ToUpper(p.TypeCode))
+}
+
+// parseICAO24 normalizes an ICAO 24-bit address (lower case) and checks it is 6 hex digits.
+func parseICAO24(s string) (string, error) {
+	icao := strings.ToLower(strings.TrimSpace(s))
+	if len(icao) != 6 || strings.IndexFunc(icao, func(c rune) bool {
+		return (c < '0' || c > '9') && (c < 'a' || c > 'f')
+	}) >= 0 {
+		return "", invalidParam("icao24", "want 6 hex digits, got %q", icao)
+	}
+	return icao, nil
+}
+
+// queryInt reads an optional integer parameter within lo..hi (def when absent; hi math.MaxInt
+// means unbounded).
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		if hi == math.MaxInt {
+			return 0, invalidParam(name, "want an integer >= %d, got %q", lo, v)
+		}
+		return 0, invalidParam(name, "want an integer in %d..%d, got %q", lo, hi, v)
+	}
+	return n, nil
+}
+
+// queryUnix reads an optional unix timestamp (seconds) parameter (def when absent).
+func queryUnix(r *http.Request, name string, def int64) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, invalidParam(name, "want unix seconds, got %q", v)
+	}
+	return n, nil
+}
+
+// queryTimeRange reads ?from=&to= as unix seconds; by default the range is the last span.
+func queryTimeRange(r *http.Request, span time.Duration) (from, to int64, err error) {
+	if to, err = queryUnix(r, "to", time.Now().Unix()); err != nil {
+		return 0, 0, err
+	}
+	if from, err = queryUnix(r, "from", to-int64(span/time.Second)); err != nil {
+		return 0, 0, err
+	}
+	if to < from {
+		return 0, 0, problem.New(http.StatusBadRequest, "from must not be after to")
+	}
+	return from, to, nil
+}
+
+// parseDayRange parses from/to (YYYY-MM-DD, inclusive) with a default span of days ending today.
+func parseDayRange(r *http.Request, days int) (time.Time, time.Time, error) {
+	const layout = "2006-01-02"
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	from := now.Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	to := now
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			return from, to, invalidParam("from", "want YYYY-MM-DD, got %q", v)
+		}
+		from = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			return from, to, invalidParam("to", "want YYYY-MM-DD, got %q", v)
+		}
+		to = t.Add(24*time.Hour - time.Second)
+	}
+	if to.Before(from) {
+		return from, to, problem.New(http.StatusBadRequest, "from must not be after to")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Start-up warm-up: storage.Open rebuilds the current state from history, but it may be stale
+// until the first poll. Until that poll completes, /ws/flights clients get a warming_up status
+// instead of a snapshot that the next diff would largely replace.
+var (
+	warmMu    sync.Mutex
+	warmC     chan struct{} // closed when warm-up ends; nil when no warm-up is pending
+	warmStart time.Time
+)
+
+// StartWarmup holds back WebSocket snapshots until the first ingest attempt completes (also
+// when it fails) or timeout passes. A timeout <= 0 disables the gate.
+func StartWarmup(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	warmMu.Lock()
+	warmC = make(chan struct{})
+	warmStart = time.Now()
+	warmMu.Unlock()
+	time.AfterFunc(timeout, func() { endWarmup("timeout") })
+}
+
+// endWarmup releases waiting clients; reason is logged the first time.
+func endWarmup(reason string) {
+	warmMu.Lock()
+	c := warmC
+	warmC = nil
+	warmMu.Unlock()
+	if c != nil {
+		close(c)
+		log.Printf("warm-up complete (%s) after %s", reason, time.Since(warmStart).Round(time.Millisecond))
+	}
+}
+
+// warmingUp returns a channel that is closed when warm-up ends, or nil when it is over.
+func warmingUp() <-chan struct{} {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+	return warmC
+}
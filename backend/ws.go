@@ -2,8 +2,6 @@ package backend
 
 import (
 	"bufio"
-	"bytes"
-	"compress/flate"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
@@ -15,9 +13,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/maniack/miniflightradar/chaos"
 	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
 	"github.com/maniack/miniflightradar/security"
 	"github.com/maniack/miniflightradar/storage"
 	"go.opentelemetry.io/otel"
@@ -30,26 +31,76 @@ const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 type wsConn struct {
 	c       net.Conn
 	buf     *bufio.ReadWriter
-	deflate bool
+	deflate *wsDeflate // permessage-deflate state, nil when not negotiated
 	mu      sync.Mutex
+
+	// fragmented client message being reassembled (read side only)
+	fragOp   byte
+	fragRSV1 bool
+	frag     []byte
+
+	// backpressure state read by the load-shedding monitor
+	inflightSince atomic.Int64 // unix nanos of the unacknowledged diff (0 = none)
+	bufferHigh    atomic.Bool
+
+	kind      string // handler label for closure metrics ("flights", "flight")
+	remote    string
+	since     time.Time
+	queue     *wsSendQueue // outbound diffs of /ws/flights, nil for other handlers
+	closeOnce sync.Once
+
+	usage wsUsage // bandwidth quota accounting (see wsquota.go), guarded by mu
 }
 
+// wsWriteTimeout bounds a single frame write; slow or dead peers end with cause write_timeout.
+const wsWriteTimeout = 10 * time.Second
+
+// wsAckTimeout evicts clients that leave a diff unacknowledged this long.
+const wsAckTimeout = 2 * time.Minute
+
 func (w *wsConn) Close() error { return w.c.Close() }
 
-func (w *wsConn) WriteText(b []byte) error {
+// recordClose counts the termination cause of this connection once.
+func (w *wsConn) recordClose(cause string) {
+	w.closeOnce.Do(func() {
+		monitoring.WSClosures.WithLabelValues(w.kind, cause).Inc()
+		monitoring.SubDebugf("ws", "%s closed cause=%s", w.kind, cause)
+	})
+}
+
+// closeCause classifies an error that ended a WS connection.
+func closeCause(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, errWSQuota):
+		return "quota_exceeded"
+	case errors.As(err, &ne) && ne.Timeout():
+		return "write_timeout"
+	case errors.As(err, &ne), errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		return "write_error"
+	}
+	return "server_error"
+}
+
+func (w *wsConn) WriteText(b []byte) error { return w.writeData(0x1, b) }
+
+// WriteBinary sends a binary frame (opcode 2).
+func (w *wsConn) WriteBinary(b []byte) error { return w.writeData(0x2, b) }
+
+func (w *wsConn) writeData(opcode byte, b []byte) error {
+	if err := chaos.WSWrite(); err != nil {
+		return err
+	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	// Optionally compress payload with permessage-deflate if negotiated
+	_ = w.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	// Compress with permessage-deflate if negotiated
 	payload := b
-	first := byte(0x81)            // FIN=1, RSV1=0, opcode=1 (text)
-	if w.deflate && len(b) >= 64 { // compress only if non-trivial size
-		var buf bytes.Buffer
-		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
-		if err == nil {
-			_, _ = fw.Write(b)
-			_ = fw.Close()
-			payload = buf.Bytes()
-			first = 0xC1 // FIN=1, RSV1=1, opcode=1
+	first := 0x80 | opcode // FIN=1, RSV1=0
+	if w.deflate != nil && len(b) >= wsDeflateMin {
+		if z, err := w.deflate.compress(b); err == nil {
+			payload = z
+			first = 0xC0 | opcode // FIN=1, RSV1=1
 		}
 	}
 	// Frame header with optional extended length
@@ -72,12 +123,32 @@ func (w *wsConn) WriteText(b []byte) error {
 	if _, err := w.buf.Write(payload); err != nil {
 		return err
 	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	w.account(len(header) + len(payload))
+	return nil
+}
+
+// WriteClose sends a close frame with a status code and a short reason.
+func (w *wsConn) WriteClose(code int, reason string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	p := append([]byte{byte(code >> 8), byte(code)}, reason[:min(len(reason), 123)]...)
+	if _, err := w.buf.Write([]byte{0x88, byte(len(p))}); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(p); err != nil {
+		return err
+	}
 	return w.buf.Flush()
 }
 
 func (w *wsConn) WritePing() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	_ = w.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 	// small ping payload
 	p := []byte("p")
 	h := []byte{0x89, byte(len(p))}
@@ -93,6 +164,7 @@ func (w *wsConn) WritePing() error {
 func (w *wsConn) WritePong(p []byte) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	_ = w.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 	if p == nil {
 		p = []byte{}
 	}
@@ -109,21 +181,72 @@ func (w *wsConn) WritePong(p []byte) error {
 	return w.buf.Flush()
 }
 
-// ReadFrame reads a single frame from client (masked as per RFC6455)
-// Returns opcode and unmasked payload
+// ReadFrame reads the next message from the client (frames are masked as per RFC 6455):
+// control frames as they come, data messages once all their fragments have arrived,
+// inflated when they were compressed. Returns opcode and unmasked payload.
 func (w *wsConn) ReadFrame() (byte, []byte, error) {
+	for {
+		fin, rsv1, opcode, payload, err := w.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		if opcode >= 0x8 {
+			// Control frames must not be fragmented or compressed; they may arrive between
+			// the fragments of a message
+			if !fin || rsv1 || len(payload) > 125 {
+				return 0, nil, errors.New("invalid control frame")
+			}
+			return opcode, payload, nil
+		}
+		switch {
+		case opcode == 0x0 && w.frag == nil:
+			return 0, nil, errors.New("continuation frame without a message")
+		case opcode != 0x0 && w.frag != nil:
+			return 0, nil, errors.New("new message before the last fragment")
+		case opcode != 0x0:
+			w.fragOp, w.fragRSV1, w.frag = opcode, rsv1, []byte{}
+		case rsv1:
+			return 0, nil, errors.New("RSV1 set on a continuation frame")
+		}
+		if len(w.frag)+len(payload) > wsMaxMessage {
+			return 0, nil, errWSMessageTooBig
+		}
+		w.frag = append(w.frag, payload...)
+		if !fin {
+			continue
+		}
+		opcode, rsv1, payload = w.fragOp, w.fragRSV1, w.frag
+		w.frag = nil
+		// If RSV1 set and permessage-deflate negotiated, decompress payload
+		if rsv1 {
+			if w.deflate == nil {
+				return 0, nil, errors.New("compressed frame received without negotiation")
+			}
+			if payload, err = w.deflate.decompress(payload); err != nil {
+				return 0, nil, err
+			}
+		}
+		return opcode, payload, nil
+	}
+}
+
+// readFrame reads a single frame.
+func (w *wsConn) readFrame() (fin, rsv1 bool, opcode byte, payload []byte, err error) {
 	// Read first two bytes
 	h := make([]byte, 2)
 	if _, err := io.ReadFull(w.buf, h); err != nil {
-		return 0, nil, err
+		return false, false, 0, nil, err
+	}
+	fin = (h[0] & 0x80) != 0
+	rsv1 = (h[0] & 0x40) != 0
+	opcode = h[0] & 0x0F
+	if h[0]&0x30 != 0 {
+		return false, false, 0, nil, errors.New("reserved bits RSV2/RSV3 set")
 	}
-	fin := (h[0] & 0x80) != 0
-	rsv1 := (h[0] & 0x40) != 0
-	opcode := h[0] & 0x0F
 	mask := (h[1] & 0x80) != 0
 	if !mask {
 		// client frames must be masked
-		return 0, nil, errors.New("client frame not masked")
+		return false, false, 0, nil, errors.New("client frame not masked")
 	}
 	length := int(h[1] & 0x7F)
 	switch length {
@@ -131,49 +254,37 @@ func (w *wsConn) ReadFrame() (byte, []byte, error) {
 		// 16-bit length
 		b := make([]byte, 2)
 		if _, err := io.ReadFull(w.buf, b); err != nil {
-			return 0, nil, err
+			return false, false, 0, nil, err
 		}
 		length = int(b[0])<<8 | int(b[1])
 	case 127:
 		b := make([]byte, 8)
 		if _, err := io.ReadFull(w.buf, b); err != nil {
-			return 0, nil, err
+			return false, false, 0, nil, err
+		}
+		if b[0]|b[1]|b[2]|b[3] != 0 || b[4]&0x80 != 0 {
+			return false, false, 0, nil, errWSMessageTooBig
 		}
-		// we only support up to 2^31-1
 		length = int(b[4])<<24 | int(b[5])<<16 | int(b[6])<<8 | int(b[7])
 	}
+	if length > wsMaxMessage {
+		return false, false, 0, nil, errWSMessageTooBig
+	}
 	// Masking key
 	key := make([]byte, 4)
 	if _, err := io.ReadFull(w.buf, key); err != nil {
-		return 0, nil, err
+		return false, false, 0, nil, err
 	}
-	payload := make([]byte, length)
+	payload = make([]byte, length)
 	if length > 0 {
 		if _, err := io.ReadFull(w.buf, payload); err != nil {
-			return 0, nil, err
+			return false, false, 0, nil, err
 		}
 		for i := 0; i < length; i++ {
 			payload[i] ^= key[i%4]
 		}
 	}
-	// Control frames must not be fragmented; data frames could be fragmented but we do not support fragmentation in this minimal impl
-	if !fin {
-		return 0, nil, errors.New("fragmented frames not supported")
-	}
-	// If RSV1 set and permessage-deflate negotiated, decompress payload
-	if rsv1 {
-		if !w.deflate {
-			return 0, nil, errors.New("compressed frame received without negotiation")
-		}
-		fr := flate.NewReader(bytes.NewReader(payload))
-		dec, err := io.ReadAll(fr)
-		_ = fr.Close()
-		if err != nil {
-			return 0, nil, err
-		}
-		payload = dec
-	}
-	return opcode, payload, nil
+	return fin, rsv1, opcode, payload, nil
 }
 
 func tokenListContains(headerVal, token string) bool {
@@ -189,29 +300,6 @@ func tokenListContains(headerVal, token string) bool {
 	return false
 }
 
-// hasExtension reports whether Sec-WebSocket-Extensions contains the named
-// extension, ignoring any parameters (e.g., "permessage-deflate; client_max_window_bits").
-func hasExtension(headerVal, name string) bool {
-	if headerVal == "" {
-		return false
-	}
-	name = strings.ToLower(strings.TrimSpace(name))
-	for _, part := range strings.Split(headerVal, ",") {
-		p := strings.ToLower(strings.TrimSpace(part))
-		if p == "" {
-			continue
-		}
-		base := p
-		if i := strings.IndexByte(p, ';'); i >= 0 {
-			base = strings.TrimSpace(p[:i])
-		}
-		if base == name {
-			return true
-		}
-	}
-	return false
-}
-
 func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
 	if !tokenListContains(r.Header.Get("Connection"), "upgrade") || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
 		return nil, fmt.Errorf("not a websocket upgrade")
@@ -233,10 +321,13 @@ func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error)
 		return nil, err
 	}
 
-	// Write handshake response
-	// Temporarily disable permessage-deflate negotiation until full client decompression is robust
+	// Write handshake response, accepting permessage-deflate when offered (see wsdeflate.go)
+	ws := &wsConn{c: conn, buf: rw, remote: r.RemoteAddr, since: time.Now()}
 	extLine := ""
-	negDeflate := false
+	if params, ext, ok := negotiateDeflate(r.Header.Get("Sec-WebSocket-Extensions")); ok {
+		ws.deflate = &wsDeflate{params: params}
+		extLine = "Sec-WebSocket-Extensions: " + ext + "\r\n"
+	}
 	resp := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n%s\r\n", accept, extLine)
 	if _, err := rw.WriteString(resp); err != nil {
 		_ = conn.Close()
@@ -246,115 +337,250 @@ func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error)
 		_ = conn.Close()
 		return nil, err
 	}
-	return &wsConn{c: conn, buf: rw, deflate: negDeflate}, nil
+	return ws, nil
 }
 
 // FlightsWSHandler streams diffs of flights. It sends initial snapshot and then only changes
 // upon new ingests from OpenSky. Implements simple backpressure: waits for client ACK before
 // sending next diff and skips while client reports bufferedAmount > 1MB.
 func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
-	// Security check: require valid JWT cookie and CSRF token matching query param
-	if !security.ValidateJWTFromRequest(r) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	// Security check: require valid JWT cookie and CSRF token matching query param,
+	// unless the stream is configured as public (security.public) or an API key was presented
+	public := security.IsPublic(r) || security.HasAPIKey(r)
+	if !public && !security.ValidateJWTFromRequest(r) {
+		monitoring.WSClosures.WithLabelValues("flights", "auth_failure").Inc()
+		problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	csrfQ := r.URL.Query().Get("csrf")
 	csrfC := security.GetCSRFFromRequest(r)
-	if csrfQ == "" || csrfQ != csrfC {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	if !public && (csrfQ == "" || csrfQ != csrfC) {
+		monitoring.WSClosures.WithLabelValues("flights", "auth_failure").Inc()
+		problem.Write(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	// Optional coordinate rounding and delta-encoded trails (bandwidth savings)
+	precision, err := parsePrecision(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	// Trail points with timestamps and altitudes (?trail=full); also switchable by the
+	// "trail" field of viewport and subscribe messages
+	full, err := parseTrailMode(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	var trailFull atomic.Bool
+	trailFull.Store(full)
+	// Trail color codes (?trail_color=alt|speed); also switchable by the "trail_color" field of
+	// viewport and subscribe messages
+	color, err := parseTrailColor(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	var trailColorMode atomic.Uint32
+	trailColorMode.Store(uint32(color))
+	// Payload encoding: "json" (objects), "compact" (fixed-order arrays) or "pb" (protobuf binary
+	// frames, ?enc=pb); also negotiable via hello
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = r.URL.Query().Get("enc")
+	}
+	if encoding == "" {
+		encoding = encodingJSON
+	}
+	if !validEncoding(encoding) {
+		problem.Write(w, r, http.StatusBadRequest, "invalid encoding (json|compact|pb)")
+		return
+	}
+	initialBBox, hasInitialBBox, err := queryBBox(r, false)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	// Optional flight filter (?callsign=DLH*,EWG*&callsign_re=...&type=A38*), replaceable via "filter"
+	initialFilter, err := queryFlightFilter(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	var flFilter atomic.Pointer[flightFilter]
+	flFilter.Store(initialFilter)
+	if rejectIfShedding(w, r) {
+		monitoring.WSClosures.WithLabelValues("flights", "overload_rejected").Inc()
 		return
 	}
 
 	ws, err := upgradeToWebSocket(w, r)
 	if err != nil {
-		monitoring.Debugf("ws upgrade error: %v", err)
+		monitoring.SubDebugf("ws", "upgrade error: %v", err)
 		return
 	}
+	ws.kind = "flights"
+	// send initial snapshot immediately (filtered by ?bbox when given)
+	queue := newSendQueue(ws)
+	// cause classifies why the connection ended (miniflightradar_ws_closures_total)
+	cause := "panic"
+	// Panics after the upgrade end this connection only and are recorded as crashes
+	defer monitoring.Recover("ws.flights")
 	registerWS(ws)
 	defer func() {
 		unregisterWS(ws)
+		queue.close()
+		ws.recordClose(cause)
 		_ = ws.Close()
 	}()
-	monitoring.Debugf("ws flights connected remote=%s deflate=%t", r.RemoteAddr, ws.deflate)
+	monitoring.SubDebugf("ws", "flights connected remote=%s deflate=%t", r.RemoteAddr, ws.deflate != nil)
 
 	// Telemetry: track latest viewport bbox reported by the client (if any)
 	baseCtx := r.Context()
 	tracer := otel.Tracer("backend/ws")
 	var bboxMu sync.RWMutex
 	var lastBBox string
-	var bboxVals [4]float64 // minLon, minLat, maxLon, maxLat
+	var bboxVals bbox
 	var hasBBox bool
 
-	parseBBox := func(s string) (float64, float64, float64, float64, bool) {
-		parts := strings.Split(s, ",")
-		if len(parts) != 4 {
-			return 0, 0, 0, 0, false
-		}
-		minLon, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
-		minLat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
-		maxLon, err3 := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
-		maxLat, err4 := strconv.ParseFloat(strings.TrimSpace(parts[3]), 64)
-		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
-			return 0, 0, 0, 0, false
-		}
-		if minLon < -180 || maxLon > 180 || minLat < -90 || maxLat > 90 {
-			return 0, 0, 0, 0, false
-		}
-		if maxLon <= minLon || maxLat <= minLat {
-			return 0, 0, 0, 0, false
-		}
-		return minLon, minLat, maxLon, maxLat, true
+	// Optional initial viewport (?bbox=minLon,minLat,maxLon,maxLat) so the first snapshot is
+	// already filtered; later "viewport" messages replace it.
+	if hasInitialBBox {
+		lastBBox = strings.TrimSpace(r.URL.Query().Get("bbox"))
+		bboxVals = initialBBox
+		hasBBox = true
 	}
-
-	// message formats
-	type trailPoint struct {
-		Lon float64 `json:"lon"`
-		Lat float64 `json:"lat"`
-		// TS omitted to keep payload small; add if needed later
+	// inView reports whether a point lies within the client viewport (see inViewport)
+	inView := func(lon, lat float64, known bool) bool {
+		bboxMu.RLock()
+		b, ok := bboxVals, hasBBox
+		bboxMu.RUnlock()
+		return !ok || inViewport(b, lon, lat, known)
 	}
-	type item struct {
-		Icao24   string       `json:"icao24"`
-		Callsign string       `json:"callsign"`
-		Lon      float64      `json:"lon"`
-		Lat      float64      `json:"lat"`
-		Alt      float64      `json:"alt,omitempty"`
-		Track    float64      `json:"track,omitempty"`
-		Speed    float64      `json:"speed,omitempty"`
-		TS       int64        `json:"ts"`
-		Trail    []trailPoint `json:"trail,omitempty"`
+
+	type helloMsg struct {
+		Encoding  string
+		Precision int
 	}
-	type diffMsg struct {
-		Type   string   `json:"type"`
-		Seq    int64    `json:"seq"`
-		Upsert []item   `json:"upsert,omitempty"`
-		Delete []string `json:"delete,omitempty"`
+	// subMsg (un)subscribes the full track of a callsign
+	type subMsg struct {
+		Callsign string
+		On       bool
 	}
-	type ackMsg struct {
-		Type     string `json:"type"`
-		Seq      int64  `json:"seq"`
-		Buffered int64  `json:"buffered,omitempty"`
+
+	// Priority lane: watched aircraft (watchlist entries, selected flight) are sent as soon as
+	// they change, bypassing ACK pacing; everything else is batched in diffs.
+	const maxWatched = 50
+	var watchMu sync.Mutex
+	watchICAO := map[string]bool{}
+	watchCS := map[string]bool{}
+	// parseList keeps the valid entries (normalized by parse) of a JSON string list.
+	parseList := func(v any, parse func(string) (string, error)) map[string]bool {
+		out := map[string]bool{}
+		list, _ := v.([]any)
+		for _, e := range list {
+			str, _ := e.(string)
+			if len(out) >= maxWatched {
+				break
+			}
+			if id, err := parse(str); err == nil {
+				out[id] = true
+			}
+		}
+		return out
 	}
 
 	// reader loop: handle ping/pong/close and ACKs
-	ackCh := make(chan ackMsg, 4)
+	ackCh := make(chan wsAck, 4)
+	helloCh := make(chan helloMsg, 1)
+	subCh := make(chan subMsg, 4)
+	viewportCh := make(chan struct{}, 1) // viewport or filter changed: send a diff right away
 	done := make(chan struct{})
+	readCause := "panic" // visible to the main loop after done is closed
+	// onAck and onViewport handle acks and viewports of both encodings
+	onAck := func(seq, buf int64) {
+		if seq <= 0 {
+			return
+		}
+		monitoring.SubDebugf("ws", "flights <= ack seq=%d buffered=%d", seq, buf)
+		select {
+		case ackCh <- wsAck{Type: "ack", Seq: seq, Buffered: buf}:
+		default:
+		}
+	}
+	onViewport := func(bboxStr string) {
+		if bboxStr == "" {
+			monitoring.SubDebugf("ws", "flights <= viewport missing bbox")
+			return
+		}
+		b, err := parseBBox(bboxStr)
+		if err != nil {
+			monitoring.SubDebugf("ws", "flights <= viewport %v", err)
+			return
+		}
+		minLon, minLat, maxLon, maxLat := b.MinLon, b.MinLat, b.MaxLon, b.MaxLat
+		bboxMu.Lock()
+		lastBBox = bboxStr
+		bboxVals = b
+		hasBBox = true
+		bboxMu.Unlock()
+		select {
+		case viewportCh <- struct{}{}:
+		default:
+		}
+		// Telemetry span for viewport updates
+		ctx, sp := tracer.Start(baseCtx, "ws.viewport")
+		_ = ctx
+		sp.SetAttributes(
+			attribute.String("viewport.bbox", bboxStr),
+			attribute.Float64("viewport.min_lon", minLon),
+			attribute.Float64("viewport.min_lat", minLat),
+			attribute.Float64("viewport.max_lon", maxLon),
+			attribute.Float64("viewport.max_lat", maxLat),
+			attribute.Float64("viewport.width_deg", maxLon-minLon),
+			attribute.Float64("viewport.height_deg", maxLat-minLat),
+			attribute.Float64("viewport.area_deg2", (maxLon-minLon)*(maxLat-minLat)),
+		)
+		sp.End()
+		monitoring.SubDebugf("ws", "flights <= viewport bbox=%s", bboxStr)
+	}
+	// onTrailMode applies the "trail" capability of a viewport or subscribe message; the new
+	// mode applies to trails sent from then on
+	onTrailMode := func(v any) {
+		switch v {
+		case "full":
+			trailFull.Store(true)
+		case "short":
+			trailFull.Store(false)
+		}
+	}
+	onTrailColor := func(v any) {
+		if name, ok := v.(string); ok {
+			if c, ok := parseTrailColorName(name); ok {
+				trailColorMode.Store(uint32(c))
+			}
+		}
+	}
 	go func() {
 		defer close(done)
+		defer monitoring.Recover("ws.reader")
 		for {
 			op, payload, err := ws.ReadFrame()
 			if err != nil {
-				monitoring.Debugf("ws flights read error: %v", err)
+				monitoring.SubDebugf("ws", "flights read error: %v", err)
+				readCause = "read_error"
 				return
 			}
 			switch op := op; op {
 			case 0x9: // ping
-				monitoring.Debugf("ws flights <= ping len=%d", len(payload))
+				monitoring.SubDebugf("ws", "flights <= ping len=%d", len(payload))
 				_ = ws.WritePong(payload)
 			case 0xA: // pong
-				monitoring.Debugf("ws flights <= pong len=%d", len(payload))
+				monitoring.SubDebugf("ws", "flights <= pong len=%d", len(payload))
 				// ignore
 			case 0x8: // close
-				monitoring.Debugf("ws flights <= close")
+				monitoring.SubDebugf("ws", "flights <= close")
+				readCause = "client_close"
 				return
 			case 0x1: // text
 				// Handle ACK and VIEWPORT messages
@@ -385,49 +611,74 @@ func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
 								}
 							}
 						}
-						if seq > 0 {
-							monitoring.Debugf("ws flights <= ack seq=%d buffered=%d", seq, buf)
-							select {
-							case ackCh <- ackMsg{Type: "ack", Seq: seq, Buffered: buf}:
-							default:
-							}
+						onAck(seq, buf)
+					case "hello":
+						enc, _ := any["encoding"].(string)
+						prec := -1 // absent: keep current precision
+						if v, ok := any["precision"].(float64); ok {
+							prec = int(v)
 						}
+						monitoring.SubDebugf("ws", "flights <= hello encoding=%s precision=%d", enc, prec)
+						select {
+						case helloCh <- helloMsg{Encoding: enc, Precision: prec}:
+						default:
+						}
+					case "watch":
+						icaos, css := parseList(any["icao24"], parseICAO24), parseList(any["callsign"], parseCallsign)
+						watchMu.Lock()
+						watchICAO, watchCS = icaos, css
+						watchMu.Unlock()
+						monitoring.SubDebugf("ws", "flights <= watch icao24=%d callsign=%d", len(icaos), len(css))
 					case "viewport":
-						bboxStr := strings.TrimSpace(fmt.Sprint(any["bbox"]))
-						if bboxStr != "" {
-							minLon, minLat, maxLon, maxLat, ok := parseBBox(bboxStr)
-							if ok {
-								bboxMu.Lock()
-								lastBBox = bboxStr
-								bboxVals = [4]float64{minLon, minLat, maxLon, maxLat}
-								hasBBox = true
-								bboxMu.Unlock()
-								// Telemetry span for viewport updates
-								ctx, sp := tracer.Start(baseCtx, "ws.viewport")
-								_ = ctx
-								sp.SetAttributes(
-									attribute.String("viewport.bbox", bboxStr),
-									attribute.Float64("viewport.min_lon", minLon),
-									attribute.Float64("viewport.min_lat", minLat),
-									attribute.Float64("viewport.max_lon", maxLon),
-									attribute.Float64("viewport.max_lat", maxLat),
-									attribute.Float64("viewport.width_deg", maxLon-minLon),
-									attribute.Float64("viewport.height_deg", maxLat-minLat),
-									attribute.Float64("viewport.area_deg2", (maxLon-minLon)*(maxLat-minLat)),
-								)
-								sp.End()
-								monitoring.Debugf("ws flights <= viewport bbox=%s", bboxStr)
-							} else {
-								monitoring.Debugf("ws flights <= viewport invalid bbox=%s", bboxStr)
-							}
-						} else {
-							monitoring.Debugf("ws flights <= viewport missing bbox")
+						onTrailMode(any["trail"])
+						onTrailColor(any["trail_color"])
+						onViewport(strings.TrimSpace(fmt.Sprint(any["bbox"])))
+					case "subscribe", "unsubscribe":
+						onTrailMode(any["trail"])
+						onTrailColor(any["trail_color"])
+						raw, _ := any["callsign"].(string)
+						cs, err := parseCallsign(raw)
+						if err != nil {
+							monitoring.SubDebugf("ws", "flights <= %s %v", typ, err)
+							break
+						}
+						monitoring.SubDebugf("ws", "flights <= %s callsign=%s", typ, cs)
+						select {
+						case subCh <- subMsg{Callsign: cs, On: typ == "subscribe"}:
+						default:
+						}
+					case "filter":
+						globs, _ := any["callsign"].(string)
+						re, _ := any["callsign_re"].(string)
+						types, _ := any["typecode"].(string) // "type" is the message type
+						f, err := parseFlightFilter(globs, re, types)
+						if err != nil {
+							monitoring.SubDebugf("ws", "flights <= filter %v", err)
+							break
+						}
+						flFilter.Store(f)
+						monitoring.SubDebugf("ws", "flights <= filter callsign=%q callsign_re=%q typecode=%q", globs, re, types)
+						select {
+						case viewportCh <- struct{}{}:
+						default:
 						}
 					default:
-						monitoring.Debugf("ws flights <= text type=%s len=%d", typ, len(payload))
+						monitoring.SubDebugf("ws", "flights <= text type=%s len=%d", typ, len(payload))
 					}
 				} else {
-					monitoring.Debugf("ws flights <= text len=%d", len(payload))
+					monitoring.SubDebugf("ws", "flights <= text len=%d", len(payload))
+				}
+			case 0x2: // binary: protobuf Frame with an ack or a viewport
+				f, err := pbDecodeFrame(payload)
+				if err != nil {
+					monitoring.SubDebugf("ws", "flights <= binary %v", err)
+					break
+				}
+				if f.Ack != nil {
+					onAck(f.Ack.Seq, f.Ack.Buffered)
+				}
+				if vp := f.Viewport; vp != nil {
+					onViewport(fmt.Sprintf("%g,%g,%g,%g", vp.MinLon, vp.MinLat, vp.MaxLon, vp.MaxLat))
 				}
 			default:
 				// ignore others
@@ -435,45 +686,45 @@ func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// helpers to take current snapshot and build diff against previous
-	makeCur := func() (map[string]item, []item, error) {
-		pts, err := storage.Get().CurrentAll()
-		if err != nil {
-			return nil, nil, err
+	// send writes an encoded message: binary frames for protobuf, text frames otherwise
+	send := func(b []byte) error {
+		if encoding == encodingPB {
+			return ws.WriteBinary(b)
 		}
-		curMap := make(map[string]item, len(pts))
-		arr := make([]item, 0, len(pts))
-		for _, p := range pts {
-			it := item{Icao24: p.Icao24, Callsign: p.Callsign, Lon: p.Lon, Lat: p.Lat, Alt: p.Alt, Track: p.Track, Speed: p.Speed, TS: p.TS}
-			key := p.Icao24
-			if key == "" {
-				key = strings.TrimSpace(strings.ToUpper(p.Callsign))
-			}
-			if key == "" {
-				continue
-			}
-			curMap[key] = it
-			arr = append(arr, it)
-		}
-		return curMap, arr, nil
+		return ws.WriteText(b)
 	}
-	changed := func(a, b item) bool {
-		if a.Lon != b.Lon || a.Lat != b.Lat || a.Alt != b.Alt || a.Track != b.Track || a.Speed != b.Speed || a.TS != b.TS || a.Callsign != b.Callsign {
-			return true
+	encode := func(m wsDiff) []byte { return encodeDiff(m, encoding) }
+
+	// makeCur takes the current state: flights in the viewport matching the filter, and watched ones
+	makeCur := func(last map[string]wsItem) (map[string]wsItem, []wsItem, error) {
+		watchMu.Lock()
+		wICAO, wCS := watchICAO, watchCS
+		watchMu.Unlock()
+		filter := flFilter.Load()
+		var view *bbox
+		bboxMu.RLock()
+		if hasBBox {
+			b := bboxVals
+			view = &b
 		}
-		return false
+		bboxMu.RUnlock()
+		pts, err := candidates(view, wICAO, wCS)
+		if err != nil {
+			return nil, nil, err
+		}
+		cur, arr := currentItems(pts, last, func(p storage.Point, known bool) bool {
+			// Outside the viewport or flight filter only watched aircraft are kept; the rest
+			// fall out of cur and are removed client-side by the regular diff (out_of_view).
+			watched := wICAO[p.Icao24] || wCS[strings.TrimSpace(strings.ToUpper(p.Callsign))]
+			return watched || (inView(p.Lon, p.Lat, known) && filter.Match(p))
+		}, precision)
+		return cur, arr, nil
 	}
 
-	last := make(map[string]item)
-	var seq int64
-	inflight := false
-	bufferHigh := false
-	pending := true // send initial snapshot immediately (no server-side bbox)
 	lastSend := time.Now()
 
-	// trail limits
-	trailLimit := 24
-	trailWindow := 45 * time.Minute
+	// after a clock jump (see StartClockWatch) the client state is replaced by a fresh snapshot
+	resyncSeen := wsResync.Load()
 
 	// subscribe to updates
 	updates, unsubscribe := UpdatesSubscribe()
@@ -483,100 +734,92 @@ func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
 	ping := time.NewTicker(30 * time.Second)
 	defer ping.Stop()
 
+	// During start-up warm-up the client is told to wait instead of receiving a stale snapshot
+	// followed by a large diff once the first poll lands
+	warmC := warmingUp()
+	if warmC != nil {
+		b, _ := json.Marshal(map[string]any{"type": "status", "status": "warming_up", "ts": time.Now().Unix()})
+		if err := ws.WriteText(b); err != nil {
+			cause = closeCause(err)
+			return
+		}
+		monitoring.SubDebugf("ws", "flights => warming_up")
+	}
+
+	// bandwidth quota: throttled once over it, disconnected after repeated minutes over it
+	quotaNotified := false
 	// attempt sending if conditions permit
 	trySend := func() error {
-		if inflight || bufferHigh || !pending {
+		if !queue.ready() || warmC != nil {
 			return nil
 		}
+		throttled, kick := ws.quotaState()
+		if kick {
+			monitoring.WSQuotaEvents.WithLabelValues("disconnected").Inc()
+			monitoring.SubDebugf("ws", "flights disconnecting client over bandwidth quota remote=%s", r.RemoteAddr)
+			_ = ws.WriteClose(wsCloseQuota, "bandwidth quota exceeded")
+			return errWSQuota
+		}
+		if throttled && !quotaNotified {
+			// Tell the client once, so it can narrow the view or turn trails off
+			b, _ := json.Marshal(map[string]any{"type": "status", "status": "throttled", "reason": "bandwidth_quota", "limit": wsQuotaBytes, "ts": time.Now().Unix()})
+			if err := ws.WriteText(b); err != nil {
+				return err
+			}
+			monitoring.WSQuotaEvents.WithLabelValues("throttled").Inc()
+		}
+		quotaNotified = throttled
+		// while shedding, diffs are spaced by shedCfg.DiffInterval; delayC fires the deferred send
+		if (Shedding() || throttled) && len(queue.last) > 0 {
+			if wait := shedCfg.DiffInterval - time.Since(queue.lastDiff); wait > 0 {
+				if queue.delayC == nil {
+					if throttled {
+						monitoring.WSQuotaEvents.WithLabelValues("diff_delayed").Inc()
+					} else {
+						monitoring.ShedEvents.WithLabelValues("diff_delayed").Inc()
+					}
+					queue.delayC = time.After(wait)
+				}
+				return nil
+			}
+		}
 		// Start a span for this diff send
 		_, sp := tracer.Start(baseCtx, "ws.diff.send")
 		defer sp.End()
-		cur, arr, err := makeCur()
+		cur, arr, err := makeCur(queue.last)
 		if err != nil {
 			sp.SetAttributes(attribute.String("error", err.Error()))
 			return err
 		}
-		// build diff
-		up := make([]item, 0, len(arr))
-		dl := make([]string, 0)
-		if len(last) == 0 {
-			up = arr // initial snapshot
-		} else {
-			for k, v := range cur {
-				if ov, ok := last[k]; !ok || changed(ov, v) {
-					up = append(up, v)
-				}
-			}
-			for k := range last {
-				if _, ok := cur[k]; !ok {
-					dl = append(dl, k)
-				}
-			}
-		}
-		if len(up) == 0 && len(dl) == 0 {
-			pending = false
-			last = cur
+		// Updates queued since the last diff are coalesced into this one
+		up, dl, out := diffItems(queue.last, cur, arr)
+		if len(up) == 0 && len(dl) == 0 && len(out) == 0 {
+			queue.unchanged(cur)
 			sp.SetAttributes(
 				attribute.Int("diff.up_count", 0),
 				attribute.Int("diff.del_count", 0),
 			)
 			return nil
 		}
+		updates := queue.queued
 		// Attach short trails for upserted flights to restore UX while keeping payload small.
+		// Trails are dropped while shedding load.
 		trailTotal := 0
-		for i := range up {
-			icao := strings.TrimSpace(up[i].Icao24)
-			if icao == "" {
-				continue
-			}
-			pts, err := storage.Get().RecentTrackByICAO(icao, trailLimit, trailWindow)
-			if err != nil || len(pts) == 0 {
-				continue
-			}
-			tr := make([]trailPoint, 0, len(pts))
-			for _, tp := range pts {
-				tr = append(tr, trailPoint{Lon: tp.Lon, Lat: tp.Lat})
-			}
-			up[i].Trail = tr
-			trailTotal += len(tr)
+		shed := Shedding() || throttled
+		if shed && len(up) > 0 && !throttled {
+			monitoring.ShedEvents.WithLabelValues("trails_dropped").Inc()
 		}
-		seq++
-		msg := diffMsg{Type: "diff", Seq: seq, Upsert: up, Delete: dl}
-		b, _ := json.Marshal(msg)
-		if err := ws.WriteText(b); err != nil {
-			sp.SetAttributes(
-				attribute.Int64("diff.seq", seq),
-				attribute.Int("diff.up_count", len(up)),
-				attribute.Int("diff.del_count", len(dl)),
-				attribute.Int("diff.bytes", len(b)),
-				attribute.Int("diff.trails_total", trailTotal),
-			)
-			// also attach last known viewport if present
-			bboxMu.RLock()
-			if hasBBox {
-				sp.SetAttributes(
-					attribute.String("viewport.bbox", lastBBox),
-					attribute.Float64("viewport.min_lon", bboxVals[0]),
-					attribute.Float64("viewport.min_lat", bboxVals[1]),
-					attribute.Float64("viewport.max_lon", bboxVals[2]),
-					attribute.Float64("viewport.max_lat", bboxVals[3]),
-					attribute.Float64("viewport.width_deg", bboxVals[2]-bboxVals[0]),
-					attribute.Float64("viewport.height_deg", bboxVals[3]-bboxVals[1]),
-					attribute.Float64("viewport.area_deg2", (bboxVals[2]-bboxVals[0])*(bboxVals[3]-bboxVals[1])),
-				)
-			}
-			bboxMu.RUnlock()
-			return err
+		for i := 0; i < len(up) && !shed; i++ {
+			trailTotal += attachTrail(&up[i], precision, trailFull.Load(), trailColor(trailColorMode.Load()))
 		}
-		lastSend = time.Now()
-		monitoring.Debugf("ws flights => diff seq=%d up=%d del=%d bytes=%d trails=%d", seq, len(up), len(dl), len(b), trailTotal)
-		inflight = true
-		last = cur
-		pending = false
+		queue.seq++
+		b := encode(wsDiff{Type: "diff", Seq: queue.seq, Precision: precision, Upsert: up, Delete: dl, OutOfView: out})
 		sp.SetAttributes(
-			attribute.Int64("diff.seq", seq),
+			attribute.Int64("diff.seq", queue.seq),
 			attribute.Int("diff.up_count", len(up)),
 			attribute.Int("diff.del_count", len(dl)),
+			attribute.Int("diff.out_of_view_count", len(out)),
+			attribute.Int("diff.updates", updates),
 			attribute.Int("diff.bytes", len(b)),
 			attribute.Int("diff.trails_total", trailTotal),
 		)
@@ -585,57 +828,273 @@ func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
 		if hasBBox {
 			sp.SetAttributes(
 				attribute.String("viewport.bbox", lastBBox),
-				attribute.Float64("viewport.min_lon", bboxVals[0]),
-				attribute.Float64("viewport.min_lat", bboxVals[1]),
-				attribute.Float64("viewport.max_lon", bboxVals[2]),
-				attribute.Float64("viewport.max_lat", bboxVals[3]),
-				attribute.Float64("viewport.width_deg", bboxVals[2]-bboxVals[0]),
-				attribute.Float64("viewport.height_deg", bboxVals[3]-bboxVals[1]),
-				attribute.Float64("viewport.area_deg2", (bboxVals[2]-bboxVals[0])*(bboxVals[3]-bboxVals[1])),
+				attribute.Float64("viewport.min_lon", bboxVals.MinLon),
+				attribute.Float64("viewport.min_lat", bboxVals.MinLat),
+				attribute.Float64("viewport.max_lon", bboxVals.MaxLon),
+				attribute.Float64("viewport.max_lat", bboxVals.MaxLat),
+				attribute.Float64("viewport.width_deg", bboxVals.MaxLon-bboxVals.MinLon),
+				attribute.Float64("viewport.height_deg", bboxVals.MaxLat-bboxVals.MinLat),
+				attribute.Float64("viewport.area_deg2", (bboxVals.MaxLon-bboxVals.MinLon)*(bboxVals.MaxLat-bboxVals.MinLat)),
 			)
 		}
 		bboxMu.RUnlock()
+		if err := send(b); err != nil {
+			return err
+		}
+		queue.sentDiff(updates, cur)
+		lastSend = queue.lastDiff
+		monitoring.SubDebugf("ws", "flights => diff seq=%d up=%d del=%d out=%d updates=%d bytes=%d trails=%d", queue.seq, len(up), len(dl), len(out), updates, len(b), trailTotal)
+		return nil
+	}
+
+	// sendPriority pushes changed watched aircraft immediately and records them as sent,
+	// so the next batched diff does not repeat them.
+	sendPriority := func() error {
+		if len(queue.last) == 0 {
+			return nil // initial snapshot not delivered yet
+		}
+		watchMu.Lock()
+		icaos := make([]string, 0, len(watchICAO))
+		for k := range watchICAO {
+			icaos = append(icaos, k)
+		}
+		css := make([]string, 0, len(watchCS))
+		for k := range watchCS {
+			css = append(css, k)
+		}
+		watchMu.Unlock()
+		if len(icaos) == 0 && len(css) == 0 {
+			return nil
+		}
+		pts, err := storage.Get().CurrentByICAO(icaos)
+		if err != nil {
+			return nil
+		}
+		for _, cs := range css {
+			if p, err := storage.Get().LatestByCallsign(cs); err == nil && p != nil {
+				pts = append(pts, *p)
+			}
+		}
+		up := make([]wsItem, 0, len(pts))
+		keys := make([]string, 0, len(pts))
+		seen := map[string]bool{}
+		for _, p := range pts {
+			key := itemKey(p)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			it := toItem(p, precision)
+			if ov, ok := queue.last[key]; ok && !itemChanged(ov, it) {
+				continue
+			}
+			if !Shedding() {
+				attachTrail(&it, precision, trailFull.Load(), trailColor(trailColorMode.Load()))
+			}
+			up = append(up, it)
+			keys = append(keys, key)
+		}
+		if len(up) == 0 {
+			return nil
+		}
+		b := encode(wsDiff{Type: "priority", Precision: precision, Upsert: up})
+		if err := send(b); err != nil {
+			return err
+		}
+		for i, k := range keys {
+			it := up[i]
+			it.Trail, it.TrailD, it.TrailTA, it.TrailC = nil, nil, nil, nil
+			queue.last[k] = it
+		}
+		lastSend = time.Now()
+		monitoring.SubDebugf("ws", "flights => priority up=%d bytes=%d", len(up), len(b))
+		return nil
+	}
+
+	// Track subscriptions: the full current segment of a callsign is sent on subscribe, then
+	// new samples are appended as they are ingested, outside ACK pacing.
+	type subState struct {
+		icao   string
+		lastTS int64
+	}
+	subs := map[string]*subState{}
+	// sendTrack sends the whole segment (full) or the samples after the last one sent.
+	sendTrack := func(cs string, sub *subState, full bool) error {
+		msg := wsTrack{Type: "track", Callsign: cs}
+		var pts []storage.Point
+		if !full {
+			p, err := storage.Get().LatestByCallsign(cs)
+			if err != nil || p == nil || p.TS <= sub.lastTS {
+				return nil
+			}
+			if p.Icao24 != sub.icao {
+				full = true // first seen, or the callsign moved to another aircraft
+			} else {
+				recent, err := storage.Get().RecentTrackByICAO(sub.icao, 100, time.Since(time.Unix(sub.lastTS, 0))+time.Minute)
+				if err != nil {
+					return nil
+				}
+				for _, rp := range recent {
+					if rp.TS > sub.lastTS {
+						pts = append(pts, rp)
+					}
+				}
+				msg.Append = pts
+			}
+		}
+		if full {
+			seg, icao, err := currentSegment(cs)
+			if err != nil {
+				seg, icao = []storage.Point{}, "" // not seen yet; appended once it is
+			}
+			sub.icao, pts = icao, seg
+			msg.Points = pts
+		}
+		if len(pts) == 0 && !full {
+			return nil
+		}
+		if n := len(pts); n > 0 {
+			sub.lastTS = pts[n-1].TS
+		}
+		msg.Icao24 = sub.icao
+		b, _ := json.Marshal(msg)
+		if err := ws.WriteText(b); err != nil {
+			return err
+		}
+		lastSend = time.Now()
+		monitoring.SubDebugf("ws", "flights => track callsign=%s full=%t points=%d bytes=%d", cs, full, len(pts), len(b))
 		return nil
 	}
 
 	// kick initial send
 	if err := trySend(); err != nil {
+		cause = closeCause(err)
 		return
 	}
 
 	for {
 		select {
 		case <-r.Context().Done():
+			// The request context also ends when the peer drops the connection
+			cause = "read_error"
+			select {
+			case <-done:
+				cause = readCause
+			case <-time.After(100 * time.Millisecond):
+			}
+			if wsShuttingDown.Load() {
+				cause = "server_shutdown"
+			}
 			return
 		case <-done:
+			cause = readCause
 			return
 		case m := <-ackCh:
-			if m.Seq == seq {
-				inflight = false
-				bufferHigh = m.Buffered > 1_000_000 // 1MB
-				// if more pending, try send next
-				if !bufferHigh {
-					if err := trySend(); err != nil {
-						return
-					}
+			// if more pending, try send next
+			if queue.ack(m.Seq, m.Buffered) {
+				if err := trySend(); err != nil {
+					cause = closeCause(err)
+					return
 				}
 			}
 		case <-updates:
-			pending = true
+			queue.enqueue()
+			if v := wsResync.Load(); v != resyncSeen {
+				resyncSeen = v
+				if err := ws.WriteText(resyncMessage("clock_jump")); err != nil {
+					cause = closeCause(err)
+					return
+				}
+				monitoring.SubDebugf("ws", "flights => resync")
+				// an ACK for a diff sent before the jump is not awaited
+				queue.reset()
+			}
+			if err := sendPriority(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+			for cs, sub := range subs {
+				if err := sendTrack(cs, sub, false); err != nil {
+					cause = closeCause(err)
+					return
+				}
+			}
+			if err := trySend(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case <-warmC:
+			warmC = nil
+			if err := trySend(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case <-viewportCh:
+			// Flights entering/leaving the new viewport go out with the next diff
+			queue.enqueue()
 			if err := trySend(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case m := <-subCh:
+			if !m.On {
+				delete(subs, m.Callsign)
+				break
+			}
+			if _, ok := subs[m.Callsign]; !ok && len(subs) >= maxSubscriptions {
+				monitoring.SubDebugf("ws", "flights subscribe %s: limit of %d reached", m.Callsign, maxSubscriptions)
+				break
+			}
+			sub := &subState{}
+			subs[m.Callsign] = sub
+			if err := sendTrack(m.Callsign, sub, true); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case h := <-helloCh:
+			// Negotiate encoding/precision; applies from the next message on
+			if validEncoding(h.Encoding) {
+				encoding = h.Encoding
+			}
+			if h.Precision >= 0 && h.Precision <= maxPrecision && h.Precision != precision {
+				precision = h.Precision
+				queue.enqueue() // re-round positions already sent
+			}
+			b, _ := json.Marshal(map[string]any{"type": "hello", "encoding": encoding, "precision": precision, "fields": compactFields})
+			if err := ws.WriteText(b); err != nil {
+				cause = closeCause(err)
+				return
+			}
+			if err := trySend(); err != nil {
+				cause = closeCause(err)
+				return
+			}
+		case <-queue.delayC:
+			queue.delayC = nil
+			if err := trySend(); err != nil {
+				cause = closeCause(err)
 				return
 			}
 		case <-ping.C:
+			if since := ws.inflightSince.Load(); queue.inflight && since > 0 && time.Since(time.Unix(0, since)) > wsAckTimeout {
+				monitoring.SubDebugf("ws", "flights evicting client: no ack for seq=%d", queue.seq)
+				cause = "evicted"
+				return
+			}
 			if time.Since(lastSend) > 25*time.Second {
 				b, _ := json.Marshal(map[string]any{"type": "hb", "ts": time.Now().Unix()})
-				if err := ws.WriteText(b); err != nil {
+				if encoding == encodingPB {
+					b = pbHeartbeat(time.Now().Unix())
+				}
+				if err := send(b); err != nil {
+					cause = closeCause(err)
 					return
 				}
 				lastSend = time.Now()
-				monitoring.Debugf("ws flights => hb")
+				monitoring.SubDebugf("ws", "flights => hb")
 			} else {
 				_ = ws.WritePing()
-				monitoring.Debugf("ws flights => ping")
+				monitoring.SubDebugf("ws", "flights => ping")
 			}
 		}
 	}
@@ -644,23 +1103,31 @@ func FlightsWSHandler(w http.ResponseWriter, r *http.Request) {
 // FlightWSHandler streams latest position for a single callsign as JSON object messages (storage.Point).
 // Query: callsign=XXX
 func FlightWSHandler(w http.ResponseWriter, r *http.Request) {
-	callsign := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("callsign")))
-	if callsign == "" {
-		http.Error(w, "callsign is required", http.StatusBadRequest)
+	callsign, err := queryCallsign(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if rejectIfShedding(w, r) {
+		monitoring.WSClosures.WithLabelValues("flight", "overload_rejected").Inc()
 		return
 	}
 
 	ws, err := upgradeToWebSocket(w, r)
 	if err != nil {
-		monitoring.Debugf("ws upgrade error: %v", err)
+		monitoring.SubDebugf("ws", "upgrade error: %v", err)
 		return
 	}
+	ws.kind = "flight"
+	cause := "panic"
+	defer monitoring.Recover("ws.flight")
 	registerWS(ws)
 	defer func() {
 		unregisterWS(ws)
+		ws.recordClose(cause)
 		_ = ws.Close()
 	}()
-	monitoring.Debugf("ws flight connected remote=%s deflate=%t callsign=%s", r.RemoteAddr, ws.deflate, callsign)
+	monitoring.SubDebugf("ws", "flight connected remote=%s deflate=%t callsign=%s", r.RemoteAddr, ws.deflate != nil, callsign)
 
 	var lastSentTS int64
 	lastSend := time.Now()
@@ -678,10 +1145,11 @@ func FlightWSHandler(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 		lastSend = time.Now()
-		monitoring.Debugf("ws flight => point bytes=%d ts=%d", len(b), p.TS)
+		monitoring.SubDebugf("ws", "flight => point bytes=%d ts=%d", len(b), p.TS)
 		return nil
 	}
 	if err := send(); err != nil {
+		cause = closeCause(err)
 		return
 	}
 
@@ -695,21 +1163,27 @@ func FlightWSHandler(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case <-r.Context().Done():
+			cause = "read_error"
+			if wsShuttingDown.Load() {
+				cause = "server_shutdown"
+			}
 			return
 		case <-ticker.C:
 			if err := send(); err != nil {
+				cause = closeCause(err)
 				return
 			}
 			if time.Since(lastSend) > 25*time.Second {
 				b, _ := json.Marshal(map[string]any{"type": "hb", "ts": time.Now().Unix()})
 				if err := ws.WriteText(b); err != nil {
+					cause = closeCause(err)
 					return
 				}
 				lastSend = time.Now()
-				monitoring.Debugf("ws flight => hb")
+				monitoring.SubDebugf("ws", "flight => hb")
 			} else {
 				_ = ws.WritePing()
-				monitoring.Debugf("ws flight => ping")
+				monitoring.SubDebugf("ws", "flight => ping")
 			}
 		}
 	}
@@ -717,8 +1191,9 @@ func FlightWSHandler(w http.ResponseWriter, r *http.Request) {
 
 // --- WS connection registry and broadcast ---
 var (
-	wsClientsMu sync.RWMutex
-	wsClients   = make(map[*wsConn]struct{})
+	wsClientsMu    sync.RWMutex
+	wsClients      = make(map[*wsConn]struct{})
+	wsShuttingDown atomic.Bool
 )
 
 func registerWS(c *wsConn) {
@@ -733,9 +1208,100 @@ func unregisterWS(c *wsConn) {
 	wsClientsMu.Unlock()
 }
 
+// maxSubscriptions bounds the track subscriptions of one /ws/flights connection.
+const maxSubscriptions = 5
+
+// WS payload encodings; compactFields documents the order of compact item arrays.
+const (
+	encodingJSON    = "json"
+	encodingCompact = "compact"
+	encodingPB      = "pb" // protobuf binary frames, see flights.proto
+)
+
+func validEncoding(e string) bool {
+	return e == encodingJSON || e == encodingCompact || e == encodingPB
+}
+
+var compactFields = []string{"icao24", "callsign", "lon", "lat", "alt", "track", "speed", "ts", "agl", "rarity", "trail", "registration", "typecode", "operator", "phase", "trail_ta", "vrate", "trail_c"}
+
+// wsItem is one flight in a /ws/flights diff.
+type wsItem struct {
+	Icao24   string       `json:"icao24"`
+	Callsign string       `json:"callsign"`
+	Lon      float64      `json:"lon"`
+	Lat      float64      `json:"lat"`
+	Alt      float64      `json:"alt,omitempty"`
+	Track    float64      `json:"track,omitempty"`
+	Speed    float64      `json:"speed,omitempty"`
+	VRate    float64      `json:"vrate,omitempty"`
+	AGL      *float64     `json:"agl,omitempty"`
+	Rarity   int          `json:"rarity,omitempty"`
+	TS       int64        `json:"ts"`
+	Trail    []trailPoint `json:"trail,omitempty"`
+	TrailD   []int64      `json:"trail_d,omitempty"`  // delta-encoded trail when precision is set
+	TrailTA  []int64      `json:"trail_ta,omitempty"` // delta-encoded ts,alt pairs ("trail":"full")
+	TrailC   []int        `json:"trail_c,omitempty"`  // color code per trail point (trail_color)
+	Reg      string       `json:"registration,omitempty"`
+	TypeCode string       `json:"typecode,omitempty"`
+	Operator string       `json:"operator,omitempty"`
+	Phase    string       `json:"phase,omitempty"`
+}
+
+// wsDiff is a diff (ACK-paced) or priority (watched aircraft) message.
+type wsDiff struct {
+	Type      string   `json:"type"`
+	Seq       int64    `json:"seq"`
+	Precision int      `json:"precision,omitempty"`
+	Upsert    []wsItem `json:"upsert,omitempty"`
+	Delete    []string `json:"delete,omitempty"`
+	OutOfView []string `json:"out_of_view,omitempty"` // still tracked, left the viewport or filter
+}
+
+// wsTrack carries the track of a subscribed callsign: the whole segment (Points) or new samples
+// (Append).
+type wsTrack struct {
+	Type     string          `json:"type"`
+	Callsign string          `json:"callsign"`
+	Icao24   string          `json:"icao24"`
+	Points   []storage.Point `json:"points,omitzero"`
+	Append   []storage.Point `json:"append,omitzero"`
+}
+
+// wsAck acknowledges a diff; Buffered is the client's WebSocket bufferedAmount.
+type wsAck struct {
+	Type     string `json:"type"`
+	Seq      int64  `json:"seq"`
+	Buffered int64  `json:"buffered,omitempty"`
+}
+
+// trailPoint is one point of a short trail attached to WS items.
+type trailPoint struct {
+	Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+	// Only for clients that asked for "trail":"full"; plain trails of other clients stay small
+	TS  int64   `json:"ts,omitempty"`
+	Alt float64 `json:"alt,omitempty"`
+}
+
+// wsBacklog counts registered WS clients and those that are backlogged: reporting a large
+// client buffer or not acknowledging a diff within overdue.
+func wsBacklog(overdue time.Duration) (total, backlogged int) {
+	now := time.Now().UnixNano()
+	wsClientsMu.RLock()
+	defer wsClientsMu.RUnlock()
+	for c := range wsClients {
+		total++
+		if since := c.inflightSince.Load(); c.bufferHigh.Load() || (since > 0 && now-since > int64(overdue)) {
+			backlogged++
+		}
+	}
+	return total, backlogged
+}
+
 // BroadcastShutdown sends a one-off shutdown notice to all active WS clients.
 // The message format is: {"type":"server_shutdown","ts":unix}
 func BroadcastShutdown() {
+	wsShuttingDown.Store(true)
 	b, _ := json.Marshal(map[string]any{"type": "server_shutdown", "ts": time.Now().Unix()})
 	wsClientsMu.RLock()
 	conns := make([]*wsConn, 0, len(wsClients))
@@ -745,5 +1311,6 @@ func BroadcastShutdown() {
 	wsClientsMu.RUnlock()
 	for _, c := range conns {
 		_ = c.WriteText(b)
+		c.recordClose("server_shutdown")
 	}
 }
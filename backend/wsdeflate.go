@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// permessage-deflate (RFC 7692) for the WebSocket endpoints. Every message of at least
+// wsDeflateMin bytes is compressed with a sync flush and sent with RSV1 set, the trailing
+// 00 00 ff ff removed. By default compressors are reset for every message and pooled, so idle
+// connections hold none (server_no_context_takeover is always negotiated). With context
+// takeover (opt-in) a connection keeps its compressor, so a diff may refer back to the previous
+// ones (the window is 32 KiB, the only size compress/flate offers), at the price of the
+// compressor's memory: about 470 KiB per connection at level 1, 730 KiB at 6 and 1 MiB at 9,
+// i.e. ~1 GB for 2000 viewers at level 1. At level 1 messages under 128 bytes are Huffman-coded
+// only and clear the history, so takeover helps larger diffs alone. Client messages are
+// inflated with the last 32 KiB of the client's earlier messages as dictionary unless the
+// client negotiated client_no_context_takeover.
+
+var (
+	wsDeflateEnabled  = true
+	wsDeflateLevel    = flate.BestSpeed
+	wsDeflateTakeover = false
+)
+
+// SetWSDeflate configures permessage-deflate: whether it is offered to clients, the flate
+// level (1..9) and whether compression contexts are kept between messages (see the memory
+// cost above).
+func SetWSDeflate(enabled bool, level int, contextTakeover bool) {
+	wsDeflateEnabled, wsDeflateTakeover = enabled, contextTakeover
+	if level >= flate.BestSpeed && level <= flate.BestCompression {
+		wsDeflateLevel = level
+	}
+}
+
+const (
+	wsDeflateMin    = 64      // smaller messages are sent uncompressed
+	wsDeflateWindow = 1 << 15 // LZ77 window of client messages kept as dictionary
+	// wsMaxMessage bounds client messages (all fragments, after inflating).
+	wsMaxMessage = 1 << 20
+)
+
+// deflateTail ends every compressed message (an empty stored block from the sync flush).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateParams are the negotiated extension parameters of a connection.
+type deflateParams struct {
+	serverNoContextTakeover bool // our compressor is reset for every message
+	clientNoContextTakeover bool // the client resets its compressor for every message
+}
+
+// negotiateDeflate picks the first acceptable permessage-deflate offer of a
+// Sec-WebSocket-Extensions header and returns its parameters and the response extension.
+// Offers with unknown, repeated or invalid parameters are declined, as are offers that limit
+// server_max_window_bits below 15, since compress/flate always uses the full window.
+// client_max_window_bits is accepted without a value or with 8..15: any client window
+// inflates.
+func negotiateDeflate(header string) (deflateParams, string, bool) {
+	if !wsDeflateEnabled {
+		return deflateParams{}, "", false
+	}
+	for _, offer := range strings.Split(header, ",") {
+		parts := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), "permessage-deflate") {
+			continue
+		}
+		var p deflateParams
+		seen := map[string]bool{}
+		ok := true
+		for _, param := range parts[1:] {
+			name, val, hasVal := strings.Cut(strings.TrimSpace(param), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if name == "" || seen[name] {
+				ok = false
+				break
+			}
+			seen[name] = true
+			switch name {
+			case "server_no_context_takeover":
+				p.serverNoContextTakeover, ok = true, !hasVal
+			case "client_no_context_takeover":
+				p.clientNoContextTakeover, ok = true, !hasVal
+			case "server_max_window_bits":
+				ok = hasVal && windowBits(val) == 15
+			case "client_max_window_bits":
+				ok = !hasVal || windowBits(val) > 0
+			default:
+				ok = false
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if !wsDeflateTakeover {
+			p.serverNoContextTakeover = true
+		}
+		resp := "permessage-deflate"
+		if p.serverNoContextTakeover {
+			resp += "; server_no_context_takeover"
+		}
+		if p.clientNoContextTakeover {
+			resp += "; client_no_context_takeover"
+		}
+		return p, resp, true
+	}
+	return deflateParams{}, "", false
+}
+
+// windowBits parses a window size parameter (8..15), 0 when invalid.
+func windowBits(v string) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 8 || n > 15 || strconv.Itoa(n) != v {
+		return 0
+	}
+	return n
+}
+
+// wsDeflate is the compression state of a connection.
+type wsDeflate struct {
+	params deflateParams
+	fw     *flate.Writer // with context takeover (nil until the first message)
+	buf    bytes.Buffer
+	dict   []byte // last wsDeflateWindow bytes of inflated client messages
+}
+
+// flateWriters pools the compressors of connections without context takeover.
+var flateWriters sync.Pool
+
+func getFlateWriter(w io.Writer) *flate.Writer {
+	if fw, ok := flateWriters.Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw
+	}
+	fw, _ := flate.NewWriter(w, wsDeflateLevel)
+	return fw
+}
+
+// compress returns the compressed payload of a message (valid until the next call).
+func (d *wsDeflate) compress(b []byte) ([]byte, error) {
+	d.buf.Reset()
+	fw := d.fw
+	if d.params.serverNoContextTakeover {
+		fw = getFlateWriter(&d.buf)
+		defer flateWriters.Put(fw)
+	} else if fw == nil {
+		var err error
+		if fw, err = flate.NewWriter(&d.buf, wsDeflateLevel); err != nil {
+			return nil, err
+		}
+		d.fw = fw
+	}
+	if _, err := fw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	out := bytes.TrimSuffix(d.buf.Bytes(), deflateTail)
+	monitoring.WSDeflateBytes.WithLabelValues("uncompressed").Add(float64(len(b)))
+	monitoring.WSDeflateBytes.WithLabelValues("compressed").Add(float64(len(out)))
+	return out, nil
+}
+
+var errWSMessageTooBig = errors.New("websocket message too big")
+
+// decompress inflates a compressed client message.
+func (d *wsDeflate) decompress(b []byte) ([]byte, error) {
+	// The tail restores the sync flush; the final empty stored block ends the stream for the
+	// reader, which would otherwise expect more blocks
+	in := append(b, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff)
+	fr := flate.NewReaderDict(bytes.NewReader(in), d.dict)
+	defer fr.Close()
+	out, err := io.ReadAll(io.LimitReader(fr, wsMaxMessage+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > wsMaxMessage {
+		return nil, errWSMessageTooBig
+	}
+	if !d.params.clientNoContextTakeover {
+		d.dict = append(d.dict, out...)
+		if n := len(d.dict); n > wsDeflateWindow {
+			d.dict = append(d.dict[:0], d.dict[n-wsDeflateWindow:]...)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"testing"
+)
+
+// setDeflate changes the permessage-deflate settings for the duration of a test.
+func setDeflate(t *testing.T, enabled, takeover bool) {
+	t.Helper()
+	prevEnabled, prevTakeover := wsDeflateEnabled, wsDeflateTakeover
+	wsDeflateEnabled, wsDeflateTakeover = enabled, takeover
+	t.Cleanup(func() { wsDeflateEnabled, wsDeflateTakeover = prevEnabled, prevTakeover })
+}
+
+// Sec-WebSocket-Extensions offers sent by browsers.
+const (
+	chromeOffer  = "permessage-deflate; client_max_window_bits"
+	firefoxOffer = "permessage-deflate"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		name     string
+		takeover bool
+		header   string
+		ok       bool
+		resp     string
+		params   deflateParams
+	}{
+		{"chrome", false, chromeOffer, true, "permessage-deflate; server_no_context_takeover", deflateParams{serverNoContextTakeover: true}},
+		{"firefox", false, firefoxOffer, true, "permessage-deflate; server_no_context_takeover", deflateParams{serverNoContextTakeover: true}},
+		{"chrome takeover", true, chromeOffer, true, "permessage-deflate", deflateParams{}},
+		{"firefox takeover", true, firefoxOffer, true, "permessage-deflate", deflateParams{}},
+		{"no context takeover", true, "permessage-deflate; client_no_context_takeover; server_no_context_takeover", true,
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover", deflateParams{true, true}},
+		{"fallback offer", true, "permessage-deflate; server_max_window_bits=10, permessage-deflate", true, "permessage-deflate", deflateParams{}},
+		{"full server window", true, "permessage-deflate; server_max_window_bits=15", true, "permessage-deflate", deflateParams{}},
+		{"quoted client bits", true, `permessage-deflate; client_max_window_bits="12"`, true, "permessage-deflate", deflateParams{}},
+		{"small server window", true, "permessage-deflate; server_max_window_bits=10", false, "", deflateParams{}},
+		{"client bits out of range", true, "permessage-deflate; client_max_window_bits=16", false, "", deflateParams{}},
+		{"unknown parameter", true, "permessage-deflate; foo", false, "", deflateParams{}},
+		{"repeated parameter", true, "permessage-deflate; server_no_context_takeover; server_no_context_takeover", false, "", deflateParams{}},
+		{"takeover with value", true, "permessage-deflate; client_no_context_takeover=1", false, "", deflateParams{}},
+		{"other extension", true, "x-webkit-deflate-frame", false, "", deflateParams{}},
+		{"none", true, "", false, "", deflateParams{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDeflate(t, true, tt.takeover)
+			params, resp, ok := negotiateDeflate(tt.header)
+			if ok != tt.ok || resp != tt.resp || params != tt.params {
+				t.Errorf("negotiateDeflate(%q) = %+v, %q, %t; want %+v, %q, %t", tt.header, params, resp, ok, tt.params, tt.resp, tt.ok)
+			}
+		})
+	}
+
+	setDeflate(t, false, true)
+	if _, _, ok := negotiateDeflate(chromeOffer); ok {
+		t.Error("negotiateDeflate accepted an offer with server.ws_deflate=false")
+	}
+}
+
+// browserDeflate compresses and inflates like a browser: the tail of the sync flush is
+// stripped from messages sent and restored on messages received.
+type browserDeflate struct {
+	params deflateParams
+	buf    bytes.Buffer
+	fw     *flate.Writer
+	dict   []byte // server messages inflated so far (server context takeover)
+}
+
+func (b *browserDeflate) compress(t *testing.T, msg string) []byte {
+	t.Helper()
+	b.buf.Reset()
+	if b.fw == nil || b.params.clientNoContextTakeover {
+		b.fw, _ = flate.NewWriter(&b.buf, flate.DefaultCompression)
+	}
+	if _, err := b.fw.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.fw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Clone(bytes.TrimSuffix(b.buf.Bytes(), deflateTail))
+}
+
+func (b *browserDeflate) decompress(t *testing.T, z []byte) string {
+	t.Helper()
+	var dict []byte
+	if !b.params.serverNoContextTakeover {
+		dict = b.dict
+	}
+	fr := flate.NewReaderDict(bytes.NewReader(append(bytes.Clone(z), 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff)), dict)
+	out, err := io.ReadAll(fr)
+	if err != nil {
+		t.Fatalf("inflating server message: %v", err)
+	}
+	b.dict = append(b.dict, out...)
+	return string(out)
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	// Diffs of two aircraft climbing out; at level 1 compress/flate only refers back to
+	// earlier messages of at least 128 bytes
+	msgs := []string{
+		`{"type":"diff","seq":1,"upsert":[{"icao24":"3c6444","callsign":"DLH9LF","lat":50.03,"lon":8.57,"alt":1200},{"icao24":"4ca7b5","callsign":"RYR1AB","lat":50.11,"lon":8.63,"alt":2400}]}`,
+		`{"type":"diff","seq":2,"upsert":[{"icao24":"3c6444","callsign":"DLH9LF","lat":50.04,"lon":8.58,"alt":1350},{"icao24":"4ca7b5","callsign":"RYR1AB","lat":50.12,"lon":8.64,"alt":2550}]}`,
+		`{"type":"diff","seq":3,"upsert":[{"icao24":"3c6444","callsign":"DLH9LF","lat":50.05,"lon":8.59,"alt":1500},{"icao24":"4ca7b5","callsign":"RYR1AB","lat":50.13,"lon":8.65,"alt":2700}]}`,
+		strings.Repeat(`{"icao24":"4ca7b5","callsign":"RYR1AB"},`, 200),
+	}
+	for _, p := range []deflateParams{{}, {serverNoContextTakeover: true}, {clientNoContextTakeover: true}, {true, true}} {
+		t.Run(responseFor(p), func(t *testing.T) {
+			server := &wsDeflate{params: p}
+			browser := &browserDeflate{params: p}
+			var sizes []int
+			for _, msg := range msgs {
+				z, err := server.compress([]byte(msg))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes = append(sizes, len(z))
+				if got := browser.decompress(t, z); got != msg {
+					t.Fatalf("server to browser: got %q, want %q", got, msg)
+				}
+				got, err := server.decompress(browser.compress(t, msg))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != msg {
+					t.Fatalf("browser to server: got %q, want %q", got, msg)
+				}
+			}
+			// With takeover the repeated diffs refer back to the first one
+			if takeover := !p.serverNoContextTakeover; takeover != (sizes[1] < sizes[0]/2) {
+				t.Errorf("context takeover %t, but compressed sizes %v", takeover, sizes)
+			}
+			if p.clientNoContextTakeover && len(server.dict) != 0 {
+				t.Errorf("client_no_context_takeover: server kept %d dictionary bytes", len(server.dict))
+			}
+		})
+	}
+}
+
+// responseFor names the parameters as negotiateDeflate answers them.
+func responseFor(p deflateParams) string {
+	resp := "permessage-deflate"
+	if p.serverNoContextTakeover {
+		resp += "; server_no_context_takeover"
+	}
+	if p.clientNoContextTakeover {
+		resp += "; client_no_context_takeover"
+	}
+	return resp
+}
+
+// TestDeflateRFCExamples decodes the compressed payloads of "Hello" from RFC 7692, section 7.2.3.
+func TestDeflateRFCExamples(t *testing.T) {
+	d := &wsDeflate{}
+	for _, tt := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"first message", []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}},
+		{"context takeover", []byte{0xf2, 0x00, 0x11, 0x00, 0x00}}, // refers to the first message
+		{"no compression", []byte{0x00, 0x05, 0x00, 0xfa, 0xff, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x00}},
+		{"final block", []byte{0xf3, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00, 0x00}},
+	} {
+		got, err := d.decompress(tt.payload)
+		if err != nil || string(got) != "Hello" {
+			t.Errorf("%s: got %q, %v; want \"Hello\"", tt.name, got, err)
+		}
+	}
+}
+
+// browserFrames are masked client frames as Chrome and Firefox put them on the wire after
+// negotiating permessage-deflate with context takeover: both compress with zlib (raw deflate,
+// 15-bit window, default level), flush with Z_SYNC_FLUSH and strip the 00 00 ff ff tail.
+// The third message is encoded almost entirely as a back-reference to the second one.
+var browserFrames = []struct {
+	msg   string
+	frame string
+}{
+	{`{"type":"hello","encoding":"compact","precision":4}`,
+		"\xc1\xb3\x37\xfa\x21\x3d\x9d\xac\x0b\x94\x1b\xb2\x74\x8f\x65\x30\x69\xf0\xfe\x33\x76\xef\x66\xb0\xec\x76\xf9\xb5\xe8\xf1\x7c\xfd\x2b\x18\xd0\x1d\x37\x19\x11\x6d\x21\x38\x3c\x70\x73\xae\x04\x71\x12\xce\xc4\x6e\x8d\x71\x5d\xfb\x21"},
+	{`{"type":"viewport","bbox":"2.2,48.7,2.5,49.0","trail":"short"}`,
+		"\xc1\xb3\x37\xfa\x21\x3d\x9d\x7c\x48\x14\xfc\xb6\x0c\x12\xff\xd5\x23\x14\x7f\xb0\xeb\x92\x37\x70\x39\xd4\x2e\x13\xb9\x65\xdf\x63\xca\x25\xde\x63\xcb\xa5\x6f\x10\x38\x3d\x92\xb0\xab\x2f\x04\x89\xa1\x4f\xf2\xe3\x01\x98\x82\xfa\x21"},
+	{`{"type":"viewport","bbox":"2.2,48.7,2.6,49.1","trail":"short"}`,
+		"\xc1\x8b\x37\xfa\x21\x3d\x15\xb4\x96\x24\x7f\x4d\x00\x9b\x59\xfa\x21"},
+}
+
+func TestDeflateBrowserFrames(t *testing.T) {
+	// A control frame (an empty masked ping) may arrive between the messages
+	wire := browserFrames[0].frame + "\x89\x80\x00\x00\x00\x00" + browserFrames[1].frame + browserFrames[2].frame
+	w := &wsConn{buf: bufio.NewReadWriter(bufio.NewReader(strings.NewReader(wire)), nil), deflate: &wsDeflate{}}
+	want := []struct {
+		op  byte
+		msg string
+	}{{0x1, browserFrames[0].msg}, {0x9, ""}, {0x1, browserFrames[1].msg}, {0x1, browserFrames[2].msg}}
+	for i, m := range want {
+		op, payload, err := w.ReadFrame()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if op != m.op || string(payload) != m.msg {
+			t.Errorf("message %d: got opcode %#x %q, want %#x %q", i, op, payload, m.op, m.msg)
+		}
+	}
+
+	// Without negotiation compressed frames are a protocol error
+	w = &wsConn{buf: bufio.NewReadWriter(bufio.NewReader(strings.NewReader(browserFrames[0].frame)), nil)}
+	if _, _, err := w.ReadFrame(); err == nil {
+		t.Error("compressed frame accepted without permessage-deflate")
+	}
+}
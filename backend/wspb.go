@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of /ws/flights frames (schema in flights.proto). Messages are few and flat,
+// so they are written with protowire directly instead of generated code.
+
+// Frame fields
+const (
+	pbFrameDiff      protowire.Number = 1
+	pbFramePriority  protowire.Number = 2
+	pbFrameAck       protowire.Number = 3
+	pbFrameViewport  protowire.Number = 4
+	pbFrameHeartbeat protowire.Number = 5
+)
+
+func pbString(b []byte, n protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func pbDouble(b []byte, n protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// pbOptDouble encodes an optional double: present (even when zero) unless v is nil.
+func pbOptDouble(b []byte, n protowire.Number, v *float64) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(*v))
+}
+
+func pbVarint(b []byte, n protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func pbMessage(b []byte, n protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, n, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// pbFlight encodes a Flight message.
+func pbFlight(it wsItem) []byte {
+	var b []byte
+	b = pbString(b, 1, it.Icao24)
+	b = pbString(b, 2, it.Callsign)
+	b = pbDouble(b, 3, it.Lon)
+	b = pbDouble(b, 4, it.Lat)
+	b = pbDouble(b, 5, it.Alt)
+	b = pbDouble(b, 6, it.Track)
+	b = pbDouble(b, 7, it.Speed)
+	b = pbVarint(b, 8, uint64(it.TS))
+	b = pbOptDouble(b, 9, it.AGL)
+	b = pbVarint(b, 10, uint64(it.Rarity))
+	if len(it.Trail) > 0 {
+		var packed []byte
+		for _, tp := range it.Trail {
+			packed = protowire.AppendFixed64(packed, math.Float64bits(tp.Lon))
+			packed = protowire.AppendFixed64(packed, math.Float64bits(tp.Lat))
+		}
+		b = pbMessage(b, 11, packed)
+	}
+	if len(it.TrailD) > 0 {
+		var packed []byte
+		for _, v := range it.TrailD {
+			packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(v))
+		}
+		b = pbMessage(b, 12, packed)
+	}
+	b = pbString(b, 13, it.Reg)
+	b = pbString(b, 14, it.TypeCode)
+	b = pbString(b, 15, it.Operator)
+	b = pbString(b, 16, it.Phase)
+	if ta := trailTA(it); len(ta) > 0 {
+		var packed []byte
+		for _, v := range ta {
+			packed = protowire.AppendVarint(packed, protowire.EncodeZigZag(v))
+		}
+		b = pbMessage(b, 17, packed)
+	}
+	b = pbDouble(b, 18, it.VRate)
+	if len(it.TrailC) > 0 {
+		var packed []byte
+		for _, c := range it.TrailC {
+			packed = protowire.AppendVarint(packed, uint64(c))
+		}
+		b = pbMessage(b, 19, packed)
+	}
+	return b
+}
+
+// pbDiff encodes a diff or priority message as a Frame.
+func pbDiff(m wsDiff) []byte {
+	n := pbFrameDiff
+	if m.Type == "priority" {
+		n = pbFramePriority
+	}
+	return pbMessage(nil, n, pbDiffMsg(m))
+}
+
+// pbDiffMsg encodes the Diff message of m (also streamed by the gRPC WatchFlights).
+func pbDiffMsg(m wsDiff) []byte {
+	var d []byte
+	d = pbVarint(d, 1, uint64(m.Seq))
+	d = pbVarint(d, 2, uint64(m.Precision))
+	for _, it := range m.Upsert {
+		d = pbMessage(d, 3, pbFlight(it))
+	}
+	for _, k := range m.Delete {
+		d = protowire.AppendTag(d, 4, protowire.BytesType)
+		d = protowire.AppendString(d, k)
+	}
+	for _, k := range m.OutOfView {
+		d = protowire.AppendTag(d, 5, protowire.BytesType)
+		d = protowire.AppendString(d, k)
+	}
+	return d
+}
+
+// pbHeartbeat encodes a heartbeat Frame.
+func pbHeartbeat(ts int64) []byte {
+	return pbMessage(nil, pbFrameHeartbeat, pbVarint(nil, 1, uint64(ts)))
+}
+
+// pbClientFrame is a decoded client Frame: an ack or a viewport.
+type pbClientFrame struct {
+	Ack      *wsAck
+	Viewport *bbox // not validated yet
+}
+
+// pbDecodeFrame decodes a Frame sent by the client; unknown fields are skipped.
+func pbDecodeFrame(b []byte) (pbClientFrame, error) {
+	var f pbClientFrame
+	err := pbFields(b, func(n protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch n {
+		case pbFrameAck:
+			a := &wsAck{Type: "ack"}
+			err := pbFields(v, func(n protowire.Number, typ protowire.Type, _ []byte, x uint64) error {
+				switch {
+				case n == 1 && typ == protowire.VarintType:
+					a.Seq = int64(x)
+				case n == 2 && typ == protowire.VarintType:
+					a.Buffered = int64(x)
+				}
+				return nil
+			})
+			f.Ack = a
+			return err
+		case pbFrameViewport:
+			vp := &bbox{}
+			err := pbFields(v, func(n protowire.Number, typ protowire.Type, _ []byte, x uint64) error {
+				if typ != protowire.Fixed64Type {
+					return nil
+				}
+				switch n {
+				case 1:
+					vp.MinLon = math.Float64frombits(x)
+				case 2:
+					vp.MinLat = math.Float64frombits(x)
+				case 3:
+					vp.MaxLon = math.Float64frombits(x)
+				case 4:
+					vp.MaxLat = math.Float64frombits(x)
+				}
+				return nil
+			})
+			f.Viewport = vp
+			return err
+		}
+		return nil
+	})
+	if err == nil && f.Ack == nil && f.Viewport == nil {
+		err = errors.New("frame has no ack or viewport")
+	}
+	return f, err
+}
+
+// pbFields calls fn for each field of a message: v is set for length-delimited fields, x for
+// varint and fixed fields.
+func pbFields(b []byte, fn func(n protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return fmt.Errorf("protobuf: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			x, l = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, l = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(n, typ, b)
+		}
+		if l < 0 {
+			return fmt.Errorf("protobuf: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+		if err := fn(n, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
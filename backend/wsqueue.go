@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// wsSendQueue is the outbound diff queue of a /ws/flights connection. Ingests, viewport,
+// filter and precision changes enqueue an update; a diff goes out when the client has
+// acknowledged the previous one and reports room in its buffer. Updates arriving meanwhile are
+// not sent one by one but coalesced: the next diff compares the current state with what the
+// client was sent last (last), so it carries their cumulative effect in a single message.
+// Only the connection's main loop touches the queue; the counters are atomic for the admin
+// listing (/api/admin/ws).
+type wsSendQueue struct {
+	conn *wsConn
+
+	seq        int64             // of the last diff sent
+	inflight   bool              // that diff is not acknowledged yet
+	bufferHigh bool              // the client reported a large bufferedAmount with its last ack
+	queued     int               // updates waiting for the next diff
+	last       map[string]wsItem // client state after the diffs sent (trails aside)
+	lastDiff   time.Time         // when the last diff was sent
+	delayC     <-chan time.Time  // deferred send while diffs are spaced (shedding, quota)
+
+	depth     atomic.Int64 // = queued
+	sent      atomic.Int64 // diffs sent
+	coalesced atomic.Int64 // updates merged into a diff with others
+	dropped   atomic.Int64 // updates discarded without a diff
+}
+
+// wsBufferHigh is the client bufferedAmount (bytes) above which diffs are held back.
+const wsBufferHigh = 1_000_000
+
+// newSendQueue returns the queue of a connection with the initial snapshot pending.
+func newSendQueue(c *wsConn) *wsSendQueue {
+	q := &wsSendQueue{conn: c, last: map[string]wsItem{}}
+	c.queue = q
+	q.enqueue()
+	return q
+}
+
+// enqueue records an update to be sent with the next diff.
+func (q *wsSendQueue) enqueue() {
+	q.queued++
+	q.depth.Store(int64(q.queued))
+	monitoring.WSQueueDepth.Inc()
+}
+
+// ready reports whether a diff may be sent now.
+func (q *wsSendQueue) ready() bool {
+	return q.queued > 0 && !q.inflight && !q.bufferHigh
+}
+
+// take empties the queue for a diff about to be computed and returns the updates it covers.
+func (q *wsSendQueue) take() int {
+	n := q.queued
+	q.queued = 0
+	q.depth.Store(0)
+	monitoring.WSQueueDepth.Sub(float64(n))
+	return n
+}
+
+// drop discards the waiting updates without a diff (reason: empty, closed).
+func (q *wsSendQueue) drop(reason string) {
+	if n := q.take(); n > 0 {
+		q.dropped.Add(int64(n))
+		monitoring.WSQueueDropped.WithLabelValues(reason).Add(float64(n))
+	}
+}
+
+// unchanged records that the waiting updates changed nothing the client sees.
+func (q *wsSendQueue) unchanged(cur map[string]wsItem) {
+	q.drop("empty")
+	q.last = cur
+}
+
+// sentDiff records a diff sent for updates taken from the queue, leaving the client at cur.
+func (q *wsSendQueue) sentDiff(updates int, cur map[string]wsItem) {
+	q.take()
+	q.last = cur
+	q.inflight = true
+	q.lastDiff = time.Now()
+	q.conn.inflightSince.Store(q.lastDiff.UnixNano())
+	q.sent.Add(1)
+	if updates > 1 {
+		q.coalesced.Add(int64(updates - 1))
+		monitoring.WSQueueCoalesced.Add(float64(updates - 1))
+	}
+}
+
+// ack handles a client ACK and reports whether it acknowledged the diff in flight.
+func (q *wsSendQueue) ack(seq, buffered int64) bool {
+	if seq != q.seq {
+		return false
+	}
+	q.inflight = false
+	q.bufferHigh = buffered > wsBufferHigh
+	q.conn.inflightSince.Store(0)
+	q.conn.bufferHigh.Store(q.bufferHigh)
+	return true
+}
+
+// reset forgets the client state (a resync replaces it with a fresh snapshot) and stops
+// waiting for an ACK of a diff sent before.
+func (q *wsSendQueue) reset() {
+	q.last = map[string]wsItem{}
+	q.inflight = false
+	q.conn.inflightSince.Store(0)
+}
+
+// close releases the queue's share of the depth gauge.
+func (q *wsSendQueue) close() { q.drop("closed") }
+
+// wsClientInfo describes a connected WebSocket client in /api/admin/ws.
+type wsClientInfo struct {
+	Kind        string    `json:"kind"` // flights, flight
+	Remote      string    `json:"remote"`
+	Since       time.Time `json:"since"`
+	Deflate     bool      `json:"deflate"`
+	InflightFor float64   `json:"inflight_seconds,omitempty"` // age of the unacknowledged diff
+	BufferHigh  bool      `json:"buffer_high,omitempty"`
+	// Send queue of /ws/flights connections
+	QueueDepth int64 `json:"queue_depth"`
+	Sent       int64 `json:"diffs_sent"`
+	Coalesced  int64 `json:"coalesced"`
+	Dropped    int64 `json:"dropped"`
+}
+
+// WSClientsHandler lists the connected WebSocket clients with their send queue counters
+// (/api/admin/ws), oldest first.
+func WSClientsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	wsClientsMu.RLock()
+	out := make([]wsClientInfo, 0, len(wsClients))
+	for c := range wsClients {
+		ci := wsClientInfo{Kind: c.kind, Remote: c.remote, Since: c.since, Deflate: c.deflate != nil, BufferHigh: c.bufferHigh.Load()}
+		if since := c.inflightSince.Load(); since > 0 {
+			ci.InflightFor = now.Sub(time.Unix(0, since)).Round(time.Millisecond).Seconds()
+		}
+		if q := c.queue; q != nil {
+			ci.QueueDepth, ci.Sent, ci.Coalesced, ci.Dropped = q.depth.Load(), q.sent.Load(), q.coalesced.Load(), q.dropped.Load()
+		}
+		out = append(out, ci)
+	}
+	wsClientsMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"clients": out, "count": len(out)})
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"errors"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// Per-client bandwidth quota of /ws/flights: bytes sent to a client are counted per minute. A
+// client over the quota is throttled (diffs spaced by the load-shedding diff interval, no
+// trails) for the rest of that minute and the next one; a client over the quota in
+// wsQuotaStrikes consecutive minutes despite throttling is disconnected with close code
+// wsCloseQuota. Typical offenders are clients zoomed out to the whole world with trails on.
+var (
+	wsQuotaBytes   int64 // per minute; 0 disables the quota
+	wsQuotaStrikes = 3
+)
+
+// wsCloseQuota is the close code sent to clients disconnected for exceeding the quota.
+const wsCloseQuota = 4429
+
+// errWSQuota ends a connection that exceeded the quota (close cause quota_exceeded).
+var errWSQuota = errors.New("websocket bandwidth quota exceeded")
+
+// SetWSQuota sets the bytes per minute allowed per /ws/flights client (0 disables the quota)
+// and the consecutive minutes over it before the client is disconnected.
+func SetWSQuota(perMinute int64, strikes int) {
+	wsQuotaBytes = max(perMinute, 0)
+	if strikes > 0 {
+		wsQuotaStrikes = strikes
+	}
+}
+
+// wsUsage is the quota state of one connection, guarded by wsConn.mu.
+type wsUsage struct {
+	window int64 // unix minute being counted
+	bytes  int64 // sent in window
+	over   bool  // window exceeded the quota
+	prev   bool  // the window before exceeded it
+	// strikes counts consecutive minutes over the quota
+	strikes int
+}
+
+// account adds n bytes written to the connection; w.mu must be held.
+func (w *wsConn) account(n int) {
+	if wsQuotaBytes <= 0 {
+		return
+	}
+	u := &w.usage
+	if m := time.Now().Unix() / 60; m != u.window {
+		// A minute without traffic in between ends a streak
+		u.prev = u.over && m == u.window+1
+		if !u.prev {
+			u.strikes = 0
+		}
+		u.window, u.bytes, u.over = m, 0, false
+	}
+	u.bytes += int64(n)
+	if !u.over && u.bytes > wsQuotaBytes {
+		u.over = true
+		u.strikes++
+		monitoring.WSQuotaEvents.WithLabelValues("exceeded").Inc()
+	}
+}
+
+// quotaState reports whether the client is throttled and whether it is to be disconnected.
+func (w *wsConn) quotaState() (throttled, kick bool) {
+	if wsQuotaBytes <= 0 {
+		return false, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	u := w.usage
+	if m := time.Now().Unix() / 60; m != u.window {
+		// Nothing sent yet this minute: only a directly preceding minute over the quota counts
+		return u.over && m == u.window+1, false
+	}
+	return u.over || u.prev, u.strikes >= wsQuotaStrikes
+}
@@ -0,0 +1,176 @@
+//go:build chaos
+
+// Package chaos injects faults for resilience testing: OpenSky rate limits and server errors,
+// storage latency and WebSocket write errors, so that backoff, TouchNow and client resync can
+// be verified against a running server. It is compiled in only with -tags chaos (see
+// nochaos.go); faults are set through /api/admin/chaos.
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Enabled reports whether the binary was built with -tags chaos.
+const Enabled = true
+
+// Faults is the active fault configuration.
+type Faults struct {
+	// OpenSkyStatus answers OpenSky polls with this status (429, 5xx) instead of requesting
+	OpenSkyStatus int `json:"opensky_status,omitempty"`
+	// OpenSkyRetryAfter is sent as Retry-After with 429/503
+	OpenSkyRetryAfter Duration `json:"opensky_retry_after,omitempty"`
+	// OpenSkyCount limits the fault to this many polls (0: until cleared)
+	OpenSkyCount int `json:"opensky_count,omitempty"`
+	// StorageLatency delays every ingest write
+	StorageLatency Duration `json:"storage_latency,omitempty"`
+	// WSErrorRate fails this fraction of WebSocket frame writes (0..1)
+	WSErrorRate float64 `json:"ws_error_rate,omitempty"`
+	// Until clears all faults at this time (set from "for" in the request)
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in JSON ("30s").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+var (
+	mu     sync.Mutex
+	faults Faults
+)
+
+// current returns the active faults, clearing them once expired.
+func current() Faults {
+	mu.Lock()
+	defer mu.Unlock()
+	if faults.Until != nil && time.Now().After(*faults.Until) {
+		log.Printf("chaos: faults expired")
+		faults = Faults{}
+	}
+	return faults
+}
+
+// OpenSkyResponse returns the injected response to an OpenSky poll, or nil to poll OpenSky.
+func OpenSkyResponse() *http.Response {
+	f := current()
+	if f.OpenSkyStatus == 0 {
+		return nil
+	}
+	mu.Lock()
+	if faults.OpenSkyCount > 0 {
+		if faults.OpenSkyCount--; faults.OpenSkyCount == 0 {
+			faults.OpenSkyStatus, faults.OpenSkyRetryAfter = 0, 0
+		}
+	}
+	mu.Unlock()
+	h := http.Header{}
+	if ra := time.Duration(f.OpenSkyRetryAfter); ra > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(ra.Seconds())))
+	}
+	return &http.Response{
+		StatusCode: f.OpenSkyStatus,
+		Status:     fmt.Sprintf("%d %s", f.OpenSkyStatus, http.StatusText(f.OpenSkyStatus)),
+		Header:     h,
+		Body:       io.NopCloser(strings.NewReader("chaos: injected response")),
+	}
+}
+
+// StorageDelay sleeps for the injected storage latency.
+func StorageDelay() {
+	if d := time.Duration(current().StorageLatency); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// ErrWSWrite is returned for injected WebSocket write errors; it wraps net.ErrClosed so the
+// connection ends as on a peer reset.
+var ErrWSWrite = fmt.Errorf("chaos: injected write error: %w", net.ErrClosed)
+
+// WSWrite returns ErrWSWrite for the injected fraction of WebSocket writes.
+func WSWrite() error {
+	if r := current().WSErrorRate; r > 0 && rand.Float64() < r {
+		return ErrWSWrite
+	}
+	return nil
+}
+
+// Handler shows (GET), sets (PUT) and clears (DELETE) the faults of /api/admin/chaos. PUT
+// replaces the configuration, e.g. {"opensky_status":429,"opensky_retry_after":"2m",
+// "storage_latency":"3s","ws_error_rate":0.2,"for":"10m"}.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			Faults
+			For Duration `json:"for"`
+		}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := validate(req.Faults); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Until = nil
+		if req.For > 0 {
+			t := time.Now().Add(time.Duration(req.For)).UTC()
+			req.Until = &t
+		}
+		mu.Lock()
+		faults = req.Faults
+		mu.Unlock()
+		b, _ := json.Marshal(req.Faults)
+		log.Printf("chaos: faults set %s", b)
+	case http.MethodDelete:
+		mu.Lock()
+		faults = Faults{}
+		mu.Unlock()
+		log.Printf("chaos: faults cleared")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]any{"faults": current()})
+}
+
+func validate(f Faults) error {
+	switch {
+	case f.OpenSkyStatus != 0 && (f.OpenSkyStatus < 400 || f.OpenSkyStatus > 599):
+		return errors.New("opensky_status must be a 4xx or 5xx status")
+	case f.OpenSkyCount < 0:
+		return errors.New("opensky_count must not be negative")
+	case f.WSErrorRate < 0 || f.WSErrorRate > 1:
+		return errors.New("ws_error_rate must be between 0 and 1")
+	case time.Duration(f.StorageLatency) > time.Minute:
+		return errors.New("storage_latency must not exceed 1m")
+	}
+	return nil
+}
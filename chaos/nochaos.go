@@ -0,0 +1,22 @@
+//go:build !chaos
+
+// Package chaos injects faults for resilience testing. Regular builds carry these no-op
+// stand-ins; build with -tags chaos for the real implementation (chaos.go).
+package chaos
+
+import "net/http"
+
+// Enabled reports whether the binary was built with -tags chaos.
+const Enabled = false
+
+// OpenSkyResponse returns nil: OpenSky is always polled.
+func OpenSkyResponse() *http.Response { return nil }
+
+// StorageDelay does nothing.
+func StorageDelay() {}
+
+// WSWrite returns nil.
+func WSWrite() error { return nil }
+
+// Handler is not routed in regular builds.
+func Handler(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }
@@ -9,14 +9,30 @@ import (
 	"time"
 
 	"github.com/maniack/miniflightradar/app"
+	"github.com/maniack/miniflightradar/config"
+	"github.com/maniack/miniflightradar/monitoring"
 	"github.com/urfave/cli/v3"
 )
 
 func main() {
+	// Settings file (YAML/TOML); command-line flags and environment variables take precedence
+	cfg := &config.File{}
 	cmd := &cli.Command{
 		Name:  "mini-flight-radar",
 		Usage: "Track flights via OpenSky API with PWA frontend",
+		Version: func() string {
+			v, c := monitoring.BuildVersion()
+			return v + " (" + c + ")"
+		}(),
 		Flags: []cli.Flag{
+			// Parsed first so the other flags can read their values from the file
+			&cli.StringFlag{
+				Name:        "config",
+				Aliases:     []string{"c"},
+				Usage:       "Load settings from a YAML or TOML `FILE` (keys are flag names, e.g. server.listen); flags and environment variables take precedence",
+				Sources:     cli.EnvVars("MFR_CONFIG"),
+				Destination: &cfg.Path,
+			},
 			&cli.StringFlag{
 				Category: "net",
 				Name:     "net.http_proxy",
@@ -45,6 +61,32 @@ func main() {
 				Sources:  cli.EnvVars("NO_PROXY", "no_proxy"),
 				Hidden:   true,
 			},
+			&cli.StringFlag{
+				Category: "net",
+				Name:     "net.egress.allow",
+				Usage:    "Hosts outbound HTTP requests may reach, comma-separated: host, *.domain, IP or CIDR, each optionally with :port; empty allows all",
+			},
+			&cli.DurationFlag{
+				Category: "net",
+				Name:     "net.dns.cache_ttl",
+				Value:    time.Minute,
+				Usage:    "How long resolved addresses of outbound hosts are cached (0 disables); expired entries are still used for 10m while lookups fail",
+			},
+			&cli.StringFlag{
+				Category: "net",
+				Name:     "net.dns.resolvers",
+				Usage:    "Custom DNS `SERVERS` for outbound hosts, comma-separated: IP[:port], tcp://IP[:port], tls://HOST[:port] (DoT) or https://HOST/dns-query (DoH); empty uses the system resolver",
+			},
+			&cli.StringFlag{
+				Category: "net",
+				Name:     "net.tls.ca_file",
+				Usage:    "PEM `FILE` of CA certificates trusted for outbound HTTPS in addition to the system roots (e.g. of a TLS-intercepting proxy)",
+			},
+			&cli.BoolFlag{
+				Category: "net",
+				Name:     "net.tls.insecure_skip_verify",
+				Usage:    "Disable certificate verification of outbound HTTPS (INSECURE, for troubleshooting only; prefer --net.tls.ca_file)",
+			},
 			&cli.StringFlag{
 				Category: "server",
 				Name:     "server.listen",
@@ -52,6 +94,107 @@ func main() {
 				Value:    ":8080",
 				Usage:    "`ADDRESS` to listen on (e.g., ':8080')",
 			},
+			&cli.DurationFlag{
+				Category: "server",
+				Name:     "server.timeout",
+				Value:    15 * time.Second,
+				Usage:    "Default handler timeout for HTTP API routes",
+			},
+			&cli.StringFlag{
+				Category: "server",
+				Name:     "server.route_timeouts",
+				Usage:    "Per-route timeout overrides as `PATTERN=DURATION,...` (path.Match patterns, 0 exempts; e.g., /api/clips/*/export=5m)",
+			},
+			&cli.StringFlag{
+				Category: "server",
+				Name:     "server.ratelimit",
+				Usage:    "Per-client-IP limit for /api/* routes as `RATE[,burst=N]` (e.g., 10rps,burst=30 or 600rpm); empty disables",
+			},
+			&cli.StringFlag{
+				Category: "server",
+				Name:     "grpc.listen",
+				Usage:    "`ADDRESS` (e.g. '127.0.0.1:9091') of the gRPC API (backend/api.proto); calls need an API key with security.apikeys.file, otherwise the address must be loopback; empty disables",
+			},
+			&cli.StringFlag{
+				Category: "server",
+				Name:     "features",
+				Usage:    "Optional features switched on or off as `NAME=on|off,...` (alerts, replay; e.g. alerts=off); toggled at runtime via /api/admin/features",
+			},
+			&cli.StringFlag{
+				Category: "cluster",
+				Name:     "cluster.redis",
+				Usage:    "Redis `URL` (redis://[:password@]host:port[/db], rediss:// for TLS) shared by several instances: one leader polls OpenSky and shares the states with the others; empty disables",
+			},
+			&cli.StringFlag{
+				Category: "cluster",
+				Name:     "cluster.prefix",
+				Value:    "mfr:",
+				Usage:    "Prefix of the Redis keys and channels, to run several clusters on one Redis",
+			},
+			&cli.StringFlag{
+				Category: "cluster",
+				Name:     "cluster.node_id",
+				Usage:    "Name of this instance in the cluster (default hostname-pid)",
+			},
+			&cli.DurationFlag{
+				Category: "cluster",
+				Name:     "cluster.lease",
+				Value:    15 * time.Second,
+				Usage:    "Leader lease; another instance takes over this long after the leader stops renewing it",
+			},
+			&cli.IntFlag{
+				Category: "server",
+				Name:     "export.max_rows",
+				Value:    50000,
+				Usage:    "Maximum rows per history/export response; larger exports continue with a cursor",
+			},
+			&cli.IntFlag{
+				Category: "server",
+				Name:     "export.max_bytes_mb",
+				Value:    32,
+				Usage:    "Maximum size in MB per history/export response; larger exports continue with a cursor",
+			},
+			&cli.DurationFlag{
+				Category: "server",
+				Name:     "server.clock_jump",
+				Value:    30 * time.Second,
+				Usage:    "Wall-clock jump (sleep/suspend, container pause) that expires stale positions, re-fetches and resyncs WS clients; 0 disables",
+			},
+			&cli.DurationFlag{
+				Category: "server",
+				Name:     "server.warmup",
+				Value:    30 * time.Second,
+				Usage:    "Longest time /ws/flights clients wait for the first poll after start-up before receiving a snapshot; 0 disables the wait",
+			},
+			&cli.FloatFlag{
+				Category: "server",
+				Name:     "server.viewport_margin",
+				Value:    0.25,
+				Usage:    "Fraction of the viewport width/height added on each side when filtering /ws/flights and /sse/flights diffs",
+			},
+			&cli.FloatFlag{
+				Category: "server",
+				Name:     "server.viewport_hysteresis",
+				Value:    0.1,
+				Usage:    "Further fraction of the viewport size a flight already sent may move outside the margin before it is removed as out_of_view",
+			},
+			&cli.BoolFlag{
+				Category: "server",
+				Name:     "server.ws_deflate",
+				Value:    true,
+				Usage:    "Accept the permessage-deflate WebSocket extension (compressed frames) when clients offer it",
+			},
+			&cli.IntFlag{
+				Category: "server",
+				Name:     "server.ws_deflate_level",
+				Value:    1,
+				Usage:    "permessage-deflate compression level, 1 (fastest) to 9 (smallest)",
+			},
+			&cli.BoolFlag{
+				Category: "server",
+				Name:     "server.ws_deflate_context_takeover",
+				Usage:    "Keep a compressor per connection between messages (better ratio, but about 470 KB of memory per client at level 1 and up to 1 MB at level 9); false (default) negotiates server_no_context_takeover and shares pooled compressors",
+			},
 			&cli.StringFlag{
 				Category: "server",
 				Name:     "server.proxy",
@@ -65,6 +208,23 @@ func main() {
 				Value:    "",
 				Usage:    "OpenTelemetry collector `ENDPOINT` for traces",
 			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "tracing.proxy.headers",
+				Usage:    "Headers added to frontend OTLP exports forwarded to the collector, as name=value pairs with URL-encoded values (e.g., Authorization=Bearer%20TOKEN)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "tracing.proxy.signals",
+				Value:    "traces,metrics,logs",
+				Usage:    "Comma-separated OTLP signals the frontend may export through /otel/v1/{signal}",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "tracing.proxy.ratelimit",
+				Value:    "5rps,burst=20",
+				Usage:    "Per-client rate limit of frontend OTLP exports per signal (e.g., 5rps,burst=20); empty disables",
+			},
 			&cli.StringFlag{
 				Category: "monitoring",
 				Name:     "security.jwt.secret",
@@ -74,8 +234,7 @@ func main() {
 			&cli.StringFlag{
 				Category: "security",
 				Name:     "security.jwt.file",
-				Value:    "./data/jwt.secret",
-				Usage:    "Path to file to load/store JWT secret (used if security.jwt.secret is empty)",
+				Usage:    "Path to file to load/store JWT secret (used if security.jwt.secret is empty; default: jwt.secret in the directory of storage.path)",
 				Hidden:   true,
 			},
 			&cli.StringFlag{
@@ -85,6 +244,176 @@ func main() {
 				Value:    "./data/flight.buntdb",
 				Usage:    "Path to BuntDB database file (will be created if missing)",
 			},
+			&cli.DurationFlag{
+				Category: "storage",
+				Name:     "storage.event_log",
+				Value:    time.Hour,
+				Usage:    "Retention of the append-only ingest event log used by /api/changes (0 disables; capped at opensky.retention)",
+			},
+			&cli.BoolFlag{
+				Category: "storage",
+				Name:     "storage.migrate_dry_run",
+				Usage:    "Report pending storage schema migrations without applying them, then exit",
+			},
+			&cli.IntFlag{
+				Category: "storage",
+				Name:     "storage.shards",
+				Value:    1,
+				Usage:    "Number of BuntDB files the aircraft positions are split over by ICAO24 prefix (1 keeps a single file); records are moved when the count changes",
+			},
+			&cli.StringFlag{
+				Category: "storage",
+				Name:     "storage.max_size",
+				Usage:    "Size cap for the database files (e.g. `2GB`, 512MiB); compaction drops the oldest positions beyond it (empty or 0 disables)",
+			},
+			&cli.DurationFlag{
+				Category: "storage",
+				Name:     "storage.downsample_after",
+				Value:    24 * time.Hour,
+				Usage:    "Age after which position history is thinned to every storage.downsample_keep-th sample per aircraft (0 disables)",
+			},
+			&cli.IntFlag{
+				Category: "storage",
+				Name:     "storage.downsample_keep",
+				Value:    4,
+				Usage:    "Keep every Nth sample when thinning old history (N >= 2)",
+			},
+			&cli.DurationFlag{
+				Category: "storage",
+				Name:     "storage.compact_interval",
+				Value:    time.Hour,
+				Usage:    "How often the compaction job thins old history, shrinks the files and enforces storage.max_size (0 disables)",
+			},
+			&cli.DurationFlag{
+				Category: "storage",
+				Name:     "storage.now_ttl",
+				Usage:    "How long an unseen aircraft stays in the current state (0 derives it from opensky.interval: 2x interval + 15s, at least 1m)",
+			},
+			&cli.StringFlag{
+				Category: "ui",
+				Name:     "ui.dir",
+				Usage:    "Serve the web UI from `DIR` (a frontend build containing index.html) instead of the embedded one; required for binaries built with -tags noui",
+			},
+			&cli.StringFlag{
+				Category: "ui",
+				Name:     "ui.title",
+				Usage:    "Branding: page and header title of the web UI (default from the UI build)",
+			},
+			&cli.StringFlag{
+				Category: "ui",
+				Name:     "ui.logo",
+				Usage:    "Branding: logo and favicon `URL` (http(s) URL or absolute path, e.g. an image in ui.dir)",
+			},
+			&cli.StringFlag{
+				Category: "ui",
+				Name:     "ui.color",
+				Usage:    "Branding: primary `COLOR` of the web UI as #rgb or #rrggbb",
+			},
+			&cli.StringFlag{
+				Category: "ui",
+				Name:     "ui.attribution",
+				Usage:    "Branding: attribution text shown on the map (e.g. operator name)",
+			},
+			&cli.StringFlag{
+				Category: "tiles",
+				Name:     "tiles.mbtiles",
+				Usage:    "Path to an MBTiles `FILE` served at /tiles/offline/{z}/{x}/{y} for offline maps (optional)",
+			},
+			&cli.StringFlag{
+				Category: "receiver",
+				Name:     "receiver.location",
+				Usage:    "Receiver/home `LAT,LON` used as default center for range rings and local statistics",
+			},
+			&cli.StringFlag{
+				Category: "receiver",
+				Name:     "receiver.range",
+				Value:    "300km",
+				Usage:    "Radius of the local area around receiver.location used for statistics (e.g., 300km, 150nm)",
+			},
+			&cli.IntFlag{
+				Category: "receiver",
+				Name:     "rarity.alert_threshold",
+				Value:    80,
+				Usage:    "Rarity score (0..100) at which a new sighting triggers the rare_aircraft rule; 0 disables",
+			},
+			&cli.StringFlag{
+				Category: "alerts",
+				Name:     "alerts.webhook",
+				Usage:    "Default webhook URL receiving alert events as JSON POSTs (rules may set their own)",
+			},
+			&cli.BoolFlag{
+				Category: "alerts",
+				Name:     "alerts.new_airframe",
+				Usage:    "Fire a new_airframe alert when an airframe (ICAO24) is seen for the first time, once the ledger has 200 sightings",
+			},
+			&cli.StringFlag{
+				Category: "airports",
+				Name:     "aircraftdb.path",
+				Usage:    "Path to the OpenSky aircraft database CSV (or a CSV with the same columns); adds registration, type and operator",
+			},
+			&cli.StringFlag{
+				Category: "airports",
+				Name:     "airports.path",
+				Usage:    "Path to an OurAirports airports.csv (enables runway statistics)",
+			},
+			&cli.StringFlag{
+				Category: "airports",
+				Name:     "airports.runways",
+				Usage:    "Path to an OurAirports runways.csv (required for runway usage detection)",
+			},
+			&cli.StringFlag{
+				Category: "noise",
+				Name:     "noise.location",
+				Usage:    "Noise monitoring point as lat,lon (default: receiver.location); enables low-pass events",
+			},
+			&cli.StringFlag{
+				Category: "noise",
+				Name:     "noise.radius",
+				Value:    "5km",
+				Usage:    "Radius around the noise monitoring point (e.g., 5km, 3nm)",
+			},
+			&cli.IntFlag{
+				Category: "noise",
+				Name:     "noise.max_alt_ft",
+				Value:    3000,
+				Usage:    "Record passes below this height in feet (AGL when terrain is available, otherwise MSL)",
+			},
+			&cli.StringFlag{
+				Category: "geocode",
+				Name:     "geocode.cities",
+				Usage:    "GeoNames cities `FILE` (e.g., cities15000.txt) enabling offline reverse geocoding at /api/geocode",
+			},
+			&cli.StringFlag{
+				Category: "geocode",
+				Name:     "geocode.admin1",
+				Usage:    "GeoNames admin1CodesASCII.txt `FILE` for region names (optional)",
+			},
+			&cli.StringFlag{
+				Category: "geocode",
+				Name:     "geocode.countries",
+				Usage:    "GeoNames countryInfo.txt `FILE` for country names (optional)",
+			},
+			&cli.StringFlag{
+				Category: "geocode",
+				Name:     "geocode.alternate_names",
+				Usage:    "GeoNames alternateNamesV2.txt `FILE` for localized names (optional)",
+			},
+			&cli.StringFlag{
+				Category: "geocode",
+				Name:     "geocode.languages",
+				Value:    "en,de,fr,es,ru",
+				Usage:    "Comma-separated `LANGS` to keep from alternate names (empty keeps all)",
+			},
+			&cli.StringFlag{
+				Category: "terrain",
+				Name:     "terrain.dem_dir",
+				Usage:    "`DIR` with SRTM .hgt elevation tiles (e.g., N47E011.hgt) used to compute height above ground",
+			},
+			&cli.StringFlag{
+				Category: "terrain",
+				Name:     "terrain.api",
+				Usage:    "Open-Elevation compatible lookup `URL` (e.g., https://api.open-elevation.com/api/v1/lookup), used when terrain.dem_dir is empty",
+			},
 			&cli.DurationFlag{
 				Category: "opensky",
 				Name:     "opensky.interval",
@@ -92,6 +421,11 @@ func main() {
 				Value:    60 * time.Second,
 				Usage:    "Polling interval for OpenSky API (e.g., 10s)",
 			},
+			&cli.StringFlag{
+				Category: "opensky",
+				Name:     "opensky.regions",
+				Usage:    "Poll only these bounding boxes, each on its own schedule: `[name=]lat,lon,lat,lon[@interval];...` (e.g. 'alps=48,5,45.5,16@30s;55,5,50,15'); empty polls the whole world",
+			},
 			&cli.DurationFlag{
 				Category: "opensky",
 				Name:     "opensky.retention",
@@ -109,15 +443,372 @@ func main() {
 				Name:     "opensky.pass",
 				Usage:    "OpenSky API password for Basic Auth (optional)",
 			},
+			&cli.StringFlag{
+				Category: "opensky",
+				Name:     "opensky.client_id",
+				Usage:    "OpenSky API OAuth2 client ID (optional; takes precedence over Basic Auth)",
+			},
+			&cli.StringFlag{
+				Category: "opensky",
+				Name:     "opensky.client_secret",
+				Usage:    "OpenSky API OAuth2 client secret",
+				Sources:  cli.EnvVars("MFR_OPENSKY_CLIENT_SECRET"),
+			},
+			&cli.StringFlag{
+				Category: "source",
+				Name:     "source.provider",
+				Value:    "opensky",
+				Usage:    "REST feed polled for aircraft states: opensky, adsbx (ADS-B Exchange), adsbfi or airplaneslive; the last three need opensky.regions unless source.api_url returns all aircraft",
+			},
+			&cli.StringFlag{
+				Category: "source",
+				Name:     "source.api_url",
+				Usage:    "Endpoint `URL` overriding the provider's, with {lat}, {lon} and {dist} (nautical miles) placeholders, or without them for a readsb aircraft.json returning all aircraft",
+			},
+			&cli.StringFlag{
+				Category: "source",
+				Name:     "source.api_key",
+				Usage:    "ADS-B Exchange API key (sent as X-RapidAPI-Key to rapidapi.com, as api-auth otherwise)",
+				Sources:  cli.EnvVars("MFR_SOURCE_API_KEY"),
+			},
+			&cli.DurationFlag{
+				Category: "source",
+				Name:     "source.api_rate",
+				Usage:    "Minimum spacing of requests to the source API (0 uses the provider's limit, 1s for adsbx, adsbfi and airplaneslive)",
+			},
+			&cli.StringFlag{
+				Category: "source",
+				Name:     "source.sbs.addr",
+				Usage:    "`HOST:PORT` of a dump1090/readsb BaseStation (SBS-1) feed, usually port 30003; enables local receiver ingestion",
+			},
+			&cli.DurationFlag{
+				Category: "source",
+				Name:     "source.sbs.flush",
+				Value:    500 * time.Millisecond,
+				Usage:    "How often positions aggregated from the SBS feed are stored",
+			},
+			&cli.StringFlag{
+				Category: "output",
+				Name:     "output.mqtt.broker",
+				Usage:    "MQTT broker `URL` (tcp://, ssl://, credentials as user:pass@) receiving every new position as JSON; enables MQTT output",
+			},
+			&cli.StringFlag{
+				Category: "output",
+				Name:     "output.mqtt.topic_prefix",
+				Value:    "flights",
+				Usage:    "Topic prefix of MQTT position messages, published retained to {prefix}/{icao24}",
+			},
+			&cli.StringFlag{
+				Category: "ingest",
+				Name:     "ingest.area",
+				Usage:    "Only store points inside these polygons: a GeoJSON file path or inline \"lat,lon;lat,lon;lat,lon|...\"",
+			},
+			&cli.DurationFlag{
+				Category: "ingest",
+				Name:     "landed.window",
+				Value:    10 * time.Minute,
+				Usage:    "History examined to decide whether an aircraft has landed and is hidden from current views",
+			},
+			&cli.FloatFlag{
+				Category: "ingest",
+				Name:     "landed.max_speed",
+				Value:    1.5,
+				Usage:    "Last speed (m/s) at or below which a stationary aircraft counts as landed",
+			},
+			&cli.FloatFlag{
+				Category: "ingest",
+				Name:     "landed.max_move",
+				Value:    500,
+				Usage:    "Displacement (m) over landed.window below which an aircraft counts as stationary",
+			},
+			&cli.FloatFlag{
+				Category: "ingest",
+				Name:     "landed.max_alt_change",
+				Value:    10,
+				Usage:    "Altitude change (m) over landed.window below which an aircraft counts as stationary",
+			},
+			&cli.FloatFlag{
+				Category: "ingest",
+				Name:     "landed.ground_speed",
+				Value:    15,
+				Usage:    "Aircraft reported on ground (on_ground flag) below this speed (m/s) count as landed at once; 0 ignores the flag",
+			},
+			&cli.FloatFlag{
+				Category: "ingest",
+				Name:     "landed.runway_radius",
+				Value:    5000,
+				Usage:    "With an airport dataset, stationary aircraft farther than this (m) from any runway are not considered landed; 0 disables",
+			},
+			&cli.BoolFlag{
+				Category: "ingest",
+				Name:     "ingest.airborne_only",
+				Usage:    "Do not store states reported on ground",
+			},
+			&cli.BoolFlag{
+				Category: "ingest",
+				Name:     "ingest.exclude_ground_vehicles",
+				Usage:    "Do not store surface vehicles and obstacles (requests extended OpenSky states)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.remote_write.url",
+				Usage:    "Prometheus remote-write `URL` to push metrics to (e.g., Grafana Cloud); empty disables",
+			},
+			&cli.DurationFlag{
+				Category: "monitoring",
+				Name:     "metrics.remote_write.interval",
+				Value:    30 * time.Second,
+				Usage:    "Interval between remote-write pushes",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.remote_write.username",
+				Usage:    "Basic auth username for remote-write (e.g., Grafana Cloud instance ID)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.remote_write.password",
+				Usage:    "Basic auth password/API token for remote-write",
+				Sources:  cli.EnvVars("MFR_REMOTE_WRITE_PASSWORD"),
+				Hidden:   true,
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.push.prefix",
+				Aliases:  []string{"metrics.remote_write.prefix"},
+				Value:    "miniflightradar_",
+				Usage:    "Only push metric families with this name prefix to remote-write/StatsD (empty pushes all, including Go runtime metrics)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.statsd.addr",
+				Usage:    "StatsD/DogStatsD agent `HOST:PORT` (UDP) to emit metrics to; empty disables",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.statsd.flavor",
+				Value:    "statsd",
+				Usage:    "statsd (labels folded into metric names) or dogstatsd (labels as tags)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.statsd.prefix",
+				Usage:    "Prefix prepended to StatsD metric names (e.g., mfr.)",
+			},
+			&cli.DurationFlag{
+				Category: "monitoring",
+				Name:     "metrics.statsd.interval",
+				Value:    10 * time.Second,
+				Usage:    "Interval between StatsD flushes",
+			},
+			&cli.BoolFlag{
+				Category: "monitoring",
+				Name:     "metrics.prometheus",
+				Value:    true,
+				Usage:    "Expose the Prometheus /metrics endpoint (disable when only push sinks are used)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "metrics.listen",
+				Usage:    "Separate `ADDRESS` (e.g. '127.0.0.1:9090') serving /metrics, /healthz and /debug/pprof/ without authentication; /metrics then leaves the public listener",
+			},
+			&cli.BoolFlag{
+				Category: "load",
+				Name:     "load.shed",
+				Value:    true,
+				Usage:    "Automatically shed load under sustained overload (slower diffs, no trails, new WS connections rejected with 503)",
+			},
+			&cli.DurationFlag{
+				Category: "load",
+				Name:     "load.max_lag",
+				Value:    250 * time.Millisecond,
+				Usage:    "Scheduler lag considered overload (0 disables this signal)",
+			},
+			&cli.DurationFlag{
+				Category: "load",
+				Name:     "load.max_ingest",
+				Usage:    "Storage time per ingest cycle considered overload (0 = half of opensky.interval)",
+			},
+			&cli.FloatFlag{
+				Category: "load",
+				Name:     "load.ws_backlog_ratio",
+				Value:    0.5,
+				Usage:    "Share of backlogged WebSocket clients (large buffer or ACK overdue) considered overload (0 disables)",
+			},
+			&cli.DurationFlag{
+				Category: "load",
+				Name:     "load.diff_interval",
+				Value:    10 * time.Second,
+				Usage:    "Minimum time between diffs per WebSocket client while shedding or throttled by load.ws_quota",
+			},
+			&cli.StringFlag{
+				Category: "load",
+				Name:     "load.ws_quota",
+				Usage:    "Bytes per minute sent to one /ws/flights client before it is throttled (e.g. 20MB; empty disables)",
+			},
+			&cli.IntFlag{
+				Category: "load",
+				Name:     "load.ws_quota_strikes",
+				Value:    3,
+				Usage:    "Consecutive minutes over load.ws_quota after which the client is disconnected (close code 4429)",
+			},
 			&cli.BoolFlag{
 				Category: "monitoring",
 				Name:     "debug",
 				Aliases:  []string{"d"},
 				Usage:    "Enable debug logging",
 			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.debug.subsystems",
+				Usage:    "Comma-separated subsystems with debug logging enabled without global debug (ws, ingest, storage, terrain, metrics, all)",
+			},
+			&cli.IntFlag{
+				Category: "monitoring",
+				Name:     "log.debug.sample",
+				Value:    1,
+				Usage:    "Keep 1 in `N` subsystem debug lines per call site",
+			},
+			&cli.FloatFlag{
+				Category: "monitoring",
+				Name:     "log.debug.rate",
+				Value:    5,
+				Usage:    "Max subsystem debug lines per second per call site (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.redact.params",
+				Value:    "csrf,token,access_token,api_key,apikey,key,sig,signature,password,authorization",
+				Usage:    "Comma-separated query parameters whose values are masked in request logs",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.redact.headers",
+				Value:    "authorization,cookie,set-cookie,x-csrf-token,x-api-key,proxy-authorization",
+				Usage:    "Comma-separated request headers whose values are masked in request logs",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.headers",
+				Usage:    "Comma-separated request headers added to each request log line as hdr_<name> (e.g., referer,x-forwarded-for)",
+			},
+			&cli.IntFlag{
+				Category: "monitoring",
+				Name:     "log.buffer",
+				Value:    1000,
+				Usage:    "Recent log records kept in memory for /api/admin/logs/stream (0 disables)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.access.path",
+				Usage:    "Write HTTP access logs to this `FILE` instead of the application log (reopened on SIGUSR1)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "log.access.format",
+				Value:    "combined",
+				Usage:    "Access log format: combined (Apache) or json",
+			},
+			&cli.IntFlag{
+				Category: "monitoring",
+				Name:     "log.access.max_size_mb",
+				Value:    100,
+				Usage:    "Rotate the access log when it exceeds this size in MB (0 disables)",
+			},
+			&cli.DurationFlag{
+				Category: "monitoring",
+				Name:     "log.access.max_age",
+				Usage:    "Rotate the access log when it is older than this (e.g., 24h; 0 disables)",
+			},
+			&cli.IntFlag{
+				Category: "monitoring",
+				Name:     "log.access.max_backups",
+				Value:    7,
+				Usage:    "Rotated access log files to keep (0 keeps all)",
+			},
+			&cli.StringFlag{
+				Category: "monitoring",
+				Name:     "crash.dir",
+				Value:    "./data/crashes",
+				Usage:    "`DIR` where recovered panics are stored as JSON crash reports (empty keeps them in memory)",
+			},
+			&cli.IntFlag{
+				Category: "monitoring",
+				Name:     "crash.keep",
+				Value:    50,
+				Usage:    "Number of crash reports to keep",
+			},
+			&cli.StringFlag{
+				Category: "security",
+				Name:     "security.public",
+				Usage:    "Comma-separated read-only endpoints served without cookies/CSRF for embedding (exact paths or prefixes ending in *, e.g., /api/flights,/ws/flights)",
+			},
+			&cli.StringFlag{
+				Category: "security",
+				Name:     "security.apikeys.file",
+				Usage:    "`FILE` of API keys for scripted clients, one \"NAME KEY [read|admin]\" per line (KEY may be sha256:<hex>); re-read when changed, empty disables",
+			},
+			&cli.StringFlag{
+				Category: "security",
+				Name:     "security.admin.token",
+				Usage:    "Bearer token enabling the /admin endpoints (empty disables them)",
+				Sources:  cli.EnvVars("MFR_ADMIN_TOKEN"),
+				Hidden:   true,
+			},
+			&cli.StringFlag{
+				Category: "security",
+				Name:     "security.hooks.secret",
+				Usage:    "Shared secret of the inbound webhooks /api/hooks/{refetch,purge,alert_test}, as bearer token or HMAC-SHA256 body signature (empty disables them)",
+				Sources:  cli.EnvVars("MFR_HOOKS_SECRET"),
+			},
+		},
+		Before: func(ctx context.Context, _ *cli.Command) (context.Context, error) {
+			return ctx, cfg.Err()
 		},
 		Action: app.Run,
+		Commands: []*cli.Command{
+			{
+				Name:      "replay",
+				Usage:     "Serve a recorded database file or clip export through the API instead of polling OpenSky",
+				ArgsUsage: "FILE",
+				Flags: []cli.Flag{
+					&cli.FloatFlag{
+						Name:  "speed",
+						Value: 1,
+						Usage: "Playback speed (recorded seconds per second, e.g. 10)",
+					},
+					&cli.BoolFlag{
+						Name:  "loop",
+						Usage: "Start over at the end of the recording",
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Start at this recorded `TIME` (RFC 3339 or 2006-01-02T15:04 UTC) instead of the beginning",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Stop at this recorded `TIME` instead of the end",
+					},
+				},
+				Action: app.Replay,
+			},
+			{
+				Name:      "export",
+				Usage:     "Dump the position history and callsign map of the database to a newline-delimited JSON archive",
+				ArgsUsage: "FILE",
+				Flags:     archiveFlags(),
+				Action:    app.Export,
+			},
+			{
+				Name:      "import",
+				Usage:     "Load an archive written by export into the database (stop the server first)",
+				ArgsUsage: "FILE",
+				Flags:     archiveFlags(),
+				Action:    app.Import,
+			},
+		},
 	}
+	cfg.Attach(cmd.Flags)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
@@ -125,3 +816,21 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// archiveFlags are the filters of the export and import subcommands.
+func archiveFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "Only samples at or after this `TIME` (RFC 3339 or 2006-01-02T15:04 UTC)",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "Only samples at or before this `TIME`",
+		},
+		&cli.StringFlag{
+			Name:  "bbox",
+			Usage: "Only samples inside `minLon,minLat,maxLon,maxLat`",
+		},
+	}
+}
@@ -0,0 +1,208 @@
+// Package config loads settings from a YAML or TOML file and feeds them to the CLI flags as a
+// value source below command-line arguments and environment variables.
+//
+// Keys are the flag names; nesting is flattened with dots, so these are equivalent:
+//
+//	server.listen: ":8080"          # YAML
+//	server:
+//	  listen: ":8080"
+//
+//	[server]                        # TOML
+//	listen = ":8080"
+//
+// Lists are joined with commas for flags that take comma-separated values.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v3"
+	"go.yaml.in/yaml/v2"
+)
+
+// File is a configuration file loaded on first use. Path is set by the --config flag, which
+// must be parsed before the flags reading from the file (it is the first flag of the command).
+type File struct {
+	Path string
+
+	once   sync.Once
+	values map[string]string
+	err    error
+	names  map[string]string // flag name or alias -> canonical flag name
+}
+
+// Attach appends the file as the last value source of every flag, so command-line arguments
+// and environment variables take precedence over it.
+func (f *File) Attach(flags []cli.Flag) {
+	if f.names == nil {
+		f.names = map[string]string{}
+	}
+	for _, fl := range flags {
+		names := fl.Names()
+		if len(names) == 0 {
+			continue
+		}
+		name := names[0]
+		for _, n := range names {
+			f.names[n] = name
+		}
+		if src := sources(fl); src != nil {
+			src.Chain = append(src.Chain, &source{file: f, name: name})
+		}
+	}
+}
+
+// sources returns the value source chain of a flag. Every FlagBase has one; flags without it
+// (help, version) return nil.
+func sources(fl cli.Flag) *cli.ValueSourceChain {
+	v := reflect.ValueOf(fl)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	fv := v.Elem().FieldByName("Sources")
+	if !fv.IsValid() || !fv.CanAddr() {
+		return nil
+	}
+	src, _ := fv.Addr().Interface().(*cli.ValueSourceChain)
+	return src
+}
+
+// Err returns the error from loading the file, including settings that match no flag.
+func (f *File) Err() error {
+	f.load()
+	return f.err
+}
+
+func (f *File) load() {
+	f.once.Do(func() {
+		if strings.TrimSpace(f.Path) == "" {
+			return
+		}
+		values, err := Load(f.Path)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.values = map[string]string{}
+		var unknown []string
+		for k, v := range values {
+			name, ok := f.names[k]
+			if !ok {
+				unknown = append(unknown, k)
+				continue
+			}
+			f.values[name] = v
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			f.err = fmt.Errorf("config %s: unknown settings: %s", f.Path, strings.Join(unknown, ", "))
+		}
+	})
+}
+
+// source is the cli.ValueSource of one flag.
+type source struct {
+	file *File
+	name string
+}
+
+func (s *source) Lookup() (string, bool) {
+	s.file.load()
+	v, ok := s.file.values[s.name]
+	return v, ok
+}
+
+func (s *source) String() string   { return fmt.Sprintf("config key %q", s.name) }
+func (s *source) GoString() string { return fmt.Sprintf("&source{name:%q}", s.name) }
+
+// Load reads a YAML (.yaml, .yml) or TOML (.toml) file into flat dotted keys. Files with other
+// extensions are parsed as YAML.
+func Load(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		doc, err = parseTOML(string(b))
+	default:
+		err = yaml.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	out := map[string]string{}
+	if err := flatten(out, "", doc); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// flatten stores scalar leaves of v under dotted keys; lists become comma-separated values.
+func flatten(out map[string]string, prefix string, v any) error {
+	join := func(k any) string {
+		if prefix == "" {
+			return fmt.Sprint(k)
+		}
+		return prefix + "." + fmt.Sprint(k)
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			if err := flatten(out, join(k), e); err != nil {
+				return err
+			}
+		}
+	case map[any]any: // YAML v2 nested mappings
+		for k, e := range t {
+			if err := flatten(out, join(k), e); err != nil {
+				return err
+			}
+		}
+	case []any:
+		parts := make([]string, 0, len(t))
+		for _, e := range t {
+			s, ok := scalar(e)
+			if !ok {
+				return fmt.Errorf("%s: lists may only contain scalar values", prefix)
+			}
+			parts = append(parts, s)
+		}
+		out[prefix] = strings.Join(parts, ",")
+	default:
+		s, ok := scalar(t)
+		if !ok {
+			return fmt.Errorf("%s: unsupported value %T", prefix, t)
+		}
+		out[prefix] = s
+	}
+	return nil
+}
+
+func scalar(v any) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return t, true
+	case bool:
+		return strconv.FormatBool(t), true
+	case int:
+		return strconv.Itoa(t), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case uint64:
+		return strconv.FormatUint(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	}
+	return "", false
+}
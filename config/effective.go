@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Redacted replaces the values of secret settings in Effective.
+const Redacted = "REDACTED"
+
+// Setting is the effective value of one flag and where it came from.
+type Setting struct {
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Value    string `json:"value"`
+	Default  string `json:"default"`
+	// Source is "flag" (command line), "env", "file" (--config) or "default"
+	Source string `json:"source"`
+	Env    string `json:"env,omitempty"` // variable the value was read from (source env)
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// Changed reports whether the effective value differs from the default.
+func (s Setting) Changed() bool { return s.Value != s.Default }
+
+// secretWords mark flags whose values are masked entirely (a dotted or underscored part of the name).
+var secretWords = map[string]bool{"pass": true, "password": true, "secret": true, "token": true, "key": true, "headers": true}
+
+// Effective returns the settings of all flags sorted by name, after the command has parsed
+// args (the command-line arguments without the program name). Secret values and passwords in
+// URLs are replaced by Redacted.
+func Effective(flags []cli.Flag, args []string) []Setting {
+	onCmdline := cmdlineFlags(args)
+	out := make([]Setting, 0, len(flags))
+	for _, fl := range flags {
+		names := fl.Names()
+		if len(names) == 0 || fl == cli.HelpFlag || fl == cli.VersionFlag {
+			continue
+		}
+		s := Setting{Name: names[0], Source: "default"}
+		if c, ok := fl.(cli.CategorizableFlag); ok {
+			s.Category = c.GetCategory()
+		}
+		v := reflect.ValueOf(fl)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		if def := v.Elem().FieldByName("Value"); def.IsValid() {
+			s.Default = fmt.Sprint(def.Interface())
+		}
+		s.Value = s.Default
+		if g, ok := fl.(interface{ Get() any }); ok {
+			s.Value = fmt.Sprint(g.Get())
+		}
+		switch {
+		case !fl.IsSet():
+		case anyOf(names, onCmdline):
+			s.Source = "flag"
+		case sources(fl) != nil:
+			// Flags set otherwise got their value from the first source of the chain that has one
+			if _, vs, found := sources(fl).LookupWithSource(); found {
+				if env, ok := vs.(cli.EnvValueSource); ok && env.IsFromEnv() {
+					s.Source, s.Env = "env", env.Key()
+				} else {
+					s.Source = "file"
+				}
+			}
+		}
+		for _, part := range strings.FieldsFunc(s.Name, func(r rune) bool { return r == '.' || r == '_' }) {
+			s.Secret = s.Secret || secretWords[part]
+		}
+		s.Value, s.Default = mask(s.Value, s.Secret), mask(s.Default, s.Secret)
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Handler serves the effective configuration of the process (GET /api/admin/config): every
+// setting with its default and source, the settings that differ from their defaults, and
+// MFR_* environment variables that match no flag.
+func Handler(flags []cli.Flag, file string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings := Effective(flags, os.Args[1:])
+		diff := []Setting{}
+		for _, s := range settings {
+			if s.Changed() {
+				diff = append(diff, s)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"config_file": file,
+			"settings":    settings,
+			"diff":        diff,
+			"unused_env":  UnusedEnv(flags),
+		})
+	}
+}
+
+// UnusedEnv returns the MFR_* environment variables that no flag reads, typically misspelled.
+func UnusedEnv(flags []cli.Flag) []string {
+	known := map[string]bool{}
+	for _, fl := range flags {
+		if src := sources(fl); src != nil {
+			for _, k := range src.EnvKeys() {
+				known[k] = true
+			}
+		}
+	}
+	out := []string{}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, "MFR_") && !known[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// mask hides a secret value, or the password of a URL value.
+func mask(v string, secret bool) string {
+	if v == "" {
+		return v
+	}
+	if secret {
+		return Redacted
+	}
+	if strings.Contains(v, "://") {
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), Redacted)
+				return u.String()
+			}
+		}
+	}
+	return v
+}
+
+// cmdlineFlags returns the flag names given in args ("-x", "--name", "--name=value").
+func cmdlineFlags(args []string) map[string]bool {
+	out := map[string]bool{}
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		out[name] = true
+	}
+	return out
+}
+
+func anyOf(names []string, set map[string]bool) bool {
+	for _, n := range names {
+		if set[n] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML used for settings: [table] and [dotted.table] headers,
+// key = value pairs with bare, quoted or dotted keys, basic and literal strings, integers,
+// floats, booleans and single-line arrays of those. Multi-line strings and arrays, inline
+// tables and dates are not supported.
+func parseTOML(s string) (map[string]any, error) {
+	doc := map[string]any{}
+	table := ""
+	for n, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("line %d: %s", n+1, fmt.Sprintf(format, args...))
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fail("unsupported table header %q", line)
+			}
+			key, err := parseKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			table = key
+			continue
+		}
+		eq := indexUnquoted(line, '=')
+		if eq < 0 {
+			return nil, fail("expected key = value")
+		}
+		key, err := parseKey(line[:eq])
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		val, err := parseValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, dup := doc[key]; dup {
+			return nil, fail("duplicate key %s", key)
+		}
+		doc[key] = val
+	}
+	return doc, nil
+}
+
+// stripComment removes a # comment outside of strings.
+func stripComment(line string) string {
+	if i := indexUnquoted(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// indexUnquoted returns the index of the first c outside of quoted strings, or -1.
+func indexUnquoted(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == '\\' && quote == '"' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseKey normalizes a bare, quoted or dotted key to its dotted form.
+func parseKey(s string) (string, error) {
+	var parts []string
+	for _, p := range splitUnquoted(strings.TrimSpace(s), '.') {
+		p = strings.TrimSpace(p)
+		if len(p) >= 2 && (p[0] == '"' || p[0] == '\'') {
+			v, err := parseString(p)
+			if err != nil {
+				return "", err
+			}
+			p = v
+		} else if p == "" || strings.IndexFunc(p, func(c rune) bool {
+			return !(c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+		}) >= 0 {
+			return "", fmt.Errorf("invalid key %q", s)
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+func splitUnquoted(s string, sep byte) []string {
+	var out []string
+	for {
+		i := indexUnquoted(s, sep)
+		if i < 0 {
+			return append(out, s)
+		}
+		out = append(out, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func parseValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s[0] == '"' || s[0] == '\'':
+		return parseString(s)
+	case s[0] == '[':
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("multi-line arrays are not supported")
+		}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		out := []any{}
+		if inner == "" {
+			return out, nil
+		}
+		for _, e := range splitUnquoted(inner, ',') {
+			if e = strings.TrimSpace(e); e == "" {
+				continue // trailing comma
+			}
+			if e[0] == '[' {
+				return nil, fmt.Errorf("nested arrays are not supported")
+			}
+			v, err := parseValue(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case s == "true" || s == "false":
+		return s == "true", nil
+	}
+	num := strings.ReplaceAll(s, "_", "")
+	if i, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %q (strings must be quoted)", s)
+}
+
+// parseString parses a basic ("...", with escapes) or literal ('...') single-line string.
+func parseString(s string) (string, error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") {
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if s[0] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return v, nil
+}
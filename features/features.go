@@ -0,0 +1,173 @@
+// Package features switches optional subsystems on and off. Subsystems register a feature with
+// its default state; --features overrides the defaults at startup and /api/admin/features
+// toggles them at runtime. Runtime toggles are not persisted: a restart applies the flags again.
+package features
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+type feature struct {
+	desc    string
+	def     bool
+	on      atomic.Bool
+	changed time.Time // last runtime toggle (guarded by mu)
+}
+
+// State is the externally visible state of a feature.
+type State struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Enabled     bool       `json:"enabled"`
+	Default     bool       `json:"default"`
+	Changed     *time.Time `json:"changed,omitempty"` // last toggle through the admin API
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]*feature{}
+)
+
+// Register declares a feature; packages call it from init so that --features can refer to it.
+func Register(name, description string, def bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	f := &feature{desc: description, def: def}
+	f.on.Store(def)
+	registry[name] = f
+	monitoring.FeatureEnabled.WithLabelValues(name).Set(b2f(def))
+}
+
+// Enabled reports whether a feature is on; unknown features are off.
+func Enabled(name string) bool {
+	mu.Lock()
+	f := registry[name]
+	mu.Unlock()
+	return f != nil && f.on.Load()
+}
+
+func set(name string, f *feature, on bool) {
+	f.on.Store(on)
+	monitoring.FeatureEnabled.WithLabelValues(name).Set(b2f(on))
+}
+
+// Configure applies the startup flag: comma-separated NAME=on|off (also true/false, 1/0).
+func Configure(spec string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(strings.ToLower(name))
+		f := registry[name]
+		if f == nil {
+			return fmt.Errorf("features: unknown feature %q (known: %s)", name, strings.Join(namesLocked(), ", "))
+		}
+		var on bool
+		switch strings.TrimSpace(strings.ToLower(val)) {
+		case "on", "true", "1":
+			on = true
+		case "off", "false", "0":
+		default:
+			ok = false
+		}
+		if !ok {
+			return fmt.Errorf("features: want NAME=on|off, got %q", part)
+		}
+		set(name, f, on)
+	}
+	return nil
+}
+
+// List returns all features sorted by name.
+func List() []State {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make([]State, 0, len(registry))
+	for _, name := range namesLocked() {
+		f := registry[name]
+		s := State{Name: name, Description: f.desc, Enabled: f.on.Load(), Default: f.def}
+		if !f.changed.IsZero() {
+			t := f.changed
+			s.Changed = &t
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func namesLocked() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Require serves the wrapped routes only while the feature is on (404 otherwise).
+func Require(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Enabled(name) {
+				problem.Write(w, r, http.StatusNotFound, "the "+name+" feature is disabled")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handler lists the features (GET /api/admin/features) and toggles them
+// (PUT with {"features": {"alerts": false}}).
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		var req struct {
+			Features map[string]bool `json:"features"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		mu.Lock()
+		for name := range req.Features {
+			if registry[name] == nil {
+				mu.Unlock()
+				problem.Write(w, r, http.StatusBadRequest, "unknown feature: "+name)
+				return
+			}
+		}
+		now := time.Now().UTC()
+		for name, on := range req.Features {
+			f := registry[name]
+			if f.on.Load() != on {
+				set(name, f, on)
+				f.changed = now
+				log.Printf("admin: feature %s enabled=%t", name, on)
+			}
+		}
+		mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"features": List()})
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -5,6 +5,10 @@ import { startUISpan } from './otel-ui';
 import SearchBar from './components/ui/SearchBar';
 import NoticeCard from './components/ui/NoticeCard';
 
+// White-label branding templated into index.html by the server (see ui.* flags)
+interface Branding { title?: string; logo?: string; color?: string; attribution?: string }
+const branding: Branding = ((window as any).__MFR_BRANDING__ as Branding) || {};
+
 const App: React.FC = () => {
   const [callsign, setCallsign] = useState("");
   const [searchToken, setSearchToken] = useState(0);
@@ -128,6 +132,9 @@ const App: React.FC = () => {
       <div className="map-wrap">
         {/* Top controls: search form only */}
         <div className="controls">
+          {branding.logo && (
+            <img className="brand-logo" src={branding.logo} alt={branding.title || 'Logo'} title={branding.title} />
+          )}
           <SearchBar
             value={callsign}
             canSearch={canSearch}
@@ -234,6 +241,7 @@ const App: React.FC = () => {
           <small>
             Map data © <a href="https://www.openstreetmap.org/copyright" target="_blank" rel="noreferrer">OpenStreetMap</a> contributors ·
             Flight data via <a href="https://opensky-network.org" target="_blank" rel="noreferrer">OpenSky Network</a>
+            {branding.attribution && <> · {branding.attribution}</>}
           </small>
         </div>
       </div>
@@ -84,6 +84,9 @@ interface FlightMapProps {
 
 const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, baseMode, locateToken = 0, onSelectCallsign, onNotFound, onFound, onGeoError, onGeoOk, onBackendOffline, onBackendShuttingDown, onBackendOnline }) => {
   const mapRef = useRef<OlMap | null>(null);
+  // Selected flight is sent to the server as a watch entry (priority lane in WS diffs)
+  const callsignRef = useRef<string>(callsign);
+  const sendWatchRef = useRef<() => void>(() => {});
   const vectorSourceRef = useRef<VectorSource<Feature<Geometry>>>(new VectorSource<Feature<Geometry>>());
   // Source/layer for tracked flight + its track (on top)
   const flightFeatureRef = useRef<Feature<Point> | null>(null);
@@ -417,6 +420,7 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
       const alt = props.alt;
       const spd = props.speed; // m/s if present
       const knots = typeof spd === 'number' ? Math.round(spd * 1.94384449) : null;
+      const vrate = props.vrate; // m/s, positive climbing
       // Compose title with ICAO callsign and IATA in parentheses when available
       const csNorm = normalizeCallsign(cs);
       const altCS = convertCallsignAlternate(csNorm);
@@ -432,8 +436,9 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
       const lonStr = typeof lon === 'number' ? lon.toFixed(4) : '';
       const altStr = typeof alt === 'number' ? `<br/>alt: ${Math.round(alt)} m` : '';
       const spdStr = typeof knots === 'number' ? `<br/>spd: ${knots} kt` : '';
+      const vsStr = typeof vrate === 'number' && vrate !== 0 ? `<br/>v/s: ${vrate > 0 ? '+' : ''}${Math.round(vrate * 196.850394)} ft/min` : '';
       // base content
-      el.innerHTML = `<div><strong>${titleText}</strong><br/>lat: ${latStr}, lon: ${lonStr}${altStr}${spdStr}</div>`;
+      el.innerHTML = `<div><strong>${titleText}</strong><br/>lat: ${latStr}, lon: ${lonStr}${altStr}${spdStr}${vsStr}</div>`;
       el.style.display = 'block';
       ov.setPosition([x, y]);
 
@@ -862,6 +867,7 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
         if (typeof p.alt === 'number') feat.set('alt', p.alt); else feat.unset('alt', true);
         if (typeof p.track === 'number') feat.set('track', p.track); else feat.unset('track', true);
         if (typeof p.speed === 'number') feat.set('speed', p.speed); else feat.unset('speed', true);
+        if (typeof (p as any).vrate === 'number') feat.set('vrate', (p as any).vrate); else feat.unset('vrate', true);
         if (Array.isArray((p as any).trail)) feat.set('trail', (p as any).trail); else feat.unset('trail', true);
         if (typeof (p as any).ts === 'number') feat.set('ts', (p as any).ts); else feat.unset('ts', true);
         // Animate to new sample (no predictive motion)
@@ -992,8 +998,13 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
     // Set initial visibility; tracking effect will toggle this based on callsign
     layer.setVisible(true);
 
-    // WebSocket subscription for viewport flights
+    // WebSocket subscription for viewport flights; after repeated failed connects (e.g. a proxy
+    // blocking WebSockets) the same diffs are read from /sse/flights instead
     let ws: WebSocket | null = null;
+    let es: EventSource | null = null;
+    let wsFailures = 0;
+    const maxWSFailures = 3;
+    let lastEventId = '';
     let reconnectTimer: number | null = null;
     let resubTimer: number | null = null;
 
@@ -1006,60 +1017,130 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
     };
 
     const sendViewport = () => {
+      if (es) { pruneOutside(); subscribeSSE(); return; }
       if (!ws || ws.readyState !== WebSocket.OPEN) return;
       const bbox = bboxStr();
       if (!bbox) return;
       try { ws.send(JSON.stringify({ type: 'viewport', bbox })); } catch {}
     };
 
+    const sendWatch = () => {
+      if (!ws || ws.readyState !== WebSocket.OPEN) return;
+      const cs = (callsignRef.current || '').trim().toUpperCase();
+      try { ws.send(JSON.stringify({ type: 'watch', callsign: cs ? [cs] : [] })); } catch {}
+    };
+    sendWatchRef.current = sendWatch;
+
+    // handleMessage applies one flights stream message (WebSocket or SSE); ack confirms diffs
+    const handleMessage = async (raw: string, ack: (seq: number) => void) => {
+      await withSpan('ws.message.batch', async (span) => {
+        try {
+          const data = JSON.parse(raw);
+          if (data && typeof data === 'object' && data.type === 'server_shutdown') { if (lastStatusRef.current !== 'shutting_down') { try { onBackendShuttingDown && onBackendShuttingDown('Server is shutting down…'); } catch {} } lastStatusRef.current = 'shutting_down'; return; }
+          if (data && typeof data === 'object' && data.type === 'hb') { return; }
+          // Server just started: keep the cached aircraft until the first snapshot arrives
+          if (data && typeof data === 'object' && data.type === 'status') { return; }
+          // Server clock jumped (sleep/pause): drop stale aircraft, a full snapshot follows
+          if (data && typeof data === 'object' && data.type === 'resync') { try { source.clear(); allIndexRef.current.clear(); } catch {} return; }
+          // Backward-compat: full array snapshot
+          if (Array.isArray(data)) {
+            addEvent(span, 'received', { count: data.length, kind: 'full' });
+            processPoints(data);
+            await saveSnapshotNow(data);
+            ack(0);
+            return;
+          }
+          // Priority lane: watched aircraft, delivered outside ACK pacing (no ack expected)
+          if (data && typeof data === 'object' && data.type === 'priority') {
+            const up: any[] = Array.isArray(data.upsert) ? data.upsert : [];
+            addEvent(span, 'received', { upsert: up.length, kind: 'priority' });
+            if (up.length) processPoints(up);
+            return;
+          }
+          if (data && typeof data === 'object' && data.type === 'diff') {
+            const seq = Number(data.seq) || 0;
+            const up: any[] = Array.isArray(data.upsert) ? data.upsert : [];
+            // Aircraft that left the viewport (out_of_view) are removed like disappeared ones
+            const out: string[] = Array.isArray(data.out_of_view) ? data.out_of_view : [];
+            const del: string[] = (Array.isArray(data.delete) ? data.delete : []).concat(out);
+            addEvent(span, 'received', { upsert: up.length, delete: del.length - out.length, out_of_view: out.length, seq });
+            if (up.length) processPoints(up);
+            if (del.length) {
+              for (const id of del) {
+                const feat = allIndexRef.current.get(String(id));
+                if (feat) {
+                  source.removeFeature(feat);
+                  allIndexRef.current.delete(String(id));
+                }
+              }
+            }
+            // Note: we don't persist diffs to snapshot to avoid heavy rebuild each tick
+            ack(seq);
+          }
+        } catch (e) {
+          // ignore parse errors
+        }
+      }, { mode: callsign ? 'track' : 'browse' });
+    };
+
+    const wsAck = (seq: number) => {
+      try { ws && ws.send(JSON.stringify({ type: 'ack', seq, buffered: ws.bufferedAmount })); } catch {}
+    };
+
+    // pruneOutside drops aircraft outside the viewport grown by 25% (as filtered by the server),
+    // so an SSE stream resumed with a new bbox does not leave stale markers behind
+    const pruneOutside = () => {
+      if (!map.getSize()) return;
+      const [minX, minY, maxX, maxY] = map.getView().calculateExtent(map.getSize()!);
+      const mx = (maxX - minX) * 0.25, my = (maxY - minY) * 0.25;
+      for (const [id, feat] of allIndexRef.current.entries()) {
+        const c = (feat.getGeometry() as Point | undefined)?.getCoordinates();
+        if (c && (c[0] < minX - mx || c[0] > maxX + mx || c[1] < minY - my || c[1] > maxY + my)) {
+          source.removeFeature(feat);
+          allIndexRef.current.delete(id);
+        }
+      }
+    };
+
+    const subscribeSSE = () => {
+      if (es) { try { es.close(); } catch (_) {} es = null; }
+      try {
+        const params = new URLSearchParams();
+        const token = getCsrfTokenFromCookie();
+        if (token) params.set('csrf', token);
+        const bbox = bboxStr();
+        if (bbox) params.set('bbox', bbox);
+        if (lastEventId) params.set('last_event_id', lastEventId);
+        const url = `/sse/flights?${params.toString()}`;
+        const conn = startUISpan('sse.connect', { url, mode: callsign ? 'track' : 'browse' });
+        es = new EventSource(url);
+        es.onopen = () => { try { addEvent(conn.span, 'open'); conn.end({ ok: true }); } catch {}; if (lastStatusRef.current !== 'online') { try { onBackendOnline && onBackendOnline(); } catch {} } lastStatusRef.current = 'online'; };
+        es.onmessage = (ev) => { if (ev.lastEventId) lastEventId = ev.lastEventId; void handleMessage(ev.data, () => {}); };
+        // EventSource reconnects by itself and resumes after Last-Event-ID
+        es.onerror = () => {
+          try { addEvent(conn.span, 'error'); } catch {}
+          if (lastStatusRef.current !== 'shutting_down') { try { onBackendOffline && onBackendOffline('Backend unavailable. Trying to reconnect…'); } catch {}; lastStatusRef.current = 'offline'; }
+        };
+      } catch (_) {
+        // ignore
+      }
+    };
+
     const subscribe = () => {
+      if (wsFailures >= maxWSFailures) { subscribeSSE(); return; }
       if (ws) { try { ws.close(); } catch (_) {} ws = null; }
+      let opened = false;
       try {
         const proto = window.location.protocol === 'https:' ? 'wss' : 'ws';
         const token = getCsrfTokenFromCookie();
         const url = `${proto}://${window.location.host}/ws/flights${token ? `?csrf=${encodeURIComponent(token)}` : ''}`;
         const conn = startUISpan('ws.connect', { url, mode: callsign ? 'track' : 'browse' });
         ws = new WebSocket(url);
-        ws.onopen = () => { try { addEvent(conn.span, 'open'); conn.end({ ok: true }); } catch {}; if (lastStatusRef.current !== 'online') { try { onBackendOnline && onBackendOnline(); } catch {} } lastStatusRef.current = 'online'; try { sendViewport(); } catch {} };
-        ws.onmessage = async (ev) => {
-          await withSpan('ws.message.batch', async (span) => {
-            try {
-              const data = JSON.parse(ev.data);
-              if (data && typeof data === 'object' && data.type === 'server_shutdown') { if (lastStatusRef.current !== 'shutting_down') { try { onBackendShuttingDown && onBackendShuttingDown('Server is shutting down…'); } catch {} } lastStatusRef.current = 'shutting_down'; return; }
-              if (data && typeof data === 'object' && data.type === 'hb') { return; }
-              // Backward-compat: full array snapshot
-              if (Array.isArray(data)) {
-                addEvent(span, 'received', { count: data.length, kind: 'full' });
-                processPoints(data);
-                await saveSnapshotNow(data);
-                try { ws && ws.send(JSON.stringify({ type: 'ack', seq: 0, buffered: ws.bufferedAmount })); } catch {}
-                return;
-              }
-              if (data && typeof data === 'object' && data.type === 'diff') {
-                const seq = Number(data.seq) || 0;
-                const up: any[] = Array.isArray(data.upsert) ? data.upsert : [];
-                const del: string[] = Array.isArray(data.delete) ? data.delete : [];
-                addEvent(span, 'received', { upsert: up.length, delete: del.length, seq });
-                if (up.length) processPoints(up);
-                if (del.length) {
-                  for (const id of del) {
-                    const feat = allIndexRef.current.get(String(id));
-                    if (feat) {
-                      source.removeFeature(feat);
-                      allIndexRef.current.delete(String(id));
-                    }
-                  }
-                }
-                // Note: we don't persist diffs to snapshot to avoid heavy rebuild each tick
-                try { ws && ws.send(JSON.stringify({ type: 'ack', seq, buffered: ws.bufferedAmount })); } catch {}
-              }
-            } catch (e) {
-              // ignore parse errors
-            }
-          }, { mode: callsign ? 'track' : 'browse' });
-        };
+        ws.onopen = () => { opened = true; wsFailures = 0; try { addEvent(conn.span, 'open'); conn.end({ ok: true }); } catch {}; if (lastStatusRef.current !== 'online') { try { onBackendOnline && onBackendOnline(); } catch {} } lastStatusRef.current = 'online'; try { sendViewport(); sendWatch(); } catch {} };
+        ws.onmessage = (ev) => { void handleMessage(ev.data, wsAck); };
         ws.onclose = () => {
           try { addEvent(conn.span, 'close'); } catch {}
+          if (!opened) wsFailures++;
           if (lastStatusRef.current !== 'shutting_down') { try { onBackendOffline && onBackendOffline('Backend unavailable. Trying to reconnect…'); } catch {} ; lastStatusRef.current = 'offline'; }
           if (reconnectTimer) window.clearTimeout(reconnectTimer);
           reconnectTimer = window.setTimeout(() => subscribe(), 2500);
@@ -1120,6 +1201,7 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
       cancelled = true;
       map.un('moveend', onMoveEnd as any);
       if (ws) { try { ws.close(); } catch (_) {} }
+      if (es) { try { es.close(); } catch (_) {} }
       if (reconnectTimer) window.clearTimeout(reconnectTimer);
       if (resubTimer) window.clearTimeout(resubTimer);
       // cancel any in-flight feature animations
@@ -1132,6 +1214,12 @@ const FlightMap: React.FC<FlightMapProps> = ({ callsign, searchToken, theme, bas
   }, []);
 
 
+  // Keep the server-side watch list in sync with the selected flight
+  useEffect(() => {
+    callsignRef.current = callsign;
+    sendWatchRef.current();
+  }, [callsign]);
+
   // Update marker and track styles when theme changes
   useEffect(() => {
     const f = flightFeatureRef.current;
@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 
 export interface SearchBarProps {
   value: string;
@@ -7,7 +7,52 @@ export interface SearchBarProps {
   onSubmit: () => void;
 }
 
+// A current flight suggested by /api/search
+interface SearchResult {
+  icao24: string;
+  callsign: string;
+  registration?: string;
+  typecode?: string;
+}
+
+const getCsrfTokenFromCookie = (): string => {
+  try {
+    const m = document.cookie.match(/(?:^|; )mfr_csrf=([^;]+)/);
+    return m ? decodeURIComponent(m[1]) : '';
+  } catch {
+    return '';
+  }
+};
+
 export const SearchBar: React.FC<SearchBarProps> = ({ value, canSearch, onChange, onSubmit }) => {
+  const [suggestions, setSuggestions] = useState<SearchResult[]>([]);
+
+  // Suggest current flights matching the input (callsign, ICAO24 or registration), debounced
+  useEffect(() => {
+    const q = value.trim();
+    if (q.length < 2) {
+      setSuggestions([]);
+      return;
+    }
+    const ctrl = new AbortController();
+    const t = window.setTimeout(async () => {
+      try {
+        const csrf = getCsrfTokenFromCookie();
+        const resp = await fetch(`/api/search?q=${encodeURIComponent(q)}&limit=8`, {
+          credentials: 'include',
+          headers: csrf ? { 'X-CSRF-Token': csrf } : {},
+          signal: ctrl.signal,
+        });
+        if (!resp.ok) return;
+        const data = await resp.json();
+        setSuggestions(Array.isArray(data.results) ? data.results : []);
+      } catch {
+        // ignore aborted/failed lookups
+      }
+    }, 250);
+    return () => { window.clearTimeout(t); ctrl.abort(); };
+  }, [value]);
+
   return (
     <form onSubmit={(e) => { e.preventDefault(); onSubmit(); }} style={{ display: 'flex', alignItems: 'center', gap: 8 }}>
       <div className="field">
@@ -16,9 +61,17 @@ export const SearchBar: React.FC<SearchBarProps> = ({ value, canSearch, onChange
           className="input"
           type="text"
           placeholder="e.g. AAL100"
+          list="flight-search-suggestions"
           value={value}
           onChange={(e) => onChange(e.target.value)}
         />
+        <datalist id="flight-search-suggestions">
+          {suggestions.filter((s) => (s.callsign || '').trim()).map((s) => (
+            <option key={s.icao24} value={s.callsign.trim()}>
+              {[s.icao24, s.registration, s.typecode].filter(Boolean).join(' · ')}
+            </option>
+          ))}
+        </datalist>
       </div>
       <button className="button search-btn" type="submit" disabled={!canSearch} aria-label="Search">
         <i className="fa-solid fa-magnifying-glass"></i>
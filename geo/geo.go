@@ -0,0 +1,477 @@
+// Package geo contains small geodesic helpers (spherical earth model), distance parsing,
+// GeoJSON types and the configured receiver location shared by overlay and statistics features.
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EarthRadius is the mean earth radius in meters.
+const EarthRadius = 6371000.0
+
+// Distance unit factors to meters.
+const (
+	Meter        = 1.0
+	Kilometer    = 1000.0
+	NauticalMile = 1852.0
+	StatuteMile  = 1609.344
+	Foot         = 0.3048
+)
+
+func toRad(d float64) float64 { return d * math.Pi / 180 }
+func toDeg(r float64) float64 { return r * 180 / math.Pi }
+
+// Haversine returns great-circle distance between two lat/lon points in meters.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Sin(dLon/2)*math.Sin(dLon/2)*math.Cos(toRad(lat1))*math.Cos(toRad(lat2))
+	return EarthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Bearing returns the initial great-circle bearing (degrees, [0,360)) from point 1 to point 2.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	la1, la2 := toRad(lat1), toRad(lat2)
+	dLon := toRad(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(la2)
+	x := math.Cos(la1)*math.Sin(la2) - math.Sin(la1)*math.Cos(la2)*math.Cos(dLon)
+	return math.Mod(toDeg(math.Atan2(y, x))+360, 360)
+}
+
+// Destination returns the point reached from (lat, lon) travelling dist meters on the given bearing.
+func Destination(lat, lon, bearing, dist float64) (float64, float64) {
+	la1, lo1 := toRad(lat), toRad(lon)
+	brg := toRad(bearing)
+	d := dist / EarthRadius
+	la2 := math.Asin(math.Sin(la1)*math.Cos(d) + math.Cos(la1)*math.Sin(d)*math.Cos(brg))
+	lo2 := lo1 + math.Atan2(math.Sin(brg)*math.Sin(d)*math.Cos(la1), math.Cos(d)-math.Sin(la1)*math.Sin(la2))
+	return toDeg(la2), NormLon(toDeg(lo2))
+}
+
+// NormLon normalizes longitude into [-180,180).
+func NormLon(lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// ParseLatLon parses "lat,lon".
+func ParseLatLon(s string) (float64, float64, error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("expected lat,lon")
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lat) || math.IsNaN(lon) {
+		return 0, 0, errors.New("invalid coordinates")
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, errors.New("coordinates out of range")
+	}
+	return lat, lon, nil
+}
+
+// unitSuffixes are checked longest first.
+var unitSuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"nm", NauticalMile},
+	{"km", Kilometer},
+	{"mi", StatuteMile},
+	{"m", Meter},
+}
+
+func splitUnit(s string) (string, float64, bool) {
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			return strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor, true
+		}
+	}
+	return s, 0, false
+}
+
+// ParseDistances parses a comma-separated distance list such as "50,100,150nm" or "10km,25km".
+// A unit on any element applies to the preceding unit-less ones; defUnit is used when none is given.
+// Results are in meters.
+func ParseDistances(s string, defUnit float64) ([]float64, error) {
+	raw := strings.Split(strings.ToLower(strings.TrimSpace(s)), ",")
+	out := make([]float64, len(raw))
+	pending := []int{}
+	for i, tok := range raw {
+		tok = strings.TrimSpace(tok)
+		num, factor, ok := splitUnit(tok)
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v <= 0 {
+			return nil, fmt.Errorf("invalid distance %q", tok)
+		}
+		out[i] = v
+		if ok {
+			for _, j := range pending {
+				out[j] *= factor
+			}
+			pending = pending[:0]
+			out[i] *= factor
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	for _, j := range pending {
+		out[j] *= defUnit
+	}
+	return out, nil
+}
+
+// ============ Receiver location ============
+
+var (
+	receiverMu  sync.RWMutex
+	receiverLat float64
+	receiverLon float64
+	receiverSet bool
+	// receiverRange is the radius (m) of the local area used for statistics
+	receiverRange = 300 * Kilometer
+)
+
+// SetReceiver sets the configured receiver/home location ("lat,lon"). Empty clears it.
+func SetReceiver(s string) error {
+	receiverMu.Lock()
+	defer receiverMu.Unlock()
+	if strings.TrimSpace(s) == "" {
+		receiverSet = false
+		return nil
+	}
+	lat, lon, err := ParseLatLon(s)
+	if err != nil {
+		return err
+	}
+	receiverLat, receiverLon, receiverSet = lat, lon, true
+	return nil
+}
+
+// Receiver returns the configured receiver location, if any.
+func Receiver() (lat, lon float64, ok bool) {
+	receiverMu.RLock()
+	defer receiverMu.RUnlock()
+	return receiverLat, receiverLon, receiverSet
+}
+
+// SetReceiverRange sets the local area radius from a distance string such as "300km" or "150nm".
+func SetReceiverRange(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	d, err := ParseDistances(s, Kilometer)
+	if err != nil || len(d) != 1 {
+		return fmt.Errorf("invalid range %q", s)
+	}
+	receiverMu.Lock()
+	receiverRange = d[0]
+	receiverMu.Unlock()
+	return nil
+}
+
+// ReceiverRange returns the local area radius in meters.
+func ReceiverRange() float64 {
+	receiverMu.RLock()
+	defer receiverMu.RUnlock()
+	return receiverRange
+}
+
+// ============ GeoJSON ============
+
+// FeatureCollection is a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature.
+type Feature struct {
+	Type       string         `json:"type"`
+	Geometry   Geometry       `json:"geometry"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// Geometry is a GeoJSON geometry; Coordinates holds the type-specific nested arrays ([lon,lat] order).
+type Geometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// NewFeatureCollection returns an empty collection ready to append to.
+func NewFeatureCollection() FeatureCollection {
+	return FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+}
+
+// Circle returns a closed ring of n+1 [lon,lat] points at radius meters around the center.
+func Circle(lat, lon, radius float64, n int) [][2]float64 {
+	if n < 8 {
+		n = 8
+	}
+	ring := make([][2]float64, 0, n+1)
+	for i := 0; i <= n; i++ {
+		la, lo := Destination(lat, lon, 360*float64(i%n)/float64(n), radius)
+		ring = append(ring, [2]float64{lo, la})
+	}
+	return ring
+}
+
+// ============ Simplification ============
+
+// Simplify returns the indices of the n points (given by at) kept by the Douglas-Peucker
+// algorithm: every dropped point lies within tolerance meters of the simplified line. The first
+// and last points are always kept; tolerance <= 0 keeps all points.
+func Simplify(n int, at func(i int) (lat, lon float64), tolerance float64) []int {
+	if n <= 2 || tolerance <= 0 {
+		out := make([]int, n)
+		for i := range out {
+			out[i] = i
+		}
+		return out
+	}
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	stack := [][2]int{{0, n - 1}}
+	for len(stack) > 0 {
+		seg := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		a, b := seg[0], seg[1]
+		if b-a < 2 {
+			continue
+		}
+		lat1, lon1 := at(a)
+		lat2, lon2 := at(b)
+		far, farDist := -1, tolerance
+		for i := a + 1; i < b; i++ {
+			lat, lon := at(i)
+			if d := segmentDistance(lat, lon, lat1, lon1, lat2, lon2); d > farDist {
+				far, farDist = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			stack = append(stack, [2]int{a, far}, [2]int{far, b})
+		}
+	}
+	out := make([]int, 0, n)
+	for i, k := range keep {
+		if k {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// segmentDistance returns the distance in meters from a point to the segment 1-2, on a local
+// equirectangular projection (accurate for the short segments of a track).
+func segmentDistance(lat, lon, lat1, lon1, lat2, lon2 float64) float64 {
+	k := toRad(1) * EarthRadius
+	cos := math.Cos(toRad(lat1))
+	x, y := NormLon(lon-lon1)*cos*k, (lat-lat1)*k
+	x2, y2 := NormLon(lon2-lon1)*cos*k, (lat2-lat1)*k
+	t := 0.0
+	if l2 := x2*x2 + y2*y2; l2 > 0 {
+		t = math.Max(0, math.Min(1, (x*x2+y*y2)/l2))
+	}
+	return math.Hypot(x-t*x2, y-t*y2)
+}
+
+// ============ Polygons ============
+
+// Polygon is a closed ring of [lat, lon] vertices (closing vertex optional).
+type Polygon [][2]float64
+
+// Contains reports whether the point is inside the polygon (ray casting; edges are not antimeridian-aware).
+func (pg Polygon) Contains(lat, lon float64) bool {
+	in := false
+	n := len(pg)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		yi, xi := pg[i][0], pg[i][1]
+		yj, xj := pg[j][0], pg[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// ParsePolygons parses inline polygons "lat,lon;lat,lon;lat,lon|lat,lon;..." (at least 3 vertices each).
+func ParsePolygons(s string) ([]Polygon, error) {
+	out := []Polygon{}
+	for _, ring := range strings.Split(s, "|") {
+		if strings.TrimSpace(ring) == "" {
+			continue
+		}
+		pg := Polygon{}
+		for _, v := range strings.Split(ring, ";") {
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			lat, lon, err := ParseLatLon(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid polygon vertex %q: %w", v, err)
+			}
+			pg = append(pg, [2]float64{lat, lon})
+		}
+		if len(pg) < 3 {
+			return nil, errors.New("polygon needs at least 3 vertices")
+		}
+		out = append(out, pg)
+	}
+	return out, nil
+}
+
+// ParseGeoJSONPolygons extracts outer rings of Polygon/MultiPolygon geometries from a GeoJSON
+// FeatureCollection, Feature or bare geometry.
+func ParseGeoJSONPolygons(b []byte) ([]Polygon, error) {
+	var doc struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+		Geometry    json.RawMessage `json:"geometry"`
+		Features    []struct {
+			Geometry json.RawMessage `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	out := []Polygon{}
+	ring := func(r [][]float64) {
+		pg := Polygon{}
+		for _, c := range r {
+			if len(c) >= 2 {
+				pg = append(pg, [2]float64{c[1], c[0]})
+			}
+		}
+		if len(pg) >= 3 {
+			out = append(out, pg)
+		}
+	}
+	switch doc.Type {
+	case "FeatureCollection":
+		for _, f := range doc.Features {
+			pgs, err := ParseGeoJSONPolygons(f.Geometry)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, pgs...)
+		}
+	case "Feature":
+		return ParseGeoJSONPolygons(doc.Geometry)
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(doc.Coordinates, &rings); err != nil {
+			return nil, err
+		}
+		if len(rings) > 0 {
+			ring(rings[0])
+		}
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if err := json.Unmarshal(doc.Coordinates, &polys); err != nil {
+			return nil, err
+		}
+		for _, rings := range polys {
+			if len(rings) > 0 {
+				ring(rings[0])
+			}
+		}
+	}
+	return out, nil
+}
+
+// ============ Spatial point index ============
+
+// PointIndex is a simple fixed-grid spatial index over static points (cities, airports).
+// Points are referenced by the integer id returned from Add.
+type PointIndex struct {
+	cell  float64
+	cells map[[2]int][]int
+	lats  []float64
+	lons  []float64
+}
+
+// NewPointIndex creates an index with the given grid cell size in degrees (1 is a good default).
+func NewPointIndex(cellDeg float64) *PointIndex {
+	if cellDeg <= 0 {
+		cellDeg = 1
+	}
+	return &PointIndex{cell: cellDeg, cells: map[[2]int][]int{}}
+}
+
+func (ix *PointIndex) key(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / ix.cell)), int(math.Floor(lon / ix.cell))}
+}
+
+// Add inserts a point and returns its id (insertion order, starting at 0).
+func (ix *PointIndex) Add(lat, lon float64) int {
+	id := len(ix.lats)
+	ix.lats = append(ix.lats, lat)
+	ix.lons = append(ix.lons, lon)
+	k := ix.key(lat, lon)
+	ix.cells[k] = append(ix.cells[k], id)
+	return id
+}
+
+// Len returns the number of indexed points.
+func (ix *PointIndex) Len() int { return len(ix.lats) }
+
+// Nearest returns the id and distance (meters) of the closest point within maxDist meters, or -1.
+// An optional accept filter can skip candidates.
+func (ix *PointIndex) Nearest(lat, lon, maxDist float64, accept func(id int) bool) (int, float64) {
+	best, bestD := -1, math.Inf(1)
+	// search radius in cells; longitude cells shrink towards the poles
+	degLat := maxDist / (EarthRadius * math.Pi / 180)
+	degLon := degLat / math.Max(math.Cos(toRad(lat)), 0.01)
+	rLat := int(math.Ceil(degLat / ix.cell))
+	rLon := int(math.Ceil(degLon / ix.cell))
+	if rLon > int(360/ix.cell) {
+		rLon = int(360 / ix.cell)
+	}
+	c := ix.key(lat, lon)
+	for dy := -rLat; dy <= rLat; dy++ {
+		for dx := -rLon; dx <= rLon; dx++ {
+			kx := c[1] + dx
+			// wrap around the antimeridian
+			n := int(360 / ix.cell)
+			kx = ((kx+n/2)%n+n)%n - n/2
+			for _, id := range ix.cells[[2]int{c[0] + dy, kx}] {
+				if accept != nil && !accept(id) {
+					continue
+				}
+				d := Haversine(lat, lon, ix.lats[id], ix.lons[id])
+				if d <= maxDist && d < bestD {
+					best, bestD = id, d
+				}
+			}
+		}
+	}
+	return best, bestD
+}
+
+// InBBox returns ids of points inside [minLon,minLat,maxLon,maxLat].
+func (ix *PointIndex) InBBox(minLon, minLat, maxLon, maxLat float64) []int {
+	out := []int{}
+	a := ix.key(minLat, minLon)
+	b := ix.key(maxLat, maxLon)
+	for y := a[0]; y <= b[0]; y++ {
+		for x := a[1]; x <= b[1]; x++ {
+			for _, id := range ix.cells[[2]int{y, x}] {
+				if ix.lats[id] >= minLat && ix.lats[id] <= maxLat && ix.lons[id] >= minLon && ix.lons[id] <= maxLon {
+					out = append(out, id)
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,291 @@
+// Package geocode implements offline reverse geocoding (country, region, nearest city) from
+// GeoNames dump files, so the UI can show where an aircraft is without third-party calls.
+//
+// Supported files (all tab-separated, as distributed at https://download.geonames.org/export/dump/):
+//   - cities15000.txt (or citiesNNN.txt) — required, the city gazetteer
+//   - admin1CodesASCII.txt — optional, region (state/province) names
+//   - countryInfo.txt — optional, country names
+//   - alternateNamesV2.txt — optional, localized names; only entries for loaded places are kept
+package geocode
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Files lists the GeoNames dump files to load.
+type Files struct {
+	Cities         string
+	Admin1         string
+	Countries      string
+	AlternateNames string
+	// Languages limits localized names to these ISO 639-1 codes (empty keeps all).
+	Languages []string
+}
+
+type place struct {
+	geonameID int
+	name      string
+	country   string // ISO 3166-1 alpha-2
+	admin1    string // GeoNames admin1 code, e.g. "02"
+}
+
+type named struct {
+	geonameID int
+	name      string
+}
+
+// Gazetteer is a loaded offline dataset.
+type Gazetteer struct {
+	cities    []place
+	index     *geo.PointIndex
+	regions   map[string]named // "DE.02" -> Bavaria
+	countries map[string]named // "DE" -> Germany
+	alt       map[int]map[string]string
+}
+
+// Result is a reverse geocoding answer.
+type Result struct {
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	City        string  `json:"city,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	CountryCode string  `json:"country_code,omitempty"`
+	DistanceKm  float64 `json:"distance_km,omitempty"` // distance to the nearest city
+	Label       string  `json:"label,omitempty"`       // e.g. "over Bavaria, Germany" or "near Munich"
+	Lang        string  `json:"lang,omitempty"`
+}
+
+// maxCityDist bounds nearest-city search; beyond it the position is considered remote (e.g. over water).
+const maxCityDist = 250 * geo.Kilometer
+
+var (
+	gazMu sync.RWMutex
+	gaz   *Gazetteer
+)
+
+// Load reads GeoNames files and makes the dataset available to Lookup and Handler.
+func Load(f Files) (*Gazetteer, error) {
+	g := &Gazetteer{
+		index:     geo.NewPointIndex(1),
+		regions:   map[string]named{},
+		countries: map[string]named{},
+		alt:       map[int]map[string]string{},
+	}
+	wanted := map[int]struct{}{}
+	err := eachRow(f.Cities, func(cols []string) {
+		// geonameid, name, asciiname, alternatenames, lat, lon, fclass, fcode, cc, cc2, admin1, ...
+		if len(cols) < 11 {
+			return
+		}
+		id, _ := strconv.Atoi(cols[0])
+		lat, err1 := strconv.ParseFloat(cols[4], 64)
+		lon, err2 := strconv.ParseFloat(cols[5], 64)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		g.cities = append(g.cities, place{geonameID: id, name: cols[1], country: cols[8], admin1: cols[10]})
+		g.index.Add(lat, lon)
+		wanted[id] = struct{}{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.Admin1 != "" {
+		// code (CC.A1), name, asciiname, geonameid
+		if err := eachRow(f.Admin1, func(cols []string) {
+			if len(cols) < 4 {
+				return
+			}
+			id, _ := strconv.Atoi(cols[3])
+			g.regions[cols[0]] = named{geonameID: id, name: cols[1]}
+			wanted[id] = struct{}{}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if f.Countries != "" {
+		// ISO, ISO3, ISO-Numeric, fips, Country, Capital, ..., geonameid (col 16)
+		if err := eachRow(f.Countries, func(cols []string) {
+			if len(cols) < 17 || strings.HasPrefix(cols[0], "#") {
+				return
+			}
+			id, _ := strconv.Atoi(cols[16])
+			g.countries[cols[0]] = named{geonameID: id, name: cols[4]}
+			wanted[id] = struct{}{}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if f.AlternateNames != "" {
+		langs := map[string]struct{}{}
+		for _, l := range f.Languages {
+			if l = strings.ToLower(strings.TrimSpace(l)); l != "" {
+				langs[l] = struct{}{}
+			}
+		}
+		// alternateNameId, geonameid, isolanguage, alternate name, isPreferredName, isShortName, isColloquial, isHistoric
+		if err := eachRow(f.AlternateNames, func(cols []string) {
+			if len(cols) < 4 {
+				return
+			}
+			lang := strings.ToLower(cols[2])
+			if lang == "" || len(lang) > 3 || lang == "iata" || lang == "icao" {
+				return
+			}
+			if len(langs) > 0 {
+				if _, ok := langs[lang]; !ok {
+					return
+				}
+			}
+			id, _ := strconv.Atoi(cols[1])
+			if _, ok := wanted[id]; !ok {
+				return
+			}
+			if len(cols) > 7 && (cols[6] == "1" || cols[7] == "1") {
+				return // skip colloquial/historic names
+			}
+			m := g.alt[id]
+			if m == nil {
+				m = map[string]string{}
+				g.alt[id] = m
+			}
+			// preferred names win over the first seen one
+			if _, ok := m[lang]; !ok || (len(cols) > 4 && cols[4] == "1") {
+				m[lang] = cols[3]
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	gazMu.Lock()
+	gaz = g
+	gazMu.Unlock()
+	log.Printf("geocode: loaded cities=%d regions=%d countries=%d localized=%d", len(g.cities), len(g.regions), len(g.countries), len(g.alt))
+	return g, nil
+}
+
+func eachRow(path string, fn func(cols []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 1<<20)
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			fn(strings.Split(line, "\t"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Get returns the loaded gazetteer or nil.
+func Get() *Gazetteer {
+	gazMu.RLock()
+	defer gazMu.RUnlock()
+	return gaz
+}
+
+func (g *Gazetteer) localized(n named, lang string) string {
+	if lang != "" {
+		if m := g.alt[n.geonameID]; m != nil {
+			if v, ok := m[lang]; ok {
+				return v
+			}
+		}
+	}
+	return n.name
+}
+
+// Lookup returns the nearest city, its region and country for a location.
+// lang selects localized names when alternate names were loaded (falls back to the default name).
+func (g *Gazetteer) Lookup(lat, lon float64, lang string) Result {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	res := Result{Lat: lat, Lon: lon, Lang: lang}
+	if g == nil {
+		return res
+	}
+	id, dist := g.index.Nearest(lat, lon, maxCityDist, nil)
+	if id < 0 {
+		res.Label = "remote area"
+		return res
+	}
+	c := g.cities[id]
+	res.City = g.localized(named{geonameID: c.geonameID, name: c.name}, lang)
+	res.CountryCode = c.country
+	res.DistanceKm = math.Round(dist/geo.Kilometer*10) / 10
+	if n, ok := g.regions[c.country+"."+c.admin1]; ok {
+		res.Region = g.localized(n, lang)
+	}
+	if n, ok := g.countries[c.country]; ok {
+		res.Country = g.localized(n, lang)
+	} else {
+		res.Country = c.country
+	}
+	switch {
+	case dist <= 15*geo.Kilometer:
+		res.Label = "near " + res.City
+	case res.Region != "":
+		res.Label = "over " + res.Region + ", " + res.Country
+	default:
+		res.Label = "over " + res.Country
+	}
+	return res
+}
+
+// preferredLang picks lang query param or the first Accept-Language tag.
+func preferredLang(r *http.Request) string {
+	if l := strings.TrimSpace(r.URL.Query().Get("lang")); l != "" {
+		return l
+	}
+	al := r.Header.Get("Accept-Language")
+	if al == "" {
+		return ""
+	}
+	tag := strings.TrimSpace(strings.Split(strings.Split(al, ",")[0], ";")[0])
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		tag = tag[:i]
+	}
+	if tag == "*" {
+		return ""
+	}
+	return tag
+}
+
+// Handler serves /api/geocode?lat=&lon=[&lang=de].
+func Handler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, lon, err := geo.ParseLatLon(q.Get("lat") + "," + q.Get("lon"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "lat and lon are required: "+err.Error())
+		return
+	}
+	g := Get()
+	if g == nil {
+		problem.Write(w, r, http.StatusNotFound, "geocoder dataset is not configured")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Language")
+	_ = json.NewEncoder(w).Encode(g.Lookup(lat, lon, preferredLang(r)))
+}
@@ -0,0 +1,310 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// Outbound hosts are resolved through an in-process cache (--net.dns.cache_ttl) and optionally
+// custom resolvers (--net.dns.resolvers) instead of the system resolver, which Go does not cache
+// and which is often slow or flaky in containers.
+
+// dnsStaleFor is how long an expired entry is still used when the resolver fails.
+const dnsStaleFor = 10 * time.Minute
+
+// dohTimeout bounds one DNS-over-HTTPS exchange.
+const dohTimeout = 5 * time.Second
+
+var (
+	dnsTTL       time.Duration
+	dnsResolvers []dnsServer
+	// dnsActive is the cache of the shared transports (nil without net.dns.cache_ttl)
+	dnsActive *dnsCache
+)
+
+// dnsServer is one custom resolver: plain DNS over UDP/TCP, DNS over TLS or DNS over HTTPS.
+type dnsServer struct {
+	kind string // "dns", "tcp", "tls" or "https"
+	addr string // host:port, or the DoH URL
+}
+
+// SetDNS sets the cache TTL of resolved addresses (0 disables the cache) and the custom
+// resolvers, a comma-separated list of "IP[:port]" (plain DNS), "tcp://IP[:port]",
+// "tls://HOST[:port]" (DNS over TLS) or "https://HOST/dns-query" (DNS over HTTPS). Servers are
+// used in turn, so a failed query is retried on the next one; empty uses the system resolver.
+func SetDNS(ttl time.Duration, resolvers string) error {
+	var servers []dnsServer
+	for _, s := range strings.Split(resolvers, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		srv, err := parseDNSServer(s)
+		if err != nil {
+			return fmt.Errorf("net.dns.resolvers: %w", err)
+		}
+		servers = append(servers, srv)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("net.dns.cache_ttl: must not be negative")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dnsTTL, dnsResolvers = ttl, servers
+	resetLocked()
+	return nil
+}
+
+func parseDNSServer(s string) (dnsServer, error) {
+	kind, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		kind, rest = "dns", s
+	}
+	switch kind {
+	case "dns", "udp", "tcp", "tls":
+		port := "53"
+		if kind == "tls" {
+			port = "853"
+		}
+		if kind == "udp" {
+			kind = "dns"
+		}
+		host, p, err := net.SplitHostPort(rest)
+		if err != nil {
+			host, p = strings.Trim(rest, "[]"), port
+		}
+		if host == "" {
+			return dnsServer{}, fmt.Errorf("missing host in %q", s)
+		}
+		if kind != "tls" && net.ParseIP(host) == nil {
+			return dnsServer{}, fmt.Errorf("%q: plain DNS servers must be IP addresses", s)
+		}
+		return dnsServer{kind: kind, addr: net.JoinHostPort(host, p)}, nil
+	case "https":
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return dnsServer{}, fmt.Errorf("invalid DNS-over-HTTPS URL %q", s)
+		}
+		return dnsServer{kind: "https", addr: u.String()}, nil
+	}
+	return dnsServer{}, fmt.Errorf("unsupported resolver %q (IP[:port], tcp://, tls:// or https://)", s)
+}
+
+// dialContext returns the dial function of the shared transport: dialer.DialContext when
+// neither the cache nor custom resolvers are configured. Called with mu held.
+func dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(dnsResolvers) > 0 {
+		dialer.Resolver = newResolver(dnsResolvers, &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs, InsecureSkipVerify: insecureSkipVerify})
+	}
+	if dnsTTL <= 0 {
+		return dialer.DialContext
+	}
+	// The transports (see publicTransport) share one cache
+	c := dnsActive
+	if c == nil {
+		c = &dnsCache{ttl: dnsTTL, resolver: dialer.Resolver, entries: map[string]dnsEntry{}}
+		if c.resolver == nil {
+			c.resolver = net.DefaultResolver
+		}
+		dnsActive = c
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := c.lookup(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		// Try the addresses in turn, like the dialer does without happy eyeballs
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, err
+	}
+}
+
+type dnsEntry struct {
+	ips     []string
+	expires time.Time
+}
+
+// dnsCache keeps resolved addresses of outbound hosts for ttl, and serves them for dnsStaleFor
+// more when the resolver fails.
+type dnsCache struct {
+	ttl      time.Duration
+	resolver *net.Resolver
+	mu       sync.Mutex
+	entries  map[string]dnsEntry
+}
+
+// FlushDNS drops the cached addresses, so the next connections resolve their hosts again. It
+// reports whether a cache is in use.
+func FlushDNS() bool {
+	mu.Lock()
+	c := dnsActive
+	mu.Unlock()
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	c.entries = map[string]dnsEntry{}
+	c.mu.Unlock()
+	return true
+}
+
+func (c *dnsCache) lookup(ctx context.Context, network, host string) ([]string, error) {
+	ipNet := "ip"
+	if strings.HasSuffix(network, "4") {
+		ipNet = "ip4"
+	} else if strings.HasSuffix(network, "6") {
+		ipNet = "ip6"
+	}
+	key := ipNet + "/" + strings.ToLower(host)
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		monitoring.DNSLookups.WithLabelValues("hit").Inc()
+		return e.ips, nil
+	}
+	addrs, err := c.resolver.LookupIP(ctx, ipNet, host)
+	if err != nil || len(addrs) == 0 {
+		if ok && now.Before(e.expires.Add(dnsStaleFor)) {
+			monitoring.DNSLookups.WithLabelValues("stale").Inc()
+			monitoring.Debugf("http_client dns lookup host=%s failed, using cached addresses: %v", host, err)
+			return e.ips, nil
+		}
+		monitoring.DNSLookups.WithLabelValues("error").Inc()
+		if err == nil {
+			err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, err
+	}
+	monitoring.DNSLookups.WithLabelValues("miss").Inc()
+	ips := make([]string, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.String()
+	}
+	c.mu.Lock()
+	c.entries[key] = dnsEntry{ips: ips, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return ips, nil
+}
+
+// newResolver returns a pure-Go resolver that sends its queries to servers in turn, ignoring
+// the nameservers of resolv.conf. DoT servers get a TLS stream, DoH servers a dohConn.
+func newResolver(servers []dnsServer, tlsConf *tls.Config) *net.Resolver {
+	var next atomic.Uint32
+	// DoH requests resolve the server name with the system resolver (use an IP URL to avoid it)
+	doh := &http.Client{Transport: &http.Transport{
+		DialContext:       (&net.Dialer{Timeout: dohTimeout}).DialContext,
+		ForceAttemptHTTP2: true,
+		TLSClientConfig:   tlsConf,
+		IdleConnTimeout:   90 * time.Second,
+	}}
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		srv := servers[int(next.Add(1)-1)%len(servers)]
+		d := &net.Dialer{Timeout: dohTimeout}
+		switch srv.kind {
+		case "tcp":
+			return d.DialContext(ctx, "tcp", srv.addr)
+		case "tls":
+			host, _, _ := net.SplitHostPort(srv.addr)
+			conf := tlsConf.Clone()
+			conf.ServerName = host
+			return (&tls.Dialer{NetDialer: d, Config: conf}).DialContext(ctx, "tcp", srv.addr)
+		case "https":
+			return &dohConn{url: srv.addr, client: doh}, nil
+		}
+		return d.DialContext(ctx, network, srv.addr)
+	}}
+}
+
+// dohConn carries the DNS-over-TCP exchange of the Go resolver (2-byte length prefixed
+// messages) as RFC 8484 POSTs: each query written is sent on the next Read.
+type dohConn struct {
+	url      string
+	client   *http.Client
+	deadline time.Time
+	wbuf     bytes.Buffer // queries not sent yet
+	rbuf     bytes.Buffer // length prefixed answers
+}
+
+func (c *dohConn) Write(b []byte) (int, error) { return c.wbuf.Write(b) }
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	for c.rbuf.Len() == 0 {
+		if c.wbuf.Len() < 2 {
+			return 0, io.EOF
+		}
+		n := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+n {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c.wbuf.Next(2)
+		answer, err := c.exchange(c.wbuf.Next(n))
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+		c.rbuf.Write(answer)
+	}
+	return c.rbuf.Read(b)
+}
+
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dohTimeout)
+	defer cancel()
+	if !c.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns-over-https %s: %s", c.url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "https" }
+func (dohAddr) String() string  { return "dns-over-https" }
@@ -0,0 +1,212 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// egressRule is one entry of the allow-list (--net.egress.allow).
+type egressRule struct {
+	host   string       // exact host name, or the suffix after "*." when wildcard
+	wild   bool         // "*.example.com": subdomains of host
+	prefix netip.Prefix // IP address or CIDR range (valid when set)
+	port   string       // "" allows any port
+}
+
+var (
+	egressRules  []egressRule
+	egressWarned sync.Map // hosts already logged as denied
+)
+
+// EgressError is returned for requests to hosts outside the egress allow-list, and for
+// requests of public clients (NewPublic) to non-public addresses.
+type EgressError struct {
+	Host   string
+	Reason string // why a public client refused the host; empty for the allow-list
+}
+
+func (e *EgressError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("outbound request to %s denied: %s", e.Host, e.Reason)
+	}
+	return fmt.Sprintf("outbound request to %s denied by --net.egress.allow", e.Host)
+}
+
+// IsEgressDenied reports whether err comes from a request refused by the allow-list or the
+// public address check; such requests are not worth retrying.
+func IsEgressDenied(err error) bool {
+	var e *EgressError
+	return errors.As(err, &e)
+}
+
+// Allowed reports whether the allow-list permits requests to u.
+func Allowed(u *url.URL) bool { return egressAllowed(u.Hostname(), portOf(u)) }
+
+// portOf returns the port of u, defaulting by scheme.
+func portOf(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if strings.EqualFold(u.Scheme, "http") {
+		return "80"
+	}
+	return "443"
+}
+
+// SetEgressAllow restricts outbound requests to a comma-separated list of hosts: "host",
+// "*.example.com" (any subdomain), IP addresses and CIDR ranges, each optionally with ":port"
+// (IPv6 in brackets). Empty allows every host.
+func SetEgressAllow(list string) error {
+	var rules []egressRule
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e == "" {
+			continue
+		}
+		rule, err := parseEgressRule(e)
+		if err != nil {
+			return fmt.Errorf("net.egress.allow: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	mu.Lock()
+	egressRules = rules
+	mu.Unlock()
+	if len(rules) > 0 {
+		log.Printf("outbound requests restricted to %d allowed host pattern(s): %s", len(rules), list)
+	}
+	return nil
+}
+
+func parseEgressRule(e string) (egressRule, error) {
+	if p, err := netip.ParsePrefix(e); err == nil {
+		return egressRule{prefix: p.Masked()}, nil
+	}
+	var r egressRule
+	host := e
+	if h, port, err := net.SplitHostPort(e); err == nil {
+		host, r.port = h, port
+	}
+	host = strings.Trim(host, "[]")
+	if ip, err := netip.ParseAddr(host); err == nil {
+		r.prefix = netip.PrefixFrom(ip, ip.BitLen())
+		return r, nil
+	}
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		host, r.wild = rest, true
+	}
+	if host == "" || strings.ContainsAny(host, "*/ ") {
+		return egressRule{}, fmt.Errorf("invalid host pattern %q", e)
+	}
+	r.host = host
+	return r, nil
+}
+
+// egressListed reports whether an allow-list is configured.
+func egressListed() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(egressRules) > 0
+}
+
+// nonPublic lists ranges outside the netip predicates that are not reachable on the internet:
+// "this network", carrier-grade NAT, benchmarking and reserved addresses.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// publicAddr reports whether ip is a public unicast address: not loopback, private (RFC 1918,
+// fc00::/7), link-local (169.254.0.0/16 with the cloud metadata services, fe80::/10),
+// multicast or unspecified.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly is the dialer control of public clients: it runs on the resolved address of
+// every connection, so host names pointing at internal addresses are caught too.
+func dialPublicOnly(_ context.Context, _, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil || !publicAddr(ap.Addr()) {
+		return &EgressError{Host: address, Reason: "not a public address"}
+	}
+	return nil
+}
+
+// CheckPublic applies the destination check of public clients (NewPublic) to u ahead of a
+// request: without an allow-list, u's host must only resolve to public addresses.
+func CheckPublic(ctx context.Context, u *url.URL) error {
+	if egressListed() {
+		return nil
+	}
+	return checkPublicHost(ctx, u.Hostname())
+}
+
+// checkPublicHost resolves host and fails unless all its addresses are public.
+func checkPublicHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		if !publicAddr(ip) {
+			return &EgressError{Host: host, Reason: "not a public address"}
+		}
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !publicAddr(ip) {
+			return &EgressError{Host: host, Reason: "resolves to " + ip.Unmap().String() + ", not a public address"}
+		}
+	}
+	return nil
+}
+
+// egressAllowed reports whether host (with port) may be contacted.
+func egressAllowed(host, port string) bool {
+	mu.Lock()
+	rules := egressRules
+	mu.Unlock()
+	if len(rules) == 0 {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip, ipErr := netip.ParseAddr(host)
+	for _, r := range rules {
+		if r.port != "" && r.port != port {
+			continue
+		}
+		switch {
+		case r.prefix.IsValid():
+			if ipErr == nil && r.prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		case r.wild:
+			if strings.HasSuffix(host, "."+r.host) {
+				return true
+			}
+		case host == r.host:
+			return true
+		}
+	}
+	return false
+}
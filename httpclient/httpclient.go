@@ -0,0 +1,312 @@
+// Package httpclient builds the clients of all outbound HTTP requests (OpenSky, the OTLP
+// proxy, alert webhooks, the elevation API). They share one pooled transport honoring the proxy,
+// TLS and DNS options (public clients for user-supplied URLs have their own), refuse hosts
+// outside the egress allow-list, and record a client span and per-destination metrics for
+// every request.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	mu sync.Mutex
+	// CLI proxy override (--server.proxy) and NO_PROXY list
+	proxyOverride string
+	noProxyList   string
+	// CLI-sourced Linux-style proxies (HTTP_PROXY/HTTPS_PROXY/ALL_PROXY)
+	envHTTPProxy  string
+	envHTTPSProxy string
+	envALLProxy   string
+	// Extra trusted CAs (--net.tls.ca_file) and disabled verification (--net.tls.insecure_skip_verify)
+	rootCAs            *x509.CertPool
+	insecureSkipVerify bool
+	// base is the shared transport, rebuilt on next use after the settings change; publicBase
+	// is its counterpart for public clients, which only dials public addresses
+	base       *http.Transport
+	publicBase *http.Transport
+)
+
+// SetProxy sets a CLI-provided proxy URL (overrides environment). Empty disables override.
+func SetProxy(p string) {
+	mu.Lock()
+	defer mu.Unlock()
+	proxyOverride = strings.TrimSpace(p)
+	resetLocked()
+}
+
+// SetNoProxy sets a comma-separated NO_PROXY list (CLI-provided). Empty disables bypass rules.
+func SetNoProxy(list string) {
+	mu.Lock()
+	defer mu.Unlock()
+	noProxyList = strings.TrimSpace(list)
+	resetLocked()
+}
+
+// SetEnvProxies configures per-scheme proxies provided via CLI/env flags (HTTP_PROXY/HTTPS_PROXY/ALL_PROXY)
+func SetEnvProxies(httpP, httpsP, allP string) {
+	mu.Lock()
+	defer mu.Unlock()
+	envHTTPProxy = strings.TrimSpace(httpP)
+	envHTTPSProxy = strings.TrimSpace(httpsP)
+	envALLProxy = strings.TrimSpace(allP)
+	resetLocked()
+}
+
+// SetTLS trusts the PEM certificates in caFile in addition to the system roots (for
+// TLS-intercepting proxies) and, with insecure, disables certificate verification altogether.
+func SetTLS(caFile string, insecure bool) error {
+	var pool *x509.CertPool
+	if caFile = strings.TrimSpace(caFile); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("net.tls.ca_file: %w", err)
+		}
+		if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("net.tls.ca_file: no PEM certificates in %s", caFile)
+		}
+		log.Printf("outbound TLS: trusting the CA certificates in %s in addition to the system roots", caFile)
+	}
+	if insecure {
+		log.Printf("WARNING: outbound TLS certificate verification is DISABLED (--net.tls.insecure_skip_verify); " +
+			"OpenSky credentials, webhooks and telemetry can be intercepted. Use --net.tls.ca_file instead.")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	rootCAs, insecureSkipVerify = pool, insecure
+	resetLocked()
+	return nil
+}
+
+// resetLocked drops the shared transports so the next request rebuilds them with the new settings.
+func resetLocked() {
+	for _, t := range []**http.Transport{&base, &publicBase} {
+		if *t != nil {
+			(*t).CloseIdleConnections()
+			*t = nil
+		}
+	}
+	dnsActive = nil
+}
+
+// New returns a client for the named destination (e.g. "opensky", "webhook"), which labels its
+// metrics and spans. Clients are cheap: connections are pooled by the shared transport.
+func New(name string, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: &roundTripper{name: name}, Timeout: timeout}
+}
+
+// NewPublic returns a client like New for URLs supplied by API users (alert webhooks): unless
+// an egress allow-list is configured, it refuses loopback, private, link-local and other
+// non-public destinations, checked on the addresses dialed after DNS resolution.
+func NewPublic(name string, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: &roundTripper{name: name, public: true}, Timeout: timeout}
+}
+
+// transport returns the shared transport, building it on first use.
+func transport() *http.Transport {
+	mu.Lock()
+	defer mu.Unlock()
+	if base == nil {
+		base = newTransport(&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second})
+	}
+	return base
+}
+
+// publicTransport returns the transport of public clients. It pools its own connections, so a
+// connection opened to an internal service by another client is never reused for them.
+func publicTransport() *http.Transport {
+	mu.Lock()
+	defer mu.Unlock()
+	if publicBase == nil {
+		publicBase = newTransport(&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, ControlContext: dialPublicOnly})
+	}
+	return publicBase
+}
+
+// newTransport builds a transport dialing with dialer. Called with mu held.
+func newTransport(dialer *net.Dialer) *http.Transport {
+	t := &http.Transport{
+		Proxy:                 proxyFunc(),
+		DialContext:           dialContext(dialer),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		// Verification is only skipped on explicit request, with the warnings of SetTLS
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs, InsecureSkipVerify: insecureSkipVerify},
+	}
+	source := "cli-env"
+	if proxyOverride != "" {
+		source = "cli-override"
+	}
+	monitoring.Debugf("http_client configured source=%s no_proxy=%q custom_ca=%t insecure=%t dns_ttl=%s resolvers=%d",
+		source, noProxyList, rootCAs != nil, insecureSkipVerify, dnsTTL, len(dnsResolvers))
+	return t
+}
+
+// proxyFunc selects the proxy of a request: the CLI override when set, else the per-scheme
+// proxies (HTTPS_PROXY for https, HTTP_PROXY for http, ALL_PROXY otherwise); hosts matching
+// NO_PROXY connect directly. Called with mu held.
+func proxyFunc() func(*http.Request) (*url.URL, error) {
+	override, noProxy := proxyOverride, noProxyList
+	httpP, httpsP, allP := envHTTPProxy, envHTTPSProxy, envALLProxy
+	if override != "" {
+		purl, err := url.Parse(override)
+		if err != nil || purl.Host == "" {
+			return nil
+		}
+		return func(req *http.Request) (*url.URL, error) {
+			if noProxyMatch(noProxy, req.URL.Hostname()) {
+				return nil, nil
+			}
+			return purl, nil
+		}
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if req == nil || req.URL == nil || noProxyMatch(noProxy, req.URL.Hostname()) {
+			return nil, nil
+		}
+		var candidate string
+		scheme := strings.ToLower(req.URL.Scheme)
+		if scheme == "https" && httpsP != "" {
+			candidate = httpsP
+		} else if scheme == "http" && httpP != "" {
+			candidate = httpP
+		} else if allP != "" {
+			candidate = allP
+		}
+		if candidate == "" {
+			return nil, nil
+		}
+		purl, err := url.Parse(candidate)
+		if err != nil || purl.Host == "" {
+			return nil, nil
+		}
+		return purl, nil
+	}
+}
+
+// noProxyMatch reports whether host should bypass proxy according to the NO_PROXY list.
+func noProxyMatch(list, host string) bool {
+	if host == "" || strings.TrimSpace(list) == "" {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, token := range strings.Split(list, ",") {
+		t := strings.ToLower(strings.TrimSpace(token))
+		if t == "" {
+			continue
+		}
+		if t == "*" {
+			return true
+		}
+		// strip port in token if any
+		if h, _, err := net.SplitHostPort(t); err == nil {
+			t = h
+		}
+		// strip port from host too
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// leading dot means suffix match
+		if strings.HasPrefix(t, ".") {
+			if strings.HasSuffix(host, t) || host == strings.TrimPrefix(t, ".") {
+				return true
+			}
+			continue
+		}
+		// exact or subdomain match
+		if host == t || strings.HasSuffix(host, "."+t) {
+			return true
+		}
+	}
+	return false
+}
+
+// insecureWarned records the destinations already warned about skipped verification.
+var insecureWarned sync.Map
+
+// roundTripper instruments requests of one destination and sends them over the shared transport.
+type roundTripper struct {
+	name   string
+	public bool // only public destinations (NewPublic)
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !egressAllowed(req.URL.Hostname(), portOf(req.URL)) {
+		return nil, rt.denied(req, &EgressError{Host: req.URL.Host})
+	}
+	// The span carries the URL without query or credentials
+	u := *req.URL
+	u.RawQuery, u.User = "", nil
+	ctx, span := monitoring.StartClientSpan(req.Context(), rt.name+" "+req.Method, u.String(), req.Method)
+	defer span.End()
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	t := transport()
+	if rt.public && !egressListed() {
+		// Proxied requests are resolved by the proxy: check the addresses of the host beforehand
+		if p, _ := t.Proxy(req); p != nil {
+			if err := checkPublicHost(ctx, req.URL.Hostname()); err != nil {
+				return nil, rt.denied(req, err)
+			}
+		} else {
+			t = publicTransport()
+		}
+	}
+	if t.TLSClientConfig.InsecureSkipVerify && req.URL.Scheme == "https" {
+		if _, warned := insecureWarned.LoadOrStore(rt.name, true); !warned {
+			log.Printf("WARNING: %s: not verifying the TLS certificate of %s", rt.name, req.URL.Host)
+		}
+	}
+	start := time.Now()
+	resp, err := t.RoundTrip(req)
+	monitoring.HTTPClientDuration.WithLabelValues(rt.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if IsEgressDenied(err) { // refused at dial time (public clients)
+			return nil, rt.denied(req, err)
+		}
+		monitoring.HTTPClientRequests.WithLabelValues(rt.name, "error").Inc()
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	monitoring.HTTPClientRequests.WithLabelValues(rt.name, strconv.Itoa(resp.StatusCode/100)+"xx").Inc()
+	return resp, nil
+}
+
+// denied logs (once per host) and counts a refused request and returns err.
+func (rt *roundTripper) denied(req *http.Request, err error) error {
+	if _, warned := egressWarned.LoadOrStore(req.URL.Host, true); !warned {
+		log.Printf("WARNING: %s: %v", rt.name, err)
+	}
+	monitoring.HTTPClientRequests.WithLabelValues(rt.name, "denied").Inc()
+	return err
+}
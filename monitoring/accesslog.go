@@ -0,0 +1,182 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogConfig configures the HTTP access log written separately from application logs.
+type AccessLogConfig struct {
+	Path       string
+	Format     string        // "combined" (Apache) or "json"
+	MaxSize    int64         // rotate when the file exceeds this many bytes (0 = never)
+	MaxAge     time.Duration // rotate when the file is older than this (0 = never)
+	MaxBackups int           // rotated files to keep (0 = keep all)
+}
+
+// rotatingFile is an append-only file rotated by size/age; Reopen supports external logrotate.
+type rotatingFile struct {
+	mu     sync.Mutex
+	cfg    AccessLogConfig
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+var accessLog *rotatingFile
+
+// OpenAccessLog starts writing access logs to cfg.Path. While enabled, request lines are no longer
+// written to the application log.
+func OpenAccessLog(cfg AccessLogConfig) error {
+	switch cfg.Format {
+	case "", "combined":
+		cfg.Format = "combined"
+	case "json":
+	default:
+		return fmt.Errorf("unknown access log format %q (want combined or json)", cfg.Format)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return err
+	}
+	rf := &rotatingFile{cfg: cfg}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	accessLog = rf
+	log.Printf("access log path=%s format=%s max_size=%d max_age=%s", cfg.Path, cfg.Format, cfg.MaxSize, cfg.MaxAge)
+	return nil
+}
+
+// CloseAccessLog flushes and closes the access log file.
+func CloseAccessLog() {
+	if accessLog == nil {
+		return
+	}
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+	if accessLog.f != nil {
+		_ = accessLog.f.Close()
+		accessLog.f = nil
+	}
+}
+
+// ReopenAccessLog closes and reopens the access log file (after an external rename).
+func ReopenAccessLog() {
+	if accessLog == nil {
+		return
+	}
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+	if accessLog.f != nil {
+		_ = accessLog.f.Close()
+	}
+	if err := accessLog.open(); err != nil {
+		log.Printf("access log reopen error: %v", err)
+		return
+	}
+	log.Printf("access log reopened path=%s", accessLog.cfg.Path)
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, 0, time.Now()
+	if st, err := f.Stat(); err == nil {
+		rf.size = st.Size()
+		if st.Size() > 0 {
+			rf.opened = st.ModTime()
+		}
+	}
+	return nil
+}
+
+func (rf *rotatingFile) write(line []byte) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return
+	}
+	if (rf.cfg.MaxSize > 0 && rf.size+int64(len(line)) > rf.cfg.MaxSize && rf.size > 0) ||
+		(rf.cfg.MaxAge > 0 && time.Since(rf.opened) > rf.cfg.MaxAge && rf.size > 0) {
+		if err := rf.rotate(); err != nil {
+			log.Printf("access log rotate error: %v", err)
+		}
+	}
+	n, err := rf.f.Write(line)
+	rf.size += int64(n)
+	if err != nil {
+		log.Printf("access log write error: %v", err)
+	}
+}
+
+// rotate renames the current file to <path>.<timestamp> and prunes old backups.
+func (rf *rotatingFile) rotate() error {
+	_ = rf.f.Close()
+	rf.f = nil
+	backup := rf.cfg.Path + "." + time.Now().UTC().Format("20060102-150405.000")
+	if err := os.Rename(rf.cfg.Path, backup); err != nil {
+		return err
+	}
+	if rf.cfg.MaxBackups > 0 {
+		old, _ := filepath.Glob(rf.cfg.Path + ".*")
+		sort.Strings(old)
+		for len(old) > rf.cfg.MaxBackups {
+			_ = os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+	return rf.open()
+}
+
+// writeAccessLog renders one request in the configured format.
+func writeAccessLog(r *http.Request, status int, bytes int64, dur time.Duration, start time.Time, path, rid string) {
+	rf := accessLog
+	if rf == nil {
+		return
+	}
+	var line []byte
+	if rf.cfg.Format == "json" {
+		rec := map[string]any{
+			"time":        start.UTC().Format(time.RFC3339Nano),
+			"remote":      ClientIP(r),
+			"method":      r.Method,
+			"path":        path,
+			"proto":       r.Proto,
+			"status":      status,
+			"bytes":       bytes,
+			"duration_ms": float64(dur.Microseconds()) / 1000,
+			"referer":     r.Referer(),
+			"ua":          r.UserAgent(),
+			"request_id":  rid,
+		}
+		line, _ = json.Marshal(rec)
+		line = append(line, '\n')
+	} else {
+		size := "-"
+		if bytes > 0 {
+			size = strconv.FormatInt(bytes, 10)
+		}
+		line = []byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q\n",
+			ClientIP(r), start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, strings.ReplaceAll(path, "\"", "%22"), r.Proto,
+			status, size, orDash(r.Referer()), orDash(r.UserAgent())))
+	}
+	rf.write(line)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package monitoring
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchAccessLogReopen reopens the access log on SIGUSR1 until stop is closed.
+func WatchAccessLogReopen(stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ch:
+				ReopenAccessLog()
+			}
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package monitoring
+
+// WatchAccessLogReopen is a no-op on Windows (no SIGUSR1); size/age rotation still applies.
+func WatchAccessLogReopen(stop <-chan struct{}) {}
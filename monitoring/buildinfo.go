@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version and Commit are set at build time:
+//
+//	-ldflags "-X github.com/maniack/miniflightradar/monitoring.Version=v1.2.3 -X github.com/maniack/miniflightradar/monitoring.Commit=abc123"
+//
+// Without ldflags they fall back to the module version and VCS revision embedded by the Go toolchain.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+var (
+	BuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "build_info",
+			Help:      "Build information (constant 1) labeled by version, commit and Go version",
+		},
+		[]string{"version", "commit", "goversion"},
+	)
+	FeatureEnabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "feature_enabled",
+			Help:      "Whether an optional feature is enabled (1) or disabled (0)",
+		},
+		[]string{"feature"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(BuildInfo, FeatureEnabled)
+	v, c := BuildVersion()
+	BuildInfo.WithLabelValues(v, c, runtime.Version()).Set(1)
+}
+
+// BuildVersion returns the version and commit of the running binary ("dev"/"unknown" when not recorded).
+func BuildVersion() (version, commit string) {
+	version, commit = Version, Commit
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			version = bi.Main.Version
+		}
+		if commit == "" {
+			dirty := false
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					commit = s.Value
+				case "vcs.modified":
+					dirty = s.Value == "true"
+				}
+			}
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			if commit != "" && dirty {
+				commit += "-dirty"
+			}
+		}
+	}
+	if version == "" {
+		version = "dev"
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	return version, commit
+}
+
+// SetFeatures reports which optional features are enabled in this deployment.
+func SetFeatures(features map[string]bool) {
+	names := make([]string, 0, len(features))
+	for name, on := range features {
+		v := 0.0
+		if on {
+			v = 1
+			names = append(names, name)
+		}
+		FeatureEnabled.WithLabelValues(name).Set(v)
+	}
+	sort.Strings(names)
+	version, commit := BuildVersion()
+	Debugf("build version=%s commit=%s features=%s", version, commit, strings.Join(names, ","))
+}
@@ -0,0 +1,192 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Crash is one recovered panic with its stack trace.
+type Crash struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+	Restarted bool      `json:"restarted"`
+}
+
+var (
+	crashMu   sync.Mutex
+	crashDir  string
+	crashKeep = 50
+	crashMem  []Crash // recent crashes when no crash directory is configured
+)
+
+// SetCrashDir stores crash reports as JSON files in dir, keeping the newest keep reports.
+// Without a directory the most recent reports are kept in memory only.
+func SetCrashDir(dir string, keep int) error {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if keep > 0 {
+		crashKeep = keep
+	}
+	crashDir = dir
+	if dir == "" {
+		return nil
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+// RecordPanic logs a recovered panic, counts it, stores a crash report and exports it as an
+// errored span to the tracing endpoint when one is configured.
+func RecordPanic(component string, v any, stack []byte, restarted bool) {
+	now := time.Now().UTC()
+	c := Crash{
+		ID:        now.Format("20060102T150405.000000000Z"),
+		Time:      now,
+		Component: component,
+		Panic:     fmt.Sprint(v),
+		Stack:     string(stack),
+		Restarted: restarted,
+	}
+	log.Printf("panic component=%s restarted=%t error=%q\n%s", component, restarted, c.Panic, stack)
+	Panics.WithLabelValues(component).Inc()
+
+	_, span := tracer.Start(context.Background(), "panic "+component, trace.WithTimestamp(now))
+	span.SetAttributes(attribute.String("component", component), attribute.Bool("restarted", restarted))
+	span.RecordError(fmt.Errorf("panic: %s", c.Panic), trace.WithStackTrace(false),
+		trace.WithAttributes(attribute.String("exception.stacktrace", c.Stack)))
+	span.SetStatus(codes.Error, c.Panic)
+	span.End()
+
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if crashDir == "" {
+		crashMem = append(crashMem, c)
+		if len(crashMem) > crashKeep {
+			crashMem = crashMem[len(crashMem)-crashKeep:]
+		}
+		return
+	}
+	b, _ := json.MarshalIndent(c, "", "  ")
+	if err := os.WriteFile(filepath.Join(crashDir, "crash-"+c.ID+".json"), b, 0o644); err != nil {
+		log.Printf("crash store write error: %v", err)
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(crashDir, "crash-*.json"))
+	sort.Strings(files)
+	for len(files) > crashKeep {
+		_ = os.Remove(files[0])
+		files = files[1:]
+	}
+}
+
+// Recover is deferred at the top of goroutines that must not take the process down; the
+// goroutine ends after the panic is recorded.
+func Recover(component string) {
+	if v := recover(); v != nil {
+		RecordPanic(component, v, debug.Stack(), false)
+	}
+}
+
+// Supervise runs fn and restarts it with exponential backoff (1s..1m) when it panics, until
+// stop is closed. A normal return of fn ends supervision.
+func Supervise(component string, stop <-chan struct{}, fn func()) {
+	backoff := time.Second
+	for {
+		panicked := func() (panicked bool) {
+			defer func() {
+				if v := recover(); v != nil {
+					RecordPanic(component, v, debug.Stack(), true)
+					panicked = true
+				}
+			}()
+			fn()
+			return false
+		}()
+		if !panicked {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		log.Printf("restarting component=%s after panic", component)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// Crashes returns stored crash reports, newest first (at most limit).
+func Crashes(limit int) []Crash {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	var out []Crash
+	if crashDir == "" {
+		for i := len(crashMem) - 1; i >= 0; i-- {
+			out = append(out, crashMem[i])
+		}
+	} else {
+		files, _ := filepath.Glob(filepath.Join(crashDir, "crash-*.json"))
+		sort.Sort(sort.Reverse(sort.StringSlice(files)))
+		for _, f := range files {
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+			b, err := os.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			var c Crash
+			if json.Unmarshal(b, &c) == nil {
+				out = append(out, c)
+			}
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// CrashesHandler lists recorded panics (admin): GET ?limit=20&component=ingest.
+func CrashesHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			problem.Write(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	comp := r.URL.Query().Get("component")
+	out := []Crash{}
+	for _, c := range Crashes(0) {
+		if comp != "" && !strings.HasPrefix(c.Component, comp) {
+			continue
+		}
+		out = append(out, c)
+		if len(out) >= limit {
+			break
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,176 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// SubsystemLog controls debug logging of one subsystem (ws, ingest, storage, ...).
+// A line is written when global debug or the subsystem is enabled, then 1-in-Sample lines per
+// call site are kept and at most Rate lines per second per call site are written.
+type SubsystemLog struct {
+	Enabled bool    `json:"enabled"`
+	Sample  int     `json:"sample"` // keep 1 in N lines (<= 1 keeps all)
+	Rate    float64 `json:"rate"`   // max lines per second per call site (0 = unlimited)
+}
+
+type logSite struct {
+	count      int64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+}
+
+var (
+	subMu      sync.Mutex
+	subConfigs = map[string]SubsystemLog{
+		"ws":      {Sample: 1, Rate: 5},
+		"ingest":  {Sample: 1, Rate: 5},
+		"storage": {Sample: 1, Rate: 5},
+		"terrain": {Sample: 1, Rate: 5},
+		"metrics": {Sample: 1, Rate: 5},
+	}
+	subSites = map[string]*logSite{}
+)
+
+// SubDebugf writes a debug line for subsystem sub subject to its toggle, sampling and rate limit.
+// The format string identifies the call site, so per-frame/per-item lines are limited independently.
+func SubDebugf(sub, format string, args ...interface{}) {
+	subMu.Lock()
+	cfg, ok := subConfigs[sub]
+	if !ok {
+		cfg = SubsystemLog{Sample: 1}
+	}
+	if !cfg.Enabled && !IsDebug() {
+		subMu.Unlock()
+		return
+	}
+	key := sub + "\x00" + format
+	site := subSites[key]
+	if site == nil {
+		site = &logSite{tokens: cfg.Rate, last: time.Now()}
+		subSites[key] = site
+	}
+	site.count++
+	if cfg.Sample > 1 && (site.count-1)%int64(cfg.Sample) != 0 {
+		subMu.Unlock()
+		return
+	}
+	if cfg.Rate > 0 {
+		now := time.Now()
+		// token bucket with a burst of one second worth of lines
+		site.tokens = min(cfg.Rate, site.tokens+now.Sub(site.last).Seconds()*cfg.Rate)
+		site.last = now
+		if site.tokens < 1 {
+			site.suppressed++
+			subMu.Unlock()
+			return
+		}
+		site.tokens--
+	}
+	suppressed := site.suppressed
+	site.suppressed = 0
+	subMu.Unlock()
+	if suppressed > 0 {
+		log.Printf("DEBUG ["+sub+"] "+format+" (suppressed=%d)", append(args, suppressed)...)
+		return
+	}
+	log.Printf("DEBUG ["+sub+"] "+format, args...)
+}
+
+// SetSubsystemLog replaces the configuration of a subsystem.
+func SetSubsystemLog(sub string, cfg SubsystemLog) {
+	if cfg.Sample < 1 {
+		cfg.Sample = 1
+	}
+	if cfg.Rate < 0 {
+		cfg.Rate = 0
+	}
+	subMu.Lock()
+	subConfigs[sub] = cfg
+	// reset call-site state so new limits apply immediately
+	for k := range subSites {
+		if len(k) > len(sub) && k[:len(sub)+1] == sub+"\x00" {
+			delete(subSites, k)
+		}
+	}
+	subMu.Unlock()
+}
+
+// SubsystemLogs returns the current per-subsystem configuration.
+func SubsystemLogs() map[string]SubsystemLog {
+	subMu.Lock()
+	defer subMu.Unlock()
+	out := make(map[string]SubsystemLog, len(subConfigs))
+	for k, v := range subConfigs {
+		out[k] = v
+	}
+	return out
+}
+
+// LogConfigHandler serves the admin log configuration: GET returns it; PUT/POST accepts
+// {"level":"debug|info","subsystems":{"ws":{"enabled":true,"sample":10,"rate":2}}} (partial).
+func LogConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		var req struct {
+			Level      string                  `json:"level"`
+			Subsystems map[string]SubsystemLog `json:"subsystems"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		known := SubsystemLogs()
+		names := make([]string, 0, len(req.Subsystems))
+		for name := range req.Subsystems {
+			if _, ok := known[name]; !ok {
+				problem.Write(w, r, http.StatusBadRequest, "unknown subsystem: "+name)
+				return
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			SetSubsystemLog(name, req.Subsystems[name])
+		}
+		if req.Level != "" {
+			SetLogLevel(req.Level)
+		}
+		log.Printf("admin: log config updated level=%q subsystems=%v", req.Level, names)
+	}
+	level := "info"
+	if IsDebug() {
+		level = "debug"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"level": level, "subsystems": SubsystemLogs()})
+}
+
+// ConfigureSubsystemLogs applies the startup flags: enabled is a comma-separated subsystem list
+// ("all" enables every subsystem); sample and rate apply to every subsystem.
+func ConfigureSubsystemLogs(enabled string, sample int, rate float64) error {
+	on := map[string]bool{}
+	for _, name := range strings.Split(enabled, ",") {
+		if name = strings.TrimSpace(strings.ToLower(name)); name != "" {
+			on[name] = true
+		}
+	}
+	known := SubsystemLogs()
+	for name := range on {
+		if _, ok := known[name]; !ok && name != "all" {
+			return fmt.Errorf("unknown log subsystem %q", name)
+		}
+	}
+	for name := range known {
+		SetSubsystemLog(name, SubsystemLog{Enabled: on["all"] || on[name], Sample: sample, Rate: rate})
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// LogRecord is one captured application log line.
+type LogRecord struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`  // debug, info, warn, error
+	Module  string    `json:"module"` // subsystem tag or first word of the message
+	Message string    `json:"msg"`
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// logRing keeps the most recent log records and fans new ones out to stream subscribers.
+type logRing struct {
+	mu   sync.Mutex
+	buf  []LogRecord
+	next int64
+	subs map[chan LogRecord]struct{}
+}
+
+var recentLogs *logRing
+
+// CaptureLogs tees the standard logger into an in-process ring buffer of size records,
+// which backs the admin log stream. It must be called before other goroutines log.
+func CaptureLogs(size int) {
+	if size <= 0 {
+		return
+	}
+	recentLogs = &logRing{buf: make([]LogRecord, 0, size), subs: map[chan LogRecord]struct{}{}}
+	log.SetOutput(io.MultiWriter(os.Stderr, recentLogs))
+}
+
+// Write parses one line emitted by the standard logger; it never logs itself.
+func (lr *logRing) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	// strip the default "2006/01/02 15:04:05 " prefix
+	if len(line) > 20 && line[4] == '/' && line[7] == '/' && line[13] == ':' {
+		line = line[20:]
+	}
+	rec := LogRecord{Time: time.Now().UTC(), Level: "info", Message: line}
+	if rest, ok := strings.CutPrefix(line, "DEBUG "); ok {
+		rec.Level = "debug"
+		line = rest
+	} else if l := strings.ToLower(line); strings.Contains(l, "error") || strings.Contains(l, "panic") || strings.Contains(l, "fatal") {
+		rec.Level = "error"
+	} else if strings.Contains(l, "denied") || strings.Contains(l, "warn") || strings.Contains(l, "rate-limited") {
+		rec.Level = "warn"
+	}
+	if strings.HasPrefix(line, "[") {
+		if i := strings.IndexByte(line, ']'); i > 0 {
+			rec.Module = line[1:i]
+		}
+	} else if strings.HasPrefix(line, "http_request ") {
+		rec.Module = "http"
+	} else if i := strings.IndexAny(line, " =:"); i > 0 {
+		rec.Module = strings.ToLower(line[:i])
+	} else {
+		rec.Module = strings.ToLower(line)
+	}
+
+	lr.mu.Lock()
+	lr.next++
+	rec.Seq = lr.next
+	if len(lr.buf) < cap(lr.buf) {
+		lr.buf = append(lr.buf, rec)
+	} else {
+		lr.buf[int((rec.Seq-1)%int64(cap(lr.buf)))] = rec
+	}
+	for ch := range lr.subs {
+		select {
+		case ch <- rec:
+		default: // slow subscriber: drop rather than block logging
+		}
+	}
+	lr.mu.Unlock()
+	return len(p), nil
+}
+
+// since returns buffered records with Seq > seq in order, at most limit (newest kept).
+func (lr *logRing) since(seq int64, limit int) []LogRecord {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	n := len(lr.buf)
+	out := make([]LogRecord, 0, n)
+	start := 0
+	if n == cap(lr.buf) {
+		start = int(lr.next % int64(n))
+	}
+	for i := 0; i < n; i++ {
+		if rec := lr.buf[(start+i)%n]; rec.Seq > seq {
+			out = append(out, rec)
+		}
+	}
+	if limit >= 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+func (lr *logRing) subscribe() chan LogRecord {
+	ch := make(chan LogRecord, 256)
+	lr.mu.Lock()
+	lr.subs[ch] = struct{}{}
+	lr.mu.Unlock()
+	return ch
+}
+
+func (lr *logRing) unsubscribe(ch chan LogRecord) {
+	lr.mu.Lock()
+	delete(lr.subs, ch)
+	lr.mu.Unlock()
+}
+
+// LogStreamHandler streams captured log records as Server-Sent Events.
+// Query: level (minimum: debug|info|warn|error), module (comma-separated), backlog (records replayed
+// first, default 100). Reconnecting clients resume after Last-Event-ID.
+func LogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	lr := recentLogs
+	if lr == nil {
+		problem.Write(w, r, http.StatusNotFound, "log capture disabled")
+		return
+	}
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		problem.Write(w, r, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	q := r.URL.Query()
+	minLevel := 0
+	if lv := strings.ToLower(q.Get("level")); lv != "" {
+		l, ok := logLevels[lv]
+		if !ok {
+			problem.Write(w, r, http.StatusBadRequest, "invalid level (debug|info|warn|error)")
+			return
+		}
+		minLevel = l
+	}
+	modules := map[string]bool{}
+	for _, m := range strings.Split(q.Get("module"), ",") {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			modules[m] = true
+		}
+	}
+	match := func(rec LogRecord) bool {
+		return logLevels[rec.Level] >= minLevel && (len(modules) == 0 || modules[rec.Module])
+	}
+	backlog := 100
+	if v := q.Get("backlog"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			problem.Write(w, r, http.StatusBadRequest, "invalid backlog")
+			return
+		}
+		backlog = n
+	}
+	var after int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			after, backlog = n, -1
+		}
+	}
+
+	ch := lr.subscribe()
+	defer lr.unsubscribe(ch)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	last := after
+	send := func(rec LogRecord) bool {
+		if rec.Seq <= last {
+			return true
+		}
+		last = rec.Seq
+		if !match(rec) {
+			return true
+		}
+		b, _ := json.Marshal(rec)
+		_, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", rec.Seq, b)
+		return err == nil
+	}
+	var replay []LogRecord
+	buffered := lr.since(after, -1)
+	for _, rec := range buffered {
+		if match(rec) {
+			replay = append(replay, rec)
+		}
+	}
+	if backlog >= 0 && len(replay) > backlog {
+		replay = replay[len(replay)-backlog:]
+	}
+	for _, rec := range replay {
+		if !send(rec) {
+			return
+		}
+	}
+	if n := len(buffered); n > 0 && buffered[n-1].Seq > last {
+		last = buffered[n-1].Seq
+	}
+	fl.Flush()
+
+	hb := time.NewTicker(15 * time.Second)
+	defer hb.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case rec := <-ch:
+			if !send(rec) {
+				return
+			}
+			fl.Flush()
+		case <-hb.C:
+			if _, err := io.WriteString(w, ": hb\n\n"); err != nil {
+				return
+			}
+			fl.Flush()
+		}
+	}
+}
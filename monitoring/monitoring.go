@@ -108,6 +108,417 @@ var (
 		},
 		[]string{"method", "path"},
 	)
+
+	// Ingest metrics
+	IngestFiltered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ingest",
+			Name:      "filtered_total",
+			Help:      "Number of states dropped by ingest filters",
+		},
+		[]string{"reason"},
+	)
+
+	IngestPoints = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ingest",
+			Name:      "points_total",
+			Help:      "Number of new position samples stored",
+		},
+	)
+
+	AircraftCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "ingest",
+			Name:      "aircraft_current",
+			Help:      "Number of aircraft in the current state by region (all, local = within receiver range)",
+		},
+		[]string{"region"},
+	)
+
+	WSClosures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "closures_total",
+			Help:      "WebSocket terminations by handler and cause (client_close, read_error, write_timeout, write_error, evicted, quota_exceeded, auth_failure, overload_rejected, server_shutdown, server_error, panic)",
+		},
+		[]string{"handler", "cause"},
+	)
+	WSQuotaEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "quota_events_total",
+			Help:      "WebSocket bandwidth quota events (exceeded: a client minute over the quota, throttled, diff_delayed, disconnected)",
+		},
+		[]string{"event"},
+	)
+	WSDeflateBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "deflate_bytes_total",
+			Help:      "WebSocket message bytes compressed with permessage-deflate, before (uncompressed) and after (compressed)",
+		},
+		[]string{"kind"},
+	)
+	WSQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "queue_depth",
+			Help:      "Updates waiting in the send queues of /ws/flights connections",
+		},
+	)
+	WSQueueCoalesced = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "queue_coalesced_total",
+			Help:      "Queued updates merged into a single cumulative diff with others",
+		},
+	)
+	WSQueueDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ws",
+			Name:      "queue_dropped_total",
+			Help:      "Queued updates discarded without a diff (empty: nothing changed in view, closed: the connection ended first)",
+		},
+		[]string{"reason"},
+	)
+	GRPCStreams = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "streams",
+			Help:      "Open gRPC WatchFlights streams",
+		},
+	)
+	// ClusterLeader is 1 while this instance holds the cluster lease (--cluster.redis)
+	ClusterLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cluster",
+			Name:      "leader",
+			Help:      "1 while this instance is the cluster leader polling OpenSky",
+		},
+	)
+	ClusterBatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cluster",
+			Name:      "batches_total",
+			Help:      "Ingest batches shared over Redis by result (published, applied, error)",
+		},
+		[]string{"result"},
+	)
+	SSEClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sse",
+			Name:      "clients",
+			Help:      "Connected /sse/flights clients",
+		},
+	)
+	LoadShedding = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "load",
+			Name:      "shedding",
+			Help:      "1 while load shedding is active",
+		},
+	)
+	LoadPressure = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "load",
+			Name:      "pressure",
+			Help:      "Overload signals: scheduler_lag_seconds, ingest_seconds, ws_backlog_ratio",
+		},
+		[]string{"signal"},
+	)
+	ShedEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "load",
+			Name:      "shed_total",
+			Help:      "Load-shedding actions (enter, exit, ws_rejected, diff_delayed, trails_dropped)",
+		},
+		[]string{"action"},
+	)
+	Panics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Number of recovered panics per component",
+		},
+		[]string{"component"},
+	)
+	SBSMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sbs",
+			Name:      "messages_total",
+			Help:      "Lines read from the SBS receiver feed by result (ok, ignored)",
+		},
+		[]string{"result"},
+	)
+	SBSConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sbs",
+			Name:      "connected",
+			Help:      "1 while connected to the SBS receiver feed",
+		},
+	)
+	MQTTPublished = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "mqtt",
+			Name:      "published_total",
+			Help:      "Number of positions published to the MQTT broker by result (ok, error, dropped)",
+		},
+		[]string{"result"},
+	)
+	MQTTConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "mqtt",
+			Name:      "connected",
+			Help:      "1 while connected to the MQTT broker",
+		},
+	)
+	JobRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "jobs",
+			Name:      "runs_total",
+			Help:      "Scheduled job runs by result (ok, error, panic, skipped)",
+		},
+		[]string{"job", "result"},
+	)
+	JobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "jobs",
+			Name:      "duration_seconds",
+			Help:      "Duration of scheduled job runs",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		},
+		[]string{"job"},
+	)
+	StorageSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "size_bytes",
+			Help:      "Total size of the database files after the last compaction",
+		},
+	)
+	StorageReclaimed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "reclaimed_bytes_total",
+			Help:      "Bytes freed by storage compaction",
+		},
+	)
+	StorageCompacted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "compacted_samples_total",
+			Help:      "Position samples removed by compaction (downsample, cap) and the retention purge",
+		},
+		[]string{"reason"},
+	)
+	RemoteWriteErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "remote_write",
+			Name:      "errors_total",
+			Help:      "Number of failed remote-write pushes",
+		},
+	)
+
+	// Spotting metrics
+	FirstSightings = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "spotting",
+			Name:      "first_sightings_total",
+			Help:      "Number of operators/aircraft types seen locally for the first time",
+		},
+		[]string{"kind"},
+	)
+
+	RareSightings = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "spotting",
+			Name:      "rare_sightings_total",
+			Help:      "Number of sightings matching the rare_aircraft rule",
+		},
+	)
+
+	NewAirframes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "spotting",
+			Name:      "new_airframes_total",
+			Help:      "Number of airframes reported to the new_airframe rule (seen for the first time)",
+		},
+	)
+
+	// Alert metrics
+	AlertEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "alerts",
+			Name:      "events_total",
+			Help:      "Number of fired alerts by type (enter, exit, match, rare)",
+		},
+		[]string{"type"},
+	)
+
+	AlertWebhooks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "alerts",
+			Name:      "webhooks_total",
+			Help:      "Number of alert webhook deliveries by result (ok, error, dropped)",
+		},
+		[]string{"result"},
+	)
+
+	// Outbound HTTP client metrics (httpclient package), per destination
+	HTTPClientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "requests_total",
+			Help:      "Number of outbound HTTP requests by destination and status class (2xx..5xx, error)",
+		},
+		[]string{"destination", "status"},
+	)
+	HTTPClientDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "duration_seconds",
+			Help:      "Duration of outbound HTTP requests until the response headers, by destination",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"destination"},
+	)
+
+	DNSLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http_client",
+			Name:      "dns_lookups_total",
+			Help:      "Host lookups of outbound requests by result (hit, miss, stale, error)",
+		},
+		[]string{"result"},
+	)
+
+	// HookRequests counts inbound webhook calls (/api/hooks/*) by result
+	HookRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "hook_requests_total",
+			Help:      "Inbound webhook calls by hook (refetch, purge, alert_test) and result (ok, denied, throttled, rejected)",
+		},
+		[]string{"hook", "result"},
+	)
+
+	// APIKeyRequests counts requests authenticated by API key (security.apikeys.file)
+	APIKeyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "apikey_requests_total",
+			Help:      "Number of requests with an API key by key name and result (ok, forbidden; unknown keys as key=\"unknown\", unauthorized)",
+		},
+		[]string{"key", "result"},
+	)
+
+	// GRPCRequests counts completed gRPC calls (--grpc.listen)
+	GRPCRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "Number of completed gRPC calls by method and status code",
+		},
+		[]string{"method", "code"},
+	)
+
+	// OTLPProxyRequests counts frontend OTLP exports through /otel/v1/{signal}
+	OTLPProxyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "otlp_proxy",
+			Name:      "requests_total",
+			Help:      "Number of frontend OTLP export requests by signal and result (ok, error, rejected, ratelimited, retry)",
+		},
+		[]string{"signal", "result"},
+	)
+	OTLPProxyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "otlp_proxy",
+			Name:      "upstream_duration_seconds",
+			Help:      "Duration of collector requests made by the OTLP proxy, per attempt",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"signal"},
+	)
+
+	// RateLimited counts API requests rejected by the per-client rate limiter
+	RateLimited = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "ratelimited_total",
+			Help:      "Number of API requests rejected with 429 by the per-client rate limiter",
+		},
+	)
+
+	// ClockSkew is the offset of the OpenSky clock from the server clock
+	ClockSkew = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "clock",
+			Name:      "skew_seconds",
+			Help:      "Offset of the OpenSky response time from the server clock (positive: OpenSky ahead)",
+		},
+	)
+	IngestClockCorrections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ingest",
+			Name:      "timestamp_corrections_total",
+			Help:      "Ingested sample timestamps corrected for clock skew by action (shifted, clamped, dropped)",
+		},
+		[]string{"action"},
+	)
+
+	// ClockJumps counts detected wall-clock jumps (host sleep, container pause, clock steps)
+	ClockJumps = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "clock",
+			Name:      "jumps_total",
+			Help:      "Number of detected wall-clock jumps that triggered a catch-up",
+		},
+	)
 )
 
 func init() {
@@ -119,6 +530,50 @@ func init() {
 		LastStatus,
 		HTTPRequests,
 		HTTPDuration,
+		IngestFiltered,
+		IngestPoints,
+		AircraftCurrent,
+		StorageSize,
+		StorageReclaimed,
+		StorageCompacted,
+		RemoteWriteErrors,
+		Panics,
+		JobRuns,
+		JobDuration,
+		SBSMessages,
+		SBSConnected,
+		MQTTPublished,
+		MQTTConnected,
+		HTTPClientRequests,
+		HTTPClientDuration,
+		DNSLookups,
+		OTLPProxyRequests,
+		OTLPProxyDuration,
+		APIKeyRequests,
+		HookRequests,
+		GRPCRequests,
+		GRPCStreams,
+		ClusterLeader,
+		ClusterBatches,
+		WSClosures,
+		WSQuotaEvents,
+		WSDeflateBytes,
+		WSQueueDepth,
+		WSQueueCoalesced,
+		WSQueueDropped,
+		SSEClients,
+		LoadShedding,
+		LoadPressure,
+		ShedEvents,
+		FirstSightings,
+		RareSightings,
+		NewAirframes,
+		AlertEvents,
+		AlertWebhooks,
+		RateLimited,
+		ClockJumps,
+		ClockSkew,
+		IngestClockCorrections,
 	)
 
 	// default log level
@@ -154,6 +609,16 @@ func Debugf(format string, args ...interface{}) {
 type responseRecorder struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter { return rr.ResponseWriter }
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
 }
 
 func (rr *responseRecorder) WriteHeader(code int) {
@@ -323,17 +788,21 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			traceID = sc.TraceID().String()
 			spanID = sc.SpanID().String()
 		}
-		remote := clientIP(r)
+		remote := ClientIP(r)
 		ua := r.UserAgent()
 		path := r.URL.Path
-		query := r.URL.RawQuery
+		query := RedactQuery(r.URL.RawQuery)
 		if query != "" {
 			path = path + "?" + query
 		}
 		// Correlate with request id if present
 		rid := github_chi_mw.GetReqID(r.Context())
 
-		log.Printf("http_request method=%s path=%q status=%d duration=%s remote=%s ua=%q trace_id=%s span_id=%s request_id=%s", r.Method, path, rr.status, dur, remote, ua, traceID, spanID, rid)
+		if accessLog != nil {
+			writeAccessLog(r, rr.status, rr.bytes, dur, start, path, rid)
+			return
+		}
+		log.Printf("http_request method=%s path=%q status=%d duration=%s remote=%s ua=%q trace_id=%s span_id=%s request_id=%s%s", r.Method, path, rr.status, dur, remote, ua, traceID, spanID, rid, logHeaderFields(r.Header))
 	})
 }
 
@@ -365,6 +834,9 @@ func ETagMiddleware(next http.Handler) http.Handler {
 		// Record response
 		rec := &etagRecorder{w: w, header: make(http.Header), status: http.StatusOK}
 		next.ServeHTTP(rec, r)
+		if rec.streaming {
+			return
+		}
 
 		// If non-200 or empty body (and not HEAD), just pass through
 		if rec.status != http.StatusOK || (r.Method != http.MethodHead && rec.buf.Len() == 0) {
@@ -413,10 +885,14 @@ type etagRecorder struct {
 	buf         bytes.Buffer
 	status      int
 	wroteHeader bool
+	streaming   bool // handler flushed: ETag abandoned, writes pass through
 }
 
 func (r *etagRecorder) Header() http.Header { return r.header }
 
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (r *etagRecorder) Unwrap() http.ResponseWriter { return r.w }
+
 func (r *etagRecorder) WriteHeader(code int) {
 	if r.wroteHeader {
 		return
@@ -429,9 +905,29 @@ func (r *etagRecorder) Write(p []byte) (int, error) {
 	if !r.wroteHeader {
 		r.WriteHeader(http.StatusOK)
 	}
+	if r.streaming {
+		return r.w.Write(p)
+	}
 	return r.buf.Write(p)
 }
 
+// Flush switches to pass-through for streaming handlers (e.g., Server-Sent Events).
+func (r *etagRecorder) Flush() {
+	if !r.streaming {
+		if !r.wroteHeader {
+			r.WriteHeader(http.StatusOK)
+		}
+		r.streaming = true
+		copyHeaders(r.w.Header(), r.header)
+		r.w.WriteHeader(r.status)
+		_, _ = r.w.Write(r.buf.Bytes())
+		r.buf.Reset()
+	}
+	if fl, ok := r.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
 // copyHeaders copies header kv pairs from src to dst (preserving existing ones)
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
@@ -441,8 +937,8 @@ func copyHeaders(dst, src http.Header) {
 	}
 }
 
-// clientIP tries to determine the real client IP.
-func clientIP(r *http.Request) string {
+// ClientIP tries to determine the real client IP (X-Forwarded-For, X-Real-Ip, then the peer address).
+func ClientIP(r *http.Request) string {
 	// Check X-Forwarded-For first
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		return strings.TrimSpace(strings.Split(xff, ",")[0])
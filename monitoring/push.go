@@ -0,0 +1,93 @@
+package monitoring
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// runPusher gathers the Prometheus registry every interval and hands the metric families to
+// push until ctx is done. Push-based sinks (remote-write, StatsD) share this loop so every
+// registered metric is available to them without instrumenting call sites twice.
+func runPusher(ctx context.Context, interval time.Duration, push func([]*dto.MetricFamily)) {
+	go Supervise("metrics.push", ctx.Done(), func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				families, err := prometheus.DefaultGatherer.Gather()
+				if err != nil {
+					SubDebugf("metrics", "gather error: %v", err)
+					continue
+				}
+				push(families)
+			}
+		}
+	})
+}
+
+type series struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// familySeries flattens a metric family into samples using the exposition naming conventions.
+func familySeries(mf *dto.MetricFamily) []series {
+	out := []series{}
+	name := mf.GetName()
+	for _, m := range mf.GetMetric() {
+		base := map[string]string{}
+		for _, lp := range m.GetLabel() {
+			base[lp.GetName()] = lp.GetValue()
+		}
+		with := func(k, v string) map[string]string {
+			l := make(map[string]string, len(base)+1)
+			for bk, bv := range base {
+				l[bk] = bv
+			}
+			l[k] = v
+			return l
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			out = append(out, series{name, base, m.GetCounter().GetValue()})
+		case dto.MetricType_GAUGE:
+			out = append(out, series{name, base, m.GetGauge().GetValue()})
+		case dto.MetricType_UNTYPED:
+			out = append(out, series{name, base, m.GetUntyped().GetValue()})
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				out = append(out, series{name + "_bucket", with("le", formatFloat(b.GetUpperBound())), float64(b.GetCumulativeCount())})
+			}
+			out = append(out,
+				series{name + "_bucket", with("le", "+Inf"), float64(h.GetSampleCount())},
+				series{name + "_sum", base, h.GetSampleSum()},
+				series{name + "_count", base, float64(h.GetSampleCount())})
+		case dto.MetricType_SUMMARY:
+			sm := m.GetSummary()
+			for _, q := range sm.GetQuantile() {
+				out = append(out, series{name, with("quantile", formatFloat(q.GetQuantile())), q.GetValue()})
+			}
+			out = append(out,
+				series{name + "_sum", base, sm.GetSampleSum()},
+				series{name + "_count", base, float64(sm.GetSampleCount())})
+		}
+	}
+	return out
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,85 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+var (
+	redactMu      sync.RWMutex
+	redactParams  = toSet("csrf,token,access_token,api_key,apikey,key,sig,signature,password,authorization")
+	redactHeaders = toSet("authorization,cookie,set-cookie,x-csrf-token,x-api-key,proxy-authorization")
+	logHeaders    []string
+)
+
+func toSet(list string) map[string]bool {
+	out := map[string]bool{}
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			out[s] = true
+		}
+	}
+	return out
+}
+
+// ConfigureRedaction sets the query parameters and headers whose values are masked in request logs
+// (comma-separated, case-insensitive) and the request headers included in each log line.
+func ConfigureRedaction(params, headers, logged string) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactParams = toSet(params)
+	redactHeaders = toSet(headers)
+	logHeaders = logHeaders[:0]
+	for h := range toSet(logged) {
+		logHeaders = append(logHeaders, http.CanonicalHeaderKey(h))
+	}
+	sort.Strings(logHeaders)
+}
+
+// RedactQuery masks values of sensitive parameters in a raw query string, keeping parameter order.
+func RedactQuery(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		k, _, hasVal := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if redactParams[strings.ToLower(name)] && hasVal {
+			parts[i] = k + "=" + redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// logHeaderFields renders the configured request headers as key=value log fields, masking sensitive ones.
+func logHeaderFields(h http.Header) string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	var b strings.Builder
+	for _, name := range logHeaders {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		if redactHeaders[strings.ToLower(name)] {
+			v = redacted
+		}
+		b.WriteString(" hdr_" + strings.ReplaceAll(strings.ToLower(name), "-", "_") + "=" + quoteField(v))
+	}
+	return b.String()
+}
+
+func quoteField(v string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(v) + "\""
+}
@@ -0,0 +1,150 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RemoteWriteConfig configures pushing metrics via the Prometheus remote-write protocol
+// (e.g., to Grafana Cloud or Mimir without a local scraper).
+type RemoteWriteConfig struct {
+	URL      string
+	Interval time.Duration
+	Username string       // basic auth (Grafana Cloud instance ID)
+	Password string       // basic auth (API token)
+	Prefix   string       // only metric families with this name prefix are pushed (empty pushes all)
+	Job      string       // value of the job label added to every series
+	Instance string       // value of the instance label (default "miniflightradar")
+	Client   *http.Client // outbound client (proxy and TLS settings); nil uses a plain client
+}
+
+// StartRemoteWrite pushes gathered metrics every cfg.Interval until ctx is done.
+func StartRemoteWrite(ctx context.Context, cfg RemoteWriteConfig) {
+	if cfg.URL == "" {
+		return
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Job == "" {
+		cfg.Job = "miniflightradar"
+	}
+	if cfg.Instance == "" {
+		cfg.Instance = "miniflightradar"
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	runPusher(ctx, cfg.Interval, func(families []*dto.MetricFamily) {
+		if err := pushRemoteWrite(ctx, client, cfg, families); err != nil {
+			RemoteWriteErrors.Inc()
+			SubDebugf("metrics", "remote_write error: %v", err)
+		}
+	})
+	log.Printf("metrics remote-write enabled url=%s interval=%s", cfg.URL, cfg.Interval)
+}
+
+func pushRemoteWrite(ctx context.Context, client *http.Client, cfg RemoteWriteConfig, families []*dto.MetricFamily) error {
+	ts := time.Now().UnixMilli()
+	var req []byte
+	for _, mf := range families {
+		if cfg.Prefix != "" && !strings.HasPrefix(mf.GetName(), cfg.Prefix) {
+			continue
+		}
+		for _, s := range familySeries(mf) {
+			labels := map[string]string{"__name__": s.name, "job": cfg.Job, "instance": cfg.Instance}
+			for k, v := range s.labels {
+				labels[k] = v
+			}
+			req = pbBytes(req, 1, encodeTimeSeries(labels, s.value, ts))
+		}
+	}
+	if len(req) == 0 {
+		return nil
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(snappyEncode(req)))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	hreq.Header.Set("Content-Encoding", "snappy")
+	hreq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	hreq.Header.Set("User-Agent", "miniflightradar")
+	if cfg.Username != "" || cfg.Password != "" {
+		hreq.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// ---- minimal protobuf encoding of prometheus.WriteRequest ----
+
+func pbVarint(b []byte, v uint64) []byte { return binary.AppendUvarint(b, v) }
+
+func pbBytes(b []byte, field int, data []byte) []byte {
+	b = pbVarint(b, uint64(field)<<3|2)
+	b = pbVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// encodeTimeSeries encodes TimeSeries{labels: [Label{name, value}] (sorted), samples: [Sample{value, timestamp}]}.
+func encodeTimeSeries(labels map[string]string, value float64, tsMillis int64) []byte {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var out []byte
+	for _, k := range names {
+		var l []byte
+		l = pbBytes(l, 1, []byte(k))
+		l = pbBytes(l, 2, []byte(labels[k]))
+		out = pbBytes(out, 1, l)
+	}
+	var s []byte
+	s = pbVarint(s, 1<<3|1) // value: double
+	s = binary.LittleEndian.AppendUint64(s, math.Float64bits(value))
+	s = pbVarint(s, 2<<3|0) // timestamp: int64
+	s = pbVarint(s, uint64(tsMillis))
+	return pbBytes(out, 2, s)
+}
+
+// snappyEncode produces a valid snappy block using literal elements only. Remote-write payloads
+// here are small, so skipping back-references is an acceptable trade for no extra dependency.
+func snappyEncode(src []byte) []byte {
+	dst := pbVarint(make([]byte, 0, len(src)+len(src)/60+16), uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 65536)
+		switch m := n - 1; {
+		case m < 60:
+			dst = append(dst, byte(m)<<2)
+		case m < 256:
+			dst = append(dst, 60<<2, byte(m))
+		default:
+			dst = append(dst, 61<<2, byte(m), byte(m>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
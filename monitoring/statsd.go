@@ -0,0 +1,128 @@
+package monitoring
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// StatsDConfig configures emitting metrics to a StatsD or DogStatsD agent over UDP.
+type StatsDConfig struct {
+	Addr     string // host:port of the agent, e.g. 127.0.0.1:8125
+	Flavor   string // "statsd" (labels folded into the name) or "dogstatsd" (labels as tags)
+	Prefix   string // prepended to metric names, e.g. "mfr."
+	Interval time.Duration
+	Filter   string // only metric families with this name prefix are emitted (empty emits all)
+}
+
+// maxStatsDPacket keeps datagrams below common MTUs.
+const maxStatsDPacket = 1400
+
+// StartStatsD emits the Prometheus registry to StatsD every cfg.Interval until ctx is done.
+// Gauges are sent as gauges; counters, histogram/summary counts and sums as count deltas.
+func StartStatsD(ctx context.Context, cfg StatsDConfig) {
+	if cfg.Addr == "" {
+		return
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	dog := strings.EqualFold(cfg.Flavor, "dogstatsd")
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		log.Printf("statsd disabled: %v", err)
+		return
+	}
+	last := map[string]float64{}
+	runPusher(ctx, cfg.Interval, func(families []*dto.MetricFamily) {
+		var buf []byte
+		flush := func() {
+			if len(buf) > 0 {
+				_, _ = conn.Write(buf)
+				buf = buf[:0]
+			}
+		}
+		for _, mf := range families {
+			if cfg.Filter != "" && !strings.HasPrefix(mf.GetName(), cfg.Filter) {
+				continue
+			}
+			gauge := mf.GetType() == dto.MetricType_GAUGE || mf.GetType() == dto.MetricType_UNTYPED
+			for _, s := range familySeries(mf) {
+				if strings.HasSuffix(s.name, "_bucket") || (mf.GetType() == dto.MetricType_SUMMARY && s.labels["quantile"] != "") {
+					continue // distributions are represented by _count/_sum
+				}
+				line := statsdLine(cfg.Prefix, s, gauge, dog, last)
+				if line == "" {
+					continue
+				}
+				if len(buf)+len(line)+1 > maxStatsDPacket {
+					flush()
+				}
+				if len(buf) > 0 {
+					buf = append(buf, '\n')
+				}
+				buf = append(buf, line...)
+			}
+		}
+		flush()
+	})
+	log.Printf("metrics statsd enabled addr=%s flavor=%s interval=%s", cfg.Addr, cfg.Flavor, cfg.Interval)
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+}
+
+// statsdLine formats one sample; counters are converted to deltas against last (keyed by series).
+func statsdLine(prefix string, s series, gauge, dog bool, last map[string]float64) string {
+	keys := make([]string, 0, len(s.labels))
+	for k := range s.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	name := prefix + s.name
+	var tags []string
+	for _, k := range keys {
+		if dog {
+			tags = append(tags, k+":"+s.labels[k])
+		} else {
+			name += "." + statsdSafe(s.labels[k])
+		}
+	}
+	value, typ := s.value, "g"
+	if !gauge {
+		id := name + "|" + strings.Join(tags, ",")
+		prev, seen := last[id]
+		last[id] = s.value
+		value, typ = s.value-prev, "c"
+		if !seen || value < 0 {
+			// first observation (or counter reset): establish the baseline only
+			return ""
+		}
+		if value == 0 {
+			return ""
+		}
+	}
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// statsdSafe replaces characters with special meaning in the StatsD line protocol.
+func statsdSafe(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '/', '.':
+			return '_'
+		}
+		return r
+	}, v)
+}
@@ -0,0 +1,118 @@
+// Package problem writes API errors as RFC 7807 application/problem+json documents carrying the
+// request's trace and request IDs and a stable error envelope ({error, code, request_id}), and
+// maps typed errors to HTTP statuses.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContentType is the media type of problem documents.
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Error, Code, TraceID and RequestID are
+// extension members: Error is the message (the detail, or the title without one), Code a
+// machine-readable snake_case form of the status (e.g. "not_found"), and the IDs correlate the
+// response with server logs and traces.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Code returns the error code of an HTTP status: its status text in snake_case
+// ("too_many_requests"), or "error" for unknown statuses.
+func Code(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(text, "-", " ")), " ", "_")
+}
+
+// Error is an error with an HTTP status; handlers and helpers return it for client errors so
+// WriteError can answer with the right status and detail.
+type Error struct {
+	Status int
+	Detail string
+	Err    error // optional cause
+}
+
+func (e *Error) Error() string { return e.Detail }
+func (e *Error) Unwrap() error { return e.Err }
+
+// New returns an *Error with the given status and detail.
+func New(status int, detail string) *Error { return &Error{Status: status, Detail: detail} }
+
+// classifiers map package-level error types (e.g. storage.ErrInvalid) to statuses.
+var classifiers []func(error) int
+
+// Classify registers a function returning the HTTP status for errors it recognizes (0 for
+// others). It must be called before serving, typically from an init function.
+func Classify(fn func(error) int) { classifiers = append(classifiers, fn) }
+
+// Write sends a problem document with the given status and detail.
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Error:    detail,
+		Code:     Code(status),
+	}
+	if p.Error == "" {
+		p.Error = p.Title
+	}
+	if sc := trace.SpanFromContext(r.Context()).SpanContext(); sc.IsValid() {
+		p.TraceID = sc.TraceID().String()
+	}
+	p.RequestID = middleware.GetReqID(r.Context())
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(p)
+}
+
+// WriteError sends err as a problem document. *Error values and registered classifications keep
+// their message; anything else is logged and answered with a generic 500 so internals don't leak.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	status, detail, ok := Status(err)
+	if !ok {
+		log.Printf("internal error path=%s request_id=%s: %v", r.URL.Path, middleware.GetReqID(r.Context()), err)
+	}
+	Write(w, r, status, detail)
+}
+
+// Status returns the HTTP status and client-facing detail of err as WriteError reports them;
+// ok is false (500 "internal error") for errors that are neither *Error nor classified.
+func Status(err error) (status int, detail string, ok bool) {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.Status, pe.Detail, true
+	}
+	for _, fn := range classifiers {
+		if status := fn(err); status != 0 {
+			return status, err.Error(), true
+		}
+	}
+	return http.StatusInternalServerError, "internal error", false
+}
@@ -0,0 +1,208 @@
+// Package scheduler runs the periodic background jobs (ingest, rollups, ...) with jitter and
+// overlap prevention and exposes their state on /api/admin/jobs.
+package scheduler
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Job describes a periodic task.
+type Job struct {
+	Name     string
+	Interval time.Duration // time between the end of one run and the start of the next
+	Delay    time.Duration // wait before the first run (0: run immediately)
+	Jitter   float64       // spread each wait by ±Jitter (fraction of the wait, 0..1)
+	// Run performs one iteration. A positive next overrides Interval for the following wait
+	// (e.g. backing off after rate limiting).
+	Run func() (next time.Duration, err error)
+}
+
+// Status is the externally visible state of a job.
+type Status struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Running      bool       `json:"running"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+	Skipped      int64      `json:"skipped"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type entry struct {
+	job     Job
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+var (
+	mu   sync.Mutex
+	jobs = map[string]*entry{}
+)
+
+// Register adds a job; it starts with Start. Registering a name twice replaces the job.
+func Register(j Job) {
+	if j.Interval <= 0 {
+		j.Interval = time.Minute
+	}
+	j.Jitter = min(max(j.Jitter, 0), 1)
+	mu.Lock()
+	defer mu.Unlock()
+	jobs[j.Name] = &entry{
+		job:     j,
+		trigger: make(chan struct{}, 1),
+		status:  Status{Name: j.Name, Interval: j.Interval.String()},
+	}
+}
+
+// Start runs every registered job in its own supervised goroutine until stop is closed.
+// A job never overlaps with itself: the next run is scheduled only after the previous one ends.
+func Start(stop <-chan struct{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range jobs {
+		go monitoring.Supervise(e.job.Name, stop, func() { e.loop(stop) })
+	}
+}
+
+func (e *entry) loop(stop <-chan struct{}) {
+	wait := e.job.Delay
+	for {
+		if wait > 0 {
+			wait = jitter(wait, e.job.Jitter)
+			e.mu.Lock()
+			next := time.Now().Add(wait).UTC()
+			e.status.NextRun = &next
+			e.mu.Unlock()
+			select {
+			case <-stop:
+				return
+			case <-e.trigger:
+			case <-time.After(wait):
+			}
+		}
+		wait = e.run()
+	}
+}
+
+// run executes one iteration and returns the wait before the next one.
+func (e *entry) run() time.Duration {
+	start := time.Now()
+	e.mu.Lock()
+	e.status.Running = true
+	started := start.UTC()
+	e.status.LastStart = &started
+	e.status.NextRun = nil
+	e.mu.Unlock()
+	var next time.Duration
+	var err error
+	completed := false
+	defer func() {
+		d := time.Since(start)
+		e.mu.Lock()
+		e.status.Running = false
+		e.status.Runs++
+		e.status.LastDuration = d.Round(time.Millisecond).String()
+		e.status.LastError = ""
+		result := "ok"
+		if !completed {
+			// Run panicked; monitoring.Supervise records the crash and restarts the loop
+			e.status.Failures++
+			e.status.LastError = "panic"
+			result = "panic"
+		} else if err != nil {
+			e.status.Failures++
+			e.status.LastError = err.Error()
+			result = "error"
+		}
+		e.mu.Unlock()
+		monitoring.JobRuns.WithLabelValues(e.job.Name, result).Inc()
+		monitoring.JobDuration.WithLabelValues(e.job.Name).Observe(d.Seconds())
+	}()
+	next, err = e.job.Run()
+	completed = true
+	if next <= 0 {
+		next = e.job.Interval
+	}
+	return next
+}
+
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + f*(2*rand.Float64()-1)))
+}
+
+// Trigger starts a job ahead of schedule. It reports false when the job is unknown or already
+// running (the request is dropped rather than queued behind the current run).
+func Trigger(name string) (found, started bool) {
+	mu.Lock()
+	e := jobs[name]
+	mu.Unlock()
+	if e == nil {
+		return false, false
+	}
+	e.mu.Lock()
+	running := e.status.Running
+	if running {
+		e.status.Skipped++
+	}
+	e.mu.Unlock()
+	if running {
+		monitoring.JobRuns.WithLabelValues(name, "skipped").Inc()
+		return true, false
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+	return true, true
+}
+
+// Jobs returns the status of all registered jobs sorted by name.
+func Jobs() []Status {
+	mu.Lock()
+	out := make([]Status, 0, len(jobs))
+	for _, e := range jobs {
+		e.mu.Lock()
+		out = append(out, e.status)
+		e.mu.Unlock()
+	}
+	mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// JobsHandler lists jobs with last run, duration and next run (GET /api/admin/jobs).
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"jobs": Jobs()})
+}
+
+// TriggerHandler runs a job now (POST /api/admin/jobs/{name}/run); 409 while it is running.
+func TriggerHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	found, started := Trigger(name)
+	switch {
+	case !found:
+		problem.Write(w, r, http.StatusNotFound, "job not found")
+	case !started:
+		problem.Write(w, r, http.StatusConflict, "job already running")
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
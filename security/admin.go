@@ -0,0 +1,75 @@
+package security
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// adminToken guards /admin/* endpoints; empty disables them.
+var adminToken string
+
+// ConfigureAdmin sets the bearer token required by admin endpoints.
+func ConfigureAdmin(token string) { adminToken = strings.TrimSpace(token) }
+
+// AdminMiddleware requires "Authorization: Bearer <token>" or an API key with the admin scope.
+// Admin endpoints respond 404 when neither is configured so they are invisible by default.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" && !adminKeysConfigured() {
+			problem.Write(w, r, http.StatusNotFound, "admin API is disabled")
+			return
+		}
+		if k := adminAPIKey(r); k != nil {
+			monitoring.APIKeyRequests.WithLabelValues(k.name, "ok").Inc()
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, k)))
+			return
+		}
+		if !adminTokenValid(r) {
+			log.Printf("admin_denied path=%s", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminTokenCtx{}, true)))
+	})
+}
+
+type adminTokenCtx struct{}
+
+// adminTokenValid reports whether r carries the admin token as "Authorization: Bearer".
+func adminTokenValid(r *http.Request) bool {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(tok)), []byte(adminToken)) == 1
+}
+
+// AdminPrincipal names who authenticated r with admin rights: "admin" for the admin token,
+// "apikey:NAME" for an API key with the admin scope, "" for anyone else. Resources created
+// through RequireAdmin routes record it as their owner.
+func AdminPrincipal(r *http.Request) string {
+	if r.Context().Value(adminTokenCtx{}) != nil {
+		return "admin"
+	}
+	if k, ok := r.Context().Value(apiKeyCtx{}).(*apiKey); ok && k.admin {
+		return "apikey:" + k.name
+	}
+	return ""
+}
+
+// RequireAdmin restricts routes behind SecurityMiddleware (e.g. writes to alert rules) to the
+// admin token and API keys with the admin scope; cookie sessions and read-only keys get 403.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if AdminPrincipal(r) == "" {
+			log.Printf("admin_denied path=%s", r.URL.Path)
+			problem.Write(w, r, http.StatusForbidden, "requires the admin token or an API key with the admin scope")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
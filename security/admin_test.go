@@ -0,0 +1,114 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "admin-token-0123456789"
+
+// setAdminToken configures the admin token for the duration of a test.
+func setAdminToken(t *testing.T, token string) {
+	t.Helper()
+	prev := adminToken
+	ConfigureAdmin(token)
+	t.Cleanup(func() { adminToken = prev })
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string // configured admin token
+		keys      bool   // test API keys loaded
+		header    string // "Name: value"
+		status    int
+		principal string
+	}{
+		{"disabled", "", false, "Authorization: Bearer " + testAdminToken, http.StatusNotFound, ""},
+		{"token", testAdminToken, false, "Authorization: Bearer " + testAdminToken, http.StatusOK, "admin"},
+		{"token with spaces", testAdminToken, false, "Authorization: Bearer  " + testAdminToken + " ", http.StatusOK, "admin"},
+		{"wrong token", testAdminToken, false, "Authorization: Bearer " + testAdminToken + "x", http.StatusUnauthorized, ""},
+		{"token prefix", testAdminToken, false, "Authorization: Bearer " + testAdminToken[:8], http.StatusUnauthorized, ""},
+		{"basic auth", testAdminToken, false, "Authorization: Basic " + testAdminToken, http.StatusUnauthorized, ""},
+		{"no credentials", testAdminToken, false, "", http.StatusUnauthorized, ""},
+		{"admin key only", "", true, "X-API-Key: " + testAdminKey, http.StatusOK, "apikey:ops"},
+		{"admin key bearer", testAdminToken, true, "Authorization: Bearer " + testAdminKey, http.StatusOK, "apikey:ops"},
+		{"read key", testAdminToken, true, "X-API-Key: " + testReadKey, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setAdminToken(t, tt.token)
+			if tt.keys {
+				setTestAPIKeys(t)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/config", nil)
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				req.Header.Set(name, value)
+			}
+			rec, reached := serve(AdminMiddleware, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != nil && AdminPrincipal(reached) != tt.principal {
+				t.Errorf("AdminPrincipal = %q, want %q", AdminPrincipal(reached), tt.principal)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+// withSession adds the cookies and CSRF header of a browser session to req.
+func withSession(req *http.Request) *http.Request {
+	rec := httptest.NewRecorder()
+	EnsureAuthCookies(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+		if c.Name == "mfr_csrf" {
+			req.Header.Set("X-CSRF-Token", c.Value)
+		}
+	}
+	return req
+}
+
+func TestRequireAdmin(t *testing.T) {
+	setAdminToken(t, testAdminToken)
+	setTestAPIKeys(t)
+	chain := func(next http.Handler) http.Handler { return SecurityMiddleware(RequireAdmin(next)) }
+	tests := []struct {
+		name      string
+		header    string // "Name: value"
+		session   bool
+		status    int
+		principal string
+	}{
+		{"admin token", "Authorization: Bearer " + testAdminToken, false, http.StatusOK, "admin"},
+		{"admin key", "X-API-Key: " + testAdminKey, false, http.StatusOK, "apikey:ops"},
+		{"read key", "X-API-Key: " + testReadKey, false, http.StatusForbidden, ""},
+		{"wrong token", "Authorization: Bearer " + testAdminToken + "x", false, http.StatusUnauthorized, ""},
+		{"session", "", true, http.StatusForbidden, ""},
+		{"session with admin token", "Authorization: Bearer " + testAdminToken, true, http.StatusOK, "admin"},
+		{"anonymous", "", false, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader("{}"))
+			if tt.session {
+				req = withSession(req)
+			}
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				req.Header.Set(name, value)
+			}
+			rec, reached := serve(chain, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != nil && AdminPrincipal(reached) != tt.principal {
+				t.Errorf("AdminPrincipal = %q, want %q", AdminPrincipal(reached), tt.principal)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// API keys (--security.apikeys.file) let scripts and third-party services call the API without
+// browser cookies. Each line of the file is "NAME KEY [SCOPES]": KEY is the secret itself or
+// "sha256:<hex>" of it, SCOPES is "read" (default; GET/HEAD requests and streams) or "admin"
+// (all methods and /api/admin/*). Blank lines and "#" comments are ignored. The file is
+// re-read when it changes, checked at most every apiKeysCheckEvery.
+
+const apiKeysCheckEvery = 10 * time.Second
+
+// minAPIKeyLen is the shortest plain-text key accepted in the file.
+const minAPIKeyLen = 16
+
+type apiKey struct {
+	name  string
+	admin bool
+}
+
+type apiKeySet struct {
+	keys  map[[sha256.Size]byte]*apiKey
+	admin bool // some key has the admin scope
+}
+
+var (
+	apiKeysMu      sync.Mutex
+	apiKeysPath    string
+	apiKeysMod     time.Time
+	apiKeysChecked time.Time
+	apiKeys        *apiKeySet
+)
+
+// ConfigureAPIKeys loads the API key file; empty disables API keys.
+func ConfigureAPIKeys(path string) error {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	apiKeysPath, apiKeys = strings.TrimSpace(path), nil
+	if apiKeysPath == "" {
+		return nil
+	}
+	set, mod, err := loadAPIKeys(apiKeysPath)
+	if err != nil {
+		return fmt.Errorf("security.apikeys.file: %w", err)
+	}
+	apiKeys, apiKeysMod, apiKeysChecked = set, mod, time.Now()
+	log.Printf("API keys: %d loaded from %s", len(set.keys), apiKeysPath)
+	return nil
+}
+
+func loadAPIKeys(path string) (*apiKeySet, time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	set := &apiKeySet{keys: map[[sha256.Size]byte]*apiKey{}}
+	names := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) < 2 || len(f) > 3 {
+			return nil, time.Time{}, fmt.Errorf("line %d: want NAME KEY [SCOPES]", n)
+		}
+		if f[0] == "unknown" {
+			return nil, time.Time{}, fmt.Errorf("line %d: the key name \"unknown\" is reserved", n)
+		}
+		if names[f[0]] {
+			return nil, time.Time{}, fmt.Errorf("line %d: duplicate key name %q", n, f[0])
+		}
+		names[f[0]] = true
+		var sum [sha256.Size]byte
+		if h, ok := strings.CutPrefix(f[1], "sha256:"); ok {
+			raw, err := hex.DecodeString(h)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, time.Time{}, fmt.Errorf("line %d: invalid sha256 key hash", n)
+			}
+			copy(sum[:], raw)
+		} else {
+			if len(f[1]) < minAPIKeyLen {
+				return nil, time.Time{}, fmt.Errorf("line %d: key shorter than %d characters", n, minAPIKeyLen)
+			}
+			sum = sha256.Sum256([]byte(f[1]))
+		}
+		k := &apiKey{name: f[0]}
+		if len(f) == 3 {
+			for _, s := range strings.Split(f[2], ",") {
+				switch strings.TrimSpace(s) {
+				case "read":
+				case "admin":
+					k.admin = true
+				default:
+					return nil, time.Time{}, fmt.Errorf("line %d: unknown scope %q (read, admin)", n, s)
+				}
+			}
+		}
+		if _, dup := set.keys[sum]; dup {
+			return nil, time.Time{}, fmt.Errorf("line %d: duplicate key", n)
+		}
+		set.keys[sum] = k
+		set.admin = set.admin || k.admin
+	}
+	return set, st.ModTime(), sc.Err()
+}
+
+// currentAPIKeys returns the loaded keys (nil when disabled), re-reading a changed file.
+// A file that fails to load keeps the previous keys.
+func currentAPIKeys() *apiKeySet {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	if apiKeysPath == "" || time.Since(apiKeysChecked) < apiKeysCheckEvery {
+		return apiKeys
+	}
+	apiKeysChecked = time.Now()
+	st, err := os.Stat(apiKeysPath)
+	if err != nil || st.ModTime().Equal(apiKeysMod) {
+		return apiKeys
+	}
+	set, mod, err := loadAPIKeys(apiKeysPath)
+	if err != nil {
+		// Retried once the file changes again
+		log.Printf("API keys: reload of %s failed, keeping the previous keys: %v", apiKeysPath, err)
+		apiKeysMod = st.ModTime()
+		return apiKeys
+	}
+	apiKeys, apiKeysMod = set, mod
+	log.Printf("API keys: %d reloaded from %s", len(set.keys), apiKeysPath)
+	return apiKeys
+}
+
+// presentedAPIKey returns the key sent in X-API-Key or as "Authorization: Bearer".
+func presentedAPIKey(r *http.Request) string {
+	if k := strings.TrimSpace(r.Header.Get("X-API-Key")); k != "" {
+		return k
+	}
+	if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(tok)
+	}
+	return ""
+}
+
+// lookupAPIKey returns the key matching secret, or nil.
+func lookupAPIKey(set *apiKeySet, secret string) *apiKey {
+	if set == nil || secret == "" {
+		return nil
+	}
+	return set.keys[sha256.Sum256([]byte(secret))]
+}
+
+type apiKeyCtx struct{}
+
+// HasAPIKey reports whether r was authenticated by an API key (SecurityMiddleware or
+// APIKeyMiddleware), which replaces the cookie JWT and CSRF checks of the stream handlers.
+func HasAPIKey(r *http.Request) bool { return r.Context().Value(apiKeyCtx{}) != nil }
+
+// checkAPIKey authenticates a request carrying an API key. handled is false when the request
+// has no key or keys are disabled; otherwise the request was either rejected or served.
+func checkAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler) (handled bool) {
+	set := currentAPIKeys()
+	secret := presentedAPIKey(r)
+	if set == nil || secret == "" {
+		return false
+	}
+	k := lookupAPIKey(set, secret)
+	if k == nil {
+		monitoring.APIKeyRequests.WithLabelValues("unknown", "unauthorized").Inc()
+		log.Printf("apikey_denied path=%s", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		problem.Write(w, r, http.StatusUnauthorized, "invalid API key")
+		return true
+	}
+	if !k.admin && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		monitoring.APIKeyRequests.WithLabelValues(k.name, "forbidden").Inc()
+		problem.Write(w, r, http.StatusForbidden, "API key is read-only")
+		return true
+	}
+	monitoring.APIKeyRequests.WithLabelValues(k.name, "ok").Inc()
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, k)))
+	return true
+}
+
+// APIKeyMiddleware authenticates API keys on routes outside SecurityMiddleware (the WS and SSE
+// streams); requests without a key go on to the handler's own cookie checks.
+func APIKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkAPIKey(w, r, next) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// adminAPIKey returns the key of r when it has the admin scope, or nil.
+func adminAPIKey(r *http.Request) *apiKey {
+	k := lookupAPIKey(currentAPIKeys(), presentedAPIKey(r))
+	if k == nil || !k.admin {
+		return nil
+	}
+	return k
+}
+
+// adminKeysConfigured reports whether some API key has the admin scope.
+func adminKeysConfigured() bool {
+	set := currentAPIKeys()
+	return set != nil && set.admin
+}
+
+// APIKeysEnabled reports whether API keys are configured (security.apikeys.file).
+func APIKeysEnabled() bool { return currentAPIKeys() != nil }
+
+// APIKeyName returns the name of the key matching secret, for callers outside HTTP (the gRPC
+// API); ok is false for unknown keys.
+func APIKeyName(secret string) (name string, ok bool) {
+	k := lookupAPIKey(currentAPIKeys(), strings.TrimSpace(secret))
+	if k == nil {
+		return "", false
+	}
+	return k.name, true
+}
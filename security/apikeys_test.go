@@ -0,0 +1,162 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Keys of the test key file: a read-only key and an admin key stored as its hash.
+const (
+	testReadKey  = "read-key-0123456789"
+	testAdminKey = "admin-key-0123456789"
+)
+
+// setAPIKeys loads an API key file with content for the duration of a test.
+func setAPIKeys(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "apikeys")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureAPIKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ConfigureAPIKeys("") })
+}
+
+// setTestAPIKeys loads the reader (read scope) and ops (admin scope) keys.
+func setTestAPIKeys(t *testing.T) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(testAdminKey))
+	setAPIKeys(t, "# test keys\nreader "+testReadKey+"\nops sha256:"+hex.EncodeToString(sum[:])+" read,admin\n")
+}
+
+// serve runs req through mw and reports the status and whether the handler was reached.
+func serve(mw func(http.Handler) http.Handler, req *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+	var reached *http.Request
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec, reached
+}
+
+func TestSecurityMiddlewareAPIKeys(t *testing.T) {
+	setTestAPIKeys(t)
+	tests := []struct {
+		name   string
+		method string
+		header string // "Name: value"
+		status int
+		apiKey bool
+	}{
+		{"read key get", http.MethodGet, "X-API-Key: " + testReadKey, http.StatusOK, true},
+		{"read key head", http.MethodHead, "X-API-Key: " + testReadKey, http.StatusOK, true},
+		{"read key bearer", http.MethodGet, "Authorization: Bearer " + testReadKey, http.StatusOK, true},
+		{"read key post", http.MethodPost, "X-API-Key: " + testReadKey, http.StatusForbidden, false},
+		{"read key delete", http.MethodDelete, "X-API-Key: " + testReadKey, http.StatusForbidden, false},
+		{"admin key post", http.MethodPost, "X-API-Key: " + testAdminKey, http.StatusOK, true},
+		{"admin key bearer delete", http.MethodDelete, "Authorization: Bearer " + testAdminKey, http.StatusOK, true},
+		{"hash as key", http.MethodGet, "X-API-Key: sha256:" + testAdminKey, http.StatusUnauthorized, false},
+		{"unknown key", http.MethodGet, "X-API-Key: unknown-key-0123456789", http.StatusUnauthorized, false},
+		{"no key or cookies", http.MethodGet, "", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/alerts", nil)
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				req.Header.Set(name, value)
+			}
+			rec, reached := serve(SecurityMiddleware, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := reached != nil && HasAPIKey(reached); got != tt.apiKey {
+				t.Errorf("HasAPIKey = %t, want %t", got, tt.apiKey)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	setTestAPIKeys(t)
+	tests := []struct {
+		name    string
+		key     string
+		status  int
+		reached bool
+		apiKey  bool
+	}{
+		{"valid key", testReadKey, http.StatusOK, true, true},
+		{"invalid key", "unknown-key-0123456789", http.StatusUnauthorized, false, false},
+		// the stream handler runs its own cookie checks
+		{"no key", "", http.StatusOK, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws/flights", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec, reached := serve(APIKeyMiddleware, req)
+			if rec.Code != tt.status || (reached != nil) != tt.reached {
+				t.Fatalf("status = %d, reached = %t; want %d, %t", rec.Code, reached != nil, tt.status, tt.reached)
+			}
+			if reached != nil && HasAPIKey(reached) != tt.apiKey {
+				t.Errorf("HasAPIKey = %t, want %t", HasAPIKey(reached), tt.apiKey)
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string // substring; "" when the file is valid
+		keys    int
+	}{
+		{"read and admin", "a " + testReadKey + "\nb " + testAdminKey + " admin # ops\n\n", "", 2},
+		{"hashed", "a sha256:" + strings.Repeat("ab", sha256.Size), "", 1},
+		{"short key", "a short", "shorter than", 0},
+		{"missing key", "a", "want NAME KEY", 0},
+		{"extra field", "a " + testReadKey + " read extra", "want NAME KEY", 0},
+		{"unknown scope", "a " + testReadKey + " write", "unknown scope", 0},
+		{"reserved name", "unknown " + testReadKey, "reserved", 0},
+		{"duplicate name", "a " + testReadKey + "\na " + testAdminKey, "duplicate key name", 0},
+		{"duplicate key", "a " + testReadKey + "\nb " + testReadKey, "duplicate key", 0},
+		{"bad hash", "a sha256:xyz", "invalid sha256", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "apikeys")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			set, _, err := loadAPIKeys(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(set.keys) != tt.keys {
+				t.Errorf("keys = %d, want %d", len(set.keys), tt.keys)
+			}
+		})
+	}
+}
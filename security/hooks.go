@@ -0,0 +1,113 @@
+package security
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// hooksSecret authenticates the inbound webhooks (/api/hooks/*); empty disables them.
+var hooksSecret string
+
+// ConfigureHooks sets the shared secret of the inbound webhooks.
+func ConfigureHooks(secret string) { hooksSecret = strings.TrimSpace(secret) }
+
+// hookBodyLimit bounds the bodies of inbound webhooks.
+const hookBodyLimit = 64 << 10
+
+// hookMaxSkew is how far the timestamp of a signed webhook may be from the server clock.
+// Signatures seen within it are remembered, so a captured request cannot be replayed.
+const hookMaxSkew = 5 * time.Minute
+
+var (
+	hookSeenMu sync.Mutex
+	hookSeen   = map[string]time.Time{} // signature -> when it stops being accepted anyway
+)
+
+// HooksMiddleware authenticates inbound webhooks by "Authorization: Bearer <secret>" or, for
+// senders that sign their requests, "X-Hook-Timestamp: <unix seconds>" and "X-Hook-Signature:
+// sha256=<hex HMAC-SHA256 of timestamp + "." + body keyed with the secret>". Signed requests
+// are accepted once and only within hookMaxSkew of their timestamp. Hooks respond 404 when no
+// secret is configured.
+func HooksMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hooksSecret == "" {
+			problem.Write(w, r, http.StatusNotFound, "webhooks are disabled")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hookBodyLimit))
+		if err != nil {
+			problem.Write(w, r, http.StatusRequestEntityTooLarge, "body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if reason := hookDenied(r, body, time.Now()); reason != "" {
+			log.Printf("hook_denied path=%s reason=%s", r.URL.Path, reason)
+			monitoring.HookRequests.WithLabelValues(path.Base(r.URL.Path), "denied").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hooks"`)
+			problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookDenied checks the bearer secret or the timestamped signature of an inbound webhook and
+// returns why it is refused, "" when authorized.
+func hookDenied(r *http.Request, body []byte, now time.Time) string {
+	if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(tok)), []byte(hooksSecret)) != 1 {
+			return "bad_secret"
+		}
+		return ""
+	}
+	sig, ok := strings.CutPrefix(r.Header.Get("X-Hook-Signature"), "sha256=")
+	if !ok {
+		return "no_credentials"
+	}
+	ts := strings.TrimSpace(r.Header.Get("X-Hook-Timestamp"))
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "no_timestamp"
+	}
+	got, err := hex.DecodeString(strings.TrimSpace(sig))
+	if err != nil {
+		return "bad_signature"
+	}
+	mac := hmac.New(sha256.New, []byte(hooksSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return "bad_signature"
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > hookMaxSkew || skew < -hookMaxSkew {
+		return "stale"
+	}
+	// Remember the signature until its timestamp leaves the window
+	key := hex.EncodeToString(got)
+	hookSeenMu.Lock()
+	defer hookSeenMu.Unlock()
+	for k, until := range hookSeen {
+		if now.After(until) {
+			delete(hookSeen, k)
+		}
+	}
+	if _, seen := hookSeen[key]; seen {
+		return "replayed"
+	}
+	hookSeen[key] = time.Unix(sec, 0).Add(hookMaxSkew)
+	return ""
+}
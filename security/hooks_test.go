@@ -0,0 +1,148 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHooksSecret = "hooks-secret-0123456789"
+
+// setHooks configures the hooks secret and an empty replay cache for the duration of a test.
+func setHooks(t *testing.T, secret string) {
+	t.Helper()
+	prev := hooksSecret
+	ConfigureHooks(secret)
+	hookSeenMu.Lock()
+	hookSeen = map[string]time.Time{}
+	hookSeenMu.Unlock()
+	t.Cleanup(func() { hooksSecret = prev })
+}
+
+// hookSignature signs body with timestamp ts like a webhook sender.
+func hookSignature(secret string, ts int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hookRequest builds a signed (sig != "") or bearer webhook request.
+func hookRequest(body, bearer, ts, sig string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/hooks/purge", strings.NewReader(body))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	if ts != "" {
+		req.Header.Set("X-Hook-Timestamp", ts)
+	}
+	if sig != "" {
+		req.Header.Set("X-Hook-Signature", sig)
+	}
+	return req
+}
+
+func TestHookDenied(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	body := `{"caches":["dns"]}`
+	ts := now.Unix()
+	at := func(d time.Duration) (string, string) {
+		sec := now.Add(d).Unix()
+		return strconv.FormatInt(sec, 10), hookSignature(testHooksSecret, sec, body)
+	}
+	okTS, okSig := at(0)
+	oldTS, oldSig := at(-hookMaxSkew - time.Second)
+	futureTS, futureSig := at(hookMaxSkew + time.Second)
+	edgeTS, edgeSig := at(-hookMaxSkew)
+	tests := []struct {
+		name   string
+		req    *http.Request
+		body   string
+		reason string
+	}{
+		{"bearer", hookRequest(body, testHooksSecret, "", ""), body, ""},
+		{"bearer with spaces", hookRequest(body, " "+testHooksSecret+" ", "", ""), body, ""},
+		{"wrong bearer", hookRequest(body, "wrong-secret", "", ""), body, "bad_secret"},
+		{"bearer prefix", hookRequest(body, testHooksSecret[:8], "", ""), body, "bad_secret"},
+		{"no credentials", hookRequest(body, "", "", ""), body, "no_credentials"},
+		{"signed", hookRequest(body, "", okTS, okSig), body, ""},
+		{"signed at skew limit", hookRequest(body, "", edgeTS, edgeSig), body, ""},
+		{"signature without prefix", hookRequest(body, "", okTS, strings.TrimPrefix(okSig, "sha256=")), body, "no_credentials"},
+		{"no timestamp", hookRequest(body, "", "", okSig), body, "no_timestamp"},
+		{"bad timestamp", hookRequest(body, "", "soon", okSig), body, "no_timestamp"},
+		{"signature not hex", hookRequest(body, "", okTS, "sha256=zz"), body, "bad_signature"},
+		{"other secret", hookRequest(body, "", okTS, hookSignature("other-secret", ts, body)), body, "bad_signature"},
+		{"changed body", hookRequest(body, "", okTS, okSig), `{"caches":["terrain"]}`, "bad_signature"},
+		{"changed timestamp", hookRequest(body, "", strconv.FormatInt(ts+1, 10), okSig), body, "bad_signature"},
+		{"stale", hookRequest(body, "", oldTS, oldSig), body, "stale"},
+		{"future", hookRequest(body, "", futureTS, futureSig), body, "stale"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setHooks(t, testHooksSecret)
+			if got := hookDenied(tt.req, []byte(tt.body), now); got != tt.reason {
+				t.Errorf("hookDenied = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestHookReplay(t *testing.T) {
+	setHooks(t, testHooksSecret)
+	now := time.Unix(1_800_000_000, 0)
+	body := "{}"
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := hookSignature(testHooksSecret, now.Unix(), body)
+	steps := []struct {
+		name   string
+		at     time.Duration
+		reason string
+	}{
+		{"first", 0, ""},
+		{"replayed", time.Second, "replayed"},
+		{"replayed at skew limit", hookMaxSkew, "replayed"},
+		{"after the window", hookMaxSkew + time.Second, "stale"},
+	}
+	for _, st := range steps {
+		if got := hookDenied(hookRequest(body, "", ts, sig), []byte(body), now.Add(st.at)); got != st.reason {
+			t.Errorf("%s: hookDenied = %q, want %q", st.name, got, st.reason)
+		}
+	}
+	// bearer requests carry no nonce and are not subject to replay checks
+	for range 2 {
+		if got := hookDenied(hookRequest(body, testHooksSecret, "", ""), []byte(body), now); got != "" {
+			t.Errorf("bearer: hookDenied = %q, want accepted", got)
+		}
+	}
+}
+
+func TestHooksMiddleware(t *testing.T) {
+	now := time.Now().Unix()
+	ts := strconv.FormatInt(now, 10)
+	tests := []struct {
+		name   string
+		secret string
+		req    *http.Request
+		status int
+	}{
+		{"disabled", "", hookRequest("{}", testHooksSecret, "", ""), http.StatusNotFound},
+		{"bearer", testHooksSecret, hookRequest("{}", testHooksSecret, "", ""), http.StatusOK},
+		{"signed", testHooksSecret, hookRequest("{}", "", ts, hookSignature(testHooksSecret, now, "{}")), http.StatusOK},
+		{"unauthorized", testHooksSecret, hookRequest("{}", "wrong-secret", "", ""), http.StatusUnauthorized},
+		{"body too large", testHooksSecret, hookRequest(strings.Repeat("x", hookBodyLimit+1), testHooksSecret, "", ""), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setHooks(t, tt.secret)
+			rec, _ := serve(HooksMiddleware, tt.req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// publicPaths lists read-only endpoints served without JWT/CSRF (e.g., for embedding the live map).
+// Entries are exact paths or prefixes ending in "*".
+var publicPaths []string
+
+// ConfigurePublic sets the public endpoint list from a comma-separated value. Admin endpoints
+// cannot be made public.
+func ConfigurePublic(list string) error {
+	var out []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("public path %q must start with /", p)
+		}
+		if strings.HasPrefix(p, "/api/admin") {
+			return fmt.Errorf("admin endpoints cannot be public: %q", p)
+		}
+		out = append(out, p)
+	}
+	publicPaths = out
+	return nil
+}
+
+// IsPublic reports whether r is a read-only request to a public endpoint.
+func IsPublic(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		return false
+	}
+	for _, p := range publicPaths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+		} else if r.URL.Path == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setPublic configures the public endpoint list for the duration of a test.
+func setPublic(t *testing.T, list string) {
+	t.Helper()
+	prev := publicPaths
+	if err := ConfigurePublic(list); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publicPaths = prev })
+}
+
+func TestConfigurePublic(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		err  string // substring; "" when the list is valid
+		n    int
+	}{
+		{"empty", "", "", 0},
+		{"paths", " /api/flights , /api/stats/*,,", "", 2},
+		{"relative", "api/flights", "must start with /", 0},
+		{"admin", "/api/flights,/api/admin/config", "admin endpoints", 0},
+		{"admin prefix", "/api/admin*", "admin endpoints", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := publicPaths
+			t.Cleanup(func() { publicPaths = prev })
+			publicPaths = []string{"/previous"}
+			err := ConfigurePublic(tt.list)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				if len(publicPaths) != 1 {
+					t.Errorf("failed configuration changed the list to %q", publicPaths)
+				}
+				return
+			}
+			if err != nil || len(publicPaths) != tt.n {
+				t.Fatalf("err = %v, paths = %q; want %d paths", err, publicPaths, tt.n)
+			}
+		})
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	setPublic(t, "/api/flights,/api/stats/*")
+	tests := []struct {
+		method string
+		path   string
+		public bool
+	}{
+		{http.MethodGet, "/api/flights", true},
+		{http.MethodHead, "/api/flights", true},
+		{http.MethodOptions, "/api/flights", true},
+		{http.MethodGet, "/api/stats/daily", true},
+		{http.MethodGet, "/api/stats/", true},
+		{http.MethodPost, "/api/flights", false},
+		{http.MethodPut, "/api/stats/daily", false},
+		{http.MethodDelete, "/api/stats/daily", false},
+		{http.MethodGet, "/api/flights/abc", false},
+		{http.MethodGet, "/api/flightsx", false},
+		{http.MethodGet, "/api/stats", false},
+		{http.MethodGet, "/api/alerts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := IsPublic(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.public {
+				t.Errorf("IsPublic = %t, want %t", got, tt.public)
+			}
+		})
+	}
+}
+
+func TestSecurityMiddlewarePublic(t *testing.T) {
+	setPublic(t, "/api/flights")
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		anyCORS bool // Access-Control-Allow-Origin: *
+		cookies bool // session cookies issued
+	}{
+		{"public get", http.MethodGet, "/api/flights", http.StatusOK, true, false},
+		{"public preflight", http.MethodOptions, "/api/flights", http.StatusNoContent, true, false},
+		{"public path post", http.MethodPost, "/api/flights", http.StatusForbidden, false, true},
+		{"other path", http.MethodGet, "/api/alerts", http.StatusForbidden, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", "https://embed.example")
+			rec, _ := serve(SecurityMiddleware, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin") == "*"; got != tt.anyCORS {
+				t.Errorf("Access-Control-Allow-Origin = %q, want * %t", rec.Header().Get("Access-Control-Allow-Origin"), tt.anyCORS)
+			}
+			if got := len(rec.Result().Cookies()) > 0; got != tt.cookies {
+				t.Errorf("cookies issued = %t, want %t", got, tt.cookies)
+			}
+		})
+	}
+}
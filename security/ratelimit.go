@@ -0,0 +1,149 @@
+package security
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RateLimit is a token-bucket limit: Rate requests per second on average with bursts of up to
+// Burst requests.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses "10rps,burst=30" (also "600rpm" or "10/s", "600/m"). The burst defaults
+// to twice the per-second rate (at least 1). An empty string disables limiting (zero RateLimit).
+func ParseRateLimit(s string) (RateLimit, error) {
+	var rl RateLimit
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return rl, nil
+	}
+	for i, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if i > 0 {
+			v, ok := strings.CutPrefix(part, "burst=")
+			n, err := strconv.Atoi(v)
+			if !ok || err != nil || n < 1 {
+				return RateLimit{}, fmt.Errorf("invalid rate limit %q: want burst=N (N >= 1)", s)
+			}
+			rl.Burst = n
+			continue
+		}
+		per := time.Second
+		num := part
+		for _, u := range []struct {
+			suffix string
+			per    time.Duration
+		}{{"rps", time.Second}, {"/s", time.Second}, {"rpm", time.Minute}, {"/m", time.Minute}} {
+			if v, ok := strings.CutSuffix(part, u.suffix); ok {
+				num, per = v, u.per
+				break
+			}
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil || f <= 0 || math.IsInf(f, 0) {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: want e.g. 10rps,burst=30", s)
+		}
+		rl.Rate = f / per.Seconds()
+	}
+	if rl.Burst == 0 {
+		rl.Burst = max(1, int(math.Ceil(2*rl.Rate)))
+	}
+	return rl, nil
+}
+
+// bucket is the token bucket of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps one bucket per client IP.
+type limiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// allow takes a token for key and returns how long to wait for the next one when none is left.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Drop buckets that have refilled completely; they are equivalent to new ones
+	if now.Sub(l.lastSweep) > time.Minute {
+		full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// RateLimitMiddleware limits /api/* requests per client IP (monitoring.ClientIP) and answers
+// excess requests with 429 and Retry-After. Admin endpoints are exempt so operators keep access
+// during abuse. A zero limit returns next unchanged.
+func RateLimitMiddleware(rl RateLimit) func(http.Handler) http.Handler {
+	if rl.Rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limit := Limit(rl, monitoring.RateLimited)
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Limit limits every request to the wrapped handler per client IP like RateLimitMiddleware and
+// counts rejections in rejected (may be nil). A zero limit returns next unchanged.
+func Limit(rl RateLimit, rejected prometheus.Counter) func(http.Handler) http.Handler {
+	if rl.Rate <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	l := &limiter{limit: rl, buckets: map[string]*bucket{}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := l.allow(monitoring.ClientIP(r), time.Now()); !ok {
+				if rejected != nil {
+					rejected.Inc()
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+				problem.Write(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RateLimit
+		ok   bool
+	}{
+		{"", RateLimit{}, true},
+		{"10rps", RateLimit{Rate: 10, Burst: 20}, true},
+		{"10/s,burst=30", RateLimit{Rate: 10, Burst: 30}, true},
+		{" 600RPM , burst=5 ", RateLimit{Rate: 10, Burst: 5}, true},
+		{"30/m", RateLimit{Rate: 0.5, Burst: 1}, true},
+		{"2.5", RateLimit{Rate: 2.5, Burst: 5}, true},
+		{"0rps", RateLimit{}, false},
+		{"-1rps", RateLimit{}, false},
+		{"infrps", RateLimit{}, false},
+		{"fast", RateLimit{}, false},
+		{"10rps,burst=0", RateLimit{}, false},
+		{"10rps,burst=x", RateLimit{}, false},
+		{"10rps,30", RateLimit{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRateLimit(tt.in)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("ParseRateLimit(%q) = %+v, %v; want %+v, ok %t", tt.in, got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	l := &limiter{limit: RateLimit{Rate: 2, Burst: 3}, buckets: map[string]*bucket{}}
+	start := time.Unix(1_800_000_000, 0)
+	steps := []struct {
+		name string
+		key  string
+		at   time.Duration
+		ok   bool
+		wait time.Duration
+	}{
+		{"burst 1", "a", 0, true, 0},
+		{"burst 2", "a", 0, true, 0},
+		{"burst 3", "a", 0, true, 0},
+		{"exhausted", "a", 0, false, 500 * time.Millisecond},
+		{"other client", "b", 0, true, 0},
+		{"partly refilled", "a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"refilled one", "a", 500 * time.Millisecond, true, 0},
+		{"exhausted again", "a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"refill capped at burst 1", "a", time.Hour, true, 0},
+		{"refill capped at burst 2", "a", time.Hour, true, 0},
+		{"refill capped at burst 3", "a", time.Hour, true, 0},
+		{"refill capped", "a", time.Hour, false, 500 * time.Millisecond},
+	}
+	for _, st := range steps {
+		ok, wait := l.allow(st.key, start.Add(st.at))
+		if ok != st.ok || (wait-st.wait).Abs() > time.Millisecond {
+			t.Errorf("%s: allow = %t, %s; want %t, %s", st.name, ok, wait, st.ok, st.wait)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	mw := RateLimitMiddleware(RateLimit{Rate: 0.001, Burst: 1})
+	tests := []struct {
+		name   string
+		path   string
+		ip     string
+		status int
+	}{
+		{"first", "/api/flights", "192.0.2.1", http.StatusOK},
+		{"limited", "/api/flights", "192.0.2.1", http.StatusTooManyRequests},
+		{"other route, same bucket", "/api/alerts", "192.0.2.1", http.StatusTooManyRequests},
+		{"other client", "/api/flights", "192.0.2.2", http.StatusOK},
+		{"admin exempt", "/api/admin/config", "192.0.2.1", http.StatusOK},
+		{"ui exempt", "/index.html", "192.0.2.1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.ip + ":1234"
+		rec, _ := serve(mw, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 429 without Retry-After", tt.name)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	mw := RateLimitMiddleware(RateLimit{})
+	for i := range 100 {
+		rec, _ := serve(mw, httptest.NewRequest(http.MethodGet, "/api/flights", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d with limiting disabled", i, rec.Code)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -13,6 +14,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/maniack/miniflightradar/problem"
 )
 
 // === Minimal JWT (HS256) + CSRF + CORS helpers ===
@@ -25,16 +28,23 @@ var (
 
 // ConfigureJWT sets CLI-provided secret or persistent file path for JWT secret management.
 // If secret is non-empty, it will be used directly. Otherwise, secret will be loaded from file (or generated and persisted).
+// The file path is made absolute here, so a later change of the working directory does not move it.
 func ConfigureJWT(secret, file string) {
 	jwtSecretFromCLI = strings.TrimSpace(secret)
 	jwtSecretFilePath = strings.TrimSpace(file)
+	if jwtSecretFilePath != "" {
+		if abs, err := filepath.Abs(jwtSecretFilePath); err == nil {
+			jwtSecretFilePath = abs
+		}
+	}
 	// reset current secret; next InitAuth will re-evaluate
 	jwtSecret = nil
 }
 
 // InitAuth initializes JWT secret from CLI configuration or a persistent file.
-// If neither is present, it generates a new one and stores it under ./data/jwt.secret
-// so that sessions survive application restarts.
+// If the file is missing, it generates a new one and stores it there so that sessions survive
+// application restarts. Without a configured file the generated secret is kept in memory only:
+// nothing is written relative to the working directory (e.g. into the source tree by tests).
 func InitAuth() {
 	if len(jwtSecret) != 0 {
 		return
@@ -45,14 +55,13 @@ func InitAuth() {
 		return
 	}
 	// 2) Persistent file (path may be provided via CLI)
-	path := strings.TrimSpace(jwtSecretFilePath)
-	if path == "" {
-		path = filepath.Join(".", "data", "jwt.secret")
-	}
-	_ = os.MkdirAll(filepath.Dir(path), 0o755)
-	if b, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(b))) > 0 {
-		jwtSecret = []byte(strings.TrimSpace(string(b)))
-		return
+	path := jwtSecretFilePath
+	if path != "" {
+		_ = os.MkdirAll(filepath.Dir(path), 0o700)
+		if b, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			jwtSecret = []byte(strings.TrimSpace(string(b)))
+			return
+		}
 	}
 	// 3) Generate and persist
 	buf := make([]byte, 32)
@@ -64,7 +73,9 @@ func InitAuth() {
 			secHex[i*2] = hexdigits[v>>4]
 			secHex[i*2+1] = hexdigits[v&0x0f]
 		}
-		_ = os.WriteFile(path, secHex, 0o600)
+		if path != "" {
+			_ = os.WriteFile(path, secHex, 0o600)
+		}
 		jwtSecret = secHex
 		return
 	}
@@ -232,16 +243,49 @@ func GetCSRFFromRequest(r *http.Request) string {
 }
 
 // SecurityMiddleware applies CORS headers, handles OPTIONS, ensures auth cookies, and enforces CSRF+JWT on /api/*.
+// Requests with an API key skip the cookies and are checked against the key's scope instead.
 func SecurityMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Public read-only tier: any origin, no cookies, no CSRF/JWT
+		if IsPublic(r) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Inbound webhooks authenticate themselves with the hooks secret (HooksMiddleware)
+		if strings.HasPrefix(r.URL.Path, "/api/hooks/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The admin token and API keys of scripted clients replace cookies and CSRF (admin
+		// routes check them in AdminMiddleware)
+		if !strings.HasPrefix(r.URL.Path, "/api/admin/") {
+			if adminTokenValid(r) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminTokenCtx{}, true)))
+				return
+			}
+			if checkAPIKey(w, r, next) {
+				return
+			}
+		}
+		// Signed URLs: shared read-only links authorize themselves
+		if VerifySignedURL(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		// CORS headers (reflect origin if present)
 		origin := r.Header.Get("Origin")
 		if origin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token, Authorization, X-API-Key")
 		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -255,18 +299,19 @@ func SecurityMiddleware(next http.Handler) http.Handler {
 		// Set cookies if missing
 		EnsureAuthCookies(w, r)
 
-		// Enforce CSRF and JWT only for API routes (skip metrics)
-		if strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+		// Enforce CSRF and JWT only for API routes (skip metrics, the API description, the UI
+		// configuration and bearer-token admin routes)
+		if strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" && r.URL.Path != "/api/openapi.json" && r.URL.Path != "/api/config" && !strings.HasPrefix(r.URL.Path, "/api/admin/") {
 			csrfHeader := r.Header.Get("X-CSRF-Token")
 			csrfCookie := GetCSRFFromRequest(r)
 			if csrfHeader == "" || csrfCookie == "" || csrfHeader != csrfCookie {
 				log.Printf("csrf_denied path=%s", r.URL.Path)
-				http.Error(w, "forbidden", http.StatusForbidden)
+				problem.Write(w, r, http.StatusForbidden, "forbidden")
 				return
 			}
 			if !ValidateJWTFromRequest(r) {
 				log.Printf("jwt_denied path=%s", r.URL.Path)
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				problem.Write(w, r, http.StatusUnauthorized, "unauthorized")
 				return
 			}
 		}
@@ -0,0 +1,115 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Signed URLs let authenticated users share read-only API resources (exports, snapshots)
+// without session cookies: ?exp=<unix>&sig=<hmac> over the path and remaining query.
+
+const (
+	DefaultSignedTTL = time.Hour
+	MaxSignedTTL     = 7 * 24 * time.Hour
+)
+
+func signingKey() []byte {
+	if len(jwtSecret) == 0 {
+		InitAuth()
+	}
+	m := hmac.New(sha256.New, jwtSecret)
+	m.Write([]byte("signed-url"))
+	return m.Sum(nil)
+}
+
+func urlSignature(path string, q url.Values) string {
+	q.Del("sig")
+	m := hmac.New(sha256.New, signingKey())
+	m.Write([]byte("GET\n" + path + "\n" + q.Encode()))
+	return base64urlEncode(m.Sum(nil))
+}
+
+// signable reports whether path may be shared via a signed URL (read-only API, not admin or
+// webhooks).
+func signable(path string) bool {
+	return strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/admin/") && !strings.HasPrefix(path, "/api/hooks/")
+}
+
+// SignURL returns target (path with optional query) with exp and sig parameters appended.
+func SignURL(target string, ttl time.Duration) (string, time.Time, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.IsAbs() || u.Host != "" {
+		return "", time.Time{}, fmt.Errorf("target must be a relative API path")
+	}
+	if !signable(u.Path) {
+		return "", time.Time{}, fmt.Errorf("path %q cannot be shared", u.Path)
+	}
+	if ttl <= 0 {
+		ttl = DefaultSignedTTL
+	}
+	if ttl > MaxSignedTTL {
+		return "", time.Time{}, fmt.Errorf("ttl exceeds %s", MaxSignedTTL)
+	}
+	exp := time.Now().Add(ttl).Truncate(time.Second)
+	q := u.Query()
+	q.Set("exp", strconv.FormatInt(exp.Unix(), 10))
+	q.Set("sig", urlSignature(u.Path, q))
+	u.RawQuery = q.Encode()
+	return u.String(), exp, nil
+}
+
+// VerifySignedURL reports whether r carries a valid, unexpired signature for its GET target.
+func VerifySignedURL(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	q := r.URL.Query()
+	sig, expStr := q.Get("sig"), q.Get("exp")
+	if sig == "" || expStr == "" || !signable(r.URL.Path) {
+		return false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(urlSignature(r.URL.Path, q)))
+}
+
+// SignURLHandler issues a signed URL: POST {"path":"/api/clips/ID/export?format=gpx","ttl":"24h"}.
+func SignURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+		TTL  string `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			problem.Write(w, r, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		ttl = d
+	}
+	signed, exp, err := SignURL(req.Path, ttl)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(map[string]any{"url": signed, "expires": exp.Unix()})
+}
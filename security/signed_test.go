@@ -0,0 +1,108 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		ttl    time.Duration
+		ok     bool
+	}{
+		{"export", "/api/clips/abc/export?format=gpx", time.Hour, true},
+		{"default ttl", "/api/flights", 0, true},
+		{"max ttl", "/api/flights", MaxSignedTTL, true},
+		{"ttl too long", "/api/flights", MaxSignedTTL + time.Second, false},
+		{"absolute url", "https://example.com/api/flights", time.Hour, false},
+		{"host only", "//example.com/api/flights", time.Hour, false},
+		{"admin", "/api/admin/config", time.Hour, false},
+		{"hooks", "/api/hooks/purge", time.Hour, false},
+		{"not api", "/metrics", time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, _, err := SignURL(tt.target, tt.ttl)
+			if (err == nil) != tt.ok {
+				t.Fatalf("SignURL(%q) err = %v, want ok %t", tt.target, err, tt.ok)
+			}
+			if tt.ok && !VerifySignedURL(httptest.NewRequest(http.MethodGet, signed, nil)) {
+				t.Errorf("signed URL %q does not verify", signed)
+			}
+		})
+	}
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	signed, _, err := SignURL("/api/clips/abc/export?format=gpx", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+	// edit returns the signed URL with its query changed by fn
+	edit := func(fn func(q url.Values)) string {
+		q := u.Query()
+		fn(q)
+		return u.Path + "?" + q.Encode()
+	}
+	expired := func() string {
+		q := url.Values{"format": {"gpx"}, "exp": {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}}
+		q.Set("sig", urlSignature(u.Path, q))
+		return u.Path + "?" + q.Encode()
+	}()
+	tests := []struct {
+		name   string
+		method string
+		target string
+		ok     bool
+	}{
+		{"valid", http.MethodGet, signed, true},
+		{"head", http.MethodHead, signed, true},
+		{"post", http.MethodPost, signed, false},
+		{"other path", http.MethodGet, "/api/clips/xyz/export?" + u.RawQuery, false},
+		{"changed query", http.MethodGet, edit(func(q url.Values) { q.Set("format", "kml") }), false},
+		{"added parameter", http.MethodGet, edit(func(q url.Values) { q.Set("limit", "1") }), false},
+		{"extended expiry", http.MethodGet, edit(func(q url.Values) { q.Set("exp", strconv.FormatInt(time.Now().Add(MaxSignedTTL).Unix(), 10)) }), false},
+		{"bad signature", http.MethodGet, edit(func(q url.Values) { q.Set("sig", "AAAA") }), false},
+		{"no signature", http.MethodGet, edit(func(q url.Values) { q.Del("sig") }), false},
+		{"no expiry", http.MethodGet, edit(func(q url.Values) { q.Del("exp") }), false},
+		{"expired", http.MethodGet, expired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignedURL(httptest.NewRequest(tt.method, tt.target, nil)); got != tt.ok {
+				t.Errorf("VerifySignedURL(%s %s) = %t, want %t", tt.method, tt.target, got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSecurityMiddlewareSignedURL(t *testing.T) {
+	signed, _, err := SignURL("/api/flights", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"signed", signed, http.StatusOK},
+		{"unsigned", "/api/flights", http.StatusForbidden},
+		{"tampered", signed + "&limit=1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := serve(SecurityMiddleware, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/tidwall/buntdb"
+)
+
+// AlertRule is a user-defined geofence (polygon or circle) and/or callsign/ICAO24 pattern
+// watched by the alerts module. A rule with a fence fires enter/exit for aircraft matching its
+// patterns (all aircraft when none are set); a rule with patterns only fires when a matching
+// aircraft appears. A height limit (BelowAGLFt, optionally OffAirport) narrows either kind to
+// low-flying aircraft: a fence then bounds a volume, and a rule without one fires when an
+// aircraft descends below the limit. Rules are stored under alert:rule:{id} without TTL.
+// Owner is the admin principal that created the rule; only it may replace or delete it.
+type AlertRule struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name,omitempty"`
+	Polygon    [][2]float64 `json:"polygon,omitempty"` // [lat, lon] vertices
+	Circle     *AlertCircle `json:"circle,omitempty"`
+	Callsign   string       `json:"callsign,omitempty"`     // glob pattern (*, ?, [...]), case-insensitive
+	Icao24     string       `json:"icao24,omitempty"`       // glob pattern, case-insensitive
+	BelowAGLFt float64      `json:"below_agl_ft,omitempty"` // only aircraft less than this many feet above ground (Point.AGL, needs terrain); 0: any
+	OffAirport bool         `json:"off_airport,omitempty"`  // with BelowAGLFt: only aircraft away from airports (Point.Airport, needs airports)
+	Webhook    string       `json:"webhook,omitempty"`      // overrides the default webhook URL
+	Owner      string       `json:"owner,omitempty"`
+	CreatedAt  int64        `json:"created_at"`
+}
+
+// AlertCircle is a circular fence; Radius is in meters.
+type AlertCircle struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius"`
+}
+
+// HasFence reports whether the rule defines a polygon or circle.
+func (r AlertRule) HasFence() bool { return len(r.Polygon) > 0 || r.Circle != nil }
+
+// maxAlertRadius bounds circle fences (meters).
+const maxAlertRadius = 1000e3
+
+// AGL is only known below aglMaxAlt, airports of airborne samples only below airportMaxAGL.
+var (
+	maxAlertAGLFt   = math.Floor(aglMaxAlt / geo.Foot)
+	maxOffAirportFt = math.Floor(airportMaxAGL / geo.Foot)
+)
+
+// normalize validates the rule and canonicalizes patterns.
+func (r *AlertRule) normalize() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if len(r.Name) > 200 {
+		return invalid("name too long")
+	}
+	r.Callsign = strings.ToUpper(strings.TrimSpace(r.Callsign))
+	r.Icao24 = strings.ToLower(strings.TrimSpace(r.Icao24))
+	for _, p := range []string{r.Callsign, r.Icao24} {
+		if _, err := path.Match(p, ""); err != nil {
+			return invalid("invalid pattern " + p)
+		}
+	}
+	if len(r.Polygon) > 0 {
+		if len(r.Polygon) < 3 {
+			return invalid("polygon needs at least 3 vertices")
+		}
+		for _, v := range r.Polygon {
+			if v[0] < -90 || v[0] > 90 || v[1] < -180 || v[1] > 180 {
+				return invalid("invalid polygon vertex")
+			}
+		}
+	}
+	if c := r.Circle; c != nil {
+		if c.Lat < -90 || c.Lat > 90 || c.Lon < -180 || c.Lon > 180 || c.Radius <= 0 || c.Radius > maxAlertRadius {
+			return invalid("invalid circle (lat, lon, radius 0..1000000 m)")
+		}
+	}
+	if len(r.Polygon) > 0 && r.Circle != nil {
+		return invalid("use either polygon or circle")
+	}
+	if math.IsNaN(r.BelowAGLFt) || r.BelowAGLFt < 0 || r.BelowAGLFt > maxAlertAGLFt {
+		return invalid(fmt.Sprintf("below_agl_ft must be within 0..%g", maxAlertAGLFt))
+	}
+	if r.OffAirport && (r.BelowAGLFt == 0 || r.BelowAGLFt > maxOffAirportFt) {
+		return invalid(fmt.Sprintf("off_airport needs below_agl_ft within 1..%g", maxOffAirportFt))
+	}
+	if !r.HasFence() && r.Callsign == "" && r.Icao24 == "" && r.BelowAGLFt == 0 {
+		return invalid("rule needs a polygon, circle, callsign or icao24 pattern, or below_agl_ft")
+	}
+	if r.Webhook != "" {
+		u, err := url.Parse(r.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("invalid webhook URL")
+		}
+	}
+	return nil
+}
+
+// SaveAlertRule validates and stores a rule on behalf of r.Owner. An empty ID creates a new
+// rule; otherwise the existing rule is replaced (found reports whether it existed), which fails
+// with ErrForbidden when another owner created it. Rules stored without an owner may be
+// replaced by anyone, who then owns them.
+func (s *Store) SaveAlertRule(r AlertRule) (saved *AlertRule, found bool, err error) {
+	if s == nil {
+		return nil, false, ErrNotInitialized
+	}
+	if err := r.normalize(); err != nil {
+		return nil, false, err
+	}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		if r.ID == "" {
+			var id [8]byte
+			_, _ = rand.Read(id[:])
+			r.ID = hex.EncodeToString(id[:])
+			r.CreatedAt = time.Now().Unix()
+			found = true
+		} else {
+			v, err := tx.Get("alert:rule:" + r.ID)
+			if errors.Is(err, buntdb.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			var old AlertRule
+			_ = json.Unmarshal([]byte(v), &old)
+			found = true
+			if old.Owner != "" && old.Owner != r.Owner {
+				return ErrForbidden
+			}
+			r.CreatedAt = old.CreatedAt
+		}
+		b, _ := json.Marshal(r)
+		_, _, err := tx.Set("alert:rule:"+r.ID, string(b), nil)
+		return err
+	})
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return &r, true, nil
+}
+
+// AlertRules returns all rules, oldest first.
+func (s *Store) AlertRules() ([]AlertRule, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	out := []AlertRule{}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("alert:rule:*", func(key, val string) bool {
+			var r AlertRule
+			if json.Unmarshal([]byte(val), &r) == nil {
+				out = append(out, r)
+			}
+			return true
+		})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out, err
+}
+
+// DeleteAlertRule removes a rule on behalf of owner; it reports whether the rule existed and
+// fails with ErrForbidden when another owner created it.
+func (s *Store) DeleteAlertRule(id, owner string) (bool, error) {
+	if s == nil {
+		return false, ErrNotInitialized
+	}
+	found := false
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		v, err := tx.Get("alert:rule:" + id)
+		if err != nil {
+			return nil
+		}
+		found = true
+		var r AlertRule
+		if json.Unmarshal([]byte(v), &r) == nil && r.Owner != "" && r.Owner != owner {
+			return ErrForbidden
+		}
+		_, err = tx.Delete("alert:rule:" + id)
+		return err
+	})
+	return found, err
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Archives are portable dumps of the position history (pos:*) and the callsign map (map:cs:*)
+// as newline-delimited JSON: a header line, then one record per line. They carry samples rather
+// than keys, so they load into any key layout or shard count. Ledger, rollups, clips and alerts
+// are not included.
+
+// ArchiveFormat identifies archive files in their header.
+const ArchiveFormat = "miniflightradar-archive"
+
+// archiveVersion is the record layout written by Export; Import reads this version and older.
+const archiveVersion = 1
+
+// archiveBatch bounds the records read or written per transaction.
+const archiveBatch = 5000
+
+// ArchiveFilter selects the samples exported or imported.
+type ArchiveFilter struct {
+	From int64       `json:"from,omitempty"` // sample time range in unix seconds, inclusive (0: unbounded)
+	To   int64       `json:"to,omitempty"`
+	BBox *[4]float64 `json:"bbox,omitempty"` // minLon, minLat, maxLon, maxLat (nil: everywhere)
+}
+
+func (f ArchiveFilter) match(p *Point) bool {
+	if (f.From > 0 && p.TS < f.From) || (f.To > 0 && p.TS > f.To) {
+		return false
+	}
+	if b := f.BBox; b != nil && (p.Lon < b[0] || p.Lat < b[1] || p.Lon > b[2] || p.Lat > b[3]) {
+		return false
+	}
+	return true
+}
+
+// ArchiveStats counts the records of an export or import.
+type ArchiveStats struct {
+	Points    int // samples written
+	Callsigns int // callsign mappings written
+	Skipped   int // samples outside the filter
+	Expired   int // samples older than the retention (import only)
+	Replaced  int // samples already stored (import only)
+}
+
+type archiveHeader struct {
+	Type    string        `json:"type"` // "header"
+	Format  string        `json:"format"`
+	Version int           `json:"version"`
+	Schema  int           `json:"schema"`
+	Created time.Time     `json:"created"`
+	Filter  ArchiveFilter `json:"filter"`
+}
+
+type archiveRecord struct {
+	Type     string `json:"type"`            // "pos" or "map"
+	Point    *Point `json:"point,omitempty"` // pos
+	Callsign string `json:"callsign,omitempty"`
+	Icao24   string `json:"icao24,omitempty"` // map
+}
+
+// Export writes the samples matching f, then the callsign mappings of the exported aircraft.
+func (s *Store) Export(w io.Writer, f ArchiveFilter) (ArchiveStats, error) {
+	var st ArchiveStats
+	if s == nil {
+		return st, ErrNotInitialized
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(archiveHeader{Type: "header", Format: ArchiveFormat, Version: archiveVersion,
+		Schema: SchemaVersion(), Created: time.Now().UTC(), Filter: f}); err != nil {
+		return st, err
+	}
+	lo, hi := "pos:", "pos;"
+	if f.From > 0 {
+		lo, _ = posRange(f.From, f.From)
+	}
+	if f.To > 0 {
+		_, hi = posRange(f.To, f.To)
+	}
+	aircraft := map[string]bool{}
+	for k, db := range s.shards {
+		from := lo
+		for {
+			var pts []Point
+			n := 0
+			err := db.View(func(tx *buntdb.Tx) error {
+				return tx.AscendRange("", from, hi, func(key, val string) bool {
+					from = key + "\x00"
+					n++
+					var p Point
+					if _, _, ok := parsePosKey(key); ok && json.Unmarshal([]byte(val), &p) == nil {
+						if f.match(&p) {
+							pts = append(pts, p)
+						} else {
+							st.Skipped++
+						}
+					}
+					return n < archiveBatch
+				})
+			})
+			if err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+				return st, fmt.Errorf("export shard %d: %w", k, err)
+			}
+			// Write outside the transaction: the writer may be slow (a pipe, gzip)
+			for i := range pts {
+				if err := enc.Encode(archiveRecord{Type: "pos", Point: &pts[i]}); err != nil {
+					return st, err
+				}
+				aircraft[pts[i].Icao24] = true
+				st.Points++
+			}
+			if n < archiveBatch {
+				break
+			}
+		}
+	}
+	var maps []archiveRecord
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("map:cs:*", func(key, val string) bool {
+			if aircraft[val] {
+				maps = append(maps, archiveRecord{Type: "map", Callsign: key[len("map:cs:"):], Icao24: val})
+			}
+			return true
+		})
+	})
+	for _, m := range maps {
+		if err := enc.Encode(m); err != nil {
+			return st, err
+		}
+		st.Callsigns++
+	}
+	return st, bw.Flush()
+}
+
+// Import loads an archive written by Export, keeping the samples matching f. Samples already
+// stored are overwritten with the same value, so importing twice is harmless; samples older
+// than the retention are skipped, since the purge would remove them right away.
+func (s *Store) Import(r io.Reader, f ArchiveFilter) (ArchiveStats, error) {
+	var st ArchiveStats
+	if s == nil {
+		return st, ErrNotInitialized
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return st, err
+		}
+		return st, errors.New("import: empty archive")
+	}
+	var h archiveHeader
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Type != "header" || h.Format != ArchiveFormat {
+		return st, errors.New("import: not a " + ArchiveFormat + " file (missing header line)")
+	}
+	if h.Version > archiveVersion {
+		return st, fmt.Errorf("import: archive version %d is newer than supported (%d)", h.Version, archiveVersion)
+	}
+	cutoff := time.Now().Add(-s.retention).Unix()
+	aircraft := map[string]bool{}
+	var batch []Point
+	var maps []archiveRecord
+	line := 1
+	for sc.Scan() {
+		line++
+		b := sc.Bytes()
+		if len(strings.TrimSpace(string(b))) == 0 {
+			continue
+		}
+		var rec archiveRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return st, fmt.Errorf("import: line %d: %w", line, err)
+		}
+		switch rec.Type {
+		case "pos":
+			p := rec.Point
+			if p == nil || normalizeICAO(p.Icao24) == "" || p.TS <= 0 {
+				return st, fmt.Errorf("import: line %d: invalid sample", line)
+			}
+			p.Icao24 = normalizeICAO(p.Icao24)
+			switch {
+			case !f.match(p):
+				st.Skipped++
+			case p.TS < cutoff:
+				st.Expired++
+			default:
+				aircraft[p.Icao24] = true
+				batch = append(batch, *p)
+			}
+			if len(batch) >= archiveBatch {
+				if err := s.importPoints(batch, &st); err != nil {
+					return st, err
+				}
+				batch = batch[:0]
+			}
+		case "map":
+			maps = append(maps, rec)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return st, fmt.Errorf("import: line %d: %w", line+1, err)
+	}
+	if err := s.importPoints(batch, &st); err != nil {
+		return st, err
+	}
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		for _, m := range maps {
+			cs, icao := normalizeCallsign(m.Callsign), normalizeICAO(m.Icao24)
+			if cs == "" || !aircraft[icao] {
+				continue
+			}
+			if _, _, err := tx.Set("map:cs:"+cs, icao, &buntdb.SetOptions{Expires: true, TTL: s.retention}); err != nil {
+				return err
+			}
+			st.Callsigns++
+		}
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+	// Readers of this process see the imported trails; a server restores the current state
+	// from the latest:* index on startup
+	s.publishSnapshot(nil)
+	return st, nil
+}
+
+// importPoints writes samples to their shards, keeping the latest:* index up to date.
+func (s *Store) importPoints(pts []Point, st *ArchiveStats) error {
+	groups := s.groupByShard(len(pts), func(i int) string { return pts[i].Icao24 })
+	for k, idx := range groups {
+		if len(idx) == 0 {
+			continue
+		}
+		err := s.shards[k].Update(func(tx *buntdb.Tx) error {
+			for _, i := range idx {
+				p := pts[i]
+				b, _ := json.Marshal(p)
+				_, replaced, err := tx.Set(posKey(p.Icao24, p.TS), string(b), nil)
+				if err != nil {
+					return err
+				}
+				if replaced {
+					st.Replaced++
+				}
+				if ttl := s.retention - time.Since(time.Unix(p.TS, 0)); ttl > 0 {
+					setLatest(tx, p.Icao24, string(b), ttl)
+				}
+				st.Points++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("import shard %d: %w", k, err)
+		}
+	}
+	return nil
+}
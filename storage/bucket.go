@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/tidwall/buntdb"
+)
+
+// Position history is keyed pos:{yyyymmddhh}:{icao}:{ts}, bucketed by the UTC hour of the
+// sample. Every hour is a contiguous key range, so the retention purge deletes whole hours and
+// time-range scans read only the hours they cover; per-aircraft reads seek once per hour.
+// History keys have no TTL.
+const posBucketLayout = "2006010215"
+
+// posBucket returns the hour bucket of a unix timestamp.
+func posBucket(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(posBucketLayout)
+}
+
+func posKey(icao string, ts int64) string {
+	return fmt.Sprintf("pos:%s:%s:%010d", posBucket(ts), icao, ts)
+}
+
+// parsePosKey returns the ICAO24 address and timestamp of a pos:{yyyymmddhh}:ICAO:TS key.
+func parsePosKey(key string) (string, int64, bool) {
+	if !strings.HasPrefix(key, "pos:") {
+		return "", 0, false
+	}
+	parts := strings.SplitN(key[len("pos:"):], ":", 3)
+	if len(parts) != 3 || len(parts[0]) != len(posBucketLayout) || parts[1] == "" {
+		return "", 0, false
+	}
+	ts, err := strconv.ParseInt(parts[2], 10, 64)
+	return parts[1], ts, err == nil
+}
+
+// posRange returns the key range [lo, hi) of the hours covering from..to (unix seconds).
+func posRange(from, to int64) (string, string) {
+	return "pos:" + posBucket(from), "pos:" + posBucket(to+3600)
+}
+
+// historySpan bounds per-aircraft scans to the hours that can hold history: retention (plus
+// the hour not purged yet) up to an hour ahead of the clock.
+func (s *Store) historySpan(from, to int64) (int64, int64) {
+	now := time.Now()
+	return max(from, now.Add(-s.retention-time.Hour).Unix()), min(to, now.Add(time.Hour).Unix())
+}
+
+// ascendTrack calls fn for the samples of icao with from <= TS <= to in time order until fn
+// returns false.
+func ascendTrack(tx *buntdb.Tx, icao string, from, to int64, fn func(key, val string) bool) {
+	for h := from / 3600; h <= to/3600; h++ {
+		b := posBucket(h * 3600)
+		lo := fmt.Sprintf("pos:%s:%s:%010d", b, icao, max(from, h*3600))
+		hi := fmt.Sprintf("pos:%s:%s:%010d", b, icao, min(to, h*3600+3599)+1)
+		more := true
+		_ = tx.AscendRange("", lo, hi, func(key, val string) bool {
+			more = fn(key, val)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// descendTrack calls fn for the samples of icao with from <= TS <= to, newest first, until fn
+// returns false.
+func descendTrack(tx *buntdb.Tx, icao string, from, to int64, fn func(key, val string) bool) {
+	for h := to / 3600; h >= from/3600; h-- {
+		b := posBucket(h * 3600)
+		hi := fmt.Sprintf("pos:%s:%s:%010d", b, icao, min(to, h*3600+3599))
+		lo := fmt.Sprintf("pos:%s:%s:%010d", b, icao, max(from, h*3600)-1)
+		more := true
+		_ = tx.DescendRange("", hi, lo, func(key, val string) bool {
+			more = fn(key, val)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// maybePurge starts a background purge when an hour bucket has fallen out of retention since
+// the last one.
+func (s *Store) maybePurge(now time.Time) {
+	h := now.Add(-s.retention).Unix() / 3600
+	old := s.purged.Load()
+	if h <= old || !s.purged.CompareAndSwap(old, h) {
+		return
+	}
+	go s.purgeHistory(old, h)
+}
+
+// purgeHistory deletes the hour buckets before hour (unix hours), shard by shard in batches,
+// after rolling up those since the previous purge (from) that the stats job has not reached.
+func (s *Store) purgeHistory(from, hour int64) {
+	defer monitoring.Recover("storage.purge")
+	s.rollupMu.Lock()
+	err := s.rollupHours(max(from, hour-int64(rollupHourTTL/time.Hour)), hour)
+	s.rollupMu.Unlock()
+	if err != nil {
+		log.Printf("storage: rollup before purge: %v", err)
+	}
+	hi := "pos:" + posBucket(hour*3600)
+	total := 0
+	for k, db := range s.shards {
+		n, err := scanPos(db, "pos:", hi, func(keys []string) []string { return keys })
+		total += n
+		if err != nil {
+			log.Printf("storage: purge history: shard %d: %v", k, err)
+			break
+		}
+	}
+	monitoring.StorageCompacted.WithLabelValues("retention").Add(float64(total))
+	if total > 0 {
+		monitoring.SubDebugf("storage", "history purged before=%s samples=%d", posBucket(hour*3600), total)
+	}
+}
+
+// migrationBatch bounds the records a batched migration rewrites per transaction.
+const migrationBatch = 10000
+
+// migrateHourlyBuckets rewrites pos:ICAO:TS keys as pos:{yyyymmddhh}:ICAO:TS without TTL; the
+// retention purge removes them by hour from then on. It works in batches of migrationBatch
+// keys, resuming after the last key of the previous batch (which it deleted).
+func migrateHourlyBuckets(m *MigrationRun) error {
+	from := m.Cursor
+	if from == "" {
+		from = "pos:"
+	}
+	type legacy struct {
+		key, val, icao string
+		ts             int64
+	}
+	var old []legacy
+	err := m.Tx.AscendRange("", from, "pos;", func(key, val string) bool {
+		m.Scanned()
+		if _, _, ok := parsePosKey(key); ok {
+			return true
+		}
+		rest := key[len("pos:"):]
+		i := strings.LastIndexByte(rest, ':')
+		if i <= 0 {
+			return true
+		}
+		ts, err := strconv.ParseInt(rest[i+1:], 10, 64)
+		if err != nil {
+			return true
+		}
+		if m.DryRun {
+			m.Changed++
+			return true
+		}
+		old = append(old, legacy{key: key, val: val, icao: rest[:i], ts: ts})
+		return len(old) < migrationBatch
+	})
+	if err != nil {
+		return err
+	}
+	if len(old) == migrationBatch {
+		m.More, m.Cursor = true, old[len(old)-1].key
+	}
+	for _, o := range old {
+		if _, err := m.Tx.Delete(o.key); err != nil {
+			return err
+		}
+		if _, _, err := m.Tx.Set(posKey(o.icao, o.ts), o.val, nil); err != nil {
+			return err
+		}
+		m.Changed++
+	}
+	return nil
+}
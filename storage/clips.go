@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Clip is a bookmarked playback time range. Positions inside the range are frozen into the clip
+// when it is created (clip:data:{id}), so clips can be exported after raw history has expired.
+// Clip keys (clip:meta:{id}, clip:data:{id}) expire ClipTTL after creation. Owner is the admin
+// principal that created the clip; only it lists and deletes it.
+type Clip struct {
+	ID        string      `json:"id"`
+	Title     string      `json:"title"`
+	From      int64       `json:"from"` // unix seconds
+	To        int64       `json:"to"`
+	BBox      *[4]float64 `json:"bbox,omitempty"` // minLon,minLat,maxLon,maxLat
+	Icao24    []string    `json:"icao24,omitempty"`
+	Aircraft  int         `json:"aircraft"`
+	Points    int         `json:"points"`
+	Owner     string      `json:"owner,omitempty"`
+	CreatedAt int64       `json:"created_at"`
+	ExpiresAt int64       `json:"expires_at"`
+}
+
+const (
+	// MaxClipDuration bounds a clip's time range.
+	MaxClipDuration = time.Hour
+	// ClipTTL is how long a clip is kept.
+	ClipTTL = 30 * 24 * time.Hour
+	// maxClipPoints and maxClipBytes bound the frozen positions of a clip (their JSON size).
+	maxClipPoints = 50000
+	maxClipBytes  = 8 << 20
+	// maxClips bounds the clips stored at once.
+	maxClips = 100
+)
+
+// HistoryFilter narrows History queries; zero values match everything.
+type HistoryFilter struct {
+	BBox   *[4]float64
+	Icao24 []string
+}
+
+func (f HistoryFilter) match(p Point) bool {
+	if b := f.BBox; b != nil && (p.Lon < b[0] || p.Lon > b[2] || p.Lat < b[1] || p.Lat > b[3]) {
+		return false
+	}
+	return true
+}
+
+// History returns stored positions with from <= ts <= to, ordered by aircraft and time.
+// It is the building block for playback and clips.
+func (s *Store) History(from, to int64, f HistoryFilter, limit int) ([]Point, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	pts := []Point{}
+	collect := func(key, val string) bool {
+		var p Point
+		if json.Unmarshal([]byte(val), &p) != nil || p.TS < from || p.TS > to || !f.match(p) {
+			return true
+		}
+		pts = append(pts, p)
+		return limit <= 0 || len(pts) < limit
+	}
+	if len(f.Icao24) > 0 {
+		for _, icao := range f.Icao24 {
+			icao = normalizeICAO(icao)
+			lo, hi := s.historySpan(from, to)
+			err := s.shard(icao).View(func(tx *buntdb.Tx) error {
+				ascendTrack(tx, icao, lo, hi, collect)
+				return nil
+			})
+			if err != nil {
+				return pts, err
+			}
+		}
+		return pts, nil
+	}
+	lo, hi := posRange(from, to)
+	for _, db := range s.shards {
+		if err := db.View(func(tx *buntdb.Tx) error { return tx.AscendRange("", lo, hi, collect) }); err != nil {
+			return pts, err
+		}
+		if limit > 0 && len(pts) >= limit {
+			break
+		}
+	}
+	// Keys are ordered by hour, then aircraft
+	slices.SortStableFunc(pts, func(a, b Point) int { return strings.Compare(a.Icao24, b.Icao24) })
+	return pts, nil
+}
+
+// CreateClip validates c, freezes the matching positions and stores the clip on behalf of
+// c.Owner. It fails with ErrInvalid when maxClips clips are stored.
+func (s *Store) CreateClip(c Clip) (*Clip, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	c.Title = strings.TrimSpace(c.Title)
+	if len(c.Title) > 200 {
+		return nil, invalid("title too long")
+	}
+	if c.From <= 0 || c.To <= c.From {
+		return nil, invalid("invalid time range")
+	}
+	if time.Duration(c.To-c.From)*time.Second > MaxClipDuration {
+		return nil, invalid(fmt.Sprintf("clip longer than %s", MaxClipDuration))
+	}
+	if b := c.BBox; b != nil && (b[0] > b[2] || b[1] > b[3]) {
+		return nil, invalid("invalid bbox")
+	}
+	for i := range c.Icao24 {
+		c.Icao24[i] = normalizeICAO(c.Icao24[i])
+	}
+	pts, err := s.History(c.From, c.To, HistoryFilter{BBox: c.BBox, Icao24: c.Icao24}, maxClipPoints+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(pts) > maxClipPoints {
+		return nil, invalid("too many positions in range; narrow the bbox or aircraft list")
+	}
+	aircraft := map[string]struct{}{}
+	for _, p := range pts {
+		aircraft[p.Icao24] = struct{}{}
+	}
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	c.ID = hex.EncodeToString(id[:])
+	c.Aircraft = len(aircraft)
+	c.Points = len(pts)
+	now := time.Now()
+	c.CreatedAt, c.ExpiresAt = now.Unix(), now.Add(ClipTTL).Unix()
+	meta, _ := json.Marshal(c)
+	data, _ := json.Marshal(pts)
+	if len(data) > maxClipBytes {
+		return nil, invalid(fmt.Sprintf("clip data over %d MiB; narrow the bbox or aircraft list", maxClipBytes>>20))
+	}
+	opts := &buntdb.SetOptions{Expires: true, TTL: ClipTTL}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		n := 0
+		_ = tx.AscendKeys("clip:meta:*", func(_, _ string) bool { n++; return n < maxClips })
+		if n >= maxClips {
+			return invalid(fmt.Sprintf("%d clips stored; delete some first", maxClips))
+		}
+		if _, _, err := tx.Set("clip:meta:"+c.ID, string(meta), opts); err != nil {
+			return err
+		}
+		_, _, err := tx.Set("clip:data:"+c.ID, string(data), opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Clips returns the clips of owner and those stored without an owner, newest first.
+func (s *Store) Clips(owner string) ([]Clip, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	out := []Clip{}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("clip:meta:*", func(key, val string) bool {
+			var c Clip
+			if json.Unmarshal([]byte(val), &c) == nil && (c.Owner == owner || c.Owner == "") {
+				out = append(out, c)
+			}
+			return true
+		})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out, err
+}
+
+// ClipGet returns a clip and its frozen positions, or nil if it does not exist.
+func (s *Store) ClipGet(id string) (*Clip, []Point, error) {
+	if s == nil {
+		return nil, nil, ErrNotInitialized
+	}
+	var c Clip
+	var pts []Point
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		v, err := tx.Get("clip:meta:" + id)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			return err
+		}
+		if d, err := tx.Get("clip:data:" + id); err == nil {
+			_ = json.Unmarshal([]byte(d), &pts)
+		}
+		return nil
+	})
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &c, pts, nil
+}
+
+// DeleteClip removes a clip on behalf of owner; it reports whether the clip existed and fails
+// with ErrForbidden when another owner created it (clips without an owner may be deleted by
+// anyone).
+func (s *Store) DeleteClip(id, owner string) (bool, error) {
+	if s == nil {
+		return false, ErrNotInitialized
+	}
+	found := false
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		v, err := tx.Get("clip:meta:" + id)
+		if err != nil {
+			return nil
+		}
+		found = true
+		var c Clip
+		if json.Unmarshal([]byte(v), &c) == nil && c.Owner != "" && c.Owner != owner {
+			return ErrForbidden
+		}
+		_, _ = tx.Delete("clip:meta:" + id)
+		_, _ = tx.Delete("clip:data:" + id)
+		return nil
+	})
+	return found, err
+}
+
+// migrateClipExpiry sets the expiry of clips stored before clips expired: ClipTTL after their
+// creation, at least a day from now so none disappears right after the upgrade.
+func migrateClipExpiry(m *MigrationRun) error {
+	type clipKeys struct {
+		id, meta string
+		ttl      time.Duration
+	}
+	var pending []clipKeys
+	err := m.Tx.AscendKeys("clip:meta:*", func(key, val string) bool {
+		m.Scanned()
+		if ttl, err := m.Tx.TTL(key); err != nil || ttl >= 0 {
+			return true // already expiring
+		}
+		var c Clip
+		_ = json.Unmarshal([]byte(val), &c)
+		ttl := max(time.Until(time.Unix(c.CreatedAt, 0).Add(ClipTTL)), 24*time.Hour)
+		c.ExpiresAt = time.Now().Add(ttl).Unix()
+		meta, _ := json.Marshal(c)
+		pending = append(pending, clipKeys{id: strings.TrimPrefix(key, "clip:meta:"), meta: string(meta), ttl: ttl})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		opts := &buntdb.SetOptions{Expires: true, TTL: p.ttl}
+		if _, _, err := m.Tx.Set("clip:meta:"+p.id, p.meta, opts); err != nil {
+			return err
+		}
+		if data, err := m.Tx.Get("clip:data:" + p.id); err == nil {
+			if _, _, err := m.Tx.Set("clip:data:"+p.id, data, opts); err != nil {
+				return err
+			}
+		}
+		m.Changed++
+	}
+	return nil
+}
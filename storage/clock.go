@@ -0,0 +1,26 @@
+package storage
+
+import "time"
+
+// SkewTolerance is the clock difference between a position source and the server that is
+// accepted as is; OpenSky positions are normally a few seconds old.
+const SkewTolerance = 30 * time.Second
+
+// correctTS maps a source timestamp (unix seconds) to server time. It returns the action taken,
+// if any: "shifted" (source clock skew beyond SkewTolerance subtracted), "clamped" (still in the
+// future; set to now so it cannot stay ahead of every later sample) or "dropped" (older than
+// retention; it would be purged right away and corrupt the landed heuristic).
+func (s *Store) correctTS(ts int64, skew time.Duration, now time.Time) (int64, string) {
+	action := ""
+	if skew > SkewTolerance || skew < -SkewTolerance {
+		ts -= int64(skew / time.Second)
+		action = "shifted"
+	}
+	switch {
+	case ts > now.Add(SkewTolerance).Unix():
+		return now.Unix(), "clamped"
+	case ts < now.Add(-s.retention).Unix():
+		return ts, "dropped"
+	}
+	return ts, action
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/tidwall/buntdb"
+)
+
+// CompactConfig bounds the size of the database files beyond TTL retention (see Store.Compact).
+type CompactConfig struct {
+	MaxSize        int64         // total bytes of the base and shard files; 0 disables the hard cap
+	DownsampleAge  time.Duration // position history older than this is thinned; 0 disables
+	DownsampleKeep int           // keep every Nth sample per aircraft when thinning (>= 2)
+}
+
+var compactCfg = CompactConfig{DownsampleAge: 24 * time.Hour, DownsampleKeep: 4}
+
+// SetCompaction configures Store.Compact.
+func SetCompaction(cfg CompactConfig) { compactCfg = cfg }
+
+// downsampledKey holds the time up to which the history has been thinned (base file).
+const downsampledKey = "meta:downsampled"
+
+// capHeadroom is the fraction of MaxSize the hard cap shrinks the files to, so the next
+// compaction does not have to drop history again right away.
+const capHeadroom = 0.9
+
+// CompactResult reports one compaction run.
+type CompactResult struct {
+	Downsampled int   // samples removed by thinning
+	Dropped     int   // samples removed by the size cap
+	Reclaimed   int64 // bytes
+	Size        int64 // bytes of all files afterwards
+}
+
+// Compact thins position history older than DownsampleAge to every DownsampleKeep-th sample
+// per aircraft, rewrites the files without dead records (Shrink) and, when they still exceed
+// MaxSize, drops the oldest positions and shrinks again. Only pos:* records are removed.
+func (s *Store) Compact() (CompactResult, error) {
+	var res CompactResult
+	if s == nil || s.db == nil {
+		return res, ErrNotInitialized
+	}
+	cfg := compactCfg
+	before := s.fileSize()
+	if cfg.DownsampleAge > 0 && cfg.DownsampleKeep > 1 {
+		n, err := s.downsample(time.Now().Add(-cfg.DownsampleAge).Unix(), cfg.DownsampleKeep)
+		res.Downsampled = n
+		monitoring.StorageCompacted.WithLabelValues("downsample").Add(float64(n))
+		if err != nil {
+			return res, err
+		}
+	}
+	if err := s.shrink(); err != nil {
+		return res, err
+	}
+	res.Size = s.fileSize()
+	if cfg.MaxSize > 0 && res.Size > cfg.MaxSize {
+		n, err := s.dropOldest(res.Size - int64(float64(cfg.MaxSize)*capHeadroom))
+		res.Dropped = n
+		monitoring.StorageCompacted.WithLabelValues("cap").Add(float64(n))
+		if err != nil {
+			return res, err
+		}
+		if err := s.shrink(); err != nil {
+			return res, err
+		}
+		res.Size = s.fileSize()
+	}
+	res.Reclaimed = max(before-res.Size, 0)
+	monitoring.StorageReclaimed.Add(float64(res.Reclaimed))
+	monitoring.StorageSize.Set(float64(res.Size))
+	return res, nil
+}
+
+// files returns the paths of the base and shard files.
+func (s *Store) files() []string {
+	if len(s.shards) == 1 {
+		return []string{s.path}
+	}
+	out := []string{s.path}
+	for i := range s.shards {
+		out = append(out, shardPath(s.path, i))
+	}
+	return out
+}
+
+// fileSize returns the total size of the database files.
+func (s *Store) fileSize() int64 {
+	var n int64
+	for _, f := range s.files() {
+		if fi, err := os.Stat(f); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+func (s *Store) shrink() error {
+	dbs := []*buntdb.DB{s.db}
+	if len(s.shards) > 1 {
+		dbs = append(dbs, s.shards...)
+	}
+	for _, db := range dbs {
+		if err := db.Shrink(); err != nil && err != buntdb.ErrShrinkInProcess {
+			return fmt.Errorf("shrink: %w", err)
+		}
+	}
+	return nil
+}
+
+// scanPos calls fn for batches of up to latestBatch pos:* keys of db in [lo, hi), each batch read
+// in its own transaction; the keys fn returns are deleted before the next batch is read.
+func scanPos(db *buntdb.DB, lo, hi string, fn func(keys []string) []string) (int, error) {
+	from, deleted := lo, 0
+	for {
+		var keys []string
+		err := db.View(func(tx *buntdb.Tx) error {
+			return tx.AscendRange("", from, hi, func(key, _ string) bool {
+				keys = append(keys, key)
+				return len(keys) < latestBatch
+			})
+		})
+		if err != nil || len(keys) == 0 {
+			return deleted, err
+		}
+		from = keys[len(keys)-1] + "\x00"
+		if del := fn(keys); len(del) > 0 {
+			err := db.Update(func(tx *buntdb.Tx) error {
+				for _, k := range del {
+					if _, err := tx.Delete(k); err == nil {
+						deleted++
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if len(keys) < latestBatch {
+			return deleted, nil
+		}
+	}
+}
+
+// downsample keeps every keep-th sample per aircraft among those taken after the previous run's
+// cutoff and up to cutoff (unix seconds), so samples are thinned once.
+func (s *Store) downsample(cutoff int64, keep int) (int, error) {
+	var since int64
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		if v, err := tx.Get(downsampledKey); err == nil {
+			since, _ = strconv.ParseInt(v, 10, 64)
+		}
+		return nil
+	})
+	if since >= cutoff {
+		return 0, nil
+	}
+	total := 0
+	lo, hi := posRange(since+1, cutoff)
+	for _, db := range s.shards {
+		n := map[string]int{} // samples per aircraft in range so far, visited in time order
+		deleted, err := scanPos(db, lo, hi, func(keys []string) []string {
+			var del []string
+			for _, k := range keys {
+				icao, ts, ok := parsePosKey(k)
+				if !ok || ts <= since || ts > cutoff {
+					continue
+				}
+				if n[icao]%keep != 0 {
+					del = append(del, k)
+				}
+				n[icao]++
+			}
+			return del
+		})
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(downsampledKey, strconv.FormatInt(cutoff, 10), nil)
+		return err
+	})
+	return total, err
+}
+
+// dropOldest removes the oldest positions, whole hours at a time, until about excess bytes are
+// freed, estimating the size of a sample from the files and the number of samples.
+func (s *Store) dropOldest(excess int64) (int, error) {
+	hours := map[int64]int{}
+	count := 0
+	for _, db := range s.shards {
+		if _, err := scanPos(db, "pos:", "pos;", func(keys []string) []string {
+			for _, k := range keys {
+				if _, ts, ok := parsePosKey(k); ok {
+					hours[ts/3600]++
+					count++
+				}
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if count == 0 || excess <= 0 {
+		return 0, nil
+	}
+	need := int(excess / max(s.fileSize()/int64(count), 1))
+	// Hours are dropped oldest first until enough samples are covered; the newest hour is kept
+	var cutoff int64 // hour from which samples are kept
+	for acc := 0; acc < need && len(hours) > 1; {
+		oldest := int64(-1)
+		for h := range hours {
+			if oldest < 0 || h < oldest {
+				oldest = h
+			}
+		}
+		acc += hours[oldest]
+		delete(hours, oldest)
+		cutoff = oldest + 1
+	}
+	if cutoff == 0 {
+		return 0, nil
+	}
+	total := 0
+	for _, db := range s.shards {
+		deleted, err := scanPos(db, "pos:", "pos:"+posBucket(cutoff*3600), func(keys []string) []string { return keys })
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "errors"
+
+// Error kinds returned by Store methods; test with errors.Is.
+var (
+	ErrNotInitialized = errors.New("store not initialized")
+	ErrDisabled       = errors.New("disabled")
+	ErrInvalid        = errors.New("invalid argument")
+	ErrForbidden      = errors.New("owned by another principal")
+)
+
+// ValidationError reports invalid caller input (a bad rule, time range, sort field...). Its
+// message is meant for API clients; errors.Is(err, ErrInvalid) holds.
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string        { return e.Msg }
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalid }
+
+func invalid(msg string) error { return &ValidationError{Msg: msg} }
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// LogBatch is one ingest batch in the append-only event log (log:{seq}). It records the points
+// actually written (after filters, without re-polled samples) and, with tombstones, the aircraft
+// removed from the current state. Batches expire with the event log retention (compaction).
+type LogBatch struct {
+	Seq    int64    `json:"seq"`
+	TS     int64    `json:"ts"` // unix milliseconds of the ingest
+	Upsert []Point  `json:"upsert,omitempty"`
+	Delete []string `json:"delete,omitempty"`
+}
+
+const logSeqKey = "log:seq"
+
+// SetEventLog enables the ingest event log with the given retention (capped at the point
+// retention); ttl <= 0 disables it. The sequence continues from the persisted counter.
+func (s *Store) SetEventLog(ttl time.Duration) {
+	if s == nil {
+		return
+	}
+	if ttl > s.retention {
+		ttl = s.retention
+	}
+	s.logTTL = ttl
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		if v, err := tx.Get(logSeqKey); err == nil {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			s.seq.Store(n)
+		}
+		return nil
+	})
+}
+
+// appendLog writes a batch inside an ingest transaction and returns its sequence number (0 if disabled).
+func (s *Store) appendLog(tx *buntdb.Tx, upsert []Point, del []string) int64 {
+	if s.logTTL <= 0 || (len(upsert) == 0 && len(del) == 0) {
+		return 0
+	}
+	seq := s.seq.Add(1)
+	b, _ := json.Marshal(LogBatch{Seq: seq, TS: time.Now().UnixMilli(), Upsert: upsert, Delete: del})
+	_, _, _ = tx.Set(fmt.Sprintf("log:%012d", seq), string(b), &buntdb.SetOptions{Expires: true, TTL: s.logTTL})
+	_, _, _ = tx.Set(logSeqKey, strconv.FormatInt(seq, 10), nil)
+	return seq
+}
+
+// LastSeq returns the sequence number of the latest logged batch.
+func (s *Store) LastSeq() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.seq.Load()
+}
+
+// Changes returns up to limit batches with seq > since and the oldest sequence still retained.
+// When since+1 < oldest, the caller has missed compacted batches and must resynchronize.
+func (s *Store) Changes(since int64, limit int) ([]LogBatch, int64, error) {
+	if s == nil {
+		return nil, 0, ErrNotInitialized
+	}
+	if s.logTTL <= 0 {
+		return nil, 0, fmt.Errorf("event log %w", ErrDisabled)
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	out := []LogBatch{}
+	var oldest int64
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		_ = tx.AscendGreaterOrEqual("", "log:0", func(key, val string) bool {
+			if key != logSeqKey {
+				oldest, _ = strconv.ParseInt(key[4:], 10, 64)
+			}
+			return false
+		})
+		return tx.AscendGreaterOrEqual("", fmt.Sprintf("log:%012d", since+1), func(key, val string) bool {
+			if key == logSeqKey || len(key) != 16 {
+				return false
+			}
+			var b LogBatch
+			if json.Unmarshal([]byte(val), &b) == nil {
+				out = append(out, b)
+			}
+			return len(out) < limit
+		})
+	})
+	return out, oldest, err
+}
+
+// StateAt reconstructs the current-position state after batch seq by replaying the retained log.
+// Aircraft not updated within nowTTL before a batch are dropped, as with now:* expiry.
+// The result is exact only when the log covers the aircraft's whole presence (see Changes oldest).
+func (s *Store) StateAt(seq int64) (map[string]Point, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	state := map[string]Point{}
+	var since int64
+	for {
+		batches, _, err := s.Changes(since, 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range batches {
+			if b.Seq > seq {
+				return state, nil
+			}
+			for _, p := range b.Upsert {
+				state[p.Icao24] = p
+			}
+			for _, icao := range b.Delete {
+				delete(state, icao)
+			}
+			cutoff := b.TS/1000 - int64(s.nowTTL/time.Second)
+			for icao, p := range state {
+				if p.TS < cutoff {
+					delete(state, icao)
+				}
+			}
+			since = b.Seq
+		}
+		if len(batches) < 500 {
+			return state, nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Event is a detected occurrence derived from positions (runway movement, noise pass, ...).
+// Event keys (evt:{kind}:{ts}:{icao}) expire after eventRetention, far beyond raw positions.
+type Event struct {
+	Kind     string            `json:"kind"`
+	TS       int64             `json:"ts"`
+	Icao24   string            `json:"icao24"`
+	Callsign string            `json:"callsign,omitempty"`
+	Lat      float64           `json:"lat"`
+	Lon      float64           `json:"lon"`
+	Alt      float64           `json:"alt,omitempty"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+const eventRetention = 400 * 24 * time.Hour
+
+// observers are notified of each new position sample (re-polled samples are skipped) together
+// with the previous current position of the aircraft, if any. They run after the ingest commit.
+var observers []func(prev *Point, cur Point)
+
+// AddObserver registers a position observer. It must be called before ingestion starts.
+func AddObserver(fn func(prev *Point, cur Point)) { observers = append(observers, fn) }
+
+type observation struct {
+	prev *Point
+	cur  Point
+}
+
+// AddEvent stores an event.
+func (s *Store) AddEvent(e Event) error {
+	if s == nil {
+		return ErrNotInitialized
+	}
+	b, _ := json.Marshal(e)
+	key := fmt.Sprintf("evt:%s:%010d:%s", e.Kind, e.TS, e.Icao24)
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(key, string(b), &buntdb.SetOptions{Expires: true, TTL: eventRetention})
+		return err
+	})
+}
+
+// Events returns events of a kind with from <= ts <= to in ascending time order.
+func (s *Store) Events(kind string, from, to int64, match func(Event) bool) ([]Event, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	out := []Event{}
+	lo := fmt.Sprintf("evt:%s:%010d", kind, from)
+	hi := fmt.Sprintf("evt:%s:%010d", kind, to+1)
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", lo, hi, func(key, val string) bool {
+			var e Event
+			if json.Unmarshal([]byte(val), &e) == nil && (match == nil || match(e)) {
+				out = append(out, e)
+			}
+			return true
+		})
+	})
+	return out, err
+}
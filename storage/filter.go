@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"github.com/maniack/miniflightradar/geo"
+)
+
+// IngestFilter limits which states UpsertStates stores, reducing storage for regional
+// deployments that fetch global data. The zero value stores everything.
+type IngestFilter struct {
+	// Areas keeps only points inside any of the polygons (empty keeps all).
+	Areas []geo.Polygon
+	// AirborneOnly drops states reported on ground (OpenSky on_ground flag).
+	AirborneOnly bool
+	// ExcludeGround drops surface vehicles and obstacles (OpenSky categories 16..20,
+	// available when states are fetched with extended=1).
+	ExcludeGround bool
+}
+
+var ingestFilter IngestFilter
+
+// SetIngestFilter configures the ingest filter. It must be called before ingestion starts.
+func SetIngestFilter(f IngestFilter) { ingestFilter = f }
+
+// GetIngestFilter returns the configured ingest filter.
+func GetIngestFilter() IngestFilter { return ingestFilter }
+
+// reject returns the reason a state is filtered out, or "".
+// State fields used: 8:on_ground, 17:category.
+func (f IngestFilter) reject(st []interface{}, lat, lon float64) string {
+	if f.AirborneOnly && len(st) > 8 {
+		if onGround, _ := st[8].(bool); onGround {
+			return "on_ground"
+		}
+	}
+	if f.ExcludeGround && len(st) > 17 {
+		if cat, ok := toInt64(st[17]); ok && cat >= 16 && cat <= 20 {
+			return "ground_vehicle"
+		}
+	}
+	if len(f.Areas) > 0 {
+		for _, a := range f.Areas {
+			if a.Contains(lat, lon) {
+				return ""
+			}
+		}
+		return "outside_area"
+	}
+	return ""
+}
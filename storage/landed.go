@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/tidwall/buntdb"
+)
+
+// LandedPolicy decides when an aircraft still reported in the current state has landed (is
+// parked or taxiing) and is hidden from current views.
+type LandedPolicy struct {
+	// Window is the recent history that is examined; samples must cover at least half of it
+	// unless the source reports the aircraft on ground.
+	Window time.Duration
+	// MaxSpeed (m/s), MaxMove (m) and MaxAltChange (m) bound the last reported speed, the
+	// displacement and the altitude change over the window of a stationary aircraft.
+	MaxSpeed     float64
+	MaxMove      float64
+	MaxAltChange float64
+	// GroundSpeed (m/s): aircraft the source reports on ground (on_ground flag) below this speed
+	// count as landed right away, e.g. taxiing after landing. 0 ignores the flag.
+	GroundSpeed float64
+	// RunwayRadius (m): with an airport dataset (SetRunwayProximity), stationary aircraft
+	// farther than this from any runway are not considered landed (hovering helicopters,
+	// balloons, bad positions). 0 disables the check.
+	RunwayRadius float64
+}
+
+// DefaultLandedPolicy is the policy used unless SetLandedPolicy configures another one.
+var DefaultLandedPolicy = LandedPolicy{
+	Window:       10 * time.Minute,
+	MaxSpeed:     1.5,
+	MaxMove:      500,
+	MaxAltChange: 10,
+	GroundSpeed:  15,
+	RunwayRadius: 5000,
+}
+
+var landedPolicy = DefaultLandedPolicy
+
+// SetLandedPolicy configures the landed heuristic; a zero Window keeps the default window.
+func SetLandedPolicy(p LandedPolicy) {
+	if p.Window <= 0 {
+		p.Window = DefaultLandedPolicy.Window
+	}
+	landedPolicy = p
+}
+
+// nearRunwayFn reports whether a position is within radius meters of a known runway; nil skips
+// the proximity check.
+var nearRunwayFn func(lat, lon, radius float64) bool
+
+// SetRunwayProximity configures the runway lookup used by LandedPolicy.RunwayRadius.
+func SetRunwayProximity(fn func(lat, lon, radius float64) bool) { nearRunwayFn = fn }
+
+// airportFn returns the code of the airport at a position ("" when none); nil disables the
+// annotation of samples on the ground.
+var airportFn func(lat, lon float64) string
+
+// airportMaxAGL is the height above ground (m) below which airborne samples are annotated with
+// the airport they are over as well, so alert rules can tell off-airport low flying.
+const airportMaxAGL = 1500 * geo.Foot
+
+// SetAirportLookup configures the airport lookup that annotates landed and takeoff samples,
+// and those less than airportMaxAGL above ground, (Point.Airport) on ingest.
+func SetAirportLookup(fn func(lat, lon float64) string) { airportFn = fn }
+
+// landedCache holds verdicts of the current poll; it is reset by every ingest.
+type landedCache struct {
+	mu      sync.Mutex
+	gen     int64 // ingest generation the verdicts belong to
+	verdict map[string]bool
+}
+
+// invalidateLanded drops cached verdicts after new positions were stored.
+func (s *Store) invalidateLanded() {
+	s.landed.mu.Lock()
+	s.landed.gen++
+	s.landed.verdict = nil
+	s.landed.mu.Unlock()
+}
+
+// IsLanded applies the landed policy to an aircraft. Verdicts are cached until the next ingest,
+// so repeated bbox/WS requests within a poll do not rescan the history.
+func (s *Store) IsLanded(icao string) (bool, error) {
+	if s == nil {
+		return false, ErrNotInitialized
+	}
+	s.landed.mu.Lock()
+	v, ok := s.landed.verdict[icao]
+	gen := s.landed.gen
+	s.landed.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+	v, err := s.isLanded(icao, landedPolicy)
+	if err != nil {
+		return false, err
+	}
+	s.landed.mu.Lock()
+	if s.landed.gen == gen {
+		if s.landed.verdict == nil {
+			s.landed.verdict = map[string]bool{}
+		}
+		s.landed.verdict[icao] = v
+	}
+	s.landed.mu.Unlock()
+	return v, nil
+}
+
+// IsLandedWithin reports whether the aircraft for given ICAO has been effectively stationary
+// (on the ground) within the provided time window, using the configured policy otherwise.
+// Unlike IsLanded the verdict is not cached.
+func (s *Store) IsLandedWithin(icao string, window time.Duration) (bool, error) {
+	if s == nil {
+		return false, ErrNotInitialized
+	}
+	p := landedPolicy
+	if window > 0 {
+		p.Window = window
+	}
+	return s.isLanded(icao, p)
+}
+
+func (s *Store) isLanded(icao string, pol LandedPolicy) (bool, error) {
+	var landed bool
+	err := s.shard(icao).View(func(tx *buntdb.Tx) error {
+		landed = pol.landedInTx(tx, icao, time.Now())
+		return nil
+	})
+	return landed, err
+}
+
+// dropLanded removes landed aircraft from pts, which belong to the shard of tx. Cached verdicts
+// are reused and misses are evaluated within tx, so a current-state query needs a single
+// transaction per shard rather than one per aircraft.
+func (s *Store) dropLanded(tx *buntdb.Tx, pts []Point) []Point {
+	pol := landedPolicy
+	now := time.Now()
+	s.landed.mu.Lock()
+	gen := s.landed.gen
+	verdicts := make([]int8, len(pts)) // 0 unknown, 1 airborne, 2 landed
+	for i, p := range pts {
+		if v, ok := s.landed.verdict[p.Icao24]; ok {
+			verdicts[i] = 1
+			if v {
+				verdicts[i] = 2
+			}
+		}
+	}
+	s.landed.mu.Unlock()
+	fresh := map[string]bool{}
+	out := pts[:0]
+	for i, p := range pts {
+		landed := verdicts[i] == 2
+		if verdicts[i] == 0 {
+			landed = pol.landedInTx(tx, p.Icao24, now)
+			fresh[p.Icao24] = landed
+		}
+		if !landed {
+			out = append(out, p)
+		}
+	}
+	if len(fresh) > 0 {
+		s.landed.mu.Lock()
+		if s.landed.gen == gen {
+			if s.landed.verdict == nil {
+				s.landed.verdict = make(map[string]bool, len(fresh))
+			}
+			for k, v := range fresh {
+				s.landed.verdict[k] = v
+			}
+		}
+		s.landed.mu.Unlock()
+	}
+	return out
+}
+
+// landedInTx reads the newest samples of icao within the policy window and applies the policy.
+func (pol LandedPolicy) landedInTx(tx *buntdb.Tx, icao string, now time.Time) bool {
+	var newest *Point
+	var oldest *Point
+	cutoff := now.Add(-pol.Window).Unix()
+	count := 0
+	// The first sample before the window is the oldest one compared; look one window further back
+	descendTrack(tx, icao, now.Add(-2*pol.Window).Unix(), now.Add(time.Hour).Unix(), func(key, val string) bool {
+		var p Point
+		if json.Unmarshal([]byte(val), &p) != nil {
+			return true
+		}
+		if newest == nil {
+			newest = &p
+		}
+		oldest = &p
+		count++
+		if p.TS < cutoff || count >= 10 {
+			return false
+		}
+		return true
+	})
+	return pol.landed(newest, oldest)
+}
+
+// landed evaluates the policy on the newest and oldest sample of the window.
+func (pol LandedPolicy) landed(newest, oldest *Point) bool {
+	if newest == nil || oldest == nil {
+		return false
+	}
+	if pol.GroundSpeed > 0 && newest.Ground && newest.Speed < pol.GroundSpeed {
+		return true
+	}
+	if math.Abs(newest.VRate) > phaseVRate {
+		// Climbing or descending per the source: airborne whatever the positions say
+		return false
+	}
+	if newest.TS-oldest.TS < int64((pol.Window/time.Second)/2) {
+		// Not enough history to decide
+		return false
+	}
+	altDiff := math.Abs(newest.Alt - oldest.Alt)
+	dist := haversineMeters(oldest.Lat, oldest.Lon, newest.Lat, newest.Lon)
+	// consider landed if last speed ~0, tiny movement and nearly no alt change
+	if newest.Speed > pol.MaxSpeed || dist >= pol.MaxMove || altDiff >= pol.MaxAltChange {
+		return false
+	}
+	if pol.RunwayRadius > 0 && nearRunwayFn != nil && !nearRunwayFn(newest.Lat, newest.Lon, pol.RunwayRadius) {
+		return false
+	}
+	return true
+}
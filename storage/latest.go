@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/tidwall/buntdb"
+)
+
+// latest:ICAO holds the newest sample of every aircraft within retention (expiring with it),
+// written with the position history. RebuildNow restores the current state from it instead of
+// scanning pos:* keys. Databases written before the index existed are backfilled once in the
+// background; latestIndexKey in the base file marks the index as complete.
+const latestIndexKey = "meta:latest_index"
+
+// latestBatch bounds the history records scanned per transaction while backfilling, so the
+// ingest and readers are not blocked for long.
+const latestBatch = 10000
+
+// pointTS returns the timestamp of a stored point (0 when it cannot be decoded).
+func pointTS(val string) int64 {
+	var p struct {
+		TS int64 `json:"ts"`
+	}
+	_ = json.Unmarshal([]byte(val), &p)
+	return p.TS
+}
+
+// setLatest stores val as the newest sample of icao unless the index holds a newer one.
+func setLatest(tx *buntdb.Tx, icao, val string, ttl time.Duration) {
+	if cur, err := tx.Get("latest:" + icao); err == nil && pointTS(cur) > pointTS(val) {
+		return
+	}
+	_, _, _ = tx.Set("latest:"+icao, val, &buntdb.SetOptions{Expires: true, TTL: ttl})
+}
+
+// latestSample returns the newest stored sample of icao (nil when there is none) from the
+// latest:* index in tx, its shard; ok is false while the index is incomplete, in which case the
+// caller reads the history instead.
+func (s *Store) latestSample(tx *buntdb.Tx, icao string) (p *Point, ok bool) {
+	if !s.indexed.Load() {
+		return nil, false
+	}
+	v, err := tx.Get("latest:" + icao)
+	if err != nil {
+		return nil, true
+	}
+	var pt Point
+	if json.Unmarshal([]byte(v), &pt) != nil {
+		return nil, false
+	}
+	return &pt, true
+}
+
+// latestIndexed reports whether the latest:* index is complete. A dataset without history is
+// marked as indexed right away, since every write maintains the index.
+func (s *Store) latestIndexed() bool {
+	indexed := false
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(latestIndexKey)
+		indexed = err == nil
+		return nil
+	})
+	if indexed {
+		s.indexed.Store(true)
+		return true
+	}
+	empty := true
+	for _, db := range s.shards {
+		_ = db.View(func(tx *buntdb.Tx) error {
+			_ = tx.AscendKeys("pos:*", func(_, _ string) bool {
+				empty = false
+				return false
+			})
+			return nil
+		})
+	}
+	if empty {
+		s.markLatestIndexed()
+	}
+	return empty
+}
+
+func (s *Store) markLatestIndexed() {
+	s.indexed.Store(true)
+	_ = s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(latestIndexKey, "1", nil)
+		return err
+	})
+}
+
+// backfillLatest builds the latest:* index from the position history, shard by shard in
+// batches with progress logging, then restores the current state from it.
+func (s *Store) backfillLatest() {
+	defer monitoring.Recover("storage.rebuild")
+	start := time.Now()
+	scanned := 0
+	log.Printf("storage: building latest position index from history")
+	for k, db := range s.shards {
+		from := "pos:"
+		for {
+			latest := map[string]string{}
+			n := 0
+			err := db.View(func(tx *buntdb.Tx) error {
+				return tx.AscendGreaterOrEqual("", from, func(key, val string) bool {
+					if !strings.HasPrefix(key, "pos:") {
+						return false
+					}
+					icao, ok := aircraftKeyICAO(key)
+					if !ok {
+						return true
+					}
+					latest[icao] = val // ascending by time: the last one wins
+					from = key + "\x00"
+					n++
+					return n < latestBatch
+				})
+			})
+			if err == nil && len(latest) > 0 {
+				err = db.Update(func(tx *buntdb.Tx) error {
+					for icao, val := range latest {
+						// The index entry expires when the sample leaves retention
+						if ttl := s.retention - time.Since(time.Unix(pointTS(val), 0)); ttl > 0 {
+							setLatest(tx, icao, val, ttl)
+						}
+					}
+					return nil
+				})
+			}
+			if err != nil {
+				log.Printf("storage: latest position index: shard %d: %v", k, err)
+				return
+			}
+			if scanned/migrationLogEvery != (scanned+n)/migrationLogEvery {
+				log.Printf("storage: latest position index: scanned=%d", scanned+n)
+			}
+			scanned += n
+			if n < latestBatch {
+				break
+			}
+		}
+	}
+	s.markLatestIndexed()
+	if err := s.restoreNow(); err != nil {
+		log.Printf("storage: restore current state: %v", err)
+		return
+	}
+	s.publishSnapshot(nil)
+	log.Printf("storage: latest position index built: scanned=%d duration=%s", scanned, time.Since(start).Round(time.Millisecond))
+}
+
+// restoreNow sets now:* and callsign mapping keys from the latest:* index. Current positions
+// written meanwhile (newer than the indexed sample) are kept.
+func (s *Store) restoreNow() error {
+	var mu sync.Mutex
+	callsigns := map[string]string{} // callsign -> icao
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		err := s.fanOut(tx, true, func(_ int, tx *buntdb.Tx) error {
+			var restore [][2]string
+			_ = tx.AscendKeys("latest:*", func(key, val string) bool {
+				restore = append(restore, [2]string{key[len("latest:"):], val})
+				return true
+			})
+			for _, r := range restore {
+				icao, val := r[0], r[1]
+				if cur, err := tx.Get("now:" + icao); err == nil && pointTS(cur) >= pointTS(val) {
+					continue
+				}
+				// Restore now: key; it is tombstoned after nowTTL unless seen again (TTL is a fallback)
+				_, _, _ = tx.Set("now:"+icao, val, &buntdb.SetOptions{Expires: true, TTL: 2 * s.nowTTL})
+				s.markSeen(icao, time.Now().Add(s.nowTTL))
+				var p Point
+				if json.Unmarshal([]byte(val), &p) == nil && p.Callsign != "" {
+					mu.Lock()
+					callsigns[normalizeCallsign(p.Callsign)] = icao
+					mu.Unlock()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for cs, icao := range callsigns {
+			_, _, _ = tx.Set("map:cs:"+cs, icao, &buntdb.SetOptions{Expires: true, TTL: s.retention})
+		}
+		return nil
+	})
+}
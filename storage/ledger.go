@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// LedgerEntry is the all-time record of a single airframe (ICAO24). Ledger keys (ledger:{icao})
+// have no TTL, so the ledger outlives point retention.
+type LedgerEntry struct {
+	Icao24       string `json:"icao24"`
+	FirstSeen    int64  `json:"first_seen"`
+	LastSeen     int64  `json:"last_seen"`
+	Sightings    int64  `json:"sightings"` // distinct appearances (separated by ledgerSessionGap)
+	Samples      int64  `json:"samples"`   // stored position samples
+	LastCallsign string `json:"last_callsign,omitempty"`
+}
+
+// ledgerSessionGap separates two sightings of the same airframe.
+const ledgerSessionGap = int64(30 * 60)
+
+// ledgerCountKey holds the number of airframes in the ledger, maintained on ingest so pages
+// do not have to walk the whole ledger for their total.
+const ledgerCountKey = "meta:ledger_count"
+
+// newAirframeFn is invoked (after commit) for airframes entering the ledger; nil disables the
+// new_airframe hook.
+var newAirframeFn func(Sighting)
+
+// SetNewAirframeHandler configures the callback invoked (after commit) for airframes seen for
+// the first time. It starts once rarityMinTotal sightings are counted: on a fresh database
+// every airframe is new.
+func SetNewAirframeHandler(fn func(Sighting)) { newAirframeFn = fn }
+
+// ledgerIndexes maps sort keys to buntdb index names over ledger:* values.
+var ledgerIndexes = map[string]string{
+	"first_seen": "ledger_first_seen",
+	"last_seen":  "ledger_last_seen",
+	"sightings":  "ledger_sightings",
+	"samples":    "ledger_samples",
+}
+
+func createLedgerIndexes(db *buntdb.DB) {
+	for field, name := range ledgerIndexes {
+		_ = db.CreateIndex(name, "ledger:*", buntdb.IndexJSON(field))
+	}
+}
+
+// updateLedger records a position sample in the airframe ledger inside an ingest transaction.
+// It reports whether the airframe has never been seen before and whether the sample starts a
+// new sighting (which includes a first sighting).
+func updateLedger(tx *buntdb.Tx, p Point) (isNew, sighting bool) {
+	key := "ledger:" + p.Icao24
+	var e LedgerEntry
+	isNew = true
+	if v, err := tx.Get(key); err == nil && json.Unmarshal([]byte(v), &e) == nil {
+		isNew = false
+	}
+	if isNew {
+		e = LedgerEntry{Icao24: p.Icao24, FirstSeen: p.TS, LastSeen: p.TS, Sightings: 1}
+		sighting = true
+		_, _, _ = tx.Set(ledgerCountKey, strconv.Itoa(ledgerCount(tx)+1), nil)
+	} else if p.TS == e.LastSeen {
+		// same sample re-polled (no new contact since last ingest)
+		return false, false
+	} else {
+		if p.TS < e.LastSeen {
+			// late/out-of-order sample; only widen first_seen if needed
+			if p.TS < e.FirstSeen {
+				e.FirstSeen = p.TS
+			}
+		} else {
+			if p.TS-e.LastSeen > ledgerSessionGap {
+				e.Sightings++
+				sighting = true
+			}
+			e.LastSeen = p.TS
+		}
+	}
+	e.Samples++
+	if p.Callsign != "" {
+		e.LastCallsign = p.Callsign
+	}
+	b, _ := json.Marshal(e)
+	_, _, _ = tx.Set(key, string(b), nil)
+	return isNew, sighting
+}
+
+// ledgerCount returns the number of airframes in the ledger.
+func ledgerCount(tx *buntdb.Tx) int {
+	v, err := tx.Get(ledgerCountKey)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// migrateLedgerCount initializes the ledger count of databases written before it was kept.
+func migrateLedgerCount(m *MigrationRun) error {
+	n := 0
+	err := m.Tx.AscendKeys("ledger:*", func(string, string) bool {
+		m.Scanned()
+		n++
+		return true
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+	m.Changed++
+	_, _, err = m.Tx.Set(ledgerCountKey, strconv.Itoa(n), nil)
+	return err
+}
+
+// LedgerGet returns the ledger entry for an ICAO24 or nil if it has never been seen.
+func (s *Store) LedgerGet(icao string) (*LedgerEntry, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	var out *LedgerEntry
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		v, err := tx.Get("ledger:" + normalizeICAO(icao))
+		if err != nil {
+			return err
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return err
+		}
+		out = &e
+		return nil
+	})
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return nil, nil
+	}
+	return out, err
+}
+
+// Ledger returns a page of ledger entries ordered by sortBy (first_seen, last_seen, sightings,
+// samples or icao24) and the total number of airframes in the ledger. The page is read from the
+// sort index, stopping once it is full; the total is the maintained count.
+func (s *Store) Ledger(sortBy string, desc bool, offset, limit int) ([]LedgerEntry, int, error) {
+	if s == nil {
+		return nil, 0, ErrNotInitialized
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	sortBy = strings.ToLower(strings.TrimSpace(sortBy))
+	index, ok := ledgerIndexes[sortBy]
+	if !ok && sortBy != "" && sortBy != "icao24" {
+		return nil, 0, invalid("unsupported sort field")
+	}
+	out := make([]LedgerEntry, 0, limit)
+	total := 0
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		total = ledgerCount(tx)
+		if offset >= total {
+			return nil
+		}
+		skipped := 0
+		iter := func(key, val string) bool {
+			if skipped < offset {
+				skipped++
+				return true
+			}
+			var e LedgerEntry
+			if json.Unmarshal([]byte(val), &e) == nil {
+				out = append(out, e)
+			}
+			return len(out) < limit
+		}
+		switch {
+		case index != "" && desc:
+			return tx.Descend(index, iter)
+		case index != "":
+			return tx.Ascend(index, iter)
+		case desc:
+			return tx.DescendKeys("ledger:*", iter)
+		default:
+			return tx.AscendKeys("ledger:*", iter)
+		}
+	})
+	return out, total, err
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// schemaKey holds the key-schema version of the database.
+const schemaKey = "meta:schema"
+
+// migrationLogEvery controls progress logging while a migration scans records.
+const migrationLogEvery = 50000
+
+// ErrMigration wraps failures to bring the database to the current schema; the store is not
+// opened in that case so records of different formats are never mixed.
+var ErrMigration = errors.New("storage migration")
+
+// Migration upgrades the key schema from Version-1 to Version (e.g. when Point gains fields or
+// a key format changes). Apply runs inside a write transaction, once for the base file and once
+// for every shard file. Migrations that rewrite many records work in batches: Apply sets
+// MigrationRun.More and is called again in a new transaction; the version is stamped with the
+// last batch.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(m *MigrationRun) error
+}
+
+// MigrationRun is passed to Migration.Apply. In dry-run mode the transaction is rolled back
+// afterwards, so Apply runs the same code either way.
+type MigrationRun struct {
+	Tx      *buntdb.Tx
+	DryRun  bool
+	Changed int // records rewritten or deleted; reported in logs
+
+	// More requests another batch: Apply is called again in a new transaction and resumes from
+	// Cursor. Dry runs roll back every transaction, so they must finish in one call.
+	More   bool
+	Cursor string
+
+	name    string
+	scanned int
+}
+
+// Scanned records progress (one call per record visited) and logs it periodically.
+func (m *MigrationRun) Scanned() {
+	m.scanned++
+	if m.scanned%migrationLogEvery == 0 {
+		log.Printf("storage migration %s: scanned=%d changed=%d", m.name, m.scanned, m.Changed)
+	}
+}
+
+// MigrationResult summarizes one applied (or, in dry-run mode, planned) migration.
+type MigrationResult struct {
+	Version  int
+	Name     string
+	Scanned  int
+	Changed  int
+	Duration time.Duration
+}
+
+// migrations lists schema upgrades in version order; append new ones with the next version.
+var migrations = []Migration{
+	// Version 1 is the layout this framework was introduced with: pos:ICAO:TS, now:ICAO,
+	// map:cs:CALLSIGN, log:SEQ, ledger, rollup and clip keys. Existing databases are stamped.
+	{Version: 1, Name: "baseline", Apply: func(*MigrationRun) error { return nil }},
+	{Version: 2, Name: "hourly position buckets", Apply: migrateHourlyBuckets},
+	{Version: 3, Name: "clip expiry", Apply: migrateClipExpiry},
+	{Version: 4, Name: "ledger count", Apply: migrateLedgerCount},
+}
+
+// SchemaVersion is the key-schema version this build reads and writes.
+func SchemaVersion() int { return migrations[len(migrations)-1].Version }
+
+func schemaVersion(tx *buntdb.Tx) (int, error) {
+	v, err := tx.Get(schemaKey)
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// migrate brings db to SchemaVersion, one transaction per migration. New (empty) databases are
+// stamped with the current version; a database written by a newer build is refused.
+func migrate(db *buntdb.DB, dryRun bool) ([]MigrationResult, error) {
+	var current int
+	empty := true
+	err := db.View(func(tx *buntdb.Tx) error {
+		var err error
+		if current, err = schemaVersion(tx); err != nil {
+			return err
+		}
+		return tx.AscendKeys("*", func(string, string) bool {
+			empty = false
+			return false
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: read schema version: %v", ErrMigration, err)
+	}
+	latest := SchemaVersion()
+	if current > latest {
+		return nil, fmt.Errorf("%w: database schema v%d is newer than this build supports (v%d)", ErrMigration, current, latest)
+	}
+	if empty {
+		if !dryRun {
+			err = db.Update(func(tx *buntdb.Tx) error {
+				_, _, err := tx.Set(schemaKey, strconv.Itoa(latest), nil)
+				return err
+			})
+		}
+		return nil, err
+	}
+	var out []MigrationResult
+	for _, mg := range migrations {
+		if mg.Version <= current {
+			continue
+		}
+		run := &MigrationRun{DryRun: dryRun, name: fmt.Sprintf("v%d %s", mg.Version, mg.Name)}
+		start := time.Now()
+		errDryRun := errors.New("dry run")
+		for {
+			run.More = false
+			err := db.Update(func(tx *buntdb.Tx) error {
+				run.Tx = tx
+				if err := mg.Apply(run); err != nil {
+					return err
+				}
+				if dryRun {
+					return errDryRun // roll back
+				}
+				if run.More {
+					return nil
+				}
+				_, _, err := tx.Set(schemaKey, strconv.Itoa(mg.Version), nil)
+				return err
+			})
+			if err != nil && !errors.Is(err, errDryRun) {
+				return out, fmt.Errorf("%w: %s: %v", ErrMigration, run.name, err)
+			}
+			if !run.More || dryRun {
+				break
+			}
+		}
+		res := MigrationResult{Version: mg.Version, Name: mg.Name, Scanned: run.scanned, Changed: run.Changed, Duration: time.Since(start)}
+		out = append(out, res)
+		mode := ""
+		if dryRun {
+			mode = " (dry run)"
+		}
+		log.Printf("storage migration%s %s: scanned=%d changed=%d duration=%s", mode, run.name, res.Scanned, res.Changed, res.Duration.Round(time.Millisecond))
+	}
+	return out, nil
+}
+
+// DryRunMigrations opens the database at path and its shard files, runs pending migrations in
+// rolled-back transactions and reports what they would change.
+func DryRunMigrations(path string) ([]MigrationResult, error) {
+	path = resolvePath(path)
+	db, err := buntdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	out, err := migrate(db, true)
+	if err != nil {
+		return out, err
+	}
+	shards := 1
+	_ = db.View(func(tx *buntdb.Tx) error {
+		if v, err := tx.Get(shardMetaKey); err == nil {
+			shards, _ = strconv.Atoi(v)
+		}
+		return nil
+	})
+	for i := 0; i < shards && shards > 1; i++ {
+		sdb, err := buntdb.Open(shardPath(path, i))
+		if err != nil {
+			return out, err
+		}
+		res, err := migrate(sdb, true)
+		_ = sdb.Close()
+		out = append(out, res...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Flight phases stored on Point.Phase; empty means not enough data yet.
+const (
+	PhaseLanded  = "landed"  // on the ground: parked, taxiing or rolling out after landing
+	PhaseTakeoff = "takeoff" // take-off roll and initial climb close to the ground
+	PhaseClimb   = "climb"
+	PhaseCruise  = "cruise"
+	PhaseDescent = "descent"
+)
+
+// Phase thresholds: below phaseGroundSpeed an aircraft at (near) zero height is taxiing or
+// parked; a vertical speed beyond phaseVRate m/s (~500 ft/min) counts as climbing or
+// descending; take-off ends phaseTakeoffHeight meters above ground. The vertical speed is the
+// one reported by the source, else measured over at least phaseMinInterval (smoothing altitude
+// steps) and at most phaseWindow. maxVRate bounds reported rates (~40000 ft/min).
+const (
+	phaseGroundSpeed   = 25.0
+	phaseVRate         = 2.5
+	phaseMinInterval   = 30 * time.Second
+	phaseWindow        = 3 * time.Minute
+	phaseTakeoffHeight = 450.0 // ~1500 ft
+	maxVRate           = 200.0
+)
+
+// flightPhase classifies p from its previous current position and its reported vertical rate
+// or, without one, the vertical speed since the newest stored sample at least phaseMinInterval
+// older (read from tx, the shard of p).
+func flightPhase(tx *buntdb.Tx, p Point, prev *Point) string {
+	height := p.Alt
+	if p.AGL != nil {
+		height = *p.AGL
+	}
+	if p.Ground || p.Alt <= 0 || (p.AGL != nil && *p.AGL < 30 && p.Speed < phaseGroundSpeed) {
+		// Fast on the ground: accelerating for take-off or decelerating after landing
+		if p.Speed >= phaseGroundSpeed && (prev == nil || prev.Speed <= p.Speed) {
+			return PhaseTakeoff
+		}
+		return PhaseLanded
+	}
+	if p.VRate != 0 {
+		return climbPhase(p.VRate, height, prev)
+	}
+	var old *Point
+	cutoff := p.TS - int64(phaseWindow/time.Second)
+	minTS := p.TS - int64(phaseMinInterval/time.Second)
+	descendTrack(tx, p.Icao24, cutoff, p.TS-1, func(key, val string) bool {
+		var s Point
+		if json.Unmarshal([]byte(val), &s) != nil {
+			return true
+		}
+		old = &s
+		return s.TS > minTS // older samples only when this one is too recent
+	})
+	if old == nil || old.TS >= p.TS {
+		if prev != nil {
+			return prev.Phase // no trend yet: keep the last verdict
+		}
+		return ""
+	}
+	return climbPhase((p.Alt-old.Alt)/float64(p.TS-old.TS), height, prev)
+}
+
+// climbPhase classifies an airborne aircraft by its vertical rate vr (m/s).
+func climbPhase(vr, height float64, prev *Point) string {
+	switch {
+	case vr > phaseVRate:
+		// Take-off continues while climbing out low after the ground phases
+		if height < phaseTakeoffHeight && prev != nil && (prev.Phase == PhaseLanded || prev.Phase == PhaseTakeoff) {
+			return PhaseTakeoff
+		}
+		return PhaseClimb
+	case vr < -phaseVRate:
+		return PhaseDescent
+	}
+	return PhaseCruise
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// RarityEntry counts local sightings of one operator (ICAO airline designator) or aircraft type.
+// Rarity keys (rarity:op:{DLH}, rarity:type:{A320}) have no TTL, like the ledger.
+type RarityEntry struct {
+	Kind      string `json:"kind"` // "operator" or "type"
+	Key       string `json:"key"`
+	Sightings int64  `json:"sightings"`
+	FirstSeen int64  `json:"first_seen"`
+	FirstIcao string `json:"first_icao24,omitempty"`
+	Score     int    `json:"score"`
+}
+
+const (
+	rarityTotalKey = "rarity:total"
+	// rarityMinTotal is the number of sightings needed before scores are meaningful.
+	rarityMinTotal = 200
+)
+
+var rarityPrefixes = map[string]string{"operator": "rarity:op:", "type": "rarity:type:"}
+
+// Sighting is a new appearance of an airframe reported to the rare-sighting hook.
+type Sighting struct {
+	Point    Point
+	Operator string
+	Type     string
+}
+
+var (
+	// rareThreshold is the minimum score for the rare_aircraft hook; <= 0 disables it.
+	rareThreshold = 80
+	rareFn        func(Sighting)
+)
+
+// SetRareHandler configures the callback invoked (after commit) for new sightings with a rarity
+// score at or above threshold.
+func SetRareHandler(threshold int, fn func(Sighting)) {
+	rareThreshold = threshold
+	rareFn = fn
+}
+
+// newSighting describes a sighting of p for the hooks.
+func newSighting(p Point) Sighting {
+	sg := Sighting{Point: p, Operator: AirlinePrefix(p.Callsign)}
+	if typeResolver != nil {
+		sg.Type = typeResolver(p.Icao24)
+	}
+	return sg
+}
+
+// sightingsTotal returns the number of sightings counted so far.
+func sightingsTotal(tx *buntdb.Tx) int64 {
+	var total int64
+	if v, err := tx.Get(rarityTotalKey); err == nil {
+		total, _ = strconv.ParseInt(v, 10, 64)
+	}
+	return total
+}
+
+// rarityScore maps n sightings out of total to 0..100 on a log scale (100 = never seen).
+func rarityScore(n, total int64) int {
+	if total < rarityMinTotal {
+		return 0
+	}
+	return int(math.Round(100 * (1 - math.Log1p(float64(n))/math.Log1p(float64(total)))))
+}
+
+// updateRarity counts a new sighting (when sighting is true) per operator and type and returns the
+// rarity score of the point: the highest of its operator and type scores. firsts lists the kinds
+// ("operator", "type") seen for the first time; the caller counts them once the tx commits.
+func updateRarity(tx *buntdb.Tx, p Point, sighting bool) (score int, firsts []string) {
+	total := sightingsTotal(tx)
+	if sighting {
+		total++
+		_, _, _ = tx.Set(rarityTotalKey, strconv.FormatInt(total, 10), nil)
+	}
+	dims := [][2]string{{"operator", AirlinePrefix(p.Callsign)}}
+	if typeResolver != nil {
+		dims = append(dims, [2]string{"type", typeResolver(p.Icao24)})
+	}
+	for _, d := range dims {
+		if d[1] == "" {
+			continue
+		}
+		key := rarityPrefixes[d[0]] + d[1]
+		var e RarityEntry
+		found := false
+		if v, err := tx.Get(key); err == nil && json.Unmarshal([]byte(v), &e) == nil {
+			found = true
+		}
+		if sighting {
+			if !found {
+				e = RarityEntry{Kind: d[0], Key: d[1], FirstSeen: p.TS, FirstIcao: p.Icao24}
+				firsts = append(firsts, d[0])
+			}
+			e.Sightings++
+			b, _ := json.Marshal(e)
+			_, _, _ = tx.Set(key, string(b), nil)
+		}
+		// score against sightings before this one so a first-of-type scores 100
+		n := e.Sightings
+		if sighting {
+			n--
+		}
+		if s := rarityScore(n, total); s > score {
+			score = s
+		}
+	}
+	return score, firsts
+}
+
+// Rarity returns operators or types (kind "operator" or "type") ordered from rarest, with scores.
+func (s *Store) Rarity(kind string, limit int) ([]RarityEntry, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	prefix, ok := rarityPrefixes[strings.ToLower(strings.TrimSpace(kind))]
+	if !ok {
+		return nil, invalid("unsupported kind")
+	}
+	out := []RarityEntry{}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		total := sightingsTotal(tx)
+		return tx.AscendKeys(prefix+"*", func(key, val string) bool {
+			var e RarityEntry
+			if json.Unmarshal([]byte(val), &e) == nil {
+				e.Score = rarityScore(e.Sightings, total)
+				out = append(out, e)
+			}
+			return true
+		})
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Sightings < out[j].Sightings })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, err
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Recording reads the position history of a database file written by this application (the
+// base file or one of its shard files) hour by hour, for replaying it. The file is not opened
+// as the store and is never written, so the server recording it may keep running.
+type Recording struct {
+	db       *buntdb.DB
+	from, to int64 // first and last sample (unix seconds)
+}
+
+// OpenRecording opens a recorded database file and finds the hours it covers.
+func OpenRecording(path string) (*Recording, error) {
+	// buntdb creates missing files
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := buntdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// Never rewrite someone else's file
+	_ = db.SetConfig(buntdb.Config{SyncPolicy: buntdb.Never, AutoShrinkDisabled: true})
+	r := &Recording{db: db}
+	err = db.View(func(tx *buntdb.Tx) error {
+		if v, err := schemaVersion(tx); err != nil || v > SchemaVersion() {
+			return fmt.Errorf("%s: written by a newer version (schema %d)", path, v)
+		}
+		var first, last string
+		_ = tx.AscendRange("", "pos:", "pos;", func(key, _ string) bool { first = key; return false })
+		_ = tx.DescendRange("", "pos;", "pos:", func(key, _ string) bool { last = key; return false })
+		_, ts0, ok0 := parsePosKey(first)
+		_, ts1, ok1 := parsePosKey(last)
+		if !ok0 || !ok1 {
+			return fmt.Errorf("%s: no position history (databases older than schema 2 must be opened by the server once to migrate)", path)
+		}
+		// Keys are ordered by hour, then aircraft: scan the first and last hour for the extremes
+		r.from, r.to = ts0, ts1
+		for _, h := range []int64{ts0, ts1} {
+			prefix := "pos:" + posBucket(h) + ":"
+			_ = tx.AscendRange("", prefix, prefix[:len(prefix)-1]+";", func(key, _ string) bool {
+				if _, ts, ok := parsePosKey(key); ok {
+					r.from, r.to = min(r.from, ts), max(r.to, ts)
+				}
+				return true
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Span returns the time of the first and last sample of the recording (unix seconds).
+func (r *Recording) Span() (from, to int64) { return r.from, r.to }
+
+// Hour returns the samples of the hour starting at h (unix seconds) in time order.
+func (r *Recording) Hour(h int64) ([]Point, error) {
+	pts := []Point{}
+	prefix := "pos:" + posBucket(h) + ":"
+	err := r.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", prefix, prefix[:len(prefix)-1]+";", func(key, val string) bool {
+			var p Point
+			if json.Unmarshal([]byte(val), &p) == nil && strings.HasPrefix(key, prefix) {
+				pts = append(pts, p)
+			}
+			return true
+		})
+	})
+	if errors.Is(err, buntdb.ErrNotFound) {
+		err = nil
+	}
+	sort.SliceStable(pts, func(i, j int) bool { return pts[i].TS < pts[j].TS })
+	return pts, err
+}
+
+func (r *Recording) Close() error { return r.db.Close() }
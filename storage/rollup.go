@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/tidwall/buntdb"
+)
+
+// DailyRollup is a compact per-day (UTC) aggregate computed from raw positions before they expire.
+// Rollup keys (rollup:day:{yyyy-mm-dd}) have no TTL so statistics survive short raw retention.
+type DailyRollup struct {
+	Day            string         `json:"day"`
+	UniqueAircraft int            `json:"unique_aircraft"`
+	Samples        int64          `json:"samples"`
+	FlightsPerHour [24]int        `json:"flights_per_hour"` // distinct aircraft seen per UTC hour
+	ByAirline      map[string]int `json:"by_airline,omitempty"`
+	ByType         map[string]int `json:"by_type,omitempty"`
+	AreaDistanceKm float64        `json:"area_distance_km,omitempty"` // distance flown inside the receiver area
+	CreatedAt      int64          `json:"created_at"`
+}
+
+const rollupDayLayout = "2006-01-02"
+
+// typeResolver maps ICAO24 to an aircraft type designator; nil leaves ByType empty.
+var typeResolver func(icao string) string
+
+// SetTypeResolver configures the aircraft type lookup used by rollups and type-based features.
+func SetTypeResolver(fn func(icao string) string) { typeResolver = fn }
+
+// AircraftType returns the type designator for an ICAO24 via the configured resolver, or "".
+func AircraftType(icao string) string {
+	if typeResolver == nil {
+		return ""
+	}
+	return typeResolver(normalizeICAO(icao))
+}
+
+// rollupArea is the circle used for AreaDistanceKm (lat, lon, radius meters); zero radius disables it.
+var rollupArea [3]float64
+
+// SetRollupArea configures the receiver area for distance statistics.
+func SetRollupArea(lat, lon, radius float64) { rollupArea = [3]float64{lat, lon, radius} }
+
+// updateCurrentGauges publishes the number of aircraft in the current state, overall and inside
+// the receiver area (region="local") when one is configured.
+func (s *Store) updateCurrentGauges(tx *buntdb.Tx) {
+	area := rollupArea
+	var all, local atomic.Int64
+	_ = s.fanOut(tx, false, func(_ int, tx *buntdb.Tx) error {
+		return tx.AscendKeys("now:*", func(key, val string) bool {
+			all.Add(1)
+			if area[2] > 0 {
+				var p Point
+				if json.Unmarshal([]byte(val), &p) == nil && haversineMeters(area[0], area[1], p.Lat, p.Lon) <= area[2] {
+					local.Add(1)
+				}
+			}
+			return true
+		})
+	})
+	monitoring.AircraftCurrent.WithLabelValues("all").Set(float64(all.Load()))
+	if area[2] > 0 {
+		monitoring.AircraftCurrent.WithLabelValues("local").Set(float64(local.Load()))
+	}
+}
+
+// AirlinePrefix returns the 3-letter ICAO airline designator of a callsign like "DLH4AB", or "".
+func AirlinePrefix(cs string) string {
+	cs = normalizeCallsign(cs)
+	if len(cs) < 4 {
+		return ""
+	}
+	for i := 0; i < 3; i++ {
+		if cs[i] < 'A' || cs[i] > 'Z' {
+			return ""
+		}
+	}
+	if cs[3] < '0' || cs[3] > '9' {
+		return ""
+	}
+	return cs[:3]
+}
+
+// Rollups are built incrementally: every completed UTC hour is aggregated into a partial
+// (rollup:hour:{yyyymmddhh}) while its raw positions are still stored, by the hourly stats job
+// and, at the latest, by the retention purge before it deletes the hour. A completed day is
+// then assembled from its partials, so the rollup is complete even when raw retention is
+// shorter than a day. Partials are deleted once their day is rolled up; rollupHourTTL removes
+// strays.
+const rollupHourTTL = 7 * 24 * time.Hour
+
+// hourRollup is the partial aggregate of one UTC hour.
+type hourRollup struct {
+	Aircraft       map[string]string `json:"aircraft"` // icao -> airline prefix ("" when unknown)
+	Samples        int64             `json:"samples"`
+	AreaDistanceKm float64           `json:"area_distance_km,omitempty"`
+}
+
+// rollupHour aggregates the raw positions of hour (unix hours). Samples up to 15 minutes
+// before the hour link the first segment of each track for the area distance.
+func (s *Store) rollupHour(hour int64) (*hourRollup, error) {
+	from, to := hour*3600, hour*3600+3600
+	h := &hourRollup{Aircraft: map[string]string{}}
+	area := rollupArea
+	prev := map[string]Point{} // previous sample per aircraft, for the area distance
+	lo, hi := posRange(from-15*60, to-1)
+	collect := func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", lo, hi, func(key, val string) bool {
+			var p Point
+			if json.Unmarshal([]byte(val), &p) != nil || p.TS < from-15*60 || p.TS >= to {
+				return true
+			}
+			if p.TS >= from {
+				h.Samples++
+				if a := AirlinePrefix(p.Callsign); a != "" || h.Aircraft[p.Icao24] == "" {
+					h.Aircraft[p.Icao24] = a
+				}
+			}
+			if area[2] > 0 {
+				if q, ok := prev[p.Icao24]; ok && p.TS >= from && p.TS-q.TS < 15*60 &&
+					haversineMeters(area[0], area[1], p.Lat, p.Lon) <= area[2] &&
+					haversineMeters(area[0], area[1], q.Lat, q.Lon) <= area[2] {
+					h.AreaDistanceKm += haversineMeters(q.Lat, q.Lon, p.Lat, p.Lon) / 1000
+				}
+				prev[p.Icao24] = p
+			}
+			return true
+		})
+	}
+	// Shards are scanned one after another; each holds complete tracks
+	for _, db := range s.shards {
+		if err := db.View(collect); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// rollupHours stores the partials of the completed hours from first up to (excluding) until
+// (unix hours) that have none yet and whose day is not rolled up.
+func (s *Store) rollupHours(first, until int64) error {
+	for hour := first; hour < until; hour++ {
+		key := "rollup:hour:" + posBucket(hour*3600)
+		dayKey := "rollup:day:" + time.Unix(hour*3600, 0).UTC().Format(rollupDayLayout)
+		done := false
+		_ = s.db.View(func(tx *buntdb.Tx) error {
+			_, err := tx.Get(key)
+			_, dayErr := tx.Get(dayKey)
+			done = err == nil || dayErr == nil
+			return nil
+		})
+		if done {
+			continue
+		}
+		h, err := s.rollupHour(hour)
+		if err != nil {
+			return err
+		}
+		b, _ := json.Marshal(h)
+		err = s.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(key, string(b), &buntdb.SetOptions{Expires: true, TTL: rollupHourTTL})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assembleDay persists the rollup of the UTC day starting at day from its hourly partials
+// (hours without one count as empty) and deletes them.
+func (s *Store) assembleDay(day time.Time) (*DailyRollup, error) {
+	r := &DailyRollup{Day: day.Format(rollupDayLayout), ByAirline: map[string]int{}, ByType: map[string]int{}}
+	aircraft := map[string]string{} // icao -> airline prefix
+	var keys []string
+	lo := "rollup:hour:" + posBucket(day.Unix())
+	hi := "rollup:hour:" + posBucket(day.Add(24*time.Hour).Unix())
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", lo, hi, func(key, val string) bool {
+			var h hourRollup
+			t, err := time.Parse(posBucketLayout, strings.TrimPrefix(key, "rollup:hour:"))
+			if err != nil || json.Unmarshal([]byte(val), &h) != nil {
+				return true
+			}
+			keys = append(keys, key)
+			r.Samples += h.Samples
+			r.FlightsPerHour[t.Hour()] = len(h.Aircraft)
+			r.AreaDistanceKm += h.AreaDistanceKm
+			for icao, a := range h.Aircraft {
+				if a != "" || aircraft[icao] == "" {
+					aircraft[icao] = a
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.UniqueAircraft = len(aircraft)
+	for icao, airline := range aircraft {
+		if airline != "" {
+			r.ByAirline[airline]++
+		}
+		if typeResolver != nil {
+			if t := typeResolver(icao); t != "" {
+				r.ByType[t]++
+			}
+		}
+	}
+	r.AreaDistanceKm = math.Round(r.AreaDistanceKm*10) / 10
+	r.CreatedAt = time.Now().Unix()
+	b, _ := json.Marshal(r)
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		if _, _, err := tx.Set("rollup:day:"+r.Day, string(b), nil); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			_, _ = tx.Delete(k)
+		}
+		return nil
+	})
+	return r, err
+}
+
+// RollupDay computes and persists the rollup for the UTC day starting at day, from its hourly
+// partials and the completed hours still in raw history.
+func (s *Store) RollupDay(day time.Time) (*DailyRollup, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	day = day.UTC().Truncate(24 * time.Hour)
+	first := max(day.Unix(), time.Now().Add(-s.retention).Unix()) / 3600
+	until := min(day.Add(24*time.Hour).Unix(), time.Now().Unix()) / 3600
+	if err := s.rollupHours(first, until); err != nil {
+		return nil, err
+	}
+	return s.assembleDay(day)
+}
+
+// RollupPending rolls up the completed hours still in raw history, then assembles every
+// completed day that has hourly partials but no rollup yet. It returns the days assembled.
+func (s *Store) RollupPending() ([]string, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	now := time.Now()
+	if err := s.rollupHours(now.Add(-s.retention).Unix()/3600, now.Unix()/3600); err != nil {
+		return nil, err
+	}
+	today := now.UTC().Truncate(24 * time.Hour).Format(rollupDayLayout)
+	days := map[string]bool{}
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("rollup:hour:*", func(key, _ string) bool {
+			if t, err := time.Parse(posBucketLayout, strings.TrimPrefix(key, "rollup:hour:")); err == nil {
+				days[t.Format(rollupDayLayout)] = true
+			}
+			return true
+		})
+	})
+	done := []string{}
+	for day := range days {
+		if day >= today {
+			continue
+		}
+		t, _ := time.Parse(rollupDayLayout, day)
+		if _, err := s.assembleDay(t); err != nil {
+			return done, err
+		}
+		done = append(done, day)
+	}
+	sort.Strings(done)
+	return done, nil
+}
+
+// Rollups returns stored daily rollups within [from, to] (inclusive, "yyyy-mm-dd") in ascending order.
+func (s *Store) Rollups(from, to string) ([]DailyRollup, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	out := []DailyRollup{}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", "rollup:day:"+from, "rollup:day:"+to+"~", func(key, val string) bool {
+			if !strings.HasPrefix(key, "rollup:day:") {
+				return true
+			}
+			var r DailyRollup
+			if json.Unmarshal([]byte(val), &r) == nil {
+				out = append(out, r)
+			}
+			return true
+		})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out, err
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Sharding splits the per-aircraft keyspace (pos:{yyyymmddhh}:ICAO:TS, now:ICAO and
+// latest:ICAO) over several BuntDB files, so a write burst or a file shrink only locks the
+// aircraft of one shard. Everything else (callsign map, ledger, event log, rollups, clips,
+// alerts) stays in the base file at storage.path; with a single shard the base file holds all
+// keys as before.
+
+// ShardFunc maps an ICAO24 address to a shard in [0, n).
+type ShardFunc func(icao string, n int) int
+
+// ShardByPrefix assigns contiguous ranges of the first three hex digits to shards, so shards
+// concatenated in order list aircraft in key order.
+func ShardByPrefix(icao string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	var v int
+	for i := 0; i < 3; i++ {
+		d := 0
+		if i < len(icao) {
+			if x, err := strconv.ParseUint(icao[i:i+1], 16, 8); err == nil {
+				d = int(x)
+			}
+		}
+		v = v<<4 | d
+	}
+	return v * n / 4096
+}
+
+// shardMetaKey holds the shard count of the dataset in the base file.
+const shardMetaKey = "meta:shards"
+
+// maxShards bounds the number of shard files.
+const maxShards = 64
+
+var (
+	shardCount           = 1
+	shardFn    ShardFunc = ShardByPrefix
+)
+
+// SetShards configures sharding for stores opened afterwards: n shard files (1 disables
+// sharding) and the mapping of aircraft to shards (nil keeps ShardByPrefix). Records stored
+// under a different count or mapping are moved to their shard when the store opens.
+func SetShards(n int, fn ShardFunc) {
+	shardCount = min(max(n, 1), maxShards)
+	if fn != nil {
+		shardFn = fn
+	}
+}
+
+// shardPath returns the file of shard i, e.g. flight.buntdb -> flight.03.buntdb.
+func shardPath(base string, i int) string {
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s.%02d%s", strings.TrimSuffix(base, ext), i, ext)
+}
+
+// shardIndex returns the shard of icao.
+func (s *Store) shardIndex(icao string) int {
+	if len(s.shards) <= 1 {
+		return 0
+	}
+	i := shardFn(icao, len(s.shards))
+	if i < 0 || i >= len(s.shards) {
+		return 0
+	}
+	return i
+}
+
+// shard returns the database holding the aircraft keys of icao.
+func (s *Store) shard(icao string) *buntdb.DB { return s.shards[s.shardIndex(icao)] }
+
+// inShard runs fn in a transaction of shard i. Inside a base transaction (tx != nil) a
+// single-file store reuses tx, since its only shard is the base database.
+func (s *Store) inShard(tx *buntdb.Tx, i int, writable bool, fn func(*buntdb.Tx) error) error {
+	db := s.shards[i]
+	if tx != nil && db == s.db {
+		return fn(tx)
+	}
+	if writable {
+		return db.Update(fn)
+	}
+	return db.View(fn)
+}
+
+// fanOut runs fn for every shard concurrently, each in its own transaction (see inShard), and
+// returns the first error. fn must only touch state of its own shard.
+func (s *Store) fanOut(tx *buntdb.Tx, writable bool, fn func(i int, tx *buntdb.Tx) error) error {
+	if len(s.shards) == 1 {
+		return s.inShard(tx, 0, writable, func(stx *buntdb.Tx) error { return fn(0, stx) })
+	}
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.inShard(tx, i, writable, func(stx *buntdb.Tx) error { return fn(i, stx) })
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupByShard returns the indexes of icaos per shard.
+func (s *Store) groupByShard(n int, icao func(i int) string) [][]int {
+	groups := make([][]int, len(s.shards))
+	for i := 0; i < n; i++ {
+		k := s.shardIndex(icao(i))
+		groups[k] = append(groups[k], i)
+	}
+	return groups
+}
+
+// aircraftKeyICAO returns the ICAO24 address of a pos:HOUR:ICAO:TS, now:ICAO or latest:ICAO key.
+func aircraftKeyICAO(key string) (string, bool) {
+	switch {
+	case strings.HasPrefix(key, "now:"):
+		return key[4:], true
+	case strings.HasPrefix(key, "latest:"):
+		return key[7:], true
+	}
+	icao, _, ok := parsePosKey(key)
+	return icao, ok
+}
+
+// openShards opens the shard files next to the base file at path and moves aircraft records
+// that are not in their shard, e.g. after the shard count or mapping changed. Files of shards
+// beyond the configured count are drained and removed.
+func (s *Store) openShards(path string) error {
+	prev := 1
+	_ = s.db.View(func(tx *buntdb.Tx) error {
+		if v, err := tx.Get(shardMetaKey); err == nil {
+			prev, _ = strconv.Atoi(v)
+		}
+		return nil
+	})
+	n := shardCount
+	files := 0 // shard files to open: the configured ones and those of a larger previous count
+	if n > 1 || prev > 1 {
+		files = max(n, prev)
+	}
+	dbs := make([]*buntdb.DB, 0, files)
+	for i := 0; i < files; i++ {
+		db, err := buntdb.Open(shardPath(path, i))
+		if err == nil {
+			if _, err = migrate(db, false); err != nil {
+				_ = db.Close()
+			}
+		}
+		if err != nil {
+			closeAll(dbs)
+			return fmt.Errorf("open shard %d: %w", i, err)
+		}
+		dbs = append(dbs, db)
+	}
+	s.shards = []*buntdb.DB{s.db}
+	extra := dbs
+	if n > 1 {
+		s.shards, extra = dbs[:n], dbs[n:]
+	}
+	moved := 0
+	for _, db := range append([]*buntdb.DB{s.db}, dbs...) {
+		m, err := s.relocate(db)
+		moved += m
+		if err != nil {
+			closeAll(dbs)
+			s.shards = []*buntdb.DB{s.db}
+			return err
+		}
+	}
+	closeAll(extra)
+	for i := files - len(extra); i < files; i++ {
+		_ = os.Remove(shardPath(path, i))
+	}
+	if moved > 0 || prev != n {
+		log.Printf("storage: %d shard(s), moved %d record(s)", n, moved)
+	}
+	return s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(shardMetaKey, strconv.Itoa(n), nil)
+		return err
+	})
+}
+
+// relocateBatch bounds the records moved per transaction.
+const relocateBatch = 10000
+
+// relocate moves aircraft records of db that belong to another shard, keeping their TTL.
+func (s *Store) relocate(db *buntdb.DB) (int, error) {
+	type rec struct {
+		key, val string
+		ttl      time.Duration
+	}
+	moved := 0
+	for {
+		byShard := map[int][]rec{}
+		count := 0
+		err := db.View(func(tx *buntdb.Tx) error {
+			for _, prefix := range []string{"pos:*", "now:*", "latest:*"} {
+				_ = tx.AscendKeys(prefix, func(key, val string) bool {
+					icao, ok := aircraftKeyICAO(key)
+					if !ok {
+						return true
+					}
+					i := s.shardIndex(icao)
+					if s.shards[i] == db {
+						return true
+					}
+					ttl, _ := tx.TTL(key)
+					byShard[i] = append(byShard[i], rec{key, val, ttl})
+					count++
+					return count < relocateBatch
+				})
+			}
+			return nil
+		})
+		if err != nil || count == 0 {
+			return moved, err
+		}
+		for i, recs := range byShard {
+			err := s.shards[i].Update(func(tx *buntdb.Tx) error {
+				for _, r := range recs {
+					var opts *buntdb.SetOptions
+					if r.ttl > 0 {
+						opts = &buntdb.SetOptions{Expires: true, TTL: r.ttl}
+					}
+					if _, _, err := tx.Set(r.key, r.val, opts); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return moved, fmt.Errorf("move records to shard %d: %w", i, err)
+			}
+		}
+		err = db.Update(func(tx *buntdb.Tx) error {
+			for _, recs := range byShard {
+				for _, r := range recs {
+					_, _ = tx.Delete(r.key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved += count
+	}
+}
+
+func closeAll(dbs []*buntdb.DB) {
+	for _, db := range dbs {
+		_ = db.Close()
+	}
+}
+
+// concatByICAO joins per-shard results; they are re-sorted unless there is a single part.
+func concatByICAO(parts [][]Point) []Point {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	out := make([]Point, 0, n)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	slices.SortStableFunc(out, func(a, b Point) int { return strings.Compare(a.Icao24, b.Icao24) })
+	return out
+}
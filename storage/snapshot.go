@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/maniack/miniflightradar/geo"
+	"github.com/maniack/miniflightradar/monitoring"
+)
+
+// snapshot is an immutable view of the current state (non-landed aircraft) and their recent
+// trails, published after every write. Current-state reads and short trails are served from
+// it without a database transaction, so readers do not contend with the ingest at poll
+// boundaries. Slices held by a snapshot are never modified; the next one shares unchanged
+// trails and copies those that grow (copy-on-write).
+type snapshot struct {
+	points    []Point            // ordered by ICAO24 address
+	index     map[string]int     // icao -> index in points
+	callsigns map[string]int     // normalized callsign -> index in points
+	grid      *geo.PointIndex    // ids are indexes in points
+	trails    map[string][]Point // recent samples per aircraft in points, ascending time
+}
+
+// snapshotGridCell is the cell size (degrees) of the snapshot's spatial index.
+const snapshotGridCell = 1.0
+
+// Trails kept per aircraft in the snapshot; longer or older trail requests read the database.
+const (
+	snapshotTrailWindow = 45 * time.Minute
+	snapshotTrailPoints = 32
+)
+
+// publishSnapshot builds the next snapshot from the database and the points written by the
+// last ingest, then makes it visible to readers.
+func (s *Store) publishSnapshot(written []Point) {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	start := time.Now()
+	prev := s.snap.Load()
+	cur := s.currentWhere(nil)
+	added := map[string][]Point{}
+	for _, p := range written {
+		added[p.Icao24] = append(added[p.Icao24], p)
+	}
+	next := &snapshot{points: cur, index: make(map[string]int, len(cur)), callsigns: make(map[string]int, len(cur)),
+		grid: geo.NewPointIndex(snapshotGridCell), trails: make(map[string][]Point, len(cur))}
+	cutoff := start.Add(-snapshotTrailWindow).Unix()
+	for i, p := range cur {
+		next.index[p.Icao24] = i
+		next.grid.Add(p.Lat, p.Lon)
+		if cs := normalizeCallsign(p.Callsign); cs != "" {
+			next.callsigns[cs] = i
+		}
+		var tr []Point
+		ok := false
+		if prev != nil {
+			tr, ok = prev.trails[p.Icao24]
+		}
+		if !ok {
+			// new aircraft (or first snapshot): its trail already includes the written points
+			tr, _ = s.recentTrack(p.Icao24, snapshotTrailPoints, snapshotTrailWindow)
+		} else if add := added[p.Icao24]; len(add) > 0 {
+			tr = slices.Clip(tr) // append copies; the previous snapshot keeps its trail
+			for _, a := range add {
+				if len(tr) == 0 || a.TS > tr[len(tr)-1].TS {
+					tr = append(tr, a)
+				}
+			}
+		}
+		lo := 0
+		for lo < len(tr) && tr[lo].TS < cutoff {
+			lo++
+		}
+		lo = max(lo, len(tr)-snapshotTrailPoints)
+		next.trails[p.Icao24] = tr[lo:]
+	}
+	s.snap.Store(next)
+	monitoring.SubDebugf("storage", "snapshot published aircraft=%d duration=%s", len(cur), time.Since(start).Round(time.Microsecond))
+}
+
+// inBBox returns the points inside [minLon,minLat,maxLon,maxLat], ordered by ICAO24 address.
+// Boxes spanning more grid cells than there are aircraft are scanned linearly instead.
+func (sn *snapshot) inBBox(minLon, minLat, maxLon, maxLat float64) []Point {
+	pts := []Point{}
+	cells := (math.Floor(maxLat/snapshotGridCell) - math.Floor(minLat/snapshotGridCell) + 1) *
+		(math.Floor(maxLon/snapshotGridCell) - math.Floor(minLon/snapshotGridCell) + 1)
+	if cells > float64(len(sn.points)) {
+		for _, p := range sn.points {
+			if p.Lon >= minLon && p.Lon <= maxLon && p.Lat >= minLat && p.Lat <= maxLat {
+				pts = append(pts, p)
+			}
+		}
+		return pts
+	}
+	ids := sn.grid.InBBox(minLon, minLat, maxLon, maxLat)
+	slices.Sort(ids)
+	for _, i := range ids {
+		pts = append(pts, sn.points[i])
+	}
+	return pts
+}
+
+// trail returns up to limit samples of icao not older than window from the snapshot; ok is
+// false when the snapshot cannot answer (unknown aircraft, longer trail requested).
+func (sn *snapshot) trail(icao string, limit int, window time.Duration) ([]Point, bool) {
+	if sn == nil || limit > snapshotTrailPoints || window > snapshotTrailWindow {
+		return nil, false
+	}
+	tr, ok := sn.trails[icao]
+	if !ok {
+		return nil, false
+	}
+	cutoff := time.Now().Add(-window).Unix()
+	lo := 0
+	for lo < len(tr) && tr[lo].TS < cutoff {
+		lo++
+	}
+	lo = max(lo, len(tr)-limit)
+	return slices.Clone(tr[lo:]), true
+}
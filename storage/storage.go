@@ -2,148 +2,227 @@ package storage
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/maniack/miniflightradar/chaos"
+	"github.com/maniack/miniflightradar/monitoring"
 	"github.com/tidwall/buntdb"
 )
 
 // Point represents a single aircraft position sample.
 // JSON kept compact for network payloads.
 type Point struct {
-	Icao24   string  `json:"icao24"`
-	Callsign string  `json:"callsign"`
-	Lon      float64 `json:"lon"`
-	Lat      float64 `json:"lat"`
-	Alt      float64 `json:"alt,omitempty"`
-	Track    float64 `json:"track,omitempty"`
-	Speed    float64 `json:"speed,omitempty"` // velocity (m/s) from OpenSky, if available
-	TS       int64   `json:"ts"`              // unix seconds
+	Icao24   string   `json:"icao24"`
+	Callsign string   `json:"callsign"`
+	Lon      float64  `json:"lon"`
+	Lat      float64  `json:"lat"`
+	Alt      float64  `json:"alt,omitempty"`
+	Track    float64  `json:"track,omitempty"`
+	Speed    float64  `json:"speed,omitempty"`   // velocity (m/s) from OpenSky, if available
+	VRate    float64  `json:"vrate,omitempty"`   // vertical rate (m/s, positive climbing) reported by the source
+	AGL      *float64 `json:"agl,omitempty"`     // height above ground (m), only for low-flying aircraft when terrain is available; nil: unknown
+	Rarity   int      `json:"rarity,omitempty"`  // 0..100 local rarity of operator/type (100 = first ever seen)
+	Ground   bool     `json:"ground,omitempty"`  // source reports the aircraft on ground
+	Phase    string   `json:"phase,omitempty"`   // flight phase at this sample (Phase* constants)
+	Airport  string   `json:"airport,omitempty"` // nearest airport of landed, takeoff and low samples (see SetAirportLookup)
+	TS       int64    `json:"ts"`                // unix seconds
+	// Registration metadata, only when an aircraft database is configured
+	Registration string `json:"registration,omitempty"`
+	TypeCode     string `json:"typecode,omitempty"`
+	Operator     string `json:"operator,omitempty"`
 }
 
 type Store struct {
+	path      string // base file
 	db        *buntdb.DB
+	shards    []*buntdb.DB // aircraft keyspace (pos:, now:); shards[0] is db when not sharded
 	retention time.Duration
 	nowTTL    time.Duration
+	logTTL    time.Duration // ingest event log retention; 0 disables the log
+	seq       atomic.Int64  // last event log sequence number
+
+	seenMu sync.Mutex
+	seen   map[string]int64 // icao -> unix time after which it is tombstoned
+
+	landed landedCache // IsLanded verdicts of the current poll
+
+	snapMu sync.Mutex // serializes snapshot builds
+	snap   atomic.Pointer[snapshot]
+
+	rollupMu sync.Mutex // serializes hourly and daily rollups (see RollupPending)
+
+	indexed atomic.Bool  // the latest:* index is complete (see latestIndexed)
+	purged  atomic.Int64 // hour buckets before this (unix hours) are purged (see maybePurge)
 }
 
-// TouchNow extends the TTL of all current-position keys (now:*) to the provided duration.
-// It keeps the existing values intact while refreshing their expiration.
-// If ttl <= 0, the store's default nowTTL is used.
-func (s *Store) TouchNow(ttl time.Duration) error {
+// TouchNow keeps all current positions (now:*) visible until the next ingest attempt, which is
+// expected within untilNext (e.g., while backing off after a failed or rate-limited poll).
+// Values are kept intact; only their tombstone deadline and fallback TTL are postponed.
+// If untilNext <= 0, the store's nowTTL is used.
+func (s *Store) TouchNow(untilNext time.Duration) error {
 	if s == nil || s.db == nil {
 		return nil
 	}
-	if ttl <= 0 {
-		ttl = s.nowTTL
+	if untilNext <= 0 {
+		untilNext = s.nowTTL
 	}
+	// small buffer so the next successful ingest refreshes positions before they are swept
+	hold := untilNext + 5*time.Second
+	until := time.Now().Add(hold)
+	chaos.StorageDelay()
 	return s.db.Update(func(tx *buntdb.Tx) error {
-		keys := make([]string, 0, 1024)
-		_ = tx.AscendKeys("now:*", func(key, val string) bool {
-			keys = append(keys, key)
-			return true
-		})
-		for _, k := range keys {
-			if v, err := tx.Get(k); err == nil {
-				_, _, _ = tx.Set(k, v, &buntdb.SetOptions{Expires: true, TTL: ttl})
+		return s.fanOut(tx, true, func(_ int, tx *buntdb.Tx) error {
+			keys := make([]string, 0, 1024)
+			_ = tx.AscendKeys("now:*", func(key, val string) bool {
+				keys = append(keys, key)
+				return true
+			})
+			for _, k := range keys {
+				if v, err := tx.Get(k); err == nil {
+					_, _, _ = tx.Set(k, v, &buntdb.SetOptions{Expires: true, TTL: hold + s.nowTTL})
+					s.markSeen(strings.TrimPrefix(k, "now:"), until)
+				}
 			}
-		}
-		return nil
+			return nil
+		})
 	})
 }
 
 var store *Store
 
-// Open opens a persistent BuntDB file on disk and configures retention.
-// If path is empty, it defaults to ./data/flight.buntdb (directory will be created if missing).
+// nowTTL is how long an aircraft stays in the current state without being seen again.
+var nowTTL = 60 * time.Second
+
+// NowTTLFor derives nowTTL from the poll interval: two missed polls plus a margin, at least a minute,
+// so aircraft do not vanish between polls when the interval is long.
+func NowTTLFor(poll time.Duration) time.Duration {
+	return max(2*poll+15*time.Second, 60*time.Second)
+}
+
+// SetNowTTL configures nowTTL for stores opened afterwards; d <= 0 keeps the default.
+func SetNowTTL(d time.Duration) {
+	if d > 0 {
+		nowTTL = d
+	}
+}
+
+// aglMaxAlt is the altitude (m) below which height above ground is computed on ingest.
+const aglMaxAlt = 3000.0
+
+// elevationFn returns ground elevation (m MSL) for a location without blocking; nil disables AGL.
+var elevationFn func(lat, lon float64) (float64, bool)
+
+// SetElevationSource configures the non-blocking ground elevation lookup used to compute AGL on ingest.
+func SetElevationSource(fn func(lat, lon float64) (float64, bool)) { elevationFn = fn }
+
+// aircraftFn returns registration metadata for an ICAO24 address; nil disables enrichment.
+var aircraftFn func(icao string) (registration, typeCode, operator string)
+
+// SetAircraftSource configures the registration lookup used to enrich points on ingest.
+func SetAircraftSource(fn func(icao string) (registration, typeCode, operator string)) {
+	aircraftFn = fn
+}
+
+// Open opens a persistent BuntDB file on disk (plus shard files, see SetShards), applies
+// pending schema migrations and configures retention. If path is empty, it defaults to
+// ./data/flight.buntdb (directory will be created if missing).
 func Open(path string, retention time.Duration) (*Store, error) {
 	if retention <= 0 {
 		retention = 7 * 24 * time.Hour
 	}
-	if strings.TrimSpace(path) == "" {
-		// default path
-		path = filepath.Join(".", "data", "flight.buntdb")
-	}
-	// Ensure parent directory exists
-	_ = os.MkdirAll(filepath.Dir(path), 0o755)
-
+	path = resolvePath(path)
 	db, err := buntdb.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	store = &Store{db: db, retention: retention, nowTTL: 60 * time.Second}
+	// Bring the key schema up to date before anything reads or writes records
+	if _, err := migrate(db, false); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	s := &Store{path: path, db: db, retention: retention, nowTTL: nowTTL}
+	if err := s.openShards(path); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	store = s
+	createLedgerIndexes(db)
 	// Rebuild ephemeral "now:*" keys from persisted historical data on startup
 	_ = store.RebuildNow()
+	store.publishSnapshot(nil)
 	return store, nil
 }
 
 func Get() *Store { return store }
 
-// RebuildNow scans historical position keys (pos:ICAO:TS) and rebuilds ephemeral
-// now:* and callsign mapping keys at startup so the app has immediate data
-// after restart, even before the ingestor runs again.
+// resolvePath applies the default database path and ensures its directory exists.
+func resolvePath(path string) string {
+	if strings.TrimSpace(path) == "" {
+		// default path
+		path = filepath.Join(".", "data", "flight.buntdb")
+	}
+	// Ensure parent directory exists
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	return path
+}
+
+// RebuildNow restores the ephemeral now:* and callsign mapping keys at startup from the
+// latest:* index, so the app has immediate data after restart, even before the ingestor runs
+// again. When the index is incomplete (history written by an older version) it is built from
+// the position history in the background and RebuildNow returns without waiting for it.
 func (s *Store) RebuildNow() error {
 	if s == nil || s.db == nil {
 		return nil
 	}
-	latest := map[string]string{}
-	// Collect latest value per ICAO (keys are lexicographically ordered; timestamps are zero-padded)
-	if err := s.db.View(func(tx *buntdb.Tx) error {
-		_ = tx.AscendKeys("pos:*", func(key, val string) bool {
-			if len(key) <= 5 {
-				return true
-			}
-			// key format: pos:{icao}:{ts}
-			rest := key[4:]
-			sep := strings.IndexByte(rest, ':')
-			if sep <= 0 {
-				return true
-			}
-			icao := rest[:sep]
-			latest[icao] = val // last assignment wins (ascending order by TS)
-			return true
-		})
-		return nil
-	}); err != nil {
-		return err
-	}
-	if len(latest) == 0 {
+	if !s.latestIndexed() {
+		go s.backfillLatest()
 		return nil
 	}
-	return s.db.Update(func(tx *buntdb.Tx) error {
-		for icao, val := range latest {
-			// Restore now: key with short TTL
-			_, _, _ = tx.Set("now:"+icao, val, &buntdb.SetOptions{Expires: true, TTL: s.nowTTL})
-			// Restore callsign mapping if present
-			var p Point
-			if json.Unmarshal([]byte(val), &p) == nil && p.Callsign != "" {
-				cs := normalizeCallsign(p.Callsign)
-				_, _, _ = tx.Set("map:cs:"+cs, icao, &buntdb.SetOptions{Expires: true, TTL: s.retention})
-			}
-		}
-		return nil
-	})
+	return s.restoreNow()
 }
 
 func (s *Store) Close() error {
 	if s == nil || s.db == nil {
 		return nil
 	}
+	if len(s.shards) > 1 {
+		closeAll(s.shards)
+	}
 	return s.db.Close()
 }
 
 // UpsertStates stores many OpenSky states. Each state is [][]interface{}
-// fields used: 0:icao24, 1:callsign, 3:time_position, 4:last_contact, 5:lon, 6:lat
+// fields used: 0:icao24, 1:callsign, 3:time_position, 4:last_contact, 5:lon, 6:lat,
+// 7:baro_altitude, 8:on_ground, 9:velocity, 10:true_track, 11:vertical_rate, 13:geo_altitude
 func (s *Store) UpsertStates(states [][]interface{}) error {
+	return s.UpsertStatesWithSkew(states, 0)
+}
+
+// UpsertStatesWithSkew stores states whose timestamps come from a source clock that is skew
+// ahead of the server clock (negative: behind). Skew beyond SkewTolerance is subtracted so
+// tracks stay ordered in server time; see correctTS for the per-sample checks.
+func (s *Store) UpsertStatesWithSkew(states [][]interface{}, skew time.Duration) error {
 	if s == nil {
-		return errors.New("store not initialized")
-	}
-	return s.db.Update(func(tx *buntdb.Tx) error {
+		return ErrNotInitialized
+	}
+	chaos.StorageDelay()
+	now := time.Now()
+	corrected := map[string]int{}
+	var rare, fresh []Sighting
+	var firsts []string
+	var observed []observation
+	filtered := map[string]int{}
+	var written []Point
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		cands := make([]Point, 0, len(states))
 		for _, st := range states {
 			if len(st) < 7 {
 				continue
@@ -163,6 +242,10 @@ func (s *Store) UpsertStates(states [][]interface{}) error {
 			// Clamp coordinates to valid ranges
 			lon = clamp(lon, -180, 180)
 			lat = clamp(lat, -90, 90)
+			if reason := ingestFilter.reject(st, lat, lon); reason != "" {
+				filtered[reason]++
+				continue
+			}
 			var ts int64
 			if v, ok := toInt64(st[4]); ok && v > 0 {
 				ts = v
@@ -170,7 +253,14 @@ func (s *Store) UpsertStates(states [][]interface{}) error {
 				ts = v
 			}
 			if ts <= 0 {
-				ts = time.Now().Unix()
+				ts = now.Unix()
+			}
+			ts, action := s.correctTS(ts, skew, now)
+			if action != "" {
+				corrected[action]++
+				if action == "dropped" {
+					continue
+				}
 			}
 
 			var alt float64
@@ -193,33 +283,148 @@ func (s *Store) UpsertStates(states [][]interface{}) error {
 					speed = 0
 				}
 			}
-			p := Point{Icao24: icao, Callsign: callsign, Lon: lon, Lat: lat, Alt: alt, Track: track, Speed: speed, TS: ts}
-			b, _ := json.Marshal(p)
-
-			keyPos := fmt.Sprintf("pos:%s:%010d", icao, ts)
-			_, _, _ = tx.Set(keyPos, string(b), &buntdb.SetOptions{Expires: true, TTL: s.retention})
-
-			keyNow := fmt.Sprintf("now:%s", icao)
-			_, _, _ = tx.Set(keyNow, string(b), &buntdb.SetOptions{Expires: true, TTL: s.nowTTL})
+			var vrate float64
+			if v, ok := toFloat(st[11]); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				vrate = clamp(v, -maxVRate, maxVRate)
+			}
+			ground, _ := st[8].(bool)
+			p := Point{Icao24: icao, Callsign: callsign, Lon: lon, Lat: lat, Alt: alt, Track: track, Speed: speed, VRate: vrate, Ground: ground, TS: ts}
+			if elevationFn != nil && alt > 0 && alt < aglMaxAlt {
+				if elev, ok := elevationFn(lat, lon); ok {
+					agl := math.Max(alt-elev, 0)
+					p.AGL = &agl
+				}
+			}
+			if aircraftFn != nil {
+				p.Registration, p.TypeCode, p.Operator = aircraftFn(icao)
+			}
+			cands = append(cands, p)
+		}
+		// Current positions of the batch, read from the shards, and flight phases
+		prevs := make([]*Point, len(cands))
+		groups := s.groupByShard(len(cands), func(i int) string { return cands[i].Icao24 })
+		_ = s.fanOut(tx, false, func(k int, tx *buntdb.Tx) error {
+			for _, i := range groups[k] {
+				if v, err := tx.Get("now:" + cands[i].Icao24); err == nil {
+					var pp Point
+					if json.Unmarshal([]byte(v), &pp) == nil {
+						prevs[i] = &pp
+					}
+				}
+				cands[i].Phase = flightPhase(tx, cands[i], prevs[i])
+			}
+			return nil
+		})
+		if airportFn != nil {
+			for i := range cands {
+				if p := &cands[i]; p.Phase == PhaseLanded || p.Phase == PhaseTakeoff || p.AGL != nil && *p.AGL < airportMaxAGL {
+					p.Airport = airportFn(p.Lat, p.Lon)
+				}
+			}
+		}
+		current := make([]bool, len(cands)) // sample becomes the current position
+		batch := map[string]*Point{}        // current positions written by this batch
+		for i := range cands {
+			p := &cands[i]
+			prev := prevs[i]
+			if bp := batch[p.Icao24]; bp != nil {
+				prev = bp
+			}
+			if prev != nil && prev.TS > p.TS {
+				// Older report from a slower source (e.g. OpenSky behind a local receiver): keep it
+				// in history but do not move the current position back
+				continue
+			}
+			current[i] = true
+			isNew, sighting := updateLedger(tx, *p)
+			var first []string
+			p.Rarity, first = updateRarity(tx, *p, sighting)
+			firsts = append(firsts, first...)
+			if sighting && rareFn != nil && rareThreshold > 0 && p.Rarity >= rareThreshold {
+				rare = append(rare, newSighting(*p))
+			}
+			if isNew && newAirframeFn != nil && sightingsTotal(tx) > rarityMinTotal {
+				fresh = append(fresh, newSighting(*p))
+			}
+			s.markSeen(p.Icao24, time.Now().Add(s.nowTTL))
+			if prev == nil || prev.TS != p.TS {
+				if len(observers) > 0 {
+					observed = append(observed, observation{prev: prev, cur: *p})
+				}
+				written = append(written, *p)
+			}
+			batch[p.Icao24] = p
 
-			if callsign != "" {
+			if callsign := p.Callsign; callsign != "" {
 				keyMap := fmt.Sprintf("map:cs:%s", callsign)
-				_, _, _ = tx.Set(keyMap, icao, &buntdb.SetOptions{Expires: true, TTL: s.retention})
+				_, _, _ = tx.Set(keyMap, p.Icao24, &buntdb.SetOptions{Expires: true, TTL: s.retention})
 				// Also map alternate airline code form (IATA<->ICAO) if available
 				if alt := convertCallsignAlternate(callsign); alt != "" {
 					keyMapAlt := fmt.Sprintf("map:cs:%s", alt)
-					_, _, _ = tx.Set(keyMapAlt, icao, &buntdb.SetOptions{Expires: true, TTL: s.retention})
+					_, _, _ = tx.Set(keyMapAlt, p.Icao24, &buntdb.SetOptions{Expires: true, TTL: s.retention})
 				}
 			}
 		}
+		// Write history and current positions, each shard in parallel
+		_ = s.fanOut(tx, true, func(k int, tx *buntdb.Tx) error {
+			for _, i := range groups[k] {
+				p := cands[i]
+				b, _ := json.Marshal(p)
+				_, _, _ = tx.Set(posKey(p.Icao24, p.TS), string(b), nil)
+				if current[i] {
+					// now: keys are removed by the tombstone sweep; the TTL is only a fallback
+					_, _, _ = tx.Set("now:"+p.Icao24, string(b), &buntdb.SetOptions{Expires: true, TTL: 2 * s.nowTTL})
+					setLatest(tx, p.Icao24, string(b), s.retention)
+				}
+			}
+			return nil
+		})
+		removed := s.sweepTombstones(tx, time.Now())
+		s.appendLog(tx, written, removed)
+		s.updateCurrentGauges(tx)
 		return nil
 	})
+	s.invalidateLanded()
+	for reason, n := range filtered {
+		monitoring.IngestFiltered.WithLabelValues(reason).Add(float64(n))
+	}
+	for action, n := range corrected {
+		monitoring.IngestClockCorrections.WithLabelValues(action).Add(float64(n))
+	}
+	if len(corrected) > 0 {
+		monitoring.SubDebugf("ingest", "timestamps corrected skew=%s shifted=%d clamped=%d dropped=%d", skew, corrected["shifted"], corrected["clamped"], corrected["dropped"])
+	}
+	if err != nil {
+		// nothing was stored: no sightings to count or report
+		return err
+	}
+	s.publishSnapshot(written)
+	s.maybePurge(time.Now())
+	monitoring.IngestPoints.Add(float64(len(written)))
+	for _, kind := range firsts {
+		monitoring.FirstSightings.WithLabelValues(kind).Inc()
+	}
+	// hooks run outside the transaction, after it committed
+	for _, sg := range rare {
+		monitoring.RareSightings.Inc()
+		rareFn(sg)
+	}
+	for _, sg := range fresh {
+		monitoring.NewAirframes.Inc()
+		newAirframeFn(sg)
+	}
+	for _, o := range observed {
+		for _, fn := range observers {
+			fn(o.prev, o.cur)
+		}
+	}
+	return nil
 }
 
 // LatestByCallsign returns the latest sample for callsign (if mapped) or nil.
 func (s *Store) LatestByCallsign(callsign string) (*Point, error) {
 	if s == nil {
-		return nil, errors.New("store not initialized")
+		return nil, ErrNotInitialized
 	}
 	callsign = normalizeCallsign(callsign)
 	var icao string
@@ -250,7 +455,7 @@ func (s *Store) LatestByCallsign(callsign string) (*Point, error) {
 		}
 	}
 	var out *Point
-	s.db.View(func(tx *buntdb.Tx) error {
+	s.shard(icao).View(func(tx *buntdb.Tx) error {
 		v, err := tx.Get("now:" + icao)
 		if err != nil {
 			return err
@@ -267,7 +472,7 @@ func (s *Store) LatestByCallsign(callsign string) (*Point, error) {
 // TrackByCallsign returns all stored points (ascending time) for given callsign.
 func (s *Store) TrackByCallsign(callsign string, limit int) ([]Point, string, error) {
 	if s == nil {
-		return nil, "", errors.New("store not initialized")
+		return nil, "", ErrNotInitialized
 	}
 	callsign = normalizeCallsign(callsign)
 	var icao string
@@ -298,9 +503,9 @@ func (s *Store) TrackByCallsign(callsign string, limit int) ([]Point, string, er
 		}
 	}
 	pts := make([]Point, 0, 256)
-	s.db.View(func(tx *buntdb.Tx) error {
-		prefix := fmt.Sprintf("pos:%s:", icao)
-		_ = tx.AscendKeys(prefix+"*", func(key, val string) bool {
+	s.shard(icao).View(func(tx *buntdb.Tx) error {
+		from, to := s.historySpan(0, math.MaxInt64)
+		ascendTrack(tx, icao, from, to, func(key, val string) bool {
 			var p Point
 			if json.Unmarshal([]byte(val), &p) == nil {
 				pts = append(pts, p)
@@ -315,92 +520,62 @@ func (s *Store) TrackByCallsign(callsign string, limit int) ([]Point, string, er
 	return pts, icao, nil
 }
 
-// CurrentInBBox returns latest non-landed points inside [minLon,minLat,maxLon,maxLat].
+// CurrentInBBox returns latest non-landed points inside [minLon,minLat,maxLon,maxLat], ordered
+// by ICAO24 address. With a snapshot it is answered from its spatial grid, so the cost follows
+// the aircraft in the box rather than all current aircraft.
 func (s *Store) CurrentInBBox(minLon, minLat, maxLon, maxLat float64) ([]Point, error) {
 	if s == nil {
-		return nil, errors.New("store not initialized")
+		return nil, ErrNotInitialized
 	}
-	pts := []Point{}
-	// Collect current points within bbox
-	_ = s.db.View(func(tx *buntdb.Tx) error {
-		_ = tx.AscendKeys("now:*", func(key, val string) bool {
-			var p Point
-			if json.Unmarshal([]byte(val), &p) == nil {
-				if p.Lon >= minLon && p.Lon <= maxLon && p.Lat >= minLat && p.Lat <= maxLat {
-					pts = append(pts, p)
-				}
-			}
-			return true
-		})
-		return nil
-	})
-	// Filter out flights that have likely landed using historical heuristic.
-	// Do not hide aircraft solely based on current speed value, as many samples may lack speed or report it as 0.
-	out := make([]Point, 0, len(pts))
-	for _, p := range pts {
-		landed, _ := s.IsLandedWithin(p.Icao24, 10*time.Minute)
-		if landed {
-			continue
-		}
-		out = append(out, p)
+	if sn := s.snap.Load(); sn != nil {
+		return sn.inBBox(minLon, minLat, maxLon, maxLat), nil
 	}
-	return out, nil
+	return s.currentWhere(func(p *Point) bool {
+		return p.Lon >= minLon && p.Lon <= maxLon && p.Lat >= minLat && p.Lat <= maxLat
+	}), nil
 }
 
-// IsLandedWithin reports whether the aircraft for given ICAO has been effectively stationary
-// (on the ground) within the provided time window. The heuristic checks that over the window:
-// - time span covers at least half the window,
-// - geographic displacement is small,
-// - last recorded speed is near zero,
-// - altitude change is minimal.
-func (s *Store) IsLandedWithin(icao string, window time.Duration) (bool, error) {
+// CurrentByCallsign returns the current (non-landed) points with the given callsigns
+// (case-insensitive); unknown callsigns are skipped.
+func (s *Store) CurrentByCallsign(callsigns []string) ([]Point, error) {
 	if s == nil {
-		return false, errors.New("store not initialized")
+		return nil, ErrNotInitialized
 	}
-	if window <= 0 {
-		window = 15 * time.Minute
+	if sn := s.snap.Load(); sn != nil {
+		pts := make([]Point, 0, len(callsigns))
+		for _, cs := range callsigns {
+			if i, ok := sn.callsigns[normalizeCallsign(cs)]; ok {
+				pts = append(pts, sn.points[i])
+			}
+		}
+		return pts, nil
 	}
-	var newest *Point
-	var oldest *Point
-	err := s.db.View(func(tx *buntdb.Tx) error {
-		prefix := fmt.Sprintf("pos:%s:", icao)
-		cutoff := time.Now().Add(-window).Unix()
-		count := 0
-		_ = tx.DescendKeys(prefix+"*", func(key, val string) bool {
+	want := make(map[string]bool, len(callsigns))
+	for _, cs := range callsigns {
+		want[normalizeCallsign(cs)] = true
+	}
+	return s.currentWhere(func(p *Point) bool { return want[normalizeCallsign(p.Callsign)] }), nil
+}
+
+// currentWhere returns current points accepted by keep (nil: all) without landed flights,
+// scanning the shards in parallel. Results are ordered by ICAO24 address.
+func (s *Store) currentWhere(keep func(p *Point) bool) []Point {
+	parts := make([][]Point, len(s.shards))
+	_ = s.fanOut(nil, false, func(k int, tx *buntdb.Tx) error {
+		pts := []Point{}
+		_ = tx.AscendKeys("now:*", func(key, val string) bool {
 			var p Point
-			if json.Unmarshal([]byte(val), &p) != nil {
-				return true
-			}
-			if newest == nil {
-				newest = &p
-			}
-			oldest = &p
-			count++
-			if p.TS < cutoff || count >= 10 {
-				return false
+			if json.Unmarshal([]byte(val), &p) == nil && (keep == nil || keep(&p)) {
+				pts = append(pts, p)
 			}
 			return true
 		})
+		// Filter out flights that have likely landed using historical heuristic.
+		// Do not hide aircraft solely based on current speed value, as many samples may lack speed or report it as 0.
+		parts[k] = s.dropLanded(tx, pts)
 		return nil
 	})
-	if err != nil {
-		return false, err
-	}
-	if newest == nil || oldest == nil {
-		return false, nil
-	}
-	span := newest.TS - oldest.TS
-	if span < int64((window/time.Second)/2) {
-		// Not enough history to decide
-		return false, nil
-	}
-	altDiff := math.Abs(newest.Alt - oldest.Alt)
-	dist := haversineMeters(oldest.Lat, oldest.Lon, newest.Lat, newest.Lon)
-	// consider landed if last speed ~0, tiny movement and nearly no alt change
-	if newest.Speed <= 1.5 && dist < 500 && altDiff < 10 {
-		return true, nil
-	}
-	return false, nil
+	return concatByICAO(parts)
 }
 
 // haversineMeters returns great-circle distance between two lat/lon points in meters.
@@ -612,36 +787,60 @@ func convertCallsignAlternate(cs string) string {
 // CurrentAll returns latest non-landed points worldwide.
 func (s *Store) CurrentAll() ([]Point, error) {
 	if s == nil {
-		return nil, errors.New("store not initialized")
+		return nil, ErrNotInitialized
 	}
-	pts := []Point{}
-	_ = s.db.View(func(tx *buntdb.Tx) error {
-		_ = tx.AscendKeys("now:*", func(key, val string) bool {
+	// Callers may modify the result, so the snapshot is copied
+	if sn := s.snap.Load(); sn != nil {
+		return slices.Clone(sn.points), nil
+	}
+	// Same landed heuristic as in CurrentInBBox
+	return s.currentWhere(nil), nil
+}
+
+// CurrentByICAO returns the current (non-landed) points for the given ICAO24 addresses; unknown
+// or expired addresses are skipped.
+func (s *Store) CurrentByICAO(icaos []string) ([]Point, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	if sn := s.snap.Load(); sn != nil {
+		pts := make([]Point, 0, len(icaos))
+		for _, icao := range icaos {
+			if i, ok := sn.index[normalizeICAO(icao)]; ok {
+				pts = append(pts, sn.points[i])
+			}
+		}
+		return pts, nil
+	}
+	parts := make([][]Point, len(s.shards))
+	groups := s.groupByShard(len(icaos), func(i int) string { return normalizeICAO(icaos[i]) })
+	_ = s.fanOut(nil, false, func(k int, tx *buntdb.Tx) error {
+		pts := make([]Point, 0, len(groups[k]))
+		for _, i := range groups[k] {
+			val, err := tx.Get("now:" + normalizeICAO(icaos[i]))
+			if err != nil {
+				continue
+			}
 			var p Point
 			if json.Unmarshal([]byte(val), &p) == nil {
 				pts = append(pts, p)
 			}
-			return true
-		})
+		}
+		parts[k] = s.dropLanded(tx, pts)
 		return nil
 	})
-	// Filter out flights that have likely landed (same heuristic as in CurrentInBBox)
-	out := make([]Point, 0, len(pts))
-	for _, p := range pts {
-		landed, _ := s.IsLandedWithin(p.Icao24, 10*time.Minute)
-		if landed {
-			continue
-		}
-		out = append(out, p)
+	if len(parts) == 1 {
+		return parts[0], nil // request order
 	}
-	return out, nil
+	return concatByICAO(parts), nil
 }
 
 // RecentTrackByICAO returns up to 'limit' most recent points for given ICAO within 'window'.
 // Points are returned in ascending time order.
+// Short trails of current aircraft are served from the in-memory snapshot.
 func (s *Store) RecentTrackByICAO(icao string, limit int, window time.Duration) ([]Point, error) {
 	if s == nil {
-		return nil, errors.New("store not initialized")
+		return nil, ErrNotInitialized
 	}
 	if limit <= 0 {
 		limit = 100
@@ -650,11 +849,24 @@ func (s *Store) RecentTrackByICAO(icao string, limit int, window time.Duration)
 		window = 45 * time.Minute
 	}
 	icao = normalizeICAO(icao)
+	if pts, ok := s.snap.Load().trail(icao, limit, window); ok {
+		return pts, nil
+	}
+	return s.recentTrack(icao, limit, window)
+}
+
+// recentTrack reads a trail from the database; see RecentTrackByICAO.
+func (s *Store) recentTrack(icao string, limit int, window time.Duration) ([]Point, error) {
 	pts := make([]Point, 0, limit)
-	err := s.db.View(func(tx *buntdb.Tx) error {
-		prefix := fmt.Sprintf("pos:%s:", icao)
-		cutoff := time.Now().Add(-window).Unix()
-		_ = tx.DescendKeys(prefix+"*", func(key, val string) bool {
+	err := s.shard(icao).View(func(tx *buntdb.Tx) error {
+		cutoff, to := s.historySpan(time.Now().Add(-window).Unix(), math.MaxInt64)
+		if last, ok := s.latestSample(tx, icao); ok {
+			if last == nil || last.TS < cutoff {
+				return nil // nothing within the window: no need to touch the history
+			}
+			to = last.TS
+		}
+		descendTrack(tx, icao, cutoff, to, func(key, val string) bool {
 			var p Point
 			if json.Unmarshal([]byte(val), &p) != nil {
 				return true
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Tombstone records that an aircraft left the current state (tomb:{icao}). Current positions
+// are removed explicitly by the ingest sweep rather than by now:* TTL expiry, so WebSocket
+// deletes, /api/changes and replay all derive from the same transition.
+type Tombstone struct {
+	Icao24   string `json:"icao24"`
+	Callsign string `json:"callsign,omitempty"`
+	TS       int64  `json:"ts"`      // removal time (unix seconds)
+	LastTS   int64  `json:"last_ts"` // last position sample
+}
+
+// tombstoneTTL is used when the event log is disabled.
+const tombstoneTTL = time.Hour
+
+// markSeen sets the time after which icao is tombstoned unless it is seen again.
+func (s *Store) markSeen(icao string, until time.Time) {
+	s.seenMu.Lock()
+	if s.seen == nil {
+		s.seen = map[string]int64{}
+	}
+	if u := until.Unix(); u > s.seen[icao] {
+		s.seen[icao] = u
+	}
+	s.seenMu.Unlock()
+}
+
+// sweepTombstones removes current positions whose deadline passed, writes tombstones and
+// returns the removed ICAO24 codes. It runs inside the ingest transaction (of the base file).
+func (s *Store) sweepTombstones(tx *buntdb.Tx, now time.Time) []string {
+	s.seenMu.Lock()
+	expired := []string{}
+	for icao, until := range s.seen {
+		if until < now.Unix() {
+			expired = append(expired, icao)
+			delete(s.seen, icao)
+		}
+	}
+	s.seenMu.Unlock()
+	ttl := s.logTTL
+	if ttl <= 0 {
+		ttl = tombstoneTTL
+	}
+	tombs := make([]Tombstone, len(expired))
+	groups := s.groupByShard(len(expired), func(i int) string { return expired[i] })
+	_ = s.fanOut(tx, true, func(k int, tx *buntdb.Tx) error {
+		for _, i := range groups[k] {
+			t := Tombstone{Icao24: expired[i], TS: now.Unix()}
+			if v, err := tx.Delete("now:" + t.Icao24); err == nil {
+				var p Point
+				if json.Unmarshal([]byte(v), &p) == nil {
+					t.Callsign, t.LastTS = p.Callsign, p.TS
+				}
+			}
+			tombs[i] = t
+		}
+		return nil
+	})
+	for _, t := range tombs {
+		icao := t.Icao24
+		b, _ := json.Marshal(t)
+		_, _, _ = tx.Set("tomb:"+icao, string(b), &buntdb.SetOptions{Expires: true, TTL: ttl})
+	}
+	return expired
+}
+
+// Tombstones returns aircraft removed from the current state at or after since (unix seconds).
+func (s *Store) Tombstones(since int64) ([]Tombstone, error) {
+	if s == nil {
+		return nil, ErrNotInitialized
+	}
+	out := []Tombstone{}
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("tomb:*", func(key, val string) bool {
+			var t Tombstone
+			if json.Unmarshal([]byte(val), &t) == nil && t.TS >= since {
+				out = append(out, t)
+			}
+			return true
+		})
+	})
+	return out, err
+}
+
+// ExpireStale tombstones current positions whose last sample is older than nowTTL, e.g. after
+// the process was suspended and the regular sweep could not run. It returns the removed ICAO24
+// codes; the removal is recorded in the event log like an ingest sweep.
+func (s *Store) ExpireStale() ([]string, error) {
+	if s == nil || s.db == nil {
+		return nil, ErrNotInitialized
+	}
+	now := time.Now()
+	cutoff := now.Add(-s.nowTTL).Unix()
+	var removed []string
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		var mu sync.Mutex
+		stale := []string{}
+		_ = s.fanOut(tx, false, func(_ int, tx *buntdb.Tx) error {
+			return tx.AscendKeys("now:*", func(key, val string) bool {
+				var p Point
+				if json.Unmarshal([]byte(val), &p) == nil && p.TS < cutoff {
+					mu.Lock()
+					stale = append(stale, key[len("now:"):])
+					mu.Unlock()
+				}
+				return true
+			})
+		})
+		if len(stale) == 0 {
+			return nil
+		}
+		// Force the deadline of stale aircraft into the past so the sweep removes them now
+		s.seenMu.Lock()
+		if s.seen == nil {
+			s.seen = map[string]int64{}
+		}
+		for _, icao := range stale {
+			s.seen[icao] = 0
+		}
+		s.seenMu.Unlock()
+		removed = s.sweepTombstones(tx, now)
+		s.appendLog(tx, nil, removed)
+		s.updateCurrentGauges(tx)
+		return nil
+	})
+	if err == nil && len(removed) > 0 {
+		s.publishSnapshot(nil)
+	}
+	return removed, err
+}
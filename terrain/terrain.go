@@ -0,0 +1,439 @@
+// Package terrain provides ground elevation lookups used to compute height above ground (AGL)
+// for low-flying aircraft. Elevation comes either from local SRTM .hgt DEM tiles or from an
+// Open-Elevation compatible HTTP API; results are cached in memory.
+package terrain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maniack/miniflightradar/httpclient"
+	"github.com/maniack/miniflightradar/monitoring"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// ErrNoData is returned when no elevation is available for a location (outside DEM coverage or voids).
+var ErrNoData = errors.New("no elevation data")
+
+// errPending is returned by cached lookups while the elevation is loaded in the background.
+var errPending = errors.New("elevation is being loaded")
+
+// Provider returns ground elevation in meters above mean sea level.
+type Provider interface {
+	Elevation(lat, lon float64) (float64, error)
+}
+
+// cachedProvider is a Provider that can answer from memory: cached returns errPending (and
+// schedules a background load) when the elevation is not loaded yet.
+type cachedProvider interface {
+	cached(lat, lon float64) (float64, error)
+}
+
+var (
+	provMu   sync.RWMutex
+	provider Provider
+)
+
+// Configure selects the elevation provider: a directory of SRTM .hgt tiles has priority over an API URL.
+// Both empty disables terrain lookups.
+func Configure(demDir, apiURL string) {
+	demDir = strings.TrimSpace(demDir)
+	apiURL = strings.TrimSpace(apiURL)
+	var p Provider
+	switch {
+	case demDir != "":
+		p = newHGTProvider(demDir)
+		monitoring.SubDebugf("terrain", "provider=hgt dir=%s", demDir)
+	case apiURL != "":
+		p = newAPIProvider(apiURL)
+		monitoring.SubDebugf("terrain", "provider=api url=%s", apiURL)
+	}
+	provMu.Lock()
+	provider = p
+	provMu.Unlock()
+}
+
+// Enabled reports whether an elevation provider is configured.
+func Enabled() bool {
+	provMu.RLock()
+	defer provMu.RUnlock()
+	return provider != nil
+}
+
+// Cached returns elevation only if it is available without blocking on disk or network I/O.
+// A miss schedules a background load of the DEM tile or API lookup, so later samples hit the
+// cache. It is safe to call from inside storage transactions.
+func Cached(lat, lon float64) (float64, bool) {
+	e, err := cached(lat, lon)
+	return e, err == nil
+}
+
+func cached(lat, lon float64) (float64, error) {
+	provMu.RLock()
+	p := provider
+	provMu.RUnlock()
+	c, ok := p.(cachedProvider)
+	if !ok {
+		return 0, ErrNoData
+	}
+	return c.cached(lat, lon)
+}
+
+// Flush drops the elevations cached from the API provider, so they are looked up again. It
+// reports whether there was such a cache (DEM tiles are read from disk and kept).
+func Flush() bool {
+	provMu.RLock()
+	a, ok := provider.(*apiProvider)
+	provMu.RUnlock()
+	if !ok {
+		return false
+	}
+	a.mu.Lock()
+	a.cache = map[[2]int32]float64{}
+	a.missing = map[[2]int32]time.Time{}
+	a.mu.Unlock()
+	return true
+}
+
+// ============ SRTM .hgt tiles ============
+
+// hgtTile is one 1x1 degree tile (1201x1201 for SRTM3 or 3601x3601 for SRTM1), big-endian int16 samples.
+type hgtTile struct {
+	size int
+	data []int16
+}
+
+type hgtProvider struct {
+	dir    string
+	mu     sync.Mutex
+	tiles  map[string]*hgtTile // nil value caches a missing tile
+	queue  chan string
+	queued map[string]struct{}
+}
+
+// maxHGTTiles bounds memory (an SRTM1 tile is ~25MB in memory).
+const maxHGTTiles = 16
+
+func newHGTProvider(dir string) *hgtProvider {
+	h := &hgtProvider{
+		dir:    dir,
+		tiles:  map[string]*hgtTile{},
+		queue:  make(chan string, maxHGTTiles),
+		queued: map[string]struct{}{},
+	}
+	go monitoring.Supervise("terrain.tiles", nil, h.worker)
+	return h
+}
+
+func hgtName(lat, lon float64) string {
+	la := int(math.Floor(lat))
+	lo := int(math.Floor(lon))
+	ns, ew := 'N', 'E'
+	if la < 0 {
+		ns = 'S'
+		la = -la
+	}
+	if lo < 0 {
+		ew = 'W'
+		lo = -lo
+	}
+	return fmt.Sprintf("%c%02d%c%03d.hgt", ns, la, ew, lo)
+}
+
+// tile returns the named tile, reading it from disk (outside the lock) if it is not loaded.
+func (h *hgtProvider) tile(name string) *hgtTile {
+	h.mu.Lock()
+	t, ok := h.tiles[name]
+	h.mu.Unlock()
+	if ok {
+		return t
+	}
+	t = readHGT(filepath.Join(h.dir, name))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.tiles) >= maxHGTTiles {
+		// drop an arbitrary tile; access is highly local so a simple bound is enough
+		for k := range h.tiles {
+			delete(h.tiles, k)
+			break
+		}
+	}
+	h.tiles[name] = t
+	return t
+}
+
+// readHGT reads and decodes a tile file; nil when it is missing or not an SRTM tile.
+func readHGT(path string) *hgtTile {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var size int
+	switch len(b) {
+	case 1201 * 1201 * 2:
+		size = 1201
+	case 3601 * 3601 * 2:
+		size = 3601
+	default:
+		monitoring.SubDebugf("terrain", "unexpected hgt size file=%s bytes=%d", filepath.Base(path), len(b))
+		return nil
+	}
+	t := &hgtTile{size: size, data: make([]int16, size*size)}
+	for i := range t.data {
+		t.data[i] = int16(binary.BigEndian.Uint16(b[i*2:]))
+	}
+	return t
+}
+
+// cached answers from loaded tiles only; a tile that is not loaded yet is queued for the
+// worker and reported as pending.
+func (h *hgtProvider) cached(lat, lon float64) (float64, error) {
+	if !validLocation(lat, lon) {
+		return 0, ErrNoData
+	}
+	name := hgtName(lat, lon)
+	h.mu.Lock()
+	t, ok := h.tiles[name]
+	if !ok {
+		if _, q := h.queued[name]; !q {
+			select {
+			case h.queue <- name:
+				h.queued[name] = struct{}{}
+			default:
+			}
+		}
+		h.mu.Unlock()
+		return 0, errPending
+	}
+	h.mu.Unlock()
+	if t == nil {
+		return 0, ErrNoData
+	}
+	return t.elevation(lat, lon)
+}
+
+func (h *hgtProvider) worker() {
+	for name := range h.queue {
+		h.tile(name)
+		h.mu.Lock()
+		delete(h.queued, name)
+		h.mu.Unlock()
+	}
+}
+
+func (h *hgtProvider) Elevation(lat, lon float64) (float64, error) {
+	if !validLocation(lat, lon) {
+		return 0, ErrNoData
+	}
+	t := h.tile(hgtName(lat, lon))
+	if t == nil {
+		return 0, ErrNoData
+	}
+	return t.elevation(lat, lon)
+}
+
+func validLocation(lat, lon float64) bool {
+	return !math.IsNaN(lat) && !math.IsNaN(lon) && lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+func (t *hgtTile) elevation(lat, lon float64) (float64, error) {
+	// Rows run north to south, columns west to east; bilinear interpolation between samples.
+	n := float64(t.size - 1)
+	fx := (lon - math.Floor(lon)) * n
+	fy := (1 - (lat - math.Floor(lat))) * n
+	x0, y0 := int(fx), int(fy)
+	x1, y1 := x0+1, y0+1
+	if x1 > t.size-1 {
+		x1 = t.size - 1
+	}
+	if y1 > t.size-1 {
+		y1 = t.size - 1
+	}
+	at := func(x, y int) (float64, bool) {
+		v := t.data[y*t.size+x]
+		if v == -32768 {
+			return 0, false
+		}
+		return float64(v), true
+	}
+	v00, ok1 := at(x0, y0)
+	v10, ok2 := at(x1, y0)
+	v01, ok3 := at(x0, y1)
+	v11, ok4 := at(x1, y1)
+	if !(ok1 && ok2 && ok3 && ok4) {
+		if ok1 {
+			return v00, nil
+		}
+		return 0, ErrNoData
+	}
+	dx, dy := fx-float64(x0), fy-float64(y0)
+	top := v00*(1-dx) + v10*dx
+	bot := v01*(1-dx) + v11*dx
+	return top*(1-dy) + bot*dy, nil
+}
+
+// ============ HTTP API (Open-Elevation / Open Topo Data compatible) ============
+
+// apiGrid is the cache cell size in degrees (~1km); terrain is smooth enough at that scale for AGL display.
+const apiGrid = 0.01
+
+// apiFetchInterval spaces background lookups, bounding outbound requests to 10 per second.
+const apiFetchInterval = 100 * time.Millisecond
+
+type apiProvider struct {
+	base   string
+	client *http.Client
+
+	mu      sync.Mutex
+	cache   map[[2]int32]float64
+	missing map[[2]int32]time.Time
+	queue   chan [2]int32
+	queued  map[[2]int32]struct{}
+}
+
+func newAPIProvider(base string) *apiProvider {
+	a := &apiProvider{
+		base:    base,
+		client:  httpclient.New("terrain", 10*time.Second),
+		cache:   map[[2]int32]float64{},
+		missing: map[[2]int32]time.Time{},
+		queue:   make(chan [2]int32, 256),
+		queued:  map[[2]int32]struct{}{},
+	}
+	go monitoring.Supervise("terrain.worker", nil, a.worker)
+	return a
+}
+
+func gridKey(lat, lon float64) [2]int32 {
+	return [2]int32{int32(math.Round(lat / apiGrid)), int32(math.Round(lon / apiGrid))}
+}
+
+func (a *apiProvider) cached(lat, lon float64) (float64, error) {
+	k := gridKey(lat, lon)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e, ok := a.cache[k]; ok {
+		return e, nil
+	}
+	if t, ok := a.missing[k]; ok && time.Since(t) < 10*time.Minute {
+		return 0, ErrNoData
+	}
+	if _, ok := a.queued[k]; !ok {
+		select {
+		case a.queue <- k:
+			a.queued[k] = struct{}{}
+		default:
+		}
+	}
+	return 0, errPending
+}
+
+func (a *apiProvider) worker() {
+	tick := time.NewTicker(apiFetchInterval)
+	defer tick.Stop()
+	for k := range a.queue {
+		<-tick.C
+		_, _ = a.fetch(k)
+		a.mu.Lock()
+		delete(a.queued, k)
+		a.mu.Unlock()
+	}
+}
+
+func (a *apiProvider) Elevation(lat, lon float64) (float64, error) {
+	k := gridKey(lat, lon)
+	a.mu.Lock()
+	if e, ok := a.cache[k]; ok {
+		a.mu.Unlock()
+		return e, nil
+	}
+	a.mu.Unlock()
+	return a.fetch(k)
+}
+
+func (a *apiProvider) fetch(k [2]int32) (float64, error) {
+	lat := float64(k[0]) * apiGrid
+	lon := float64(k[1]) * apiGrid
+	u := a.base + "?locations=" + url.QueryEscape(strconv.FormatFloat(lat, 'f', 4, 64)+","+strconv.FormatFloat(lon, 'f', 4, 64))
+	resp, err := a.client.Get(u)
+	if err != nil {
+		a.markMissing(k)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		a.markMissing(k)
+		return 0, fmt.Errorf("elevation api status %d", resp.StatusCode)
+	}
+	var body struct {
+		Results []struct {
+			Elevation *float64 `json:"elevation"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		a.markMissing(k)
+		return 0, err
+	}
+	if len(body.Results) == 0 || body.Results[0].Elevation == nil {
+		a.markMissing(k)
+		return 0, ErrNoData
+	}
+	e := *body.Results[0].Elevation
+	a.mu.Lock()
+	if len(a.cache) > 200000 {
+		a.cache = map[[2]int32]float64{}
+	}
+	a.cache[k] = e
+	delete(a.missing, k)
+	a.mu.Unlock()
+	return e, nil
+}
+
+func (a *apiProvider) markMissing(k [2]int32) {
+	a.mu.Lock()
+	a.missing[k] = time.Now()
+	a.mu.Unlock()
+}
+
+// ============ HTTP handler ============
+
+// ElevationHandler serves /api/elevation?lat=&lon= returning ground elevation in meters (MSL).
+// It only answers from loaded tiles and cached lookups, so clients cannot drive disk or network
+// I/O directly: a miss queues the load and answers 503 with Retry-After.
+func ElevationHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(q.Get("lat")), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(q.Get("lon")), 64)
+	if err1 != nil || err2 != nil || !validLocation(lat, lon) {
+		problem.Write(w, r, http.StatusBadRequest, "lat and lon are required")
+		return
+	}
+	if !Enabled() {
+		problem.Write(w, r, http.StatusNotFound, "terrain provider is not configured")
+		return
+	}
+	e, err := cached(lat, lon)
+	if errors.Is(err, errPending) {
+		w.Header().Set("Retry-After", "1")
+		problem.Write(w, r, http.StatusServiceUnavailable, "elevation is being loaded, retry shortly")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusNotFound, "no elevation data for location")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"lat": lat, "lon": lon, "elevation": e})
+}
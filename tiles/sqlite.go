@@ -0,0 +1,646 @@
+package tiles
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Minimal read-only SQLite3 file reader.
+//
+// MBTiles archives are plain SQLite databases. To avoid pulling a SQL engine (and cgo) into
+// the binary we only implement what is needed to walk table b-trees: the file header,
+// table b-trees, searching index b-trees by key, overflow chains and the record format. WAL
+// files and WITHOUT ROWID tables are not supported; the archive is expected to be a finished
+// file. Indexes are searched with the BINARY collation in ascending order.
+// Every offset read from the file is checked against the page it points into, so a truncated
+// or corrupt archive yields errors rather than panics.
+
+const sqliteMagic = "SQLite format 3\x00"
+
+type sqliteFile struct {
+	f        *os.File
+	pageSize int
+	usable   int
+}
+
+// sqliteRow is a decoded table row: rowid plus column values
+// (nil, int64, float64, string or []byte).
+type sqliteRow struct {
+	rowid int64
+	vals  []interface{}
+}
+
+// sqliteObject is an entry of the sqlite_master schema table.
+type sqliteObject struct {
+	typ      string
+	name     string
+	table    string // tbl_name: the table of an index
+	rootpage int
+	sql      string
+}
+
+// b-tree page types
+const (
+	pageIndexInterior = 0x02
+	pageTableInterior = 0x05
+	pageIndexLeaf     = 0x0A
+	pageTableLeaf     = 0x0D
+)
+
+func openSQLite(path string) (*sqliteFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 100)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("read sqlite header: %w", err)
+	}
+	if string(hdr[:16]) != sqliteMagic {
+		_ = f.Close()
+		return nil, errors.New("not a sqlite3 file")
+	}
+	ps := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if ps == 1 {
+		ps = 65536
+	}
+	if ps < 512 || ps&(ps-1) != 0 {
+		_ = f.Close()
+		return nil, fmt.Errorf("invalid sqlite page size %d", ps)
+	}
+	// File format read/write versions are 2 in WAL mode: committed pages may still sit in the
+	// -wal file, which is not read, so the main file alone can be stale or inconsistent
+	if hdr[18] == 2 || hdr[19] == 2 {
+		_ = f.Close()
+		return nil, errors.New("sqlite file is in WAL mode; convert it with sqlite3 FILE 'PRAGMA journal_mode=DELETE'")
+	}
+	if enc := binary.BigEndian.Uint32(hdr[56:60]); enc > 1 {
+		_ = f.Close()
+		return nil, errors.New("only UTF-8 sqlite files are supported")
+	}
+	// SQLite requires at least 480 usable bytes per page; the payload formulas rely on it
+	usable := ps - int(hdr[20])
+	if usable < 480 {
+		_ = f.Close()
+		return nil, fmt.Errorf("invalid sqlite reserved space %d", hdr[20])
+	}
+	return &sqliteFile{f: f, pageSize: ps, usable: usable}, nil
+}
+
+func (s *sqliteFile) Close() error { return s.f.Close() }
+
+func (s *sqliteFile) page(n int) ([]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid page number %d", n)
+	}
+	buf := make([]byte, s.pageSize)
+	if _, err := s.f.ReadAt(buf, int64(n-1)*int64(s.pageSize)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readVarint decodes a SQLite varint (1-9 bytes, big-endian).
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// maxPayload bounds the payload of a cell; larger sizes come from corrupt files.
+const maxPayload = 1 << 30
+
+var errCorrupt = errors.New("corrupt sqlite file")
+
+// payload returns the full payload of a table leaf or index cell, following overflow pages.
+func (s *sqliteFile) payload(pg []byte, off int, total uint64, index bool) ([]byte, error) {
+	if total > maxPayload || off < 0 || off > len(pg) {
+		return nil, errCorrupt
+	}
+	u, size := s.usable, int(total)
+	x := u - 35
+	if index {
+		x = ((u-12)*64)/255 - 23
+	}
+	local := size
+	if size > x {
+		m := ((u-12)*32)/255 - 23
+		k := m + (size-m)%(u-4)
+		if k <= x {
+			local = k
+		} else {
+			local = m
+		}
+	}
+	if off+local > len(pg) {
+		return nil, errCorrupt
+	}
+	out := make([]byte, 0, local)
+	out = append(out, pg[off:off+local]...)
+	if local == size {
+		return out, nil
+	}
+	if off+local+4 > len(pg) {
+		return nil, errCorrupt
+	}
+	next := int(binary.BigEndian.Uint32(pg[off+local:]))
+	// Each overflow page carries u-4 bytes; a longer chain loops
+	for pages := 0; next != 0 && len(out) < size; pages++ {
+		if pages > (size-local)/(u-4) {
+			return nil, errors.New("overflow chain loops")
+		}
+		op, err := s.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = int(binary.BigEndian.Uint32(op[:4]))
+		n := min(size-len(out), u-4)
+		out = append(out, op[4:4+n]...)
+	}
+	if len(out) != size {
+		return nil, errors.New("truncated overflow chain")
+	}
+	return out, nil
+}
+
+// pageHeader returns b-tree page type, header offset, cell count and right-most pointer.
+// Only pages of the expected kind of b-tree (table or index) are accepted, and the cell
+// pointer array must fit the page.
+func pageHeader(pg []byte, n int, index bool) (typ byte, hdr int, cells int, right int, err error) {
+	if n == 1 {
+		hdr = 100
+	}
+	if hdr+8 > len(pg) {
+		return 0, 0, 0, 0, errCorrupt
+	}
+	typ = pg[hdr]
+	size := 8
+	switch {
+	case typ == pageTableLeaf && !index, typ == pageIndexLeaf && index:
+	case typ == pageTableInterior && !index, typ == pageIndexInterior && index:
+		size = 12
+		if hdr+size > len(pg) {
+			return 0, 0, 0, 0, errCorrupt
+		}
+		right = int(binary.BigEndian.Uint32(pg[hdr+8:]))
+	default:
+		return 0, 0, 0, 0, fmt.Errorf("unsupported b-tree page type 0x%02x on page %d", typ, n)
+	}
+	cells = int(binary.BigEndian.Uint16(pg[hdr+3:]))
+	if hdr+size+2*cells > len(pg) {
+		return 0, 0, 0, 0, errCorrupt
+	}
+	return typ, hdr, cells, right, nil
+}
+
+// cellPointer returns the offset of cell i, which must lie past the cell pointer array.
+func cellPointer(pg []byte, hdr int, typ byte, cells, i int) (int, error) {
+	size := 8
+	if typ == pageTableInterior || typ == pageIndexInterior {
+		size = 12
+	}
+	off := int(binary.BigEndian.Uint16(pg[hdr+size+2*i:]))
+	if off < hdr+size+2*cells || off >= len(pg) {
+		return 0, errCorrupt
+	}
+	return off, nil
+}
+
+// interiorCell decodes the left child pointer and rowid key of a table interior cell.
+func interiorCell(pg []byte, off int) (child int, key int64, err error) {
+	if off+4 > len(pg) {
+		return 0, 0, errCorrupt
+	}
+	k, n := readVarint(pg[off+4:])
+	if n == 0 {
+		return 0, 0, errCorrupt
+	}
+	return int(binary.BigEndian.Uint32(pg[off:])), int64(k), nil
+}
+
+// leafCell decodes a table leaf cell at off into a row.
+func (s *sqliteFile) leafCell(pg []byte, off int) (sqliteRow, error) {
+	plen, n1 := readVarint(pg[off:])
+	if n1 == 0 {
+		return sqliteRow{}, errors.New("corrupt leaf cell")
+	}
+	rowid, n2 := readVarint(pg[off+n1:])
+	if n2 == 0 {
+		return sqliteRow{}, errors.New("corrupt leaf cell")
+	}
+	data, err := s.payload(pg, off+n1+n2, plen, false)
+	if err != nil {
+		return sqliteRow{}, err
+	}
+	vals, err := decodeRecord(data)
+	if err != nil {
+		return sqliteRow{}, err
+	}
+	return sqliteRow{rowid: int64(rowid), vals: vals}, nil
+}
+
+// scan walks a table b-tree in rowid order calling fn for each row until fn returns false.
+func (s *sqliteFile) scan(root int, fn func(sqliteRow) bool) error {
+	_, err := s.walk(root, fn, 0, map[int]bool{})
+	return err
+}
+
+// walk visits the subtree at page n; seen catches pages linked twice, which would make a
+// corrupt file loop.
+func (s *sqliteFile) walk(n int, fn func(sqliteRow) bool, depth int, seen map[int]bool) (bool, error) {
+	if depth > 64 {
+		return false, errors.New("b-tree too deep")
+	}
+	if seen[n] {
+		return false, fmt.Errorf("b-tree page %d linked twice", n)
+	}
+	seen[n] = true
+	pg, err := s.page(n)
+	if err != nil {
+		return false, err
+	}
+	typ, hdr, cells, right, err := pageHeader(pg, n, false)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < cells; i++ {
+		off, err := cellPointer(pg, hdr, typ, cells, i)
+		if err != nil {
+			return false, err
+		}
+		if typ == pageTableLeaf {
+			row, err := s.leafCell(pg, off)
+			if err != nil {
+				return false, err
+			}
+			if !fn(row) {
+				return false, nil
+			}
+			continue
+		}
+		child, _, err := interiorCell(pg, off)
+		if err != nil {
+			return false, err
+		}
+		if cont, err := s.walk(child, fn, depth+1, seen); err != nil || !cont {
+			return cont, err
+		}
+	}
+	if typ == pageTableLeaf {
+		return true, nil
+	}
+	return s.walk(right, fn, depth+1, seen)
+}
+
+// lookup finds a row by rowid in a table b-tree.
+func (s *sqliteFile) lookup(root int, rowid int64) (*sqliteRow, error) {
+	n := root
+	for depth := 0; depth < 64; depth++ {
+		pg, err := s.page(n)
+		if err != nil {
+			return nil, err
+		}
+		typ, hdr, cells, right, err := pageHeader(pg, n, false)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case pageTableLeaf:
+			for i := 0; i < cells; i++ {
+				off, err := cellPointer(pg, hdr, typ, cells, i)
+				if err != nil {
+					return nil, err
+				}
+				_, n1 := readVarint(pg[off:])
+				if n1 == 0 {
+					return nil, errCorrupt
+				}
+				id, n2 := readVarint(pg[off+n1:])
+				if n2 == 0 {
+					return nil, errCorrupt
+				}
+				if int64(id) == rowid {
+					row, err := s.leafCell(pg, off)
+					if err != nil {
+						return nil, err
+					}
+					return &row, nil
+				}
+			}
+			return nil, nil
+		default: // pageTableInterior
+			next := right
+			for i := 0; i < cells; i++ {
+				off, err := cellPointer(pg, hdr, typ, cells, i)
+				if err != nil {
+					return nil, err
+				}
+				child, key, err := interiorCell(pg, off)
+				if err != nil {
+					return nil, err
+				}
+				if rowid <= key {
+					next = child
+					break
+				}
+			}
+			n = next
+		}
+	}
+	return nil, errors.New("b-tree too deep")
+}
+
+// seek searches an index b-tree for an entry whose leading columns equal key and returns the
+// rowid stored as its last column.
+func (s *sqliteFile) seek(root int, key []interface{}) (int64, bool, error) {
+	n := root
+	for depth := 0; depth < 64; depth++ {
+		pg, err := s.page(n)
+		if err != nil {
+			return 0, false, err
+		}
+		typ, hdr, cells, right, err := pageHeader(pg, n, true)
+		if err != nil {
+			return 0, false, err
+		}
+		// Binary search for the first entry not below key
+		next, lo, hi := right, 0, cells
+		for lo < hi {
+			mid := (lo + hi) / 2
+			child, vals, err := s.indexCell(pg, typ, hdr, cells, mid, len(key))
+			if err != nil {
+				return 0, false, err
+			}
+			c := compareKey(key, vals)
+			if c == 0 {
+				// Interior cells hold entries too
+				if id, ok := vals[len(vals)-1].(int64); ok {
+					return id, true, nil
+				}
+				return 0, false, errCorrupt
+			}
+			if c < 0 {
+				hi, next = mid, child
+			} else {
+				lo = mid + 1
+			}
+		}
+		if typ == pageIndexLeaf {
+			return 0, false, nil
+		}
+		n = next
+	}
+	return 0, false, errors.New("b-tree too deep")
+}
+
+// indexCell decodes cell i of an index page: the left child (interior pages) and the entry,
+// which must hold more than keyLen columns (the key and the rowid).
+func (s *sqliteFile) indexCell(pg []byte, typ byte, hdr, cells, i, keyLen int) (int, []interface{}, error) {
+	off, err := cellPointer(pg, hdr, typ, cells, i)
+	if err != nil {
+		return 0, nil, err
+	}
+	child := 0
+	if typ == pageIndexInterior {
+		if off+4 > len(pg) {
+			return 0, nil, errCorrupt
+		}
+		child = int(binary.BigEndian.Uint32(pg[off:]))
+		off += 4
+	}
+	plen, n := readVarint(pg[off:])
+	if n == 0 {
+		return 0, nil, errCorrupt
+	}
+	data, err := s.payload(pg, off+n, plen, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	vals, err := decodeRecord(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(vals) <= keyLen {
+		return 0, nil, errCorrupt
+	}
+	return child, vals, nil
+}
+
+// compareKey compares key with the leading columns of an index entry.
+func compareKey(key, entry []interface{}) int {
+	for i, k := range key {
+		if c := compareValues(k, entry[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues orders values as SQLite does: NULL, numbers, text (BINARY collation), blobs.
+func compareValues(a, b interface{}) int {
+	class := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case int64, float64:
+			return 1
+		case string:
+			return 2
+		}
+		return 3
+	}
+	if ca, cb := class(a), class(b); ca != cb {
+		return ca - cb
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, y)
+		}
+		return cmp.Compare(float64(x), b.(float64))
+	case float64:
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, float64(y))
+		}
+		return cmp.Compare(x, b.(float64))
+	case string:
+		return strings.Compare(x, b.(string))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	}
+	return 0
+}
+
+// decodeRecord decodes a SQLite record into Go values.
+func decodeRecord(b []byte) ([]interface{}, error) {
+	hlen, n := readVarint(b)
+	if n == 0 || hlen < uint64(n) || hlen > uint64(len(b)) {
+		return nil, errors.New("corrupt record header")
+	}
+	types := make([]uint64, 0, 8)
+	for p := n; p < int(hlen); {
+		t, m := readVarint(b[p:int(hlen)])
+		if m == 0 {
+			return nil, errors.New("corrupt record header")
+		}
+		types = append(types, t)
+		p += m
+	}
+	vals := make([]interface{}, 0, len(types))
+	p := int(hlen)
+	for _, t := range types {
+		var size uint64
+		switch {
+		case t <= 4:
+			size = t
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t == 8 || t == 9:
+			size = 0
+		case t >= 12:
+			size = (t - 12) / 2
+		default:
+			return nil, fmt.Errorf("reserved serial type %d", t)
+		}
+		// Compared unsigned: serial types of corrupt records may encode sizes beyond int
+		if size > uint64(len(b)-p) {
+			return nil, errors.New("corrupt record body")
+		}
+		v := b[p : p+int(size)]
+		p += int(size)
+		switch {
+		case t == 0:
+			vals = append(vals, nil)
+		case t >= 1 && t <= 6:
+			var x int64
+			if v[0]&0x80 != 0 {
+				x = -1
+			}
+			for _, c := range v {
+				x = x<<8 | int64(c)
+			}
+			vals = append(vals, x)
+		case t == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8:
+			vals = append(vals, int64(0))
+		case t == 9:
+			vals = append(vals, int64(1))
+		case t%2 == 0:
+			vals = append(vals, v)
+		default:
+			vals = append(vals, string(v))
+		}
+	}
+	return vals, nil
+}
+
+// schema reads sqlite_master entries.
+func (s *sqliteFile) schema() (map[string]sqliteObject, error) {
+	out := map[string]sqliteObject{}
+	err := s.scan(1, func(r sqliteRow) bool {
+		if len(r.vals) < 5 {
+			return true
+		}
+		o := sqliteObject{}
+		o.typ, _ = r.vals[0].(string)
+		o.name, _ = r.vals[1].(string)
+		o.table, _ = r.vals[2].(string)
+		if v, ok := r.vals[3].(int64); ok {
+			o.rootpage = int(v)
+		}
+		o.sql, _ = r.vals[4].(string)
+		out[strings.ToLower(o.name)] = o
+		return true
+	})
+	return out, err
+}
+
+// indexColumns extracts the column names of a CREATE INDEX statement in index order.
+// Columns seek cannot use (expressions, collations, descending order) are returned as "",
+// and partial indexes as nil.
+func indexColumns(sql string) []string {
+	open := strings.IndexByte(sql, '(')
+	if open < 0 || strings.Contains(strings.ToUpper(sql), " WHERE ") {
+		return nil
+	}
+	body, _, ok := strings.Cut(sql[open+1:], ")")
+	if !ok {
+		return nil
+	}
+	var cols []string
+	for _, d := range strings.Split(body, ",") {
+		fields := strings.Fields(d)
+		if len(fields) == 0 || len(fields) > 2 || len(fields) == 2 && !strings.EqualFold(fields[1], "ASC") || strings.ContainsRune(d, '(') {
+			cols = append(cols, "")
+			continue
+		}
+		cols = append(cols, strings.ToLower(strings.Trim(fields[0], "`\"[]'")))
+	}
+	return cols
+}
+
+// tableColumns extracts column names (in declaration order) from a CREATE TABLE statement
+// and reports which one, if any, is an INTEGER PRIMARY KEY alias of the rowid.
+func tableColumns(sql string) (cols []string, rowidCol int) {
+	rowidCol = -1
+	open := strings.IndexByte(sql, '(')
+	close := strings.LastIndexByte(sql, ')')
+	if open < 0 || close <= open {
+		return nil, -1
+	}
+	body := sql[open+1 : close]
+	depth, start := 0, 0
+	var defs []string
+	for i, ch := range body {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	defs = append(defs, body[start:])
+	for _, d := range defs {
+		fields := strings.Fields(strings.TrimSpace(d))
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue
+		}
+		name := strings.ToLower(strings.Trim(fields[0], "`\"[]'"))
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER") && strings.Contains(upper, "PRIMARY KEY") {
+			rowidCol = len(cols)
+		}
+		cols = append(cols, name)
+	}
+	return cols, rowidCol
+}
@@ -0,0 +1,341 @@
+// Package tiles serves map tiles from a local MBTiles archive so the map can work
+// without any third-party tile provider (air-gapped, ship or expedition deployments).
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// tileColumns are the coordinate columns of the tiles (or map) table.
+var tileColumns = []string{"zoom_level", "tile_column", "tile_row"}
+
+// Archive is an opened MBTiles file. Tiles are looked up through the archive's own indexes
+// (the b-trees SQLite keeps on disk), so memory use does not grow with the archive.
+type Archive struct {
+	db       *sqliteFile
+	format   string
+	metadata map[string]string
+
+	// Plain layout: tiles(zoom_level, tile_column, tile_row, tile_data). In the deduplicated
+	// layout the same columns come from map(zoom_level, tile_column, tile_row, tile_id).
+	tilesRoot int
+	tileIndex int   // root of the index on the coordinates
+	tileOrder []int // tileColumns in index order
+	dataCol   int   // tile_data, or tile_id of map
+
+	// Deduplicated layout: images(tile_data, tile_id), "tiles" being a view
+	imagesRoot  int
+	imagesIndex int // root of the index on tile_id; 0 when tile_id is the rowid
+	imagesData  int
+}
+
+var (
+	archiveMu sync.RWMutex
+	archive   *Archive
+)
+
+// Open opens an MBTiles file and finds its tile indexes. The archive becomes the one
+// served by Handler. Only finished (non-WAL) archives are supported.
+func Open(path string) (*Archive, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{db: db, metadata: map[string]string{}}
+	if err := a.load(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	archiveMu.Lock()
+	old := archive
+	archive = a
+	archiveMu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	log.Printf("tiles: opened %s format=%s deduplicated=%t", path, a.format, a.imagesRoot != 0)
+	return a, nil
+}
+
+// Get returns the currently opened archive or nil.
+func Get() *Archive {
+	archiveMu.RLock()
+	defer archiveMu.RUnlock()
+	return archive
+}
+
+// Close releases the underlying file.
+func (a *Archive) Close() error {
+	if a == nil || a.db == nil {
+		return nil
+	}
+	return a.db.Close()
+}
+
+// Format returns the tile format from metadata (png, jpg, webp or pbf).
+func (a *Archive) Format() string { return a.format }
+
+func (a *Archive) load() error {
+	objs, err := a.db.schema()
+	if err != nil {
+		return fmt.Errorf("read schema: %w", err)
+	}
+	column := func(obj sqliteObject, name string) int {
+		cols, _ := tableColumns(obj.sql)
+		for i, c := range cols {
+			if c == name {
+				return i
+			}
+		}
+		return -1
+	}
+	// index finds an index on table whose leading columns are cols, in any order, and
+	// returns its root page with the positions in cols of its leading columns
+	index := func(table string, cols ...string) (int, []int) {
+		for _, o := range objs {
+			if o.typ != "index" || !strings.EqualFold(o.table, table) {
+				continue
+			}
+			ic := indexColumns(o.sql)
+			if len(ic) < len(cols) {
+				continue
+			}
+			order := make([]int, len(cols))
+			used := map[int]bool{}
+			for i := range cols {
+				order[i] = -1
+				for j, c := range cols {
+					if ic[i] == c && !used[j] {
+						order[i], used[j] = j, true
+					}
+				}
+			}
+			if !slices.Contains(order, -1) {
+				return o.rootpage, order
+			}
+		}
+		return 0, nil
+	}
+	noIndex := func(table string, cols ...string) error {
+		return fmt.Errorf("%s table has no index on (%s); add one with CREATE UNIQUE INDEX %s_index ON %s (%s)",
+			table, strings.Join(cols, ", "), table, table, strings.Join(cols, ", "))
+	}
+
+	// metadata(name, value)
+	if md, ok := objs["metadata"]; ok && md.typ == "table" {
+		nc, vc := column(md, "name"), column(md, "value")
+		_ = a.db.scan(md.rootpage, func(r sqliteRow) bool {
+			if nc >= 0 && vc >= 0 && nc < len(r.vals) && vc < len(r.vals) {
+				n, _ := r.vals[nc].(string)
+				v, _ := r.vals[vc].(string)
+				a.metadata[n] = v
+			}
+			return true
+		})
+	}
+	a.format = strings.ToLower(a.metadata["format"])
+	if a.format == "" {
+		a.format = "png"
+	}
+
+	if t, ok := objs["tiles"]; ok && t.typ == "table" {
+		a.dataCol = column(t, "tile_data")
+		if a.dataCol < 0 {
+			return errors.New("tiles table has unexpected columns")
+		}
+		a.tilesRoot = t.rootpage
+		if a.tileIndex, a.tileOrder = index("tiles", tileColumns...); a.tileIndex == 0 {
+			return noIndex("tiles", tileColumns...)
+		}
+		return nil
+	}
+
+	// Fall back to the common deduplicated schema where "tiles" is a view.
+	m, okm := objs["map"]
+	img, oki := objs["images"]
+	if !okm || !oki || m.typ != "table" || img.typ != "table" {
+		return errors.New("no tiles table found")
+	}
+	a.dataCol = column(m, "tile_id")
+	idc := column(img, "tile_id")
+	a.imagesData = column(img, "tile_data")
+	if a.dataCol < 0 || idc < 0 || a.imagesData < 0 {
+		return errors.New("map/images tables have unexpected columns")
+	}
+	a.tilesRoot, a.imagesRoot = m.rootpage, img.rootpage
+	if a.tileIndex, a.tileOrder = index("map", tileColumns...); a.tileIndex == 0 {
+		return noIndex("map", tileColumns...)
+	}
+	if _, rowidCol := tableColumns(img.sql); rowidCol == idc {
+		return nil
+	}
+	if a.imagesIndex, _ = index("images", "tile_id"); a.imagesIndex == 0 {
+		return noIndex("images", "tile_id")
+	}
+	return nil
+}
+
+// Tile returns tile bytes for XYZ coordinates (y counted from the top as in web maps).
+// It returns nil, nil when the tile is not present in the archive.
+func (a *Archive) Tile(z, x, y int) ([]byte, error) {
+	if a == nil || z < 0 || z > 30 {
+		return nil, nil
+	}
+	// MBTiles stores rows in TMS order (y counted from the bottom)
+	zxy := []int64{int64(z), int64(x), int64(1)<<uint(z) - 1 - int64(y)}
+	key := make([]interface{}, len(a.tileOrder))
+	for i, c := range a.tileOrder {
+		key[i] = zxy[c]
+	}
+	rowid, ok, err := a.db.seek(a.tileIndex, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	row, err := a.db.lookup(a.tilesRoot, rowid)
+	if err != nil || row == nil || a.dataCol >= len(row.vals) {
+		return nil, err
+	}
+	val := row.vals[a.dataCol]
+	if a.imagesRoot != 0 {
+		// val is the tile_id of the deduplicated layout
+		if a.imagesIndex == 0 {
+			if rowid, ok = val.(int64); !ok {
+				return nil, nil
+			}
+		} else if rowid, ok, err = a.db.seek(a.imagesIndex, []interface{}{val}); err != nil || !ok {
+			return nil, err
+		}
+		row, err = a.db.lookup(a.imagesRoot, rowid)
+		if err != nil || row == nil || a.imagesData >= len(row.vals) {
+			return nil, err
+		}
+		val = row.vals[a.imagesData]
+	}
+	switch v := val.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, nil
+}
+
+func contentType(format string) string {
+	switch format {
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "webp":
+		return "image/webp"
+	case "pbf", "mvt":
+		return "application/x-protobuf"
+	default:
+		return "image/png"
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip: named with a
+// non-zero q, or covered by "*" when not named.
+func acceptsGzip(r *http.Request) bool {
+	star := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			f, err := strconv.ParseFloat(strings.TrimSpace(q), 64)
+			ok = err == nil && f > 0
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			return ok
+		case "*":
+			star = ok
+		}
+	}
+	return star
+}
+
+// TileHandler serves /tiles/offline/{z}/{x}/{y} (y may carry a file extension, e.g. 7.png).
+// Missing tiles return 204 so map clients render an empty cell instead of an error tile.
+// Tiles stored gzip-compressed (usually vector tiles) are sent as is with Content-Encoding
+// gzip to clients accepting it and inflated for the others.
+func TileHandler(w http.ResponseWriter, r *http.Request) {
+	a := Get()
+	if a == nil {
+		problem.Write(w, r, http.StatusNotFound, "offline tiles are not configured")
+		return
+	}
+	yStr := chi.URLParam(r, "y")
+	if i := strings.IndexByte(yStr, '.'); i >= 0 {
+		yStr = yStr[:i]
+	}
+	z, err1 := strconv.Atoi(chi.URLParam(r, "z"))
+	x, err2 := strconv.Atoi(chi.URLParam(r, "x"))
+	y, err3 := strconv.Atoi(yStr)
+	if err1 != nil || err2 != nil || err3 != nil || z < 0 || x < 0 || y < 0 || z > 30 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		problem.Write(w, r, http.StatusBadRequest, "invalid tile coordinates")
+		return
+	}
+	data, err := a.Tile(z, x, y)
+	if err != nil {
+		log.Printf("tiles: read z=%d x=%d y=%d: %v", z, x, y, err)
+		problem.Write(w, r, http.StatusInternalServerError, "failed to read tile")
+		return
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Vector tiles are usually stored gzip-compressed inside the archive
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else if data, err = gunzip(data); err != nil {
+			log.Printf("tiles: inflate z=%d x=%d y=%d: %v", z, x, y, err)
+			problem.Write(w, r, http.StatusInternalServerError, "failed to read tile")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType(a.format))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// MetadataHandler returns the archive metadata table as JSON (name, format, bounds, zooms, attribution).
+func MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	a := Get()
+	if a == nil {
+		problem.Write(w, r, http.StatusNotFound, "offline tiles are not configured")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"format":   a.format,
+		"url":      "/tiles/offline/{z}/{x}/{y}",
+		"metadata": a.metadata,
+	})
+}
@@ -0,0 +1,85 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Branding white-labels the UI: it is templated into index.html at serve time and returned by
+// /api/config, so operators can rebrand an instance without rebuilding the frontend.
+type Branding struct {
+	Title       string `json:"title,omitempty"`       // page and header title
+	Logo        string `json:"logo,omitempty"`        // image URL or path (also the favicon)
+	Color       string `json:"color,omitempty"`       // primary color, #rgb or #rrggbb
+	Attribution string `json:"attribution,omitempty"` // text shown with the map attributions
+}
+
+var (
+	branding Branding
+	colorRe  = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	titleTag = regexp.MustCompile(`(?is)<title>.*?</title>`)
+	oneLine  = strings.NewReplacer("\r", " ", "\n", " ")
+)
+
+// SetBranding validates and applies b; empty fields keep the defaults of the UI build.
+func SetBranding(b Branding) error {
+	b.Title = strings.TrimSpace(oneLine.Replace(b.Title))
+	b.Attribution = strings.TrimSpace(oneLine.Replace(b.Attribution))
+	b.Logo = strings.TrimSpace(b.Logo)
+	b.Color = strings.TrimSpace(b.Color)
+	if b.Color != "" && !colorRe.MatchString(b.Color) {
+		return fmt.Errorf("ui.color: want #rgb or #rrggbb, got %q", b.Color)
+	}
+	if l := strings.ToLower(b.Logo); l != "" && !strings.HasPrefix(l, "/") && !strings.HasPrefix(l, "https://") && !strings.HasPrefix(l, "http://") {
+		return fmt.Errorf("ui.logo: want an http(s) URL or an absolute path, got %q", b.Logo)
+	}
+	branding = b
+	return nil
+}
+
+// ConfigHandler returns the runtime UI configuration for /api/config.
+func ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(map[string]any{"branding": branding})
+}
+
+// brandIndex templates the branding into an index.html: the title, favicon, theme color and
+// primary color variable, window.__MFR_BRANDING__ for the frontend and the links to the
+// generated web app manifest and icons (see manifest.go).
+func brandIndex(page []byte) []byte {
+	b := branding
+	if b.Title != "" {
+		t := []byte("<title>" + html.EscapeString(b.Title) + "</title>")
+		page = titleTag.ReplaceAllLiteral(page, t)
+	}
+	var head strings.Builder
+	head.WriteString("<link rel=\"manifest\" href=\"/manifest.json\">\n")
+	head.WriteString("<link rel=\"apple-touch-icon\" href=\"/icons/icon-180.png\">\n")
+	if b.Logo != "" {
+		fmt.Fprintf(&head, "<link rel=\"icon\" href=\"%s\">\n", html.EscapeString(b.Logo))
+	} else {
+		head.WriteString("<link rel=\"icon\" type=\"image/png\" href=\"/icons/icon-192.png\">\n")
+	}
+	if b.Color != "" {
+		fmt.Fprintf(&head, "<meta name=\"theme-color\" content=\"%s\">\n", b.Color)
+		fmt.Fprintf(&head, "<style>:root[data-theme]{--primary:%s}</style>\n", b.Color)
+	}
+	js, _ := json.Marshal(b) // escapes <, > and & for inline scripts
+	fmt.Fprintf(&head, "<script>window.__MFR_BRANDING__=%s</script>\n", js)
+	// Into the head, else before the body, else in front of the page
+	lower := bytes.ToLower(page)
+	i := bytes.Index(lower, []byte("</head>"))
+	if i < 0 {
+		i = max(bytes.Index(lower, []byte("<body")), 0)
+	}
+	out := make([]byte, 0, len(page)+head.Len())
+	out = append(out, page[:i]...)
+	out = append(out, head.String()...)
+	return append(out, page[i:]...)
+}
@@ -0,0 +1,25 @@
+//go:build !noui
+
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+)
+
+//go:embed build
+var embeddedUI embed.FS
+
+// embedded reports whether the binary carries the UI build (see noembed.go).
+const embedded = true
+
+func init() {
+	// Prepare sub FS rooted at build/
+	var err error
+	buildFS, err = fs.Sub(embeddedUI, "build")
+	if err != nil {
+		// If not present (e.g., developer didn't build UI), keep nil and log
+		log.Printf("ui: embedded build not found: %v", err)
+	}
+}
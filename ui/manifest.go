@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maniack/miniflightradar/problem"
+)
+
+// Defaults of the upstream UI, used for the web app manifest and icons when no branding is set.
+const (
+	defaultTitle = "Mini Flight Radar"
+	defaultColor = "#2563eb"
+)
+
+// iconSizes are the generated PNG icons (/icons/icon-{size}.png); 180 is the Apple touch icon.
+var iconSizes = []int{180, 192, 512}
+
+var (
+	iconMu    sync.Mutex
+	iconCache = map[int][]byte{}
+)
+
+// ManifestHandler serves /manifest.json built from the branding, so installed PWAs carry the
+// instance name and theme color.
+func ManifestHandler(w http.ResponseWriter, r *http.Request) {
+	b := branding
+	name, c := b.Title, b.Color
+	if name == "" {
+		name = defaultTitle
+	}
+	if c == "" {
+		c = defaultColor
+	}
+	short := name
+	if len(short) > 12 {
+		if i := strings.IndexByte(short, ' '); i > 0 && i <= 12 {
+			short = short[:i]
+		}
+	}
+	icons := []map[string]string{}
+	for _, n := range iconSizes[1:] {
+		s := strconv.Itoa(n)
+		icons = append(icons, map[string]string{"src": "/icons/icon-" + s + ".png", "sizes": s + "x" + s, "type": "image/png", "purpose": "any maskable"})
+	}
+	if b.Logo != "" {
+		icons = append(icons, map[string]string{"src": b.Logo, "sizes": "any"})
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"name":             name,
+		"short_name":       short,
+		"start_url":        "/",
+		"scope":            "/",
+		"display":          "standalone",
+		"theme_color":      c,
+		"background_color": c,
+		"icons":            icons,
+	})
+}
+
+// IconHandler serves the generated icons: an airplane on the branding color.
+func IconHandler(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "icon-"), ".png"))
+	if err != nil || !strings.HasPrefix(name, "icon-") || !strings.HasSuffix(name, ".png") || !validIconSize(size) {
+		problem.Write(w, r, http.StatusNotFound, "icon not found")
+		return
+	}
+	iconMu.Lock()
+	img, ok := iconCache[size]
+	if !ok {
+		img = renderIcon(size, brandColor())
+		iconCache[size] = img
+	}
+	iconMu.Unlock()
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	_, _ = w.Write(img)
+}
+
+func validIconSize(n int) bool {
+	for _, s := range iconSizes {
+		if s == n {
+			return true
+		}
+	}
+	return false
+}
+
+// brandColor parses the branding color (#rgb or #rrggbb, validated by SetBranding).
+func brandColor() color.RGBA {
+	c := branding.Color
+	if c == "" {
+		c = defaultColor
+	}
+	h := c[1:]
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	v, _ := strconv.ParseUint(h, 16, 32)
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
+
+// iconPlane is an airplane pointing up in unit coordinates, inside the maskable safe zone.
+var iconPlane = [][2]float64{
+	{0.50, 0.15}, {0.545, 0.20}, {0.545, 0.41}, {0.82, 0.57}, {0.82, 0.625}, {0.545, 0.535},
+	{0.545, 0.73}, {0.625, 0.795}, {0.625, 0.84}, {0.50, 0.805}, {0.375, 0.84}, {0.375, 0.795},
+	{0.455, 0.73}, {0.455, 0.535}, {0.18, 0.625}, {0.18, 0.57}, {0.455, 0.41}, {0.455, 0.20},
+}
+
+// renderIcon draws the plane in white on bg as a size x size PNG (4x supersampled edges).
+func renderIcon(size int, bg color.RGBA) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	const ss = 4
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			in := 0
+			for sy := 0; sy < ss; sy++ {
+				for sx := 0; sx < ss; sx++ {
+					px := (float64(x) + (float64(sx)+0.5)/ss) / float64(size)
+					py := (float64(y) + (float64(sy)+0.5)/ss) / float64(size)
+					if inPolygon(iconPlane, px, py) {
+						in++
+					}
+				}
+			}
+			a := uint32(in) * 255 / (ss * ss)
+			mix := func(c uint8) uint8 { return uint8((uint32(c)*(255-a) + 255*a) / 255) }
+			img.SetRGBA(x, y, color.RGBA{R: mix(bg.R), G: mix(bg.G), B: mix(bg.B), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// inPolygon is the even-odd rule for a point against a closed polygon.
+func inPolygon(poly [][2]float64, x, y float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		xi, yi, xj, yj := poly[i][0], poly[i][1], poly[j][0], poly[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
@@ -0,0 +1,7 @@
+//go:build noui
+
+package ui
+
+// Backend-only build (-tags noui): the SPA is not embedded and is served only from ui.dir,
+// e.g. when the frontend is hosted on a CDN.
+const embedded = false
@@ -1,29 +1,27 @@
 package ui
 
 import (
-	"embed"
+	"bytes"
+	"fmt"
+	"io"
 	"io/fs"
-	"log"
 	"mime"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
-)
 
-//go:embed build
-var embeddedUI embed.FS
+	"github.com/maniack/miniflightradar/problem"
+)
 
+// buildFS is the embedded UI build (nil in noui builds or when it was not built).
 var buildFS fs.FS
 
+// dir overrides the embedded build with a directory on disk (ui.dir).
+var dir string
+
 func init() {
-	// Prepare sub FS rooted at build/
-	var err error
-	buildFS, err = fs.Sub(embeddedUI, "build")
-	if err != nil {
-		// If not present (e.g., developer didn't build UI), keep nil and log
-		log.Printf("ui: embedded build not found: %v", err)
-	}
 	// Common MIME types
 	_ = mime.AddExtensionType(".js", "application/javascript")
 	_ = mime.AddExtensionType(".css", "text/css")
@@ -32,7 +30,30 @@ func init() {
 	_ = mime.AddExtensionType(".json", "application/json")
 }
 
+// SetDir serves the SPA from d (which must contain index.html) instead of the embedded build.
+func SetDir(d string) error {
+	if d == "" {
+		dir = ""
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(d, "index.html")); err != nil {
+		return fmt.Errorf("ui.dir: %w", err)
+	}
+	dir = d
+	return nil
+}
+
+// Handler serves the SPA from ui.dir, the embedded build or, in development, ui/build on disk.
+// Binaries built with -tags noui answer 404 unless ui.dir is set.
 func Handler() http.Handler {
+	if dir != "" {
+		return http.StripPrefix("/", spaHandler{fsys: http.Dir(dir)})
+	}
+	if !embedded {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			problem.Write(w, r, http.StatusNotFound, "UI not embedded in this build (set ui.dir)")
+		})
+	}
 	if buildFS == nil {
 		// Fall back to serving from disk if available (dev mode)
 		fsys := http.Dir(filepath.Join("ui", "build"))
@@ -74,6 +95,9 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fi, err := f.Stat()
 		if err == nil && !fi.IsDir() {
 			setCacheHeaders(p)
+			if h.serveBranded(w, r, p) {
+				return
+			}
 			http.FileServer(h.fsys).ServeHTTP(w, r)
 			return
 		}
@@ -82,6 +106,9 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if ff, err := h.fsys.Open(idx); err == nil {
 			ff.Close()
 			setCacheHeaders(idx)
+			if h.serveBranded(w, r, idx) {
+				return
+			}
 			r.URL.Path = "/" + idx
 			http.FileServer(h.fsys).ServeHTTP(w, r)
 			return
@@ -90,6 +117,33 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Fallback: serve root index.html (SPA)
 	setCacheHeaders("index.html")
+	if h.serveBranded(w, r, "index.html") {
+		return
+	}
 	r.URL.Path = "/index.html"
 	http.FileServer(h.fsys).ServeHTTP(w, r)
 }
+
+// serveBranded serves an index.html page with the branding templated in; it reports false
+// (nothing written) for other files or when the page cannot be read.
+func (h spaHandler) serveBranded(w http.ResponseWriter, r *http.Request, name string) bool {
+	if path.Base(name) != "index.html" {
+		return false
+	}
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	page, err := io.ReadAll(f)
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(brandIndex(page)))
+	return true
+}